@@ -0,0 +1,98 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditRecord is an operation run by a user, written to the audit log.
+type AuditRecord struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"` // user ID with the masked IP address
+	Operation string    `json:"operation"`
+	Node      string    `json:"node,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// AuditSink durably writes the audit records, unlike the in-memory cluster
+// events.
+type AuditSink interface {
+	Audit(rec AuditRecord) error
+}
+
+// globalAudit receives the operations of all users. If nil, operations are
+// not audited.
+var globalAudit AuditSink
+
+// jsonAuditSink writes each record as a line of JSON.
+type jsonAuditSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (s *jsonAuditSink) Audit(rec AuditRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(rec)
+}
+
+func newJSONAuditSink(w io.Writer) AuditSink {
+	return &jsonAuditSink{enc: json.NewEncoder(w)}
+}
+
+// openAuditSink opens the audit log of the destination, which is either
+// 'syslog' or a file path to append to.
+func openAuditSink(dst string) (AuditSink, error) {
+	if dst == "syslog" {
+		w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "etcd-play")
+		if err != nil {
+			return nil, err
+		}
+		return newJSONAuditSink(w), nil
+	}
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return newJSONAuditSink(f), nil
+}
+
+// audit writes the operation to the audit log, if enabled. Errors are only
+// logged, so that a broken audit log does not fail the operations.
+func audit(user, operation, nodeName, key string, err error) {
+	if globalAudit == nil {
+		return
+	}
+	rec := AuditRecord{
+		Time:      time.Now(),
+		User:      user,
+		Operation: operation,
+		Node:      nodeName,
+		Key:       key,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	if err := globalAudit.Audit(rec); err != nil {
+		logger.Errorf("audit %s error (%v)", operation, err)
+	}
+}
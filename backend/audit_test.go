@@ -0,0 +1,128 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestFileAuditSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-play-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	defer func() { globalAudit = nil }()
+	audit("x_unaudited", "PUT", "etcd1", "foo", nil) // disabled
+
+	user := maskUserID("1921681100linuxchrome", "192.168.1.100")
+	for _, op := range []struct {
+		operation, node, key string
+		err                  error
+	}{
+		{"PUT", "etcd1", "foo", nil},
+		{"KILL", "etcd2", "", errors.New("etcd2 is already terminated")},
+	} {
+		// reopened for each record, to check the file is appended
+		if globalAudit, err = openAuditSink(path); err != nil {
+			t.Fatal(err)
+		}
+		audit(user, op.operation, op.node, op.key, op.err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var recs []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid JSON line %q (%v)", scanner.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %+v", recs)
+	}
+	if r := recs[0]; r.User != "192.x.x.x_linuxchrome" || r.Operation != "PUT" || r.Node != "etcd1" || r.Key != "foo" || r.Error != "" || r.Time.IsZero() {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r := recs[1]; r.Operation != "KILL" || r.Node != "etcd2" || r.Error != "etcd2 is already terminated" {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
+
+// memAuditSink keeps the audit records in memory.
+type memAuditSink struct {
+	recs []AuditRecord
+}
+
+func (s *memAuditSink) Audit(rec AuditRecord) error {
+	s.recs = append(s.recs, rec)
+	return nil
+}
+
+func TestHandlersAudit(t *testing.T) {
+	sink := &memAuditSink{}
+	globalAudit = sink
+	defer func() { globalAudit = nil }()
+
+	userID := "1921681100linuxchrome"
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.cluster = nil
+	globalCache.users[userID] = &userData{ip: "192.168.1.100"}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		delete(globalCache.users, userID)
+		globalCache.mu.Unlock()
+	}()
+
+	ctx := context.WithValue(context.Background(), userKey, &userID)
+	if err := watchCancelHandler(ctx, httptest.NewRecorder(), httptest.NewRequest("POST", "/watch_cancel", nil)); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", "/operation_cancel", strings.NewReader(url.Values{"id": {"7"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	operationCancelHandler(ctx, httptest.NewRecorder(), req) // not found
+
+	if len(sink.recs) != 2 {
+		t.Fatalf("expected 2 records, got %+v", sink.recs)
+	}
+	if r := sink.recs[0]; r.User != "192.x.x.x_linuxchrome" || r.Operation != "WATCH_CANCEL" || r.Error != "" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r := sink.recs[1]; r.Operation != "OPERATION_CANCEL 7" || !strings.Contains(r.Error, "does not exist") {
+		t.Errorf("unexpected record %+v", r)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// sessionCookie is the name of the cookie holding the session ID.
+const sessionCookie = "etcd_play_session"
+
+// Authenticator returns true if the passphrase authorizes a session to
+// run operations.
+type Authenticator func(passphrase string) bool
+
+// globalAuth authorizes the sessions. If nil, every user can run
+// operations.
+var globalAuth Authenticator
+
+// passphraseAuth authorizes the sessions that give the shared passphrase.
+func passphraseAuth(passphrase string) Authenticator {
+	return func(p string) bool {
+		return subtle.ConstantTimeCompare([]byte(p), []byte(passphrase)) == 1
+	}
+}
+
+// sessionsEnabled returns true if users are identified by session
+// cookies, instead of the IP address and user-agent.
+func sessionsEnabled() bool {
+	return globalFlags.Sessions || globalAuth != nil
+}
+
+// newSessionID returns a random session ID.
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// getSessionID returns the session ID of the request, if it has the cookie
+// of a known session. The sessions are only started by loginHandler, so
+// that the anonymous requests do not add users. Caller must hold
+// globalCache.mu.
+func getSessionID(req *http.Request) (string, bool) {
+	ck, err := req.Cookie(sessionCookie)
+	if err != nil {
+		return "", false
+	}
+	if _, ok := globalCache.users[ck.Value]; !ok {
+		return "", false
+	}
+	return ck.Value, true
+}
+
+// setSessionCookie sets the cookie of the session, which is sent only to
+// this site, and only over HTTPS if the request came over HTTPS.
+func setSessionCookie(w http.ResponseWriter, req *http.Request, id string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// isAuthorized returns true if the user can run the operations that change
+// the cluster. Caller must hold globalCache.mu.
+func isAuthorized(userID string) bool {
+	if globalAuth == nil {
+		return true
+	}
+	u, ok := globalCache.users[userID]
+	return ok && u.authorized
+}
+
+// denyUnauthorized rejects the operation of an anonymous user.
+func denyUnauthorized(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprintln(w, boldHTMLMsg("Read-only! Please log in with the passphrase to run operations..."))
+}
+
+// withAuth rejects the requests from unauthorized sessions, so that
+// anonymous users can only watch the cluster. It must be wrapped by
+// withCache.
+func withAuth(h ContextHandler) ContextHandler {
+	return ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+		userID := *ctx.Value(userKey).(*string)
+
+		globalCache.mu.Lock()
+		authorized := isAuthorized(userID)
+		globalCache.mu.Unlock()
+
+		if !authorized {
+			denyUnauthorized(w)
+			return nil
+		}
+		return h.ServeHTTPContext(ctx, w, req)
+	})
+}
+
+// denyReadOnly rejects the operation in read-only mode.
+func denyReadOnly(w http.ResponseWriter, operation string) {
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("[%s] Read-only mode! This demo only shows the cluster, and does not allow changing it...", operation)))
+}
+
+// withWritable rejects the operations that change the cluster in read-only
+// mode. Cluster status and reads are still allowed.
+func withWritable(h ContextHandler) ContextHandler {
+	return ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+		if globalFlags.ReadOnly {
+			denyReadOnly(w, strings.ToUpper(strings.TrimPrefix(req.URL.Path, "/")))
+			return nil
+		}
+		return h.ServeHTTPContext(ctx, w, req)
+	})
+}
+
+// loginHandler authorizes the user with the passphrase. With the sessions
+// enabled, it starts a new session for the user and sets its cookie.
+func loginHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "POST":
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+		if err := req.ParseForm(); err != nil {
+			return err
+		}
+		authorized := globalAuth == nil || globalAuth(req.Form.Get("passphrase"))
+
+		if authorized && sessionsEnabled() {
+			id, err := newSessionID()
+			if err != nil {
+				return err
+			}
+			globalCache.mu.Lock()
+			globalCache.users[id] = newUserData(req)
+			globalCache.mu.Unlock()
+			setSessionCookie(w, req, id)
+			userID = id
+		}
+
+		globalCache.mu.Lock()
+		globalCache.users[userID].authorized = authorized
+		globalCache.mu.Unlock()
+
+		msg := "[LOGIN] Success!"
+		if !authorized {
+			w.WriteHeader(http.StatusUnauthorized)
+			msg = "[LOGIN] Wrong passphrase!"
+		}
+		resp := struct {
+			Message string
+			Result  string
+		}{
+			boldHTMLMsg(msg),
+			"<b>" + msg + "</b>",
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
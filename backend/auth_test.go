@@ -0,0 +1,237 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestAuth(t *testing.T) {
+	globalAuth = passphraseAuth("secret")
+	defer func() { globalAuth = nil }()
+
+	globalCache.mu.Lock()
+	before := make(map[string]bool)
+	for id := range globalCache.users {
+		before[id] = true
+	}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		for id := range globalCache.users {
+			if !before[id] {
+				delete(globalCache.users, id)
+			}
+		}
+		globalCache.mu.Unlock()
+	}()
+
+	ok := ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+		return nil
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/kill", &ContextAdapter{ctx: context.Background(), handler: withCache(withAuth(ok))})
+	mux.Handle("/server_status", &ContextAdapter{ctx: context.Background(), handler: withCache(ok)})
+	mux.Handle("/login", &ContextAdapter{ctx: context.Background(), handler: withCache(ContextHandlerFunc(loginHandler))})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	newClient := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &http.Client{Jar: jar}
+	}
+	get := func(cli *http.Client, path string) int {
+		resp, err := cli.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	login := func(cli *http.Client, passphrase string) int {
+		resp, err := cli.PostForm(srv.URL+"/login", url.Values{"passphrase": {passphrase}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	countUsers := func() int {
+		globalCache.mu.Lock()
+		defer globalCache.mu.Unlock()
+		return len(globalCache.users)
+	}
+
+	// the anonymous requests do not start sessions
+	get(newClient(), "/server_status")
+	n := countUsers()
+	for i := 0; i < 3; i++ {
+		get(newClient(), "/server_status")
+	}
+	if m := countUsers(); m != n {
+		t.Fatalf("expected %d users after the anonymous requests, got %d", n, m)
+	}
+
+	alice, bob := newClient(), newClient()
+	if code := get(alice, "/kill"); code != http.StatusUnauthorized {
+		t.Fatalf("anonymous operation: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := get(alice, "/server_status"); code != http.StatusOK {
+		t.Fatalf("anonymous read: expected %d, got %d", http.StatusOK, code)
+	}
+	if code := login(alice, "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong passphrase: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := get(alice, "/kill"); code != http.StatusUnauthorized {
+		t.Fatalf("operation after wrong passphrase: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+	if code := login(alice, "secret"); code != http.StatusOK {
+		t.Fatalf("right passphrase: expected %d, got %d", http.StatusOK, code)
+	}
+	if code := get(alice, "/kill"); code != http.StatusOK {
+		t.Fatalf("authorized operation: expected %d, got %d", http.StatusOK, code)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	if cks := alice.Jar.Cookies(u); len(cks) != 1 || cks[0].Name != sessionCookie {
+		t.Fatalf("expected the session cookie, got %v", cks)
+	}
+
+	// the authorization belongs to the session, not to the IP address
+	if code := get(bob, "/kill"); code != http.StatusUnauthorized {
+		t.Fatalf("operation from another session: expected %d, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	w := httptest.NewRecorder()
+	setSessionCookie(w, req, "id")
+	cks := w.Result().Cookies()
+	if len(cks) != 1 || !cks[0].HttpOnly || !cks[0].Secure || cks[0].SameSite != http.SameSiteStrictMode {
+		t.Fatalf("unexpected cookie %+v", cks)
+	}
+}
+
+func TestKeyValueAuth(t *testing.T) {
+	globalAuth = passphraseAuth("secret")
+	defer func() { globalAuth = nil }()
+
+	c := &recordCluster{}
+	userID := "anonymous-user"
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.cluster = c
+	globalCache.users[userID] = &userData{}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		delete(globalCache.users, userID)
+		globalCache.mu.Unlock()
+	}()
+
+	ctx := context.WithValue(context.Background(), userKey, &userID)
+	for _, tt := range []struct {
+		op   string
+		code int
+	}{
+		{"GET", http.StatusOK},
+		{"PUT", http.StatusUnauthorized},
+		{"DELETE", http.StatusUnauthorized},
+	} {
+		globalCache.mu.Lock()
+		globalCache.users[userID].selectedOperation = tt.op
+		globalCache.users[userID].lastKey = "foo"
+		globalCache.mu.Unlock()
+
+		w := httptest.NewRecorder()
+		if err := keyValueHandler(ctx, w, httptest.NewRequest("GET", "/key_value", nil)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tt.code {
+			t.Errorf("%s: expected %d, got %d", tt.op, tt.code, w.Code)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	globalFlags.ReadOnly = true
+	defer func() { globalFlags.ReadOnly = false }()
+
+	c := &recordCluster{}
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.cluster = c
+	globalCache.users["readonly-user"] = &userData{}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		delete(globalCache.users, "readonly-user")
+		globalCache.mu.Unlock()
+	}()
+
+	userID := "readonly-user"
+	ctx := context.WithValue(context.Background(), userKey, &userID)
+
+	called := false
+	kill := withWritable(ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+		called = true
+		return nil
+	}))
+	w := httptest.NewRecorder()
+	if err := kill.ServeHTTPContext(ctx, w, httptest.NewRequest("GET", "/kill?name=etcd1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusForbidden || called {
+		t.Fatalf("kill: expected %d without calling the handler, got %d (called %v)", http.StatusForbidden, w.Code, called)
+	}
+
+	for _, tt := range []struct {
+		operation string
+		code      int
+		ops       int
+	}{
+		{"PUT", http.StatusForbidden, 0},
+		{"DELETE", http.StatusForbidden, 0},
+		{"GET", http.StatusOK, 1},
+	} {
+		globalCache.mu.Lock()
+		globalCache.users[userID].selectedOperation = tt.operation
+		globalCache.users[userID].lastKey = "foo"
+		globalCache.mu.Unlock()
+
+		c.ops = nil
+		w := httptest.NewRecorder()
+		if err := keyValueHandler(ctx, w, httptest.NewRequest("GET", "/key_value", nil)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != tt.code || len(c.ops) != tt.ops {
+			t.Errorf("%s: expected %d with %d operations, got %d with %v", tt.operation, tt.code, tt.ops, w.Code, c.ops)
+		}
+	}
+}
@@ -15,11 +15,13 @@
 package backend
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -37,17 +39,64 @@ type (
 		EtcdBinary  string
 		ClusterSize int
 		LiveLog     bool
+		LogLevel    string
+		LogMatch    string
+		LogParse    bool
+		NodeEnv     []string
+
+		RestoreSnapshot string
+		EtcdctlBinary   string
+
+		CleanPolicy string
+		BackupDir   string
 
 		KeepAlive      bool
 		ClusterTimeout time.Duration
 		LimitInterval  time.Duration
 		ReviveInterval time.Duration
+		ReviveMode     string
+
+		StartProbeTimeout time.Duration
+		DialTimeout       time.Duration
+		LeaderTimeout     time.Duration
+		StatusConcurrency int
+
+		RetryMax        int
+		RetryBackoff    time.Duration
+		RetryMaxBackoff time.Duration
+
+		BalancedEndpoints bool
+
+		MemberWatchInterval time.Duration
+
+		MaxKeySize   int
+		MaxValueSize int
+
+		JanitorInterval     time.Duration
+		JanitorTTL          time.Duration
+		JanitorMaxKeys      int
+		JanitorKeepPrefixes []string
 
-		StressNumber int
+		AutoCompactionRetention int
+
+		StressNumber       int
+		StressSeed         int64
+		StressKeySize      int
+		StressValueSize    int
+		StressDistribution string
+		StressReadPercent  int
 
 		PlayWebPort    string
+		Sessions       bool
+		Passphrase     string
+		ReadOnly       bool
+		AuditLog       string
 		IsRemote       bool
 		AgentEndpoints []string
+		AgentLogURLs   []string
+
+		WelcomeTemplate    string
+		ClusterDescription string
 	}
 )
 
@@ -57,6 +106,9 @@ const (
 
 var (
 	globalFlags = Flags{}
+
+	// globalStressConfig is parsed from the stress flags.
+	globalStressConfig proc.StressConfig
 )
 
 type ContextHandler interface {
@@ -91,18 +143,57 @@ var (
 func init() {
 	WebCommand.PersistentFlags().StringVarP(&globalFlags.EtcdBinary, "etcd-binary", "b", filepath.Join(os.Getenv("GOPATH"), "bin/etcd"), "path of executable etcd binary")
 	WebCommand.PersistentFlags().IntVar(&globalFlags.ClusterSize, "cluster-size", 5, "size of cluster to create")
-	WebCommand.PersistentFlags().BoolVar(&globalFlags.LiveLog, "live-log", false, "'true' to enable streaming etcd logs (only support localhost)")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.RestoreSnapshot, "restore-snapshot", "", "snapshot file to restore the local nodes from, to start the cluster pre-populated (empty to start empty)")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.EtcdctlBinary, "etcdctl-binary", filepath.Join(os.Getenv("GOPATH"), "bin/etcdctl"), "path of executable etcdctl binary to restore the snapshot")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.CleanPolicy, "clean-policy", "remove", "what to do with the data directories of the local nodes on clean ('remove', 'backup' to move them to backup-dir, or 'keep')")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.BackupDir, "backup-dir", "", "directory to move the data directories to with 'backup' clean-policy (empty for next to each data directory)")
+	WebCommand.PersistentFlags().BoolVar(&globalFlags.LiveLog, "live-log", false, "'true' to enable streaming etcd logs (remote logs need agent-log-urls)")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.LogLevel, "live-log-level", "", "lowest level of the streamed etcd logs ('debug', 'info', 'notice', 'warning', 'error' or 'critical', empty for all)")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.LogMatch, "live-log-match", "", "regular expression the streamed etcd logs must match, such as 'raft' (empty for all)")
+	WebCommand.PersistentFlags().StringSliceVar(&globalFlags.NodeEnv, "node-env", []string{}, "environment variables of the local nodes, such as 'GOMAXPROCS=2', over the ones of etcd-play")
+	WebCommand.PersistentFlags().BoolVar(&globalFlags.LogParse, "live-log-parse", false, "'true' to show the level and component of the streamed etcd logs, and collapse the long lines")
 
 	WebCommand.PersistentFlags().BoolVarP(&globalFlags.KeepAlive, "keep-alive", "k", false, "'true' to run demo without auto-termination (this overwrites cluster-timeout)")
 	WebCommand.PersistentFlags().DurationVar(&globalFlags.ClusterTimeout, "cluster-timeout", 5*time.Minute, "after timeout, etcd shuts down the cluster")
 	WebCommand.PersistentFlags().DurationVar(&globalFlags.LimitInterval, "limit-interval", 7*time.Second, "interval to rate-limit immediate restart, terminate")
 	WebCommand.PersistentFlags().DurationVar(&globalFlags.ReviveInterval, "revive-interval", 15*time.Minute, "interval to automatically revive all-failed cluster")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.ReviveMode, "revive-mode", string(reviveAlways), "when to revive the failed nodes ('always', 'leaderless' to revive only after the quorum is lost, or 'off')")
+	WebCommand.PersistentFlags().DurationVar(&globalFlags.StartProbeTimeout, "start-probe-timeout", 10*time.Second, "time to wait for a started local node to serve (0 not to wait)")
+	WebCommand.PersistentFlags().DurationVar(&globalFlags.DialTimeout, "dial-timeout", 5*time.Second, "timeout for clients to connect to etcd nodes")
+	WebCommand.PersistentFlags().DurationVar(&globalFlags.LeaderTimeout, "leader-timeout", 30*time.Second, "time to wait for the started cluster to elect a leader before shutting it down (0 not to wait)")
+	WebCommand.PersistentFlags().IntVar(&globalFlags.StatusConcurrency, "status-concurrency", 8, "maximum number of nodes to ask for the status at once")
+	WebCommand.PersistentFlags().IntVar(&globalFlags.RetryMax, "retry-max", 0, "number of times to retry a put, get or delete that fails with a transient error, such as no leader (0 not to retry)")
+	WebCommand.PersistentFlags().DurationVar(&globalFlags.RetryBackoff, "retry-backoff", 100*time.Millisecond, "wait before the first retry, doubled for each of the next retries")
+	WebCommand.PersistentFlags().DurationVar(&globalFlags.RetryMaxBackoff, "retry-max-backoff", 2*time.Second, "maximum wait between the retries")
+	WebCommand.PersistentFlags().BoolVar(&globalFlags.BalancedEndpoints, "balanced-endpoints", false, "'true' to send the put, get and delete not given a node to all active nodes, for the client to fail over between them")
+	WebCommand.PersistentFlags().DurationVar(&globalFlags.MemberWatchInterval, "member-watch-interval", 0, "interval to show the members added or removed out of band in the status (0 to disable)")
+
+	WebCommand.PersistentFlags().IntVar(&globalFlags.MaxKeySize, "max-key-size", 1024, "maximum size in bytes of the keys users write (0 not to limit)")
+	WebCommand.PersistentFlags().IntVar(&globalFlags.MaxValueSize, "max-value-size", 64*1024, "maximum size in bytes of the values users write (0 not to limit)")
+
+	WebCommand.PersistentFlags().DurationVar(&globalFlags.JanitorInterval, "janitor-interval", 0, "interval to delete the old keys users left in the cluster (0 to disable)")
+	WebCommand.PersistentFlags().DurationVar(&globalFlags.JanitorTTL, "janitor-ttl", time.Hour, "janitor deletes the keys not modified for longer than this (0 not to expire keys)")
+	WebCommand.PersistentFlags().IntVar(&globalFlags.JanitorMaxKeys, "janitor-max-keys", 1000, "janitor deletes the least recently modified keys beyond this number (0 not to cap)")
+	WebCommand.PersistentFlags().StringSliceVar(&globalFlags.JanitorKeepPrefixes, "janitor-keep-prefixes", nil, "prefixes of the keys janitor never deletes, such as the sample keys of a tutorial")
+	WebCommand.PersistentFlags().IntVar(&globalFlags.AutoCompactionRetention, "auto-compaction-retention", 0, "hours of history etcd keeps before compacting automatically (0 to disable)")
 
 	WebCommand.PersistentFlags().IntVar(&globalFlags.StressNumber, "stress-number", 3, "size of stress requests")
+	WebCommand.PersistentFlags().IntVar(&globalFlags.StressKeySize, "stress-key-size", 5, "size of the random or numeric part of stress keys")
+	WebCommand.PersistentFlags().IntVar(&globalFlags.StressValueSize, "stress-value-size", 5, "size of stress values")
+	WebCommand.PersistentFlags().IntVar(&globalFlags.StressReadPercent, "stress-read-percent", 0, "percentage (0 ~ 100) of stress requests that read the written keys (e.g. 80 for 80/20 reads and writes)")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.StressDistribution, "stress-distribution", "uniform", "distribution of stress keys ('uniform', 'sequential' or 'zipfian')")
+	WebCommand.PersistentFlags().Int64Var(&globalFlags.StressSeed, "stress-seed", 0, "seed for the random keys of stress requests, to replay them (0 to seed with the current time)")
 
 	WebCommand.PersistentFlags().StringVarP(&globalFlags.PlayWebPort, "port", "p", ":8000", "port to serve the play web interface")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.WelcomeTemplate, "welcome-template", "", "file of the Go template of the welcome message in HTML, with {{.OtherUsers}}, {{.ClusterSize}} and {{.Description}} (empty for the default)")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.ClusterDescription, "cluster-description", defaultClusterDescription, "description of the cluster in the welcome message, such as where it is deployed")
+	WebCommand.PersistentFlags().BoolVar(&globalFlags.Sessions, "sessions", false, "'true' to identify the users logged in by session cookies instead of IP address and user-agent")
+	WebCommand.PersistentFlags().BoolVar(&globalFlags.ReadOnly, "read-only", false, "'true' to disable the operations that change the cluster, for display-only deployments")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.Passphrase, "passphrase", "", "passphrase to log in to run operations, leaving the others read-only (implies sessions)")
+	WebCommand.PersistentFlags().StringVar(&globalFlags.AuditLog, "audit-log", "", "file to append the audit log of user operations as JSON lines, or 'syslog' (empty to disable)")
 	WebCommand.PersistentFlags().BoolVar(&globalFlags.IsRemote, "remote", false, "'true' when agents are deployed remotely")
 	WebCommand.PersistentFlags().StringSliceVar(&globalFlags.AgentEndpoints, "agent-endpoints", []string{"localhost:9027"}, "list of remote agent endpoints")
+	WebCommand.PersistentFlags().StringSliceVar(&globalFlags.AgentLogURLs, "agent-log-urls", []string{}, "list of URLs serving the etcd log of each remote agent, in the order of agent-endpoints")
 }
 
 func CommandFunc(cmd *cobra.Command, args []string) {
@@ -120,7 +211,53 @@ func CommandFunc(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	if _, err := parseReviveMode(globalFlags.ReviveMode); err != nil {
+		logger.Errorf("etcd-play revive-mode error (%v)", err)
+		os.Exit(0)
+	}
+	if _, err := proc.ParseCleanPolicy(globalFlags.CleanPolicy); err != nil {
+		logger.Errorf("etcd-play clean-policy error (%v)", err)
+		os.Exit(0)
+	}
+	dist, err := proc.ParseKeyDistribution(globalFlags.StressDistribution)
+	if err != nil {
+		logger.Errorf("etcd-play stress-distribution error (%v)", err)
+		os.Exit(0)
+	}
+	globalStressConfig = proc.StressConfig{
+		KeySize:      globalFlags.StressKeySize,
+		ValueSize:    globalFlags.StressValueSize,
+		Distribution: dist,
+		ReadPercent:  globalFlags.StressReadPercent,
+	}
+	if globalFlags.WelcomeTemplate != "" {
+		tmpl, err := parseWelcomeTemplate(globalFlags.WelcomeTemplate)
+		if err != nil {
+			logger.Errorf("etcd-play welcome-template error (%v)", err)
+			os.Exit(0)
+		}
+		globalWelcome = tmpl
+	}
+	if globalFlags.Passphrase != "" {
+		globalAuth = passphraseAuth(globalFlags.Passphrase)
+	}
+	if globalFlags.AuditLog != "" {
+		sink, err := openAuditSink(globalFlags.AuditLog)
+		if err != nil {
+			logger.Errorf("etcd-play audit-log error (%v)", err)
+			os.Exit(0)
+		}
+		globalAudit = sink
+	}
+
 	initGlobalData()
+	if globalFlags.JanitorInterval > 0 {
+		go runJanitor(globalFlags.JanitorInterval, janitorPolicy{
+			ttl:          globalFlags.JanitorTTL,
+			maxKeys:      globalFlags.JanitorMaxKeys,
+			keepPrefixes: globalFlags.JanitorKeepPrefixes,
+		})
+	}
 
 	rootContext, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -141,6 +278,11 @@ func CommandFunc(cmd *cobra.Command, args []string) {
 		handler: withCache(ContextHandlerFunc(serverStatusHandler)),
 	})
 
+	mainRouter.Handle("/login", &ContextAdapter{
+		ctx:     rootContext,
+		handler: withCache(ContextHandlerFunc(loginHandler)),
+	})
+
 	mainRouter.Handle("/start_cluster", &ContextAdapter{
 		ctx:     rootContext,
 		handler: withCache(ContextHandlerFunc(startClusterHandler)),
@@ -148,7 +290,7 @@ func CommandFunc(cmd *cobra.Command, args []string) {
 
 	mainRouter.Handle("/stress", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(stressHandler)),
+		handler: withCache(withAuth(withWritable(ContextHandlerFunc(stressHandler)))),
 	})
 
 	mainRouter.Handle("/key_history", &ContextAdapter{
@@ -157,49 +299,89 @@ func CommandFunc(cmd *cobra.Command, args []string) {
 	})
 	mainRouter.Handle("/key_value", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(keyValueHandler)),
+		handler: withCache(ContextHandlerFunc(keyValueHandler)), // the writes are authorized in the handler
+	})
+
+	mainRouter.Handle("/latency", &ContextAdapter{
+		ctx:     rootContext,
+		handler: withCache(ContextHandlerFunc(latencyHandler)),
+	})
+
+	mainRouter.Handle("/events", &ContextAdapter{
+		ctx:     rootContext,
+		handler: withCache(ContextHandlerFunc(eventsHandler)),
+	})
+
+	mainRouter.Handle("/event_log", &ContextAdapter{
+		ctx:     rootContext,
+		handler: withCache(ContextHandlerFunc(eventLogHandler)),
+	})
+
+	mainRouter.Handle("/replay", &ContextAdapter{
+		ctx:     rootContext,
+		handler: withCache(withAuth(withWritable(ContextHandlerFunc(replayHandler)))),
 	})
 
-	mainRouter.Handle("/kill_1", &ContextAdapter{
+	mainRouter.Handle("/watch_put", &ContextAdapter{
+		ctx:     rootContext,
+		handler: withCache(withAuth(withWritable(ContextHandlerFunc(watchPutHandler)))),
+	})
+	mainRouter.Handle("/watch_cancel", &ContextAdapter{
+		ctx:     rootContext,
+		handler: withCache(withAuth(ContextHandlerFunc(watchCancelHandler))),
+	})
+	mainRouter.Handle("/operations", &ContextAdapter{
+		ctx:     rootContext,
+		handler: withCache(ContextHandlerFunc(operationsHandler)),
+	})
+	mainRouter.Handle("/operation_cancel", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(killHandler)),
+		handler: withCache(withAuth(ContextHandlerFunc(operationCancelHandler))),
 	})
-	mainRouter.Handle("/kill_2", &ContextAdapter{
+	mainRouter.Handle("/observe_leader", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(killHandler)),
+		handler: withCache(withAuth(ContextHandlerFunc(observeLeaderHandler))),
 	})
-	mainRouter.Handle("/kill_3", &ContextAdapter{
+
+	mainRouter.Handle("/hash_history", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(killHandler)),
+		handler: withCache(ContextHandlerFunc(hashHistoryHandler)),
 	})
-	mainRouter.Handle("/kill_4", &ContextAdapter{
+
+	mainRouter.Handle("/history", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(killHandler)),
+		handler: withCache(ContextHandlerFunc(historyHandler)),
 	})
-	mainRouter.Handle("/kill_5", &ContextAdapter{
+
+	mainRouter.Handle("/stm", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(killHandler)),
+		handler: withCache(withAuth(withWritable(ContextHandlerFunc(stmHandler)))),
 	})
 
-	mainRouter.Handle("/restart_1", &ContextAdapter{
+	mainRouter.Handle("/endpoints", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(restartHandler)),
+		handler: withCache(ContextHandlerFunc(endpointsHandler)),
 	})
-	mainRouter.Handle("/restart_2", &ContextAdapter{
+
+	mainRouter.Handle("/scenario", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(restartHandler)),
+		handler: withCache(withAuth(withWritable(ContextHandlerFunc(scenarioHandler)))),
 	})
-	mainRouter.Handle("/restart_3", &ContextAdapter{
+
+	mainRouter.Handle("/snapshot", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(restartHandler)),
+		handler: withCache(withAuth(ContextHandlerFunc(snapshotHandler))),
 	})
-	mainRouter.Handle("/restart_4", &ContextAdapter{
+
+	// the Nodes are killed and restarted by name, including the members
+	// added after the start
+	mainRouter.Handle("/kill", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(restartHandler)),
+		handler: withCache(withAuth(withWritable(ContextHandlerFunc(killHandler)))),
 	})
-	mainRouter.Handle("/restart_5", &ContextAdapter{
+	mainRouter.Handle("/restart", &ContextAdapter{
 		ctx:     rootContext,
-		handler: withCache(ContextHandlerFunc(restartHandler)),
+		handler: withCache(withAuth(withWritable(ContextHandlerFunc(restartHandler)))),
 	})
 
 	logger.Infof("started serving %q", fmt.Sprintf("http://localhost%s", globalFlags.PlayWebPort))
@@ -222,7 +404,7 @@ func wsHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) er
 	if err != nil {
 		// clean up users that just left the browser
 		globalCache.mu.Lock()
-		delete(globalCache.users, userID)
+		globalCache.removeUser(userID)
 		globalCache.mu.Unlock()
 		return err
 	}
@@ -234,17 +416,34 @@ func wsHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) er
 		globalCache.mu.Unlock()
 	}()
 
+	// the token resumes the stream of this connection after reconnecting
+	newToken, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	globalCache.mu.Lock()
+	if err := globalCache.attachUser(userID, req.URL.Query().Get("token"), newToken); err != nil {
+		logger.Warningf("resume stream error (%v)", err)
+	}
+	globalCache.mu.Unlock()
+	if err := c.WriteJSON(struct{ ReconnectToken string }{newToken}); err != nil {
+		globalCache.mu.Lock()
+		globalCache.detachUser(userID)
+		globalCache.mu.Unlock()
+		return err
+	}
+
 	for {
 		mt, message, err := c.ReadMessage()
 		if err != nil {
 			globalCache.mu.Lock()
-			delete(globalCache.users, userID)
+			globalCache.detachUser(userID)
 			globalCache.mu.Unlock()
 			return err
 		}
 		if err := c.WriteMessage(mt, message); err != nil {
 			globalCache.mu.Lock()
-			delete(globalCache.users, userID)
+			globalCache.detachUser(userID)
 			globalCache.mu.Unlock()
 			return err
 		}
@@ -264,6 +463,9 @@ func streamHandler(ctx context.Context, w http.ResponseWriter, req *http.Request
 		globalCache.mu.Lock()
 		sharedStream := globalCache.cluster.SharedStream()
 		userStream := globalCache.cluster.Stream(userID)
+		dropped := globalCache.cluster.Dropped()
+		lastDropped := globalCache.users[userID].lastDropped
+		globalCache.users[userID].lastDropped = dropped
 		globalCache.mu.Unlock()
 
 		// no need Lock because it's channel
@@ -280,7 +482,12 @@ func streamHandler(ctx context.Context, w http.ResponseWriter, req *http.Request
 			case s := <-sharedStream:
 				streams = append(streams, s)
 
-			case s := <-userStream:
+			case s, ok := <-userStream:
+				if !ok {
+					// the user left, and the stream was closed
+					userStream = nil
+					continue
+				}
 				streams = append(streams, s)
 
 			case <-time.After(time.Second):
@@ -289,6 +496,10 @@ func streamHandler(ctx context.Context, w http.ResponseWriter, req *http.Request
 			}
 		}
 
+		if dropped > lastDropped {
+			streams = append(streams, boldHTMLMsg(fmt.Sprintf("%d log line(s) dropped because the stream was full", dropped-lastDropped)))
+		}
+
 		if len(streams) == 0 {
 			return nil
 		}
@@ -380,6 +591,11 @@ func startClusterHandler(ctx context.Context, w http.ResponseWriter, req *http.R
 }
 
 func startCluster(nodeType proc.NodeType, clusterSize int, liveLog bool, limitInterval time.Duration, agentEndpoints []string, userID string, done chan struct{}, errc chan error) {
+	env, err := parseEnv(globalFlags.NodeEnv)
+	if err != nil {
+		errc <- err
+		return
+	}
 	fs := make([]*proc.Flags, clusterSize)
 	for i := range fs {
 		host := "localhost"
@@ -391,12 +607,29 @@ func startCluster(nodeType proc.NodeType, clusterSize int, liveLog bool, limitIn
 			errc <- err
 			return
 		}
+		df.AutoCompactionRetention = globalFlags.AutoCompactionRetention
+		df.Env = env
 		fs[i] = df
 	}
 
-	opts := []proc.OpOption{proc.WithLimitInterval(limitInterval), proc.WithAgentEndpoints(agentEndpoints)}
+	opts := []proc.OpOption{proc.WithLimitInterval(limitInterval), proc.WithAgentEndpoints(agentEndpoints), proc.WithAgentLogURLs(globalFlags.AgentLogURLs), proc.WithStressSeed(globalFlags.StressSeed), proc.WithDialTimeout(globalFlags.DialTimeout), proc.WithLeaderWait(globalFlags.LeaderTimeout), proc.WithStatusConcurrency(globalFlags.StatusConcurrency), proc.WithMemberWatch(globalFlags.MemberWatchInterval), proc.WithRetryPolicy(proc.RetryPolicy{MaxRetries: globalFlags.RetryMax, Backoff: globalFlags.RetryBackoff, MaxBackoff: globalFlags.RetryMaxBackoff}), proc.WithSizeLimits(globalFlags.MaxKeySize, globalFlags.MaxValueSize)}
 	if liveLog {
-		opts = append(opts, proc.WithLiveLog())
+		opts = append(opts, proc.WithLiveLog(), proc.WithLogFilter(globalFlags.LogLevel, globalFlags.LogMatch))
+		if globalFlags.LogParse {
+			opts = append(opts, proc.WithStructuredLog())
+		}
+	}
+	if globalFlags.BalancedEndpoints {
+		opts = append(opts, proc.WithBalancedEndpoints())
+	}
+	if globalFlags.StartProbeTimeout > 0 {
+		opts = append(opts, proc.WithStartProbe(globalFlags.StartProbeTimeout))
+	}
+	if globalFlags.RestoreSnapshot != "" && !globalFlags.IsRemote {
+		opts = append(opts, proc.WithSnapshotRestore(globalFlags.RestoreSnapshot, globalFlags.EtcdctlBinary))
+	}
+	if policy, err := proc.ParseCleanPolicy(globalFlags.CleanPolicy); err == nil && !globalFlags.IsRemote {
+		opts = append(opts, proc.WithCleanPolicy(policy, globalFlags.BackupDir))
 	}
 	c, err := proc.NewCluster(nodeType, globalFlags.EtcdBinary, fs, opts...)
 	if err != nil {
@@ -428,10 +661,19 @@ func startCluster(nodeType proc.NodeType, clusterSize int, liveLog bool, limitIn
 			cdone <- struct{}{}
 		}()
 		c.Stream(userID) <- boldHTMLMsg(fmt.Sprintf("Starting %d nodes", globalFlags.ClusterSize))
-		if err := c.Bootstrap(); err != nil {
+		progress := make(chan proc.BootstrapEvent)
+		go func() {
+			for ev := range progress {
+				c.Stream(userID) <- boldHTMLMsg(fmt.Sprintf("[BOOTSTRAP] %s", ev))
+			}
+		}()
+		if err := c.BootstrapProgress(progress); err != nil {
 			cerr <- err
 			return
 		}
+		s := proc.WaitForSignal()
+		logger.Infof("shutting down cluster with signal %q", s.String())
+		c.Shutdown()
 	}()
 	done <- struct{}{}
 
@@ -458,151 +700,155 @@ func serverStatusHandler(ctx context.Context, w http.ResponseWriter, req *http.R
 
 		globalStatus.mu.RLock()
 		activeUserList := globalStatus.activeUserList
+		versionWarning := globalStatus.versionWarning
 		copiedNameToStatus := make(map[string]proc.ServerStatus)
 		for k, v := range globalStatus.nameToStatus {
 			copiedNameToStatus[k] = v
 		}
 		globalStatus.mu.RUnlock()
 
-		etcd1_ID, etcd1_Endpoint, etcd1_State := "unknown", "unknown", ""
-		etcd1_DbSize, etcd1_DbSizeTxt, etcd1_Hash := uint64(0), "0 B", 0
-		if v, ok := copiedNameToStatus["etcd1"]; ok {
-			etcd1_ID = v.ID
-			etcd1_Endpoint = v.Endpoint
-			etcd1_State = v.State
-			etcd1_Hash = v.Hash
-			etcd1_DbSize = v.DbSize
-			etcd1_DbSizeTxt = v.DbSizeTxt
-		}
-		etcd2_ID, etcd2_Endpoint, etcd2_State := "unknown", "unknown", ""
-		etcd2_DbSize, etcd2_DbSizeTxt, etcd2_Hash := uint64(0), "0 B", 0
-		if v, ok := copiedNameToStatus["etcd2"]; ok {
-			etcd2_ID = v.ID
-			etcd2_Endpoint = v.Endpoint
-			etcd2_State = v.State
-			etcd2_Hash = v.Hash
-			etcd2_DbSize = v.DbSize
-			etcd2_DbSizeTxt = v.DbSizeTxt
-		}
-		etcd3_ID, etcd3_Endpoint, etcd3_State := "unknown", "unknown", ""
-		etcd3_DbSize, etcd3_DbSizeTxt, etcd3_Hash := uint64(0), "0 B", 0
-		if v, ok := copiedNameToStatus["etcd3"]; ok {
-			etcd3_ID = v.ID
-			etcd3_Endpoint = v.Endpoint
-			etcd3_State = v.State
-			etcd3_Hash = v.Hash
-			etcd3_DbSize = v.DbSize
-			etcd3_DbSizeTxt = v.DbSizeTxt
-		}
-		etcd4_ID, etcd4_Endpoint, etcd4_State := "unknown", "unknown", ""
-		etcd4_DbSize, etcd4_DbSizeTxt, etcd4_Hash := uint64(0), "0 B", 0
-		if v, ok := copiedNameToStatus["etcd4"]; ok {
-			etcd4_ID = v.ID
-			etcd4_Endpoint = v.Endpoint
-			etcd4_State = v.State
-			etcd4_Hash = v.Hash
-			etcd4_DbSize = v.DbSize
-			etcd4_DbSizeTxt = v.DbSizeTxt
-		}
-		etcd5_ID, etcd5_Endpoint, etcd5_State := "unknown", "unknown", ""
-		etcd5_DbSize, etcd5_DbSizeTxt, etcd5_Hash := uint64(0), "0 B", 0
-		if v, ok := copiedNameToStatus["etcd5"]; ok {
-			etcd5_ID = v.ID
-			etcd5_Endpoint = v.Endpoint
-			etcd5_State = v.State
-			etcd5_Hash = v.Hash
-			etcd5_DbSize = v.DbSize
-			etcd5_DbSizeTxt = v.DbSizeTxt
-		}
+		nodes := sortedNodeStatuses(copiedNameToStatus)
+		etcd1 := newNodeStatus("etcd1", copiedNameToStatus)
+		etcd2 := newNodeStatus("etcd2", copiedNameToStatus)
+		etcd3 := newNodeStatus("etcd3", copiedNameToStatus)
+		etcd4 := newNodeStatus("etcd4", copiedNameToStatus)
+		etcd5 := newNodeStatus("etcd5", copiedNameToStatus)
 
 		resp := struct {
 			ServerUptime     string
 			ActiveUserNumber int
 			ActiveUserList   string
-
-			Etcd1_Name      string
-			Etcd1_ID        string
-			Etcd1_Endpoint  string
-			Etcd1_State     string
-			Etcd1_Hash      int
-			Etcd1_DbSize    uint64
-			Etcd1_DbSizeTxt string
-
-			Etcd2_Name      string
-			Etcd2_ID        string
-			Etcd2_Endpoint  string
-			Etcd2_State     string
-			Etcd2_Hash      int
-			Etcd2_DbSize    uint64
-			Etcd2_DbSizeTxt string
-
-			Etcd3_Name      string
-			Etcd3_ID        string
-			Etcd3_Endpoint  string
-			Etcd3_State     string
-			Etcd3_Hash      int
-			Etcd3_DbSize    uint64
-			Etcd3_DbSizeTxt string
-
-			Etcd4_Name      string
-			Etcd4_ID        string
-			Etcd4_Endpoint  string
-			Etcd4_State     string
-			Etcd4_Hash      int
-			Etcd4_DbSize    uint64
-			Etcd4_DbSizeTxt string
-
-			Etcd5_Name      string
-			Etcd5_ID        string
-			Etcd5_Endpoint  string
-			Etcd5_State     string
-			Etcd5_Hash      int
-			Etcd5_DbSize    uint64
-			Etcd5_DbSizeTxt string
+			VersionWarning   string
+
+			// Nodes are all the Nodes, including those after etcd5 and the
+			// members added out of band, in the order of their names.
+			Nodes []NodeStatus
+
+			Etcd1_Name            string
+			Etcd1_ID              string
+			Etcd1_Endpoint        string
+			Etcd1_State           string
+			Etcd1_Hash            int
+			Etcd1_DbSize          uint64
+			Etcd1_DbSizeTxt       string
+			Etcd1_Uptime          string
+			Etcd1_LeaderChanges   int
+			Etcd1_RaftIndexBehind uint64
+			Etcd1_CatchingUp      bool
+
+			Etcd2_Name            string
+			Etcd2_ID              string
+			Etcd2_Endpoint        string
+			Etcd2_State           string
+			Etcd2_Hash            int
+			Etcd2_DbSize          uint64
+			Etcd2_DbSizeTxt       string
+			Etcd2_Uptime          string
+			Etcd2_LeaderChanges   int
+			Etcd2_RaftIndexBehind uint64
+			Etcd2_CatchingUp      bool
+
+			Etcd3_Name            string
+			Etcd3_ID              string
+			Etcd3_Endpoint        string
+			Etcd3_State           string
+			Etcd3_Hash            int
+			Etcd3_DbSize          uint64
+			Etcd3_DbSizeTxt       string
+			Etcd3_Uptime          string
+			Etcd3_LeaderChanges   int
+			Etcd3_RaftIndexBehind uint64
+			Etcd3_CatchingUp      bool
+
+			Etcd4_Name            string
+			Etcd4_ID              string
+			Etcd4_Endpoint        string
+			Etcd4_State           string
+			Etcd4_Hash            int
+			Etcd4_DbSize          uint64
+			Etcd4_DbSizeTxt       string
+			Etcd4_Uptime          string
+			Etcd4_LeaderChanges   int
+			Etcd4_RaftIndexBehind uint64
+			Etcd4_CatchingUp      bool
+
+			Etcd5_Name            string
+			Etcd5_ID              string
+			Etcd5_Endpoint        string
+			Etcd5_State           string
+			Etcd5_Hash            int
+			Etcd5_DbSize          uint64
+			Etcd5_DbSizeTxt       string
+			Etcd5_Uptime          string
+			Etcd5_LeaderChanges   int
+			Etcd5_RaftIndexBehind uint64
+			Etcd5_CatchingUp      bool
 		}{
 			humanize.Time(startTime),
 			len(globalCache.users),
 			activeUserList,
+			versionWarning,
+			nodes,
 
 			"etcd1",
-			etcd1_ID,
-			etcd1_Endpoint,
-			etcd1_State,
-			etcd1_Hash,
-			etcd1_DbSize,
-			etcd1_DbSizeTxt,
+			etcd1.ID,
+			etcd1.Endpoint,
+			etcd1.State,
+			etcd1.Hash,
+			etcd1.DbSize,
+			etcd1.DbSizeTxt,
+			etcd1.Uptime,
+			etcd1.LeaderChanges,
+			etcd1.RaftIndexBehind,
+			etcd1.CatchingUp,
 
 			"etcd2",
-			etcd2_ID,
-			etcd2_Endpoint,
-			etcd2_State,
-			etcd2_Hash,
-			etcd2_DbSize,
-			etcd2_DbSizeTxt,
+			etcd2.ID,
+			etcd2.Endpoint,
+			etcd2.State,
+			etcd2.Hash,
+			etcd2.DbSize,
+			etcd2.DbSizeTxt,
+			etcd2.Uptime,
+			etcd2.LeaderChanges,
+			etcd2.RaftIndexBehind,
+			etcd2.CatchingUp,
 
 			"etcd3",
-			etcd3_ID,
-			etcd3_Endpoint,
-			etcd3_State,
-			etcd3_Hash,
-			etcd3_DbSize,
-			etcd3_DbSizeTxt,
+			etcd3.ID,
+			etcd3.Endpoint,
+			etcd3.State,
+			etcd3.Hash,
+			etcd3.DbSize,
+			etcd3.DbSizeTxt,
+			etcd3.Uptime,
+			etcd3.LeaderChanges,
+			etcd3.RaftIndexBehind,
+			etcd3.CatchingUp,
 
 			"etcd4",
-			etcd4_ID,
-			etcd4_Endpoint,
-			etcd4_State,
-			etcd4_Hash,
-			etcd4_DbSize,
-			etcd4_DbSizeTxt,
+			etcd4.ID,
+			etcd4.Endpoint,
+			etcd4.State,
+			etcd4.Hash,
+			etcd4.DbSize,
+			etcd4.DbSizeTxt,
+			etcd4.Uptime,
+			etcd4.LeaderChanges,
+			etcd4.RaftIndexBehind,
+			etcd4.CatchingUp,
 
 			"etcd5",
-			etcd5_ID,
-			etcd5_Endpoint,
-			etcd5_State,
-			etcd5_Hash,
-			etcd5_DbSize,
-			etcd5_DbSizeTxt,
+			etcd5.ID,
+			etcd5.Endpoint,
+			etcd5.State,
+			etcd5.Hash,
+			etcd5.DbSize,
+			etcd5.DbSizeTxt,
+			etcd5.Uptime,
+			etcd5.LeaderChanges,
+			etcd5.RaftIndexBehind,
+			etcd5.CatchingUp,
 		}
 		if err := json.NewEncoder(w).Encode(resp); err != nil {
 			return err
@@ -650,15 +896,23 @@ func stressHandler(ctx context.Context, w http.ResponseWriter, req *http.Request
 		globalCache.mu.Lock()
 		selectedNodeName := globalCache.users[userID].selectedNodeName
 		cluster := globalCache.cluster
+		globalCache.users[userID].recordOp("STRESS", selectedNodeName, "", "")
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
 		globalCache.mu.Unlock()
 
-		took, err := cluster.Stress(selectedNodeName, globalFlags.StressNumber, userID)
+		// the status polls would skew the latencies of the stress
+		PausePolling()
+		took, err := cluster.StressWithConfig(selectedNodeName, globalFlags.StressNumber, globalStressConfig, userID)
+		ResumePolling()
+		audit(auditUser, "STRESS", selectedNodeName, "", err)
 		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
 			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
 			return err
 		}
 
-		rs := fmt.Sprintf("Success! Wrote %d keys to %q (took %v)", globalFlags.StressNumber, selectedNodeName, took)
+		globalLatency.record(selectedNodeName, "STRESS", took)
+		rs := fmt.Sprintf("Success! Wrote %d %s keys to %q (took %v)", globalFlags.StressNumber, globalStressConfig.Distribution, selectedNodeName, took)
 		resp := struct {
 			Message string
 			Result  string
@@ -739,12 +993,14 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 		if len(value) > 200 { // truncate user-input
 			value = value[:200]
 		}
+		valueBase64 := req.Form.Get("value_base64") == "true"
 
 		globalCache.mu.Lock()
 		globalCache.users[userID].selectedOperation = selectedOperation
 		globalCache.users[userID].selectedNodeName = selectedNodeName
 		globalCache.users[userID].lastKey = key
 		globalCache.users[userID].lastValue = value
+		globalCache.users[userID].lastValueBase64 = valueBase64
 		if key != "" {
 			hm := make(map[string]struct{})
 			for _, v := range globalCache.users[userID].keyHistory {
@@ -774,23 +1030,41 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 		name := globalCache.users[userID].selectedNodeName
 		key := globalCache.users[userID].lastKey
 		value := globalCache.users[userID].lastValue
+		valueBase64 := globalCache.users[userID].lastValueBase64
+		if globalFlags.ReadOnly && opt != "GET" {
+			globalCache.mu.Unlock()
+			denyReadOnly(w, opt)
+			return nil
+		}
+		if opt != "GET" && !isAuthorized(userID) {
+			globalCache.mu.Unlock()
+			denyUnauthorized(w)
+			return nil
+		}
+		globalCache.users[userID].recordOp(opt, name, key, value)
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
 		globalCache.mu.Unlock()
 
 		switch opt {
 		case "PUT":
-			took, err := cluster.Put(name, key, value, userID)
+			if valueBase64 {
+				return putBinary(w, cluster, name, key, value, userID, auditUser)
+			}
+			prev, took, err := cluster.PutWithPrevKV(name, key, value, userID)
+			audit(auditUser, "PUT", name, key, err)
 			if err != nil {
 				resp := struct {
 					Message string
 					Result  string
 				}{
 					boldHTMLMsg(fmt.Sprintf("[PUT] error %v (key %q / value %q)", err, key, value)),
-					fmt.Sprintf("<b>[PUT] error %v (key %q)</b>", err, key),
+					"<b>" + template.HTMLEscapeString(fmt.Sprintf("[PUT] error %v (key %q)", err, key)) + "</b>",
 				}
 				if err = json.NewEncoder(w).Encode(resp); err != nil {
 					return err
 				}
 			} else {
+				globalLatency.record(name, "PUT", took)
 				keyT, valT := key, value
 				if len(keyT) > 3 {
 					keyT = keyT[:3] + "..."
@@ -798,13 +1072,20 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 				if len(valT) > 3 {
 					valT = valT[:3] + "..."
 				}
-				rs := fmt.Sprintf("Success! %q : %q (took %v)", keyT, valT, took)
+				rs := fmt.Sprintf("Success! %q : %q (created, took %v)", keyT, valT, took)
+				if prev != nil {
+					prevT := prev.Value
+					if len(prevT) > 3 {
+						prevT = prevT[:3] + "..."
+					}
+					rs = fmt.Sprintf("Success! %q : was %q now %q (took %v)", keyT, prevT, valT, took)
+				}
 				resp := struct {
 					Message string
 					Result  string
 				}{
 					boldHTMLMsg("[PUT] Success!"),
-					"<b>[PUT]</b> " + rs,
+					"<b>[PUT]</b> " + template.HTMLEscapeString(rs),
 				}
 				if err = json.NewEncoder(w).Encode(resp); err != nil {
 					return err
@@ -812,31 +1093,34 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 			}
 
 		case "GET":
-			keyTxt, prefix := strings.TrimSpace(key), false
-			if strings.Contains(keyTxt, "--prefix") {
-				keyTxt = strings.TrimSpace(strings.Replace(keyTxt, "--prefix", "", 1))
-				prefix = true
+			keyTxt, serializable := splitConsistency(key)
+			keyTxt, prefix := splitPrefix(keyTxt)
+			get, consistency := cluster.Get, "linearizable"
+			if serializable {
+				get, consistency = cluster.GetSerializable, "serializable"
 			}
-			vs, took, err := cluster.Get(name, keyTxt, prefix, userID)
+			vs, took, err := get(name, keyTxt, prefix, userID)
+			audit(auditUser, "GET", name, key, err)
 			if err != nil {
 				resp := struct {
 					Message string
 					Result  string
 				}{
 					boldHTMLMsg(fmt.Sprintf("[GET] error %v (key %q)", err, keyTxt)),
-					fmt.Sprintf("<b>[GET] error %v (key %q)</b>", err, keyTxt),
+					"<b>" + template.HTMLEscapeString(fmt.Sprintf("[GET] error %v (key %q)", err, keyTxt)) + "</b>",
 				}
 				if err = json.NewEncoder(w).Encode(resp); err != nil {
 					return err
 				}
 			} else {
+				globalLatency.record(name, "GET", took)
 				ks := keyTxt
 				if len(ks) == 0 {
 					ks = "\x00"
 				}
 				res := ""
 				for i, rv := range vs {
-					res += fmt.Sprintf("%q", rv)
+					res += template.HTMLEscapeString(fmt.Sprintf("%q", rv))
 					if i != len(vs)-1 {
 						res += ", "
 					}
@@ -848,9 +1132,9 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 						break
 					}
 				}
-				rs := fmt.Sprintf("<b>[GET]</b> %s (key %q, took %v)", res, ks, took)
+				rs := fmt.Sprintf("<b>[GET]</b> %s %s", res, template.HTMLEscapeString(fmt.Sprintf("(key %q, %s, took %v)", ks, consistency, took)))
 				if len(vs) == 0 {
-					rs = fmt.Sprintf("<b>[GET]</b> not exist (key %q, took %v)", ks, took)
+					rs = "<b>[GET]</b> " + template.HTMLEscapeString(fmt.Sprintf("not exist (key %q, %s, took %v)", ks, consistency, took))
 				}
 				resp := struct {
 					Message string
@@ -865,12 +1149,9 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 			}
 
 		case "DELETE":
-			keyTxt, prefix := strings.TrimSpace(key), false
-			if strings.Contains(keyTxt, "--prefix") {
-				keyTxt = strings.TrimSpace(strings.Replace(keyTxt, "--prefix", "", 1))
-				prefix = true
-			}
+			keyTxt, prefix := splitPrefix(key)
 			delN, took, err := cluster.Delete(name, keyTxt, prefix, userID)
+			audit(auditUser, "DELETE", name, key, err)
 			if err != nil {
 				ks := keyTxt
 				if len(ks) == 0 {
@@ -881,12 +1162,13 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 					Result  string
 				}{
 					boldHTMLMsg(fmt.Sprintf("[DELETE] error %v (key %q)", err, ks)),
-					fmt.Sprintf("<b>[DELETE] error %v (key %q)</b>", err, ks),
+					"<b>" + template.HTMLEscapeString(fmt.Sprintf("[DELETE] error %v (key %q)", err, ks)) + "</b>",
 				}
 				if err = json.NewEncoder(w).Encode(resp); err != nil {
 					return err
 				}
 			} else {
+				globalLatency.record(name, "DELETE", took)
 				ks := keyTxt
 				if len(ks) == 0 {
 					ks = "\x00"
@@ -896,7 +1178,7 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 					Result  string
 				}{
 					boldHTMLMsg("[DELETE] Success!"),
-					fmt.Sprintf("<b>[DELETE]</b> successfully deleted %q (deleted %d keys, took %v)", ks, delN, took),
+					"<b>[DELETE]</b> " + template.HTMLEscapeString(fmt.Sprintf("successfully deleted %q (deleted %d keys, took %v)", ks, delN, took)),
 				}
 				if err = json.NewEncoder(w).Encode(resp); err != nil {
 					return err
@@ -911,7 +1193,287 @@ func keyValueHandler(ctx context.Context, w http.ResponseWriter, req *http.Reque
 	return nil
 }
 
-func killHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+// latencyHandler returns the latency percentiles of each node and operation.
+func latencyHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	switch req.Method {
+	case "GET":
+		resp := struct {
+			Latencies []LatencySummary
+		}{
+			globalLatency.summaries(),
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// putBinary puts the base64-encoded value as bytes.
+func putBinary(w http.ResponseWriter, cluster proc.Cluster, name, key, value, userID, auditUser string) error {
+	bs, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		err = fmt.Errorf("invalid base64 value (%v)", err)
+	} else {
+		var took time.Duration
+		took, err = cluster.PutBytes(name, key, bs, userID)
+		if err == nil {
+			globalLatency.record(name, "PUT", took)
+		}
+	}
+	audit(auditUser, "PUT", name, key, err)
+
+	resp := struct {
+		Message string
+		Result  string
+	}{
+		boldHTMLMsg("[PUT] Success!"),
+		"<b>[PUT]</b> " + template.HTMLEscapeString(fmt.Sprintf("Success! %q : %d bytes (binary)", key, len(bs))),
+	}
+	if err != nil {
+		resp.Message = boldHTMLMsg(fmt.Sprintf("[PUT] error %v (key %q)", err, key))
+		resp.Result = "<b>" + template.HTMLEscapeString(fmt.Sprintf("[PUT] error %v (key %q)", err, key)) + "</b>"
+	}
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// eventsHandler returns the cluster events of the shared stream and the
+// user after the optional 'since' time in RFC3339 format, as JSON.
+func eventsHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	userID := *ctx.Value(userKey).(*string)
+
+	switch req.Method {
+	case "GET":
+		var since time.Time
+		if v := req.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid since %q (%v)", v, err), http.StatusBadRequest)
+				return nil
+			}
+			since = t
+		}
+
+		globalCache.mu.Lock()
+		cluster := globalCache.cluster
+		globalCache.mu.Unlock()
+
+		resp := struct {
+			Events []proc.Event
+		}{
+			[]proc.Event{},
+		}
+		if cluster != nil {
+			if evs := userEvents(cluster.Events(since), userID); evs != nil {
+				resp.Events = evs
+			}
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// eventLogHandler downloads all the cluster events kept, or those after
+// the optional 'since' time in RFC3339 format, as a 'csv' (default) or
+// 'ndjson' file of the 'format', with the users masked. Only the events
+// of the shared stream and the user are downloaded.
+func eventLogHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	userID := *ctx.Value(userKey).(*string)
+
+	switch req.Method {
+	case "GET":
+		q := req.URL.Query()
+		var since time.Time
+		if v := q.Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid since %q (%v)", v, err), http.StatusBadRequest)
+				return nil
+			}
+			since = t
+		}
+		format := q.Get("format")
+		if format == "" {
+			format = "csv"
+		}
+		contentType, ok := eventLogFormats[format]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown format %q (csv or ndjson)", format), http.StatusBadRequest)
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		cluster := globalCache.cluster
+		userIPs := make(map[string]string, len(globalCache.users))
+		for id, u := range globalCache.users {
+			userIPs[id] = u.ip
+		}
+		globalCache.mu.Unlock()
+
+		// the cluster keeps only the latest events, so the log is bounded
+		var evs []proc.Event
+		if cluster != nil {
+			evs = userEvents(cluster.Events(since), userID)
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="events-%s.%s"`, nowPST().Format("20060102-150405"), format))
+		if err := writeEventLog(w, format, evs, userIPs); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// replayOps runs the recorded operations in order against the cluster,
+// and returns the result of each. Each operation is charged to the rate
+// limiter with allow. It stops at the first error or excess.
+func replayOps(cluster proc.Cluster, ops []RecordedOp, allow func() bool, streamIDs ...string) ([]string, error) {
+	var results []string
+	for _, op := range ops {
+		if !allow() {
+			return results, fmt.Errorf("[%s] rate limit excess! Please retry", op.Operation)
+		}
+		var (
+			rs   string
+			took time.Duration
+			err  error
+		)
+		switch op.Operation {
+		case "PUT":
+			took, err = cluster.Put(op.NodeName, op.Key, op.Value, streamIDs...)
+			rs = fmt.Sprintf("[PUT] %q : %q (took %v)", op.Key, op.Value, took)
+		case "GET":
+			keyTxt, serializable := splitConsistency(op.Key)
+			keyTxt, prefix := splitPrefix(keyTxt)
+			get := cluster.Get
+			if serializable {
+				get = cluster.GetSerializable
+			}
+			var vs []string
+			vs, took, err = get(op.NodeName, keyTxt, prefix, streamIDs...)
+			rs = fmt.Sprintf("[GET] %q (key %q, took %v)", vs, keyTxt, took)
+		case "DELETE":
+			keyTxt, prefix := splitPrefix(op.Key)
+			var delN int64
+			delN, took, err = cluster.Delete(op.NodeName, keyTxt, prefix, streamIDs...)
+			rs = fmt.Sprintf("[DELETE] deleted %d keys (key %q, took %v)", delN, keyTxt, took)
+		case "STRESS":
+			took, err = cluster.StressWithConfig(op.NodeName, globalFlags.StressNumber, globalStressConfig, streamIDs...)
+			rs = fmt.Sprintf("[STRESS] wrote %d keys to %q (took %v)", globalFlags.StressNumber, op.NodeName, took)
+		case "KILL":
+			err = cluster.Terminate(op.NodeName)
+			rs = fmt.Sprintf("[KILL] %s", op.NodeName)
+		case "RESTART":
+			err = cluster.Restart(op.NodeName)
+			rs = fmt.Sprintf("[RESTART] %s", op.NodeName)
+		default:
+			err = fmt.Errorf("unknown operation %q", op.Operation)
+		}
+		if err != nil {
+			return results, fmt.Errorf("[%s] %s error %v", op.Operation, op.NodeName, err)
+		}
+		results = append(results, rs)
+	}
+	return results, nil
+}
+
+// replayHandler replays the last n operations of the user, or all of them
+// if n is not given.
+func replayHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		n := 0
+		if v := req.URL.Query().Get("n"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+				return err
+			}
+		}
+
+		globalCache.mu.Lock()
+		ops := globalCache.users[userID].lastOps(n)
+		cluster := globalCache.cluster
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
+		globalCache.mu.Unlock()
+
+		allow := func() bool { return globalCache.okToRequest(userID) }
+		results, err := replayOps(cluster, ops, allow, userID)
+		audit(auditUser, "REPLAY", "", "", err)
+		for i := range results {
+			results[i] = template.HTMLEscapeString(results[i])
+		}
+		msg := boldHTMLMsg(fmt.Sprintf("[REPLAY] Success! Replayed %d operations", len(results)))
+		if err != nil {
+			msg = boldHTMLMsg(fmt.Sprintf("[REPLAY] %v (replayed %d of %d operations)", err, len(results), len(ops)))
+		}
+		resp := struct {
+			Message string
+			Result  string
+		}{
+			msg,
+			"<b>[REPLAY]</b><br>" + strings.Join(results, "<br>"),
+		}
+		if err = json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// watchPut runs WatchPut for the user until it finishes, or the user
+// cancels it with cancelWatches.
+func watchPut(parent context.Context, cluster proc.Cluster, userID, name, key, value string) (time.Duration, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	globalCache.mu.Lock()
+	u, ok := globalCache.users[userID]
+	if !ok {
+		globalCache.mu.Unlock()
+		return time.Duration(0), fmt.Errorf("user %q not found", userID)
+	}
+	id := u.addWatch(cancel)
+	globalCache.mu.Unlock()
+
+	defer func() {
+		globalCache.mu.Lock()
+		u.removeWatch(id)
+		globalCache.mu.Unlock()
+	}()
+	return cluster.WatchPutContext(ctx, name, key, value, userID)
+}
+
+// watchPutHandler watches the last key of the user on all nodes, and puts
+// the last value to the selected node. It stops when the user navigates
+// away, or requests watchCancelHandler.
+func watchPutHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
 	user := ctx.Value(userKey).(*string)
 	userID := *user
 
@@ -927,14 +1489,32 @@ func killHandler(ctx context.Context, w http.ResponseWriter, req *http.Request)
 		}
 
 		globalCache.mu.Lock()
-		defer globalCache.mu.Unlock()
+		selectedNodeName := globalCache.users[userID].selectedNodeName
+		key := globalCache.users[userID].lastKey
+		value := globalCache.users[userID].lastValue
+		cluster := globalCache.cluster
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
+		globalCache.mu.Unlock()
 
-		name := urlToName(req.URL.String())
-		if err := globalCache.cluster.Terminate(name); err != nil {
+		// the request context is canceled when the user navigates away
+		took, err := watchPut(req.Context(), cluster, userID, selectedNodeName, key, value)
+		audit(auditUser, "WATCH_PUT", selectedNodeName, key, err)
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
 			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
 			return err
 		}
-		fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("Kill %s request successfully requested", name)))
+
+		resp := struct {
+			Message string
+			Result  string
+		}{
+			boldHTMLMsg("[WATCH] Success!"),
+			"<b>[WATCH]</b> " + template.HTMLEscapeString(fmt.Sprintf("all watchers received %q (took %v)", key, took)),
+		}
+		if err = json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
 
 	default:
 		http.Error(w, "Method Not Allowed", 405)
@@ -943,12 +1523,15 @@ func killHandler(ctx context.Context, w http.ResponseWriter, req *http.Request)
 	return nil
 }
 
-func restartHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+// observeLeaderHandler streams the leader of the election to the user,
+// replacing the election the user observed before. An empty election
+// stops observing.
+func observeLeaderHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
 	user := ctx.Value(userKey).(*string)
 	userID := *user
 
 	switch req.Method {
-	case "GET":
+	case "POST":
 		if !globalCache.clusterActive() {
 			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
 			return nil
@@ -957,12 +1540,634 @@ func restartHandler(ctx context.Context, w http.ResponseWriter, req *http.Reques
 			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
 			return nil
 		}
+		if err := req.ParseForm(); err != nil {
+			return err
+		}
+		election := req.Form.Get("election")
 
 		globalCache.mu.Lock()
-		defer globalCache.mu.Unlock()
+		cluster := globalCache.cluster
+		stop := globalCache.users[userID].stopObserve
+		globalCache.users[userID].stopObserve = nil
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
+		globalCache.mu.Unlock()
+
+		if stop != nil {
+			stop()
+		}
+		rs := "stopped observing"
+		if election != "" {
+			cancel, err := cluster.ObserveLeader(election, userID)
+			audit(auditUser, "OBSERVE_LEADER", "", election, err)
+			if err != nil {
+				w.WriteHeader(errToStatusCode(err))
+				fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+				return err
+			}
+			globalCache.mu.Lock()
+			if u, ok := globalCache.users[userID]; ok {
+				u.stopObserve = cancel
+			} else {
+				defer cancel() // the user left meanwhile
+			}
+			globalCache.mu.Unlock()
+			rs = fmt.Sprintf("observing the leader of %q", election)
+		} else {
+			audit(auditUser, "OBSERVE_LEADER", "", "", nil)
+		}
+
+		resp := struct {
+			Message string
+			Result  string
+		}{
+			boldHTMLMsg("[OBSERVE] Success!"),
+			"<b>[OBSERVE]</b> " + template.HTMLEscapeString(rs),
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// watchCancelHandler cancels all running WatchPut of the user.
+func watchCancelHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "POST":
+		globalCache.mu.Lock()
+		n := globalCache.users[userID].cancelWatches()
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
+		globalCache.mu.Unlock()
+		audit(auditUser, "WATCH_CANCEL", "", "", nil)
+
+		resp := struct {
+			Message string
+			Result  string
+		}{
+			boldHTMLMsg("[WATCH] Canceled!"),
+			fmt.Sprintf("<b>[WATCH]</b> canceled %d watch(es)", n),
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// userOperations returns the running operations the user started.
+func userOperations(cluster proc.Cluster, userID string) []proc.OperationHandle {
+	ops := []proc.OperationHandle{}
+	if cluster == nil {
+		return ops
+	}
+	for _, op := range cluster.ListOperations() {
+		for _, id := range op.StreamIDs {
+			if id == userID {
+				ops = append(ops, op)
+				break
+			}
+		}
+	}
+	return ops
+}
+
+// operationsHandler lists the running operations of the user.
+func operationsHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		globalCache.mu.Lock()
+		cluster := globalCache.cluster
+		globalCache.mu.Unlock()
+
+		resp := struct {
+			Operations []proc.OperationHandle
+		}{
+			userOperations(cluster, userID),
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// operationCancelHandler cancels the running operation of the ID, if the
+// user started it.
+func operationCancelHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "POST":
+		if err := req.ParseForm(); err != nil {
+			return err
+		}
+		id, err := strconv.ParseUint(req.Form.Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid id %q (%v)", req.Form.Get("id"), err), http.StatusBadRequest)
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		cluster := globalCache.cluster
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
+		globalCache.mu.Unlock()
+
+		// other users' operations are reported as not found
+		err = fmt.Errorf("operation %d %w", id, proc.ErrOperationNotFound)
+		for _, op := range userOperations(cluster, userID) {
+			if op.ID == id {
+				err = cluster.CancelOperation(id)
+				break
+			}
+		}
+		audit(auditUser, fmt.Sprintf("OPERATION_CANCEL %d", id), "", "", err)
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
+			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+			return err
+		}
+
+		resp := struct {
+			Message string
+			Result  string
+		}{
+			boldHTMLMsg("[CANCEL] Success!"),
+			fmt.Sprintf("<b>[CANCEL]</b> canceled operation %d", id),
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+const (
+	// hashSamples is the number of hash samples for the chart.
+	hashSamples = 10
+
+	// hashSampleInterval is the interval between the hash samples.
+	hashSampleInterval = 500 * time.Millisecond
+)
+
+// hashHistoryHandler selects the node with POST, and samples its hash for
+// the chart with GET.
+func hashHistoryHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "POST":
+		if err := req.ParseForm(); err != nil {
+			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+			return err
+		}
+		globalCache.mu.Lock()
+		globalCache.users[userID].selectedNodeName = req.Form.Get("selected_node_name")
+		globalCache.mu.Unlock()
+
+	case "GET":
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		selectedNodeName := globalCache.users[userID].selectedNodeName
+		cluster := globalCache.cluster
+		globalCache.mu.Unlock()
+
+		// the request context is canceled when the user navigates away
+		hashes, err := cluster.HashHistoryContext(req.Context(), selectedNodeName, hashSamples, hashSampleInterval, userID)
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
+			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+			return err
+		}
+
+		stable := "changing"
+		if len(hashes) > 1 && hashes[len(hashes)-1] == hashes[len(hashes)-2] {
+			stable = "stable"
+		}
+		resp := struct {
+			Message string
+			Result  string
+			Hashes  []int
+		}{
+			boldHTMLMsg("[HASH] Success!"),
+			fmt.Sprintf("<b>[HASH]</b> %d samples, the hash is %s (last %d)", len(hashes), stable, hashes[len(hashes)-1]),
+			hashes,
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// historyMaxEvents is the maximum number of events historyHandler replays.
+const historyMaxEvents = 1000
+
+// historyHandler replays the changes of the prefix from the start revision,
+// given as the 'prefix' and 'revision' query parameters, up to the
+// optional 'max_events' number of events.
+func historyHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+		prefix := req.URL.Query().Get("prefix")
+		startRev := int64(1)
+		if s := req.URL.Query().Get("revision"); s != "" {
+			rev, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || rev < 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: invalid revision %q", s)))
+				return nil
+			}
+			startRev = rev
+		}
+		maxEvents := historyMaxEvents
+		if s := req.URL.Query().Get("max_events"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 || n > historyMaxEvents {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: invalid max_events %q (1 ~ %d)", s, historyMaxEvents)))
+				return nil
+			}
+			maxEvents = n
+		}
+
+		globalCache.mu.Lock()
+		selectedNodeName := globalCache.users[userID].selectedNodeName
+		cluster := globalCache.cluster
+		globalCache.mu.Unlock()
+
+		// the request context is canceled when the user navigates away
+		evs, err := cluster.History(req.Context(), selectedNodeName, prefix, startRev, maxEvents, userID)
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
+			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+			return err
+		}
+		resp := struct {
+			Message string
+			Result  string
+			Events  []proc.WatchEvent
+		}{
+			boldHTMLMsg("[HISTORY] Success!"),
+			fmt.Sprintf("<b>[HISTORY]</b> %d events of %q", len(evs), template.HTMLEscapeString(prefix)),
+			evs,
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// endpointsHandler lists the client endpoints of the Nodes and of the
+// members found out of band, which the operations take in place of a node
+// name.
+func endpointsHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		cluster := globalCache.cluster
+		globalCache.mu.Unlock()
+
+		endpoints, err := cluster.DiscoverEndpoints("")
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
+			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+			return err
+		}
+		_, _, epToName := cluster.Endpoints()
+		names := make([]string, len(endpoints))
+		for i, ep := range endpoints {
+			names[i] = epToName[ep]
+		}
+		resp := struct {
+			Message   string
+			Result    string
+			Endpoints []string
+			Names     []string // the node names, or empty for the other members
+		}{
+			boldHTMLMsg("[ENDPOINTS] Success!"),
+			fmt.Sprintf("<b>[ENDPOINTS]</b> %s", template.HTMLEscapeString(strings.Join(endpoints, ", "))),
+			endpoints,
+			names,
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
 
-		name := urlToName(req.URL.String())
-		if err := globalCache.cluster.Restart(name); err != nil {
+// scenarioHandler runs the failure scenario given as the 'name' query
+// parameter, or lists the scenarios without it.
+func scenarioHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		name := req.URL.Query().Get("name")
+		if name == "" {
+			resp := struct {
+				Scenarios map[string]string
+			}{
+				proc.Scenarios(),
+			}
+			return json.NewEncoder(w).Encode(resp)
+		}
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		cluster := globalCache.cluster
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
+		globalCache.mu.Unlock()
+
+		err := cluster.RunScenario(name, userID)
+		audit(auditUser, "SCENARIO "+name, "", "", err)
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
+			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+			return err
+		}
+		resp := struct {
+			Message string
+			Result  string
+		}{
+			boldHTMLMsg("[SCENARIO] Success!"),
+			fmt.Sprintf("<b>[SCENARIO]</b> %s is done", template.HTMLEscapeString(name)),
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// stmCounterKey is the key the STM demo increments.
+const stmCounterKey = "stm/counter"
+
+// stmHandler increments the demo counter in a transaction, while another
+// transaction increments it between the read and the commit, so that the
+// first one retries with the new value.
+func stmHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		selectedNodeName := globalCache.users[userID].selectedNodeName
+		cluster := globalCache.cluster
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
+		globalCache.mu.Unlock()
+
+		increment := func(stm proc.STM) (int, error) {
+			n := 0
+			if v := stm.Get(stmCounterKey); v != "" {
+				var err error
+				if n, err = strconv.Atoi(v); err != nil {
+					return 0, fmt.Errorf("%q is not a number (%v)", stmCounterKey, err)
+				}
+			}
+			stm.Put(stmCounterKey, strconv.Itoa(n+1))
+			return n + 1, nil
+		}
+		var (
+			calls   int
+			counter int
+		)
+		err := cluster.RunSTM(selectedNodeName, func(stm proc.STM) error {
+			calls++
+			if calls == 1 {
+				// the conflicting transaction
+				if err := cluster.RunSTM(selectedNodeName, func(stm proc.STM) error {
+					_, err := increment(stm)
+					return err
+				}, userID); err != nil {
+					return err
+				}
+			}
+			var err error
+			counter, err = increment(stm)
+			return err
+		}, userID)
+		audit(auditUser, "STM", selectedNodeName, stmCounterKey, err)
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
+			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+			return err
+		}
+		resp := struct {
+			Message string
+			Result  string
+		}{
+			boldHTMLMsg("[STM] Success!"),
+			fmt.Sprintf("<b>[STM]</b> %q is %d after %d retries", stmCounterKey, counter, calls-1),
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+// snapshotHandler downloads the snapshot of the selected node.
+func snapshotHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		selectedNodeName := globalCache.users[userID].selectedNodeName
+		cluster := globalCache.cluster
+		auditUser := maskUserID(userID, globalCache.users[userID].ip)
+		globalCache.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="snapshot-%s.db"`, nowPST().Format("20060102-150405")))
+		err := cluster.Snapshot(selectedNodeName, w, userID)
+		audit(auditUser, "SNAPSHOT", selectedNodeName, "", err)
+		if err != nil {
+			return err
+		}
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+func killHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+		name := req.URL.Query().Get("name")
+		if name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, boldHTMLMsg("error: no node name"))
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		defer globalCache.mu.Unlock()
+
+		globalCache.users[userID].recordOp("KILL", name, "", "")
+		err := globalCache.cluster.Terminate(name)
+		audit(maskUserID(userID, globalCache.users[userID].ip), "KILL", name, "", err)
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
+			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
+			return err
+		}
+		fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("Kill %s request successfully requested", name)))
+
+	default:
+		http.Error(w, "Method Not Allowed", 405)
+	}
+
+	return nil
+}
+
+func restartHandler(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+	user := ctx.Value(userKey).(*string)
+	userID := *user
+
+	switch req.Method {
+	case "GET":
+		if !globalCache.clusterActive() {
+			fmt.Fprintln(w, boldHTMLMsg("Cluster is not active... Please start the cluster..."))
+			return nil
+		}
+		if !globalCache.okToRequest(userID) {
+			fmt.Fprintln(w, boldHTMLMsg("Rate limit excess! Please retry..."))
+			return nil
+		}
+		name := req.URL.Query().Get("name")
+		if name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, boldHTMLMsg("error: no node name"))
+			return nil
+		}
+
+		globalCache.mu.Lock()
+		defer globalCache.mu.Unlock()
+
+		globalCache.users[userID].recordOp("RESTART", name, "", "")
+		err := globalCache.cluster.Restart(name)
+		audit(maskUserID(userID, globalCache.users[userID].ip), "RESTART", name, "", err)
+		if err != nil {
+			w.WriteHeader(errToStatusCode(err))
 			fmt.Fprintln(w, boldHTMLMsg(fmt.Sprintf("error: %v", err)))
 			return err
 		}
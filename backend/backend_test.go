@@ -0,0 +1,105 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// scriptCluster returns the value written by another user with a script.
+type scriptCluster struct {
+	recordCluster
+}
+
+func (c *scriptCluster) Get(name, key string, prefix bool, streamIDs ...string) ([]string, time.Duration, error) {
+	return []string{"<script>alert(1)</script>"}, time.Millisecond, nil
+}
+
+func TestResultEscapesHTML(t *testing.T) {
+	userID := "result-user"
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.cluster = &scriptCluster{}
+	globalCache.users[userID] = &userData{selectedOperation: "GET", lastKey: "<b>key</b>"}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		delete(globalCache.users, userID)
+		globalCache.mu.Unlock()
+	}()
+
+	ctx := context.WithValue(context.Background(), userKey, &userID)
+	w := httptest.NewRecorder()
+	if err := keyValueHandler(ctx, w, httptest.NewRequest("GET", "/key_value", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Result string
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(resp.Result, "<script>") || strings.Contains(resp.Result, "<b>key") {
+		t.Errorf("unescaped result %q", resp.Result)
+	}
+	if !strings.HasPrefix(resp.Result, "<b>[GET]</b> ") || !strings.Contains(resp.Result, "&lt;script&gt;") {
+		t.Errorf("expected the escaped value, got %q", resp.Result)
+	}
+}
+
+func TestKillRestartByName(t *testing.T) {
+	userID := "kill-user"
+	rc := &recordCluster{}
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.cluster = rc
+	globalCache.users[userID] = &userData{}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		delete(globalCache.users, userID)
+		globalCache.mu.Unlock()
+	}()
+
+	ctx := context.WithValue(context.Background(), userKey, &userID)
+	w := httptest.NewRecorder()
+	if err := killHandler(ctx, w, httptest.NewRequest("GET", "/kill", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected %d without a name, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// the members added after the start are routed by the same paths
+	if err := killHandler(ctx, httptest.NewRecorder(), httptest.NewRequest("GET", "/kill?name=etcd6", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := restartHandler(ctx, httptest.NewRecorder(), httptest.NewRequest("GET", "/restart?name=etcd6", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"KILL etcd6", "RESTART etcd6"}; !reflect.DeepEqual(rc.ops, expected) {
+		t.Errorf("expected %v, got %v", expected, rc.ops)
+	}
+}
@@ -33,6 +33,13 @@ type (
 	userData struct {
 		upgrader *websocket.Upgrader
 
+		// ip is the IP address of the user.
+		ip string
+
+		// authorized is true once the session logs in, to run operations
+		// when the demo is gated by a passphrase.
+		authorized bool
+
 		startTime       time.Time
 		lastRequestTime time.Time
 		requestCount    int
@@ -43,7 +50,39 @@ type (
 		lastKey   string
 		lastValue string
 
+		// lastValueBase64 is true if lastValue is base64-encoded binary.
+		lastValueBase64 bool
+
 		keyHistory []string
+
+		// opHistory is the FIFO of at most maxOpHistory operations, for replay.
+		opHistory []RecordedOp
+
+		// lastDropped is the number of dropped log lines already
+		// reported to this user.
+		lastDropped uint64
+
+		// watchCancels cancels the running WatchPut of this user by ID.
+		watchCancels map[uint64]context.CancelFunc
+		nextWatchID  uint64
+
+		// stopObserve stops observing the election leader, if any.
+		stopObserve func()
+
+		// reconnectToken lets the user resume the stream after the
+		// websocket disconnects, within reconnectTTL. detached is true
+		// while the websocket is disconnected.
+		reconnectToken string
+		detached       bool
+	}
+
+	// RecordedOp is an operation requested by a user.
+	RecordedOp struct {
+		Operation string
+		NodeName  string
+		Key       string
+		Value     string
+		Time      time.Time
 	}
 
 	cache struct {
@@ -56,23 +95,124 @@ type (
 		mu             sync.RWMutex
 		activeUserList string
 		nameToStatus   map[string]proc.ServerStatus
+
+		// versionWarning is not empty when the nodes run different versions.
+		versionWarning string
+	}
+
+	// pollGate pauses the status poller and the revive loop, while any
+	// caller holds it paused.
+	pollGate struct {
+		mu     sync.Mutex
+		cond   *sync.Cond
+		paused int
 	}
 )
 
+func newPollGate() *pollGate {
+	g := &pollGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *pollGate) pause() {
+	g.mu.Lock()
+	g.paused++
+	g.mu.Unlock()
+}
+
+func (g *pollGate) resume() {
+	g.mu.Lock()
+	if g.paused > 0 {
+		g.paused--
+	}
+	if g.paused == 0 {
+		g.cond.Broadcast()
+	}
+	g.mu.Unlock()
+}
+
+// wait blocks until no caller holds the gate paused.
+func (g *pollGate) wait() {
+	g.mu.Lock()
+	for g.paused > 0 {
+		g.cond.Wait()
+	}
+	g.mu.Unlock()
+}
+
+// PausePolling pauses the status poller and the revive loop, so that
+// their RPCs do not skew the numbers of a stress. Every call must be
+// followed by ResumePolling.
+func PausePolling() {
+	globalPoll.pause()
+}
+
+// ResumePolling resumes the status poller and the revive loop, once all
+// PausePolling calls are resumed.
+func ResumePolling() {
+	globalPoll.resume()
+}
+
 var (
 	globalCache = &cache{
 		cluster: nil,
 		users:   make(map[string]*userData),
 	}
+	globalLatency = newLatencyRecorder()
+	globalPoll    = newPollGate()
+
 	globalStatus = &status{
 		activeUserList: "",
 		nameToStatus:   make(map[string]proc.ServerStatus),
 	}
 
+	// reconnectTTL is how long the user data and the stream of a
+	// disconnected user are kept for the user to reconnect.
+	reconnectTTL = time.Minute
+
 	uptimeScale = time.Second
 	startTime   = time.Now().Round(uptimeScale)
 )
 
+// reviveMode decides when the revive loop restarts the failed nodes.
+type reviveMode string
+
+const (
+	// reviveAlways revives whenever any node is down.
+	reviveAlways reviveMode = "always"
+
+	// reviveLeaderless revives only when the cluster has no leader, so that
+	// a single killed node stays down to show the failover.
+	reviveLeaderless reviveMode = "leaderless"
+
+	// reviveOff never revives.
+	reviveOff reviveMode = "off"
+)
+
+func parseReviveMode(s string) (reviveMode, error) {
+	switch m := reviveMode(s); m {
+	case reviveAlways, reviveLeaderless, reviveOff:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown revive mode %q", s)
+	}
+}
+
+// reviveCluster revives the failed nodes of the cluster as the mode
+// allows, and returns true if it tried to.
+func reviveCluster(cluster proc.Cluster, mode reviveMode) (bool, error) {
+	switch mode {
+	case reviveOff:
+		return false, nil
+	case reviveLeaderless:
+		if _, err := cluster.Leader(); err == nil {
+			return false, nil
+		}
+	}
+	return true, cluster.Revive()
+}
+
 // initGlobalData must be called at the beginning of 'web' command.
 func initGlobalData() {
 	globalCache.mu.Lock()
@@ -84,6 +224,7 @@ func initGlobalData() {
 	// keep pulling cluster status
 	go func() {
 		for {
+			globalPoll.wait()
 			if globalCache.clusterActive() {
 				globalCache.mu.Lock()
 				userN := len(globalCache.users)
@@ -93,16 +234,8 @@ func initGlobalData() {
 					users := []string{}
 					globalCache.mu.Lock()
 					cn := 0
-					for u := range globalCache.users {
-						bts := []byte(u)
-						bts[2] = 'x' // mask IP addresses
-						bts[3] = 'x'
-						bts[4] = 'x'
-						bts[5] = 'x'
-						bts[6] = 'x'
-						bts[7] = 'x'
-						bts[8] = 'x'
-						bs := string(bts)
+					for u, v := range globalCache.users {
+						bs := maskUserID(u, v.ip)
 						if len(bs) > 23 {
 							bs = bs[:23] + "..."
 						}
@@ -123,9 +256,14 @@ func initGlobalData() {
 					if err != nil {
 						log.Println(err)
 					}
+					vw := ""
+					if nameToVersion, skew := proc.CompareVersions(st); skew {
+						vw = fmt.Sprintf("Warning: the cluster runs mixed versions %v", nameToVersion)
+					}
 					globalStatus.mu.Lock()
 					globalStatus.activeUserList = us
 					globalStatus.nameToStatus = st
+					globalStatus.versionWarning = vw
 					globalStatus.mu.Unlock()
 				}
 			}
@@ -141,7 +279,8 @@ func initGlobalData() {
 			for userID, v := range globalCache.users {
 				sub := now.Sub(v.startTime)
 				if sub > time.Hour {
-					delete(globalCache.users, userID)
+					v.cancelWatches()
+					globalCache.removeUser(userID)
 				}
 			}
 			globalCache.mu.Unlock()
@@ -150,38 +289,53 @@ func initGlobalData() {
 		}
 	}()
 
-	// revive cluster in case somebody killed all
+	// revive nodes in case somebody killed them
 	go func() {
 		for {
 			time.Sleep(globalFlags.ReviveInterval)
+			globalPoll.wait()
 			if !globalCache.clusterActive() {
 				continue
 			}
+			// the nodes take up to the start probe timeout to revive, so
+			// the cache is not locked meanwhile
 			globalCache.mu.Lock()
-			if err := globalCache.cluster.Revive(); err != nil {
+			cluster := globalCache.cluster
+			globalCache.mu.Unlock()
+			if _, err := reviveCluster(cluster, reviveMode(globalFlags.ReviveMode)); err != nil {
 				log.Println(err)
 			}
-			globalCache.mu.Unlock()
 		}
 	}()
 }
 
+// newUserData returns the cache of a user visiting the first time.
+func newUserData(req *http.Request) *userData {
+	return &userData{
+		upgrader:        &websocket.Upgrader{},
+		ip:              getIP(req),
+		startTime:       time.Now().Round(uptimeScale),
+		lastRequestTime: time.Time{},
+		requestCount:    0,
+		keyHistory: []string{
+			`TYPE_YOUR_KEY`,
+		},
+	}
+}
+
 func withCache(h ContextHandler) ContextHandler {
 	return ContextHandlerFunc(func(ctx context.Context, w http.ResponseWriter, req *http.Request) error {
+		globalCache.mu.Lock()
 		userID := getUserID(req)
+		if sessionsEnabled() {
+			if id, ok := getSessionID(req); ok {
+				userID = id
+			}
+		}
 		ctx = context.WithValue(ctx, userKey, &userID)
 
-		globalCache.mu.Lock()
 		if _, ok := globalCache.users[userID]; !ok { // if user visits first time, create user cache
-			globalCache.users[userID] = &userData{
-				upgrader:        &websocket.Upgrader{},
-				startTime:       time.Now().Round(uptimeScale),
-				lastRequestTime: time.Time{},
-				requestCount:    0,
-				keyHistory: []string{
-					`TYPE_YOUR_KEY`,
-				},
-			}
+			globalCache.users[userID] = newUserData(req)
 		}
 		globalCache.mu.Unlock()
 
@@ -192,6 +346,127 @@ func withCache(h ContextHandler) ContextHandler {
 	})
 }
 
+// addWatch registers the cancel function of a running WatchPut, and
+// returns its ID. Caller must hold globalCache.mu.
+func (u *userData) addWatch(cancel context.CancelFunc) uint64 {
+	if u.watchCancels == nil {
+		u.watchCancels = make(map[uint64]context.CancelFunc)
+	}
+	u.nextWatchID++
+	u.watchCancels[u.nextWatchID] = cancel
+	return u.nextWatchID
+}
+
+// removeWatch unregisters the finished WatchPut. Caller must hold
+// globalCache.mu.
+func (u *userData) removeWatch(id uint64) {
+	delete(u.watchCancels, id)
+}
+
+// cancelWatches cancels all running WatchPut of the user, and returns the
+// number of canceled ones. Caller must hold globalCache.mu.
+func (u *userData) cancelWatches() int {
+	n := len(u.watchCancels)
+	for id, cancel := range u.watchCancels {
+		cancel()
+		delete(u.watchCancels, id)
+	}
+	return n
+}
+
+// maxOpHistory is the maximum number of operations to keep for each user.
+const maxOpHistory = 20
+
+// recordOp appends the operation to the history, dropping the oldest one
+// if the history is full. Caller must hold globalCache.mu.
+func (u *userData) recordOp(operation, nodeName, key, value string) {
+	u.opHistory = append(u.opHistory, RecordedOp{
+		Operation: operation,
+		NodeName:  nodeName,
+		Key:       key,
+		Value:     value,
+		Time:      time.Now(),
+	})
+	if len(u.opHistory) > maxOpHistory {
+		copied := make([]RecordedOp, maxOpHistory)
+		copy(copied, u.opHistory[len(u.opHistory)-maxOpHistory:])
+		u.opHistory = copied
+	}
+}
+
+// lastOps returns the copy of at most n recent operations, or all of them
+// if n <= 0.
+func (u *userData) lastOps(n int) []RecordedOp {
+	ops := u.opHistory
+	if n > 0 && n < len(ops) {
+		ops = ops[len(ops)-n:]
+	}
+	copied := make([]RecordedOp, len(ops))
+	copy(copied, ops)
+	return copied
+}
+
+// removeUser forgets the user who left, and closes the user's stream.
+// Caller must hold s.mu.
+func (s *cache) removeUser(userID string) {
+	if u, ok := s.users[userID]; ok && u.stopObserve != nil {
+		u.stopObserve()
+	}
+	delete(s.users, userID)
+	if s.cluster != nil {
+		s.cluster.CloseStream(userID)
+	}
+}
+
+// detachUser keeps the user disconnected from the websocket for
+// reconnectTTL, and then removes the user unless it has reconnected.
+// Caller must hold mu.
+func (s *cache) detachUser(userID string) {
+	u, ok := s.users[userID]
+	if !ok {
+		return
+	}
+	u.detached = true
+	time.AfterFunc(reconnectTTL, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if cur, ok := s.users[userID]; ok && cur == u && u.detached {
+			s.removeUser(userID)
+		}
+	})
+}
+
+// attachUser marks the user connected with a new reconnection token. If
+// the token is of a detached user, it resumes the stream of that user.
+// Caller must hold mu.
+func (s *cache) attachUser(userID, token, newToken string) error {
+	u, ok := s.users[userID]
+	if !ok {
+		return nil
+	}
+	u.detached = false
+	u.reconnectToken = newToken
+	if token == "" {
+		return nil
+	}
+	for oldID, old := range s.users {
+		if oldID == userID || !old.detached || old.reconnectToken != token {
+			continue
+		}
+		// the old user data is removed after reconnectTTL, which closes
+		// its stream ID but not the resumed stream
+		old.reconnectToken = ""
+		if u.stopObserve == nil {
+			u.stopObserve, old.stopObserve = old.stopObserve, nil
+		}
+		if s.cluster == nil {
+			return nil
+		}
+		return s.cluster.ResumeStream(oldID, userID)
+	}
+	return nil
+}
+
 // checkCluster returns the cluster if the cluster is active.
 func (s *cache) clusterActive() bool {
 	s.mu.Lock()
@@ -222,22 +497,3 @@ func (s *cache) okToRequest(userID string) bool {
 	}
 	return false
 }
-
-func getWelcomeMsg() string {
-	return boldHTMLMsg("Hello World! Welcome to etcd playground!") + fmt.Sprintf(`<br>
-- You've joined an <a href="https://github.com/coreos/etcd" target="_blank"><b>etcd</b></a> cluster <i>with %d other user(s) now</i>.<br>
-- This is a <b>real</b> <a href="https://github.com/coreos/etcd" target="_blank"><b>etcd</b></a> cluster of 5 nodes, deployed in cloud environment <font color="red"><i>(not a simulator)</i></font>.<br>
-- <a href="https://github.com/coreos/etcd" target="_blank"><b>etcd</b></a> is distributed reliable key-value store for the most critical data of a distributed system.<br>
-- Using <a href="https://raft.github.io" target="_blank">Raft</a>, <a href="https://github.com/coreos/etcd" target="_blank"><b>etcd</b></a> gracefully handles <b>network partitions</b> and <b>machine failures</b>, even <b><font color='red'>leader failures</font></b>.<br>
-- Tutorials and source code can be found at <a href="https://github.com/coreos/etcd-play" target="_blank"><b>coreos/etcd-play</b></a>.<br>
-- This runs <b>master branch of <a href="https://github.com/coreos/etcd" target="_blank">etcd</a></b>. For any issues or questions, please report at <i><b><a href="https://github.com/coreos/etcd-play/issues/new" target="_blank">issues</a></b></i>.<br>
-- Please click <font color='#0000A0'>circle(node)</font> for more node information (<font color='green'>green</font> is leader, <font color='blue'>blue</font> is follower).<br>
-- <font color='red'>Kill</font> to stop node(even the <font color='green'><b>leader</b></font>). <font color='red'>Restart</font> to recover node.<br>
-- <font color='blue'>Hash</font> shows how <b>etcd</b>, <i>as a distributed database</i>, <b>keeps its data consistent</b>.<br>
-- Select <b>any endpoint</b><i>(etcd1, etcd2, ...)</i> to PUT, GET, DELETE, and then click <b>Submit</b>.<br>
-- Pass <b><i>--prefix</i></b> to GET and DELETE to query by prefix.<br>
-<br>
-<i>Note: Request logs are streamed based on your IP and user agent. So if you have multiple<br>
-web browsers running at the same time, logs might be shown only in one of them.</i><br>
-`, len(globalCache.users)-1)
-}
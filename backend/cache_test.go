@@ -0,0 +1,379 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd-play/proc"
+	"golang.org/x/net/context"
+)
+
+func TestRecordOp(t *testing.T) {
+	u := &userData{}
+	for i := 0; i < maxOpHistory+5; i++ {
+		u.recordOp("PUT", "etcd1", fmt.Sprintf("foo%d", i), "bar")
+	}
+	if len(u.opHistory) != maxOpHistory {
+		t.Fatalf("expected %d operations, got %d", maxOpHistory, len(u.opHistory))
+	}
+	if u.opHistory[0].Key != "foo5" {
+		t.Errorf("expected oldest key foo5, got %q", u.opHistory[0].Key)
+	}
+
+	ops := u.lastOps(3)
+	if len(ops) != 3 || ops[2].Key != fmt.Sprintf("foo%d", maxOpHistory+4) {
+		t.Errorf("unexpected last operations %+v", ops)
+	}
+	if len(u.lastOps(0)) != maxOpHistory {
+		t.Errorf("expected all operations with n 0")
+	}
+}
+
+// recordCluster records the operations requested to the cluster.
+type recordCluster struct {
+	proc.Cluster
+	ops []string
+}
+
+func (c *recordCluster) Put(name, key, value string, streamIDs ...string) (time.Duration, error) {
+	c.ops = append(c.ops, fmt.Sprintf("PUT %s %s %s", name, key, value))
+	return time.Millisecond, nil
+}
+
+func (c *recordCluster) Get(name, key string, prefix bool, streamIDs ...string) ([]string, time.Duration, error) {
+	c.ops = append(c.ops, fmt.Sprintf("GET %s %s %v", name, key, prefix))
+	return []string{"bar"}, time.Millisecond, nil
+}
+
+func (c *recordCluster) Delete(name, key string, prefix bool, streamIDs ...string) (int64, time.Duration, error) {
+	c.ops = append(c.ops, fmt.Sprintf("DELETE %s %s %v", name, key, prefix))
+	return 1, time.Millisecond, nil
+}
+
+func (c *recordCluster) PutBytes(name, key string, value []byte, streamIDs ...string) (time.Duration, error) {
+	c.ops = append(c.ops, fmt.Sprintf("PUT %s %s %x", name, key, value))
+	return time.Millisecond, nil
+}
+
+func (c *recordCluster) Terminate(name string) error {
+	c.ops = append(c.ops, fmt.Sprintf("KILL %s", name))
+	return nil
+}
+
+func (c *recordCluster) Restart(name string) error {
+	c.ops = append(c.ops, fmt.Sprintf("RESTART %s", name))
+	return nil
+}
+
+func (c *recordCluster) CloseStream(streamID string) {
+	c.ops = append(c.ops, fmt.Sprintf("CLOSE %s", streamID))
+}
+
+func (c *recordCluster) ResumeStream(oldStreamID, newStreamID string) error {
+	c.ops = append(c.ops, fmt.Sprintf("RESUME %s %s", oldStreamID, newStreamID))
+	return nil
+}
+
+func TestReconnectUser(t *testing.T) {
+	defer func(d time.Duration) { reconnectTTL = d }(reconnectTTL)
+	reconnectTTL = 50 * time.Millisecond
+
+	c := &recordCluster{}
+	s := &cache{cluster: c, users: map[string]*userData{"user1": {}, "user2": {}, "user3": {}}}
+
+	s.mu.Lock()
+	s.attachUser("user1", "", "token1")
+	s.detachUser("user1")
+	// user1 reconnects as user2, and the token is used up
+	s.attachUser("user2", "token1", "token2")
+	s.attachUser("user3", "token1", "token3")
+	// user3 reconnects with the same ID before reconnectTTL
+	s.detachUser("user3")
+	s.attachUser("user3", "", "token4")
+	s.mu.Unlock()
+
+	time.Sleep(4 * reconnectTTL)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if expected := []string{"RESUME user1 user2", "CLOSE user1"}; !reflect.DeepEqual(c.ops, expected) {
+		t.Errorf("expected %q, got %q", expected, c.ops)
+	}
+	if _, ok := s.users["user1"]; ok || len(s.users) != 2 {
+		t.Errorf("expected only the detached user1 removed, got %v", s.users)
+	}
+}
+
+func TestRemoveUser(t *testing.T) {
+	c := &recordCluster{}
+	s := &cache{cluster: c, users: map[string]*userData{"user1": {}, "user2": {}}}
+
+	s.mu.Lock()
+	s.removeUser("user1")
+	s.mu.Unlock()
+	if _, ok := s.users["user1"]; ok || len(s.users) != 1 {
+		t.Errorf("expected only user1 removed, got %v", s.users)
+	}
+	if expected := []string{"CLOSE user1"}; !reflect.DeepEqual(c.ops, expected) {
+		t.Errorf("expected %q, got %q", expected, c.ops)
+	}
+
+	// the cluster may be stopped when the user leaves
+	s.cluster = nil
+	s.mu.Lock()
+	s.removeUser("user2")
+	s.mu.Unlock()
+	if len(s.users) != 0 {
+		t.Errorf("expected no user, got %v", s.users)
+	}
+}
+
+func TestPutBinary(t *testing.T) {
+	c := &recordCluster{}
+
+	w := httptest.NewRecorder()
+	if err := putBinary(w, c, "etcd1", "blob", "/wA8/g==", "user1", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"PUT etcd1 blob ff003cfe"}; !reflect.DeepEqual(c.ops, expected) {
+		t.Errorf("expected %q, got %q", expected, c.ops)
+	}
+	if !strings.Contains(w.Body.String(), "4 bytes (binary)") {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	if err := putBinary(w, c, "etcd1", "blob", "not base64!", "user1", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.ops) != 1 {
+		t.Errorf("expected no put of invalid base64, got %q", c.ops)
+	}
+	if !strings.Contains(w.Body.String(), "invalid base64 value") {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+}
+
+func TestReplayOps(t *testing.T) {
+	u := &userData{}
+	u.recordOp("PUT", "etcd1", "foo", "bar")
+	u.recordOp("GET", "etcd2", "fo --prefix", "")
+	u.recordOp("DELETE", "", "foo", "")
+	u.recordOp("UNKNOWN", "", "", "")
+
+	c := &recordCluster{}
+	allow := func() bool { return true }
+	results, err := replayOps(c, u.lastOps(0), allow)
+	if err == nil {
+		t.Fatal("expected error on unknown operation")
+	}
+	if len(results) != 3 {
+		t.Errorf("expected 3 results, got %q", results)
+	}
+	expected := []string{"PUT etcd1 foo bar", "GET etcd2 fo true", "DELETE  foo false"}
+	if !reflect.DeepEqual(c.ops, expected) {
+		t.Errorf("expected %q, got %q", expected, c.ops)
+	}
+
+	// each operation is charged, so the replay stops at the rate limit
+	c.ops = nil
+	charged := 0
+	allow = func() bool {
+		charged++
+		return charged <= 2
+	}
+	results, err = replayOps(c, u.lastOps(0), allow)
+	if err == nil || !strings.Contains(err.Error(), "rate limit") {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if len(results) != 2 || len(c.ops) != 2 || charged != 3 {
+		t.Errorf("expected 2 replayed operations and 3 charges, got %q, %q and %d", results, c.ops, charged)
+	}
+}
+
+// watchCluster blocks WatchPutContext until ctx is canceled.
+type watchCluster struct {
+	proc.Cluster
+	started chan string
+}
+
+func (c *watchCluster) WatchPutContext(ctx context.Context, name, key, value string, streamIDs ...string) (time.Duration, error) {
+	c.started <- streamIDs[0]
+	<-ctx.Done()
+	return time.Duration(0), ctx.Err()
+}
+
+func TestWatchPutCancel(t *testing.T) {
+	globalCache.mu.Lock()
+	globalCache.users["watch-user1"] = &userData{}
+	globalCache.users["watch-user2"] = &userData{}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		delete(globalCache.users, "watch-user1")
+		delete(globalCache.users, "watch-user2")
+		globalCache.mu.Unlock()
+	}()
+
+	c := &watchCluster{started: make(chan string, 2)}
+	errc1, errc2 := make(chan error, 1), make(chan error, 1)
+	go func() {
+		_, err := watchPut(context.Background(), c, "watch-user1", "etcd1", "foo", "bar")
+		errc1 <- err
+	}()
+	go func() {
+		_, err := watchPut(context.Background(), c, "watch-user2", "etcd1", "foo", "bar")
+		errc2 <- err
+	}()
+	<-c.started
+	<-c.started
+
+	globalCache.mu.Lock()
+	n := globalCache.users["watch-user1"].cancelWatches()
+	globalCache.mu.Unlock()
+	if n != 1 {
+		t.Fatalf("expected 1 canceled watch, got %d", n)
+	}
+	if err := <-errc1; err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	select {
+	case err := <-errc2:
+		t.Fatalf("watch of another user returned (%v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	globalCache.mu.Lock()
+	globalCache.users["watch-user2"].cancelWatches()
+	globalCache.mu.Unlock()
+	<-errc2
+
+	globalCache.mu.Lock()
+	defer globalCache.mu.Unlock()
+	for _, id := range []string{"watch-user1", "watch-user2"} {
+		if n := len(globalCache.users[id].watchCancels); n != 0 {
+			t.Errorf("%s has %d watches after cancel", id, n)
+		}
+	}
+}
+
+// waitReturns returns true if the gate lets the poller through in time.
+func waitReturns(g *pollGate, timeout time.Duration) bool {
+	donec := make(chan struct{})
+	go func() {
+		g.wait()
+		close(donec)
+	}()
+	select {
+	case <-donec:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func TestPollGate(t *testing.T) {
+	g := newPollGate()
+	if !waitReturns(g, time.Second) {
+		t.Fatal("expected the poller to run when not paused")
+	}
+
+	// two overlapping stresses
+	g.pause()
+	g.pause()
+	donec := make(chan struct{})
+	go func() {
+		g.wait()
+		close(donec)
+	}()
+
+	g.resume()
+	select {
+	case <-donec:
+		t.Fatal("expected the poller paused until the last resume")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	g.resume()
+	select {
+	case <-donec:
+	case <-time.After(time.Second):
+		t.Fatal("expected the poller resumed")
+	}
+
+	// an extra resume does not leave the gate paused on the next pause
+	g.resume()
+	g.pause()
+	g.resume()
+	if !waitReturns(g, time.Second) {
+		t.Fatal("expected the poller to run after resumed")
+	}
+}
+
+// leaderCluster records the revives of a cluster with or without leader.
+type leaderCluster struct {
+	proc.Cluster
+	hasLeader bool
+	revived   int
+}
+
+func (c *leaderCluster) Leader() (string, error) {
+	if !c.hasLeader {
+		return "", fmt.Errorf("no leader found")
+	}
+	return "etcd1", nil
+}
+
+func (c *leaderCluster) Revive() error {
+	c.revived++
+	return nil
+}
+
+func TestReviveCluster(t *testing.T) {
+	tests := []struct {
+		mode      string
+		hasLeader bool
+		revived   bool
+	}{
+		{"always", true, true},
+		{"always", false, true},
+		{"leaderless", true, false},
+		{"leaderless", false, true},
+		{"off", true, false},
+		{"off", false, false},
+	}
+	for i, tt := range tests {
+		mode, err := parseReviveMode(tt.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &leaderCluster{hasLeader: tt.hasLeader}
+		revived, err := reviveCluster(c, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revived != tt.revived || (c.revived == 1) != tt.revived {
+			t.Errorf("#%d: expected revived %v, got %v (%d revives)", i, tt.revived, revived, c.revived)
+		}
+	}
+	if _, err := parseReviveMode("sometimes"); err == nil {
+		t.Error("expected an error for unknown revive mode")
+	}
+}
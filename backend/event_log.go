@@ -0,0 +1,113 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd-play/proc"
+)
+
+// EventRecord is a cluster event in the downloaded event log.
+type EventRecord struct {
+	Time      time.Time `json:"time"`
+	Node      string    `json:"node"`
+	Operation string    `json:"operation,omitempty"`
+	User      string    `json:"user,omitempty"` // masked user IDs, space-separated
+	Detail    string    `json:"detail"`
+}
+
+// eventLogFormats are the content types of the event log formats.
+var eventLogFormats = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"ndjson": "application/x-ndjson",
+}
+
+// newEventRecord converts the event, masking the users it was written to
+// with their IP addresses by user ID. The users no longer known are masked
+// entirely, since their user IDs embed the IP addresses.
+func newEventRecord(ev proc.Event, userIPs map[string]string) EventRecord {
+	users := make([]string, 0, len(ev.StreamIDs))
+	for _, id := range ev.StreamIDs {
+		if ip, ok := userIPs[id]; ok {
+			users = append(users, maskUserID(id, ip))
+		} else {
+			users = append(users, "x")
+		}
+	}
+	return EventRecord{
+		Time:      ev.Time,
+		Node:      ev.Node,
+		Operation: ev.Op,
+		User:      strings.Join(users, " "),
+		Detail:    ev.Detail,
+	}
+}
+
+// userEvents returns the events written to the shared stream or to the
+// user, so that users do not see the keys and values of each other.
+func userEvents(evs []proc.Event, userID string) []proc.Event {
+	var rs []proc.Event
+	for _, ev := range evs {
+		if len(ev.StreamIDs) == 0 {
+			rs = append(rs, ev)
+			continue
+		}
+		for _, id := range ev.StreamIDs {
+			if id == userID {
+				rs = append(rs, ev)
+				break
+			}
+		}
+	}
+	return rs
+}
+
+// writeEventLog writes the events in the format, either 'csv' with a
+// header or 'ndjson' with a JSON object per line.
+func writeEventLog(w io.Writer, format string, evs []proc.Event, userIPs map[string]string) error {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"time", "node", "operation", "user", "detail"}); err != nil {
+			return err
+		}
+		for _, ev := range evs {
+			rec := newEventRecord(ev, userIPs)
+			if err := cw.Write([]string{rec.Time.Format(time.RFC3339Nano), rec.Node, rec.Operation, rec.User, rec.Detail}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		for _, ev := range evs {
+			if err := enc.Encode(newEventRecord(ev, userIPs)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown event log format %q", format)
+	}
+}
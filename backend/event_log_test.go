@@ -0,0 +1,159 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd-play/proc"
+	"golang.org/x/net/context"
+)
+
+// eventCluster returns the events after since.
+type eventCluster struct {
+	proc.Cluster
+	evs []proc.Event
+}
+
+func (c *eventCluster) Events(since time.Time) []proc.Event {
+	var evs []proc.Event
+	for _, ev := range c.evs {
+		if ev.Time.After(since) {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
+
+func TestEventLogHandler(t *testing.T) {
+	base := time.Date(2016, 6, 15, 10, 0, 0, 0, time.UTC)
+	c := &eventCluster{evs: []proc.Event{
+		{Time: base, Node: "etcd1", Op: "PUT", Detail: `Success! "foo", "bar"`, StreamIDs: []string{"1921681100linuxchrome"}},
+		{Time: base.Add(time.Second), Node: "etcd2", Detail: "no operation"},
+		{Time: base.Add(2 * time.Second), Node: "etcd3", Op: "KILL", Detail: "Done!", StreamIDs: []string{"1921681100linuxchrome", "10001gone"}},
+		{Time: base.Add(3 * time.Second), Node: "etcd1", Op: "PUT", Detail: `Success! "secret", "bar"`, StreamIDs: []string{"10002other"}},
+	}}
+	userID := "1921681100linuxchrome"
+	ctx := context.WithValue(context.Background(), userKey, &userID)
+
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.cluster = c
+	globalCache.users["1921681100linuxchrome"] = &userData{ip: "192.168.1.100"}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		delete(globalCache.users, "1921681100linuxchrome")
+		globalCache.mu.Unlock()
+	}()
+
+	w := httptest.NewRecorder()
+	if err := eventLogHandler(ctx, w, httptest.NewRequest("GET", "/event_log", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, `attachment; filename="events-`) || !strings.HasSuffix(cd, `.csv"`) {
+		t.Errorf("unexpected content disposition %q", cd)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"time", "node", "operation", "user", "detail"},
+		{"2016-06-15T10:00:00Z", "etcd1", "PUT", "192.x.x.x_linuxchrome", `Success! "foo", "bar"`},
+		{"2016-06-15T10:00:01Z", "etcd2", "", "", "no operation"},
+		{"2016-06-15T10:00:02Z", "etcd3", "KILL", "192.x.x.x_linuxchrome x", "Done!"}, // no longer cached
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %q, got %q", expected, rows)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/event_log?format=ndjson&since="+base.Format(time.RFC3339Nano), nil)
+	if err := eventLogHandler(ctx, w, req); err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	var recs []EventRecord
+	sc := bufio.NewScanner(w.Body)
+	for sc.Scan() {
+		var rec EventRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid line %q (%v)", sc.Text(), err)
+		}
+		recs = append(recs, rec)
+	}
+	if len(recs) != 2 || recs[0].Node != "etcd2" || recs[1].User != "192.x.x.x_linuxchrome x" || !recs[1].Time.Equal(base.Add(2*time.Second)) {
+		t.Errorf("unexpected records %+v", recs)
+	}
+
+	for _, q := range []string{"?format=xml", "?since=yesterday"} {
+		w = httptest.NewRecorder()
+		if err := eventLogHandler(ctx, w, httptest.NewRequest("GET", "/event_log"+q, nil)); err != nil {
+			t.Fatal(err)
+		}
+		if w.Code != 400 {
+			t.Errorf("%s: expected 400, got %d", q, w.Code)
+		}
+	}
+}
+
+func TestEventsHandlerFiltersUsers(t *testing.T) {
+	base := time.Date(2016, 6, 15, 10, 0, 0, 0, time.UTC)
+	c := &eventCluster{evs: []proc.Event{
+		{Time: base, Node: "etcd1", Op: "PUT", Detail: `Success! "foo", "bar"`, StreamIDs: []string{"events-user"}},
+		{Time: base.Add(time.Second), Node: "etcd2", Detail: "no operation"},
+		{Time: base.Add(2 * time.Second), Node: "etcd1", Op: "PUT", Detail: `Success! "secret", "bar"`, StreamIDs: []string{"events-other"}},
+	}}
+
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.cluster = c
+	globalCache.mu.Unlock()
+	defer func() {
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		globalCache.mu.Unlock()
+	}()
+
+	userID := "events-user"
+	ctx := context.WithValue(context.Background(), userKey, &userID)
+	w := httptest.NewRecorder()
+	if err := eventsHandler(ctx, w, httptest.NewRequest("GET", "/events", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Events []proc.Event
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Events) != 2 || resp.Events[0].Detail != `Success! "foo", "bar"` || resp.Events[1].Node != "etcd2" {
+		t.Errorf("unexpected events %+v", resp.Events)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/etcd-play/proc"
+)
+
+// janitorPolicy decides which keys the janitor deletes, to keep the
+// keyspace of a long-running demo bounded.
+type janitorPolicy struct {
+	// ttl deletes the keys not modified for longer than ttl, or zero
+	// not to expire keys.
+	ttl time.Duration
+
+	// maxKeys deletes the least recently modified keys beyond maxKeys,
+	// or zero not to cap the count.
+	maxKeys int
+
+	// keepPrefixes are the prefixes of the keys never deleted, such as
+	// the tutorial sample keys. They do not count toward maxKeys.
+	keepPrefixes []string
+}
+
+// revisionSample is the revision of the cluster at a time. etcd does not
+// record when keys are written, so the janitor tells the age of a key by
+// comparing its mod revision with the samples.
+type revisionSample struct {
+	rev int64
+	at  time.Time
+}
+
+// janitor deletes the keys of a cluster as its policy says.
+type janitor struct {
+	policy  janitorPolicy
+	samples []revisionSample // from the oldest
+}
+
+// kept returns true if the key must never be deleted.
+func (p janitorPolicy) kept(key string) bool {
+	for _, pfx := range p.keepPrefixes {
+		if strings.HasPrefix(key, pfx) {
+			return true
+		}
+	}
+	return false
+}
+
+// expired returns the keys to delete, from the least recently modified.
+// Keys modified at or before expiredRev are older than the TTL, and zero
+// expires none.
+func (p janitorPolicy) expired(kvs []proc.KeyValue, expiredRev int64) []proc.KeyValue {
+	candidates := []proc.KeyValue{}
+	for _, kv := range kvs {
+		if !p.kept(kv.Key) {
+			candidates = append(candidates, kv)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ModRevision < candidates[j].ModRevision })
+
+	expired := []proc.KeyValue{}
+	for i, kv := range candidates {
+		overCap := p.maxKeys > 0 && len(candidates)-i > p.maxKeys
+		if kv.ModRevision <= expiredRev || overCap {
+			expired = append(expired, kv)
+		}
+	}
+	return expired
+}
+
+// sample records the revision of the cluster at the time, and returns the
+// latest revision recorded at least the TTL ago, or zero if none is that
+// old yet.
+func (j *janitor) sample(rev int64, now time.Time) int64 {
+	if n := len(j.samples); n > 0 && rev < j.samples[n-1].rev {
+		// the cluster restarted from scratch
+		j.samples = nil
+	}
+	j.samples = append(j.samples, revisionSample{rev: rev, at: now})
+	if j.policy.ttl <= 0 {
+		j.samples = j.samples[len(j.samples)-1:]
+		return 0
+	}
+
+	idx := -1
+	for i, s := range j.samples {
+		if now.Sub(s.at) >= j.policy.ttl {
+			idx = i
+		}
+	}
+	if idx == -1 {
+		return 0
+	}
+	// older samples are no longer needed
+	j.samples = j.samples[idx:]
+	return j.samples[0].rev
+}
+
+// clean deletes the keys of the cluster that the policy expires, and
+// returns the number of deleted keys. The keys users write again in the
+// meantime are kept.
+func (j *janitor) clean(cluster proc.Cluster, now time.Time) (int, error) {
+	kvs, rev, err := cluster.Keys("")
+	if err != nil {
+		return 0, err
+	}
+	expired := j.policy.expired(kvs, j.sample(rev, now))
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	return cluster.DeleteUnmodified("", expired)
+}
+
+// runJanitor cleans the keys of the active cluster at the interval.
+func runJanitor(interval time.Duration, policy janitorPolicy) {
+	j := &janitor{policy: policy}
+	for {
+		time.Sleep(interval)
+		globalPoll.wait()
+		if !globalCache.clusterActive() {
+			continue
+		}
+		globalCache.mu.Lock()
+		cluster := globalCache.cluster
+		globalCache.mu.Unlock()
+
+		if n, err := j.clean(cluster, time.Now()); err != nil {
+			logger.Warningf("janitor: deleted %d keys (%v)", n, err)
+		} else if n > 0 {
+			logger.Infof("janitor: deleted %d keys", n)
+		}
+	}
+}
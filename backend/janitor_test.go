@@ -0,0 +1,94 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd-play/proc"
+)
+
+// keyspaceCluster serves the keys of the mod revisions, and deletes them.
+type keyspaceCluster struct {
+	recordCluster
+	keyToRev map[string]int64
+	rev      int64
+}
+
+func (c *keyspaceCluster) Keys(name string) ([]proc.KeyValue, int64, error) {
+	kvs := []proc.KeyValue{}
+	for k, rev := range c.keyToRev {
+		kvs = append(kvs, proc.KeyValue{Key: k, ModRevision: rev})
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, c.rev, nil
+}
+
+func (c *keyspaceCluster) DeleteUnmodified(name string, kvs []proc.KeyValue) (int, error) {
+	deleted := 0
+	for _, kv := range kvs {
+		if rev, ok := c.keyToRev[kv.Key]; ok && rev == kv.ModRevision {
+			delete(c.keyToRev, kv.Key)
+			c.ops = append(c.ops, "DELETE "+kv.Key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func TestJanitorClean(t *testing.T) {
+	c := &keyspaceCluster{
+		keyToRev: map[string]int64{"tutorial/foo": 1, "old1": 2, "old2": 5, "mid": 8},
+		rev:      10,
+	}
+	j := &janitor{policy: janitorPolicy{ttl: time.Hour, maxKeys: 3, keepPrefixes: []string{"tutorial/"}}}
+	now := time.Now()
+
+	tests := []struct {
+		after time.Duration
+		put   map[string]int64
+		rev   int64
+
+		deleted []string
+	}{
+		// within the cap, and no key is older than the TTL
+		{0, nil, 10, nil},
+		// over the cap, the least recently modified keys go first
+		{30 * time.Minute, map[string]int64{"new1": 12, "new2": 15}, 20, []string{"DELETE old1", "DELETE old2"}},
+		// the keys modified before the sample of an hour ago expire
+		{time.Hour, nil, 21, []string{"DELETE mid"}},
+		// the cluster restarted from scratch
+		{2 * time.Hour, map[string]int64{"new1": 1, "new2": 2}, 3, nil},
+	}
+	for i, tt := range tests {
+		for k, rev := range tt.put {
+			c.keyToRev[k] = rev
+		}
+		c.rev, c.ops = tt.rev, nil
+		n, err := j.clean(c, now.Add(tt.after))
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if n != len(tt.deleted) || !reflect.DeepEqual(c.ops, tt.deleted) {
+			t.Errorf("#%d: expected %q deleted, got %q (%d)", i, tt.deleted, c.ops, n)
+		}
+	}
+	if _, ok := c.keyToRev["tutorial/foo"]; !ok {
+		t.Error("the tutorial key is deleted")
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/coreos/etcd-play/proc"
+)
+
+// NodeStatus is the status of a Node shown in the UI.
+type NodeStatus struct {
+	Name            string
+	ID              string
+	Endpoint        string
+	State           string
+	Hash            int
+	DbSize          uint64
+	DbSizeTxt       string
+	Uptime          string
+	LeaderChanges   int
+	RaftIndexBehind uint64
+	CatchingUp      bool
+}
+
+// newNodeStatus returns the status of the Node, or the placeholders if it
+// has no status yet.
+func newNodeStatus(name string, nameToStatus map[string]proc.ServerStatus) NodeStatus {
+	ns := NodeStatus{
+		Name:      name,
+		ID:        "unknown",
+		Endpoint:  "unknown",
+		DbSizeTxt: "0 B",
+		Uptime:    "0s",
+	}
+	if v, ok := nameToStatus[name]; ok {
+		ns.ID = v.ID
+		ns.Endpoint = v.Endpoint
+		ns.State = v.State
+		ns.Hash = v.Hash
+		ns.DbSize = v.DbSize
+		ns.DbSizeTxt = v.DbSizeTxt
+		ns.Uptime = (time.Duration(v.UptimeSeconds) * time.Second).String()
+		ns.LeaderChanges = v.LeaderChanges
+		ns.RaftIndexBehind = v.RaftIndexBehind
+		ns.CatchingUp = v.CatchingUp
+	}
+	return ns
+}
+
+// sortedNodeStatuses returns the statuses of all Nodes, sorted with
+// nameLess.
+func sortedNodeStatuses(nameToStatus map[string]proc.ServerStatus) []NodeStatus {
+	names := make([]string, 0, len(nameToStatus))
+	for name := range nameToStatus {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return nameLess(names[i], names[j]) })
+	nodes := make([]NodeStatus, 0, len(names))
+	for _, name := range names {
+		nodes = append(nodes, newNodeStatus(name, nameToStatus))
+	}
+	return nodes
+}
+
+// nameLess orders the names with the same prefix by their numeric suffix,
+// so that etcd2 comes before etcd10, and the others lexically.
+func nameLess(a, b string) bool {
+	pa, na, oka := splitNumericSuffix(a)
+	pb, nb, okb := splitNumericSuffix(b)
+	if oka && okb && pa == pb && na != nb {
+		return na < nb
+	}
+	return a < b
+}
+
+// splitNumericSuffix splits the name into the prefix and the number it
+// ends with, if any.
+func splitNumericSuffix(name string) (string, int, bool) {
+	i := len(name)
+	for i > 0 && '0' <= name[i-1] && name[i-1] <= '9' {
+		i--
+	}
+	n, err := strconv.Atoi(name[i:])
+	if err != nil {
+		return name, 0, false
+	}
+	return name[:i], n, true
+}
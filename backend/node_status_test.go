@@ -0,0 +1,93 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/coreos/etcd-play/proc"
+	"golang.org/x/net/context"
+)
+
+func TestNameLess(t *testing.T) {
+	names := []string{"etcd10", "etcd2", "8211f1d0f64f3269", "etcd1", "etcd9"}
+	sort.Slice(names, func(i, j int) bool { return nameLess(names[i], names[j]) })
+	expected := []string{"8211f1d0f64f3269", "etcd1", "etcd2", "etcd9", "etcd10"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %q, got %q", expected, names)
+	}
+}
+
+func TestServerStatusSevenNodes(t *testing.T) {
+	nameToStatus := make(map[string]proc.ServerStatus)
+	for i := 1; i <= 7; i++ {
+		name := fmt.Sprintf("etcd%d", i)
+		st := proc.ServerStatus{Name: name, ID: fmt.Sprintf("%x", i), Endpoint: fmt.Sprintf("localhost:%d379", 11+i), State: "Follower", DbSizeTxt: "20 kB", UptimeSeconds: 60}
+		if i == 7 {
+			st.State = "Leader"
+		}
+		nameToStatus[name] = st
+	}
+
+	globalStatus.mu.Lock()
+	prevStatus := globalStatus.nameToStatus
+	globalStatus.nameToStatus = nameToStatus
+	globalStatus.mu.Unlock()
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.cluster = &sizeCluster{size: 7}
+	globalCache.mu.Unlock()
+	defer func() {
+		globalStatus.mu.Lock()
+		globalStatus.nameToStatus = prevStatus
+		globalStatus.mu.Unlock()
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		globalCache.mu.Unlock()
+	}()
+
+	w := httptest.NewRecorder()
+	if err := serverStatusHandler(context.Background(), w, httptest.NewRequest("GET", "/server_status", nil)); err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Nodes       []NodeStatus
+		Etcd5_ID    string
+		Etcd5_State string
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Nodes) != 7 {
+		t.Fatalf("expected 7 nodes, got %+v", resp.Nodes)
+	}
+	for i, nd := range resp.Nodes {
+		if want := fmt.Sprintf("etcd%d", i+1); nd.Name != want || nd.ID != fmt.Sprintf("%x", i+1) || nd.Uptime != "1m0s" {
+			t.Errorf("#%d: expected the status of %s, got %+v", i, want, nd)
+		}
+	}
+	if resp.Nodes[6].State != "Leader" {
+		t.Errorf("expected etcd7 to lead, got %+v", resp.Nodes[6])
+	}
+	// the fields of the ring in the UI are kept
+	if resp.Etcd5_ID != "5" || resp.Etcd5_State != "Follower" {
+		t.Errorf("unexpected etcd5 status (%q, %q)", resp.Etcd5_ID, resp.Etcd5_State)
+	}
+}
@@ -14,10 +14,71 @@
 
 package backend
 
-import "strings"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
 
-func urlToName(s string) string {
-	ss := strings.Split(s, "_")
-	suffix := ss[len(ss)-1]
-	return "etcd" + suffix
+	"github.com/coreos/etcd-play/proc"
+)
+
+// splitPrefix trims the key and strips '--prefix' from it, and returns
+// true if it was given.
+func splitPrefix(key string) (string, bool) {
+	keyTxt := strings.TrimSpace(key)
+	if !strings.Contains(keyTxt, "--prefix") {
+		return keyTxt, false
+	}
+	return strings.TrimSpace(strings.Replace(keyTxt, "--prefix", "", 1)), true
+}
+
+// splitConsistency trims the key and strips '--consistency=serializable'
+// from it, and returns true if it was given.
+func splitConsistency(key string) (string, bool) {
+	const flag = "--consistency=serializable"
+	keyTxt := strings.TrimSpace(key)
+	if !strings.Contains(keyTxt, flag) {
+		return keyTxt, false
+	}
+	return strings.TrimSpace(strings.Replace(keyTxt, flag, "", 1)), true
+}
+
+// parseEnv parses the 'KEY=VALUE' pairs of environment variables, or
+// returns nil if none.
+func parseEnv(pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	env := make(map[string]string, len(pairs))
+	for _, p := range pairs {
+		ss := strings.SplitN(p, "=", 2)
+		if len(ss) != 2 || ss[0] == "" {
+			return nil, fmt.Errorf("environment variable %q is not 'KEY=VALUE'", p)
+		}
+		env[ss[0]] = ss[1]
+	}
+	return env, nil
+}
+
+// errToStatusCode returns the HTTP status code for the error.
+func errToStatusCode(err error) int {
+	switch {
+	case errors.Is(err, proc.ErrNodeNotFound), errors.Is(err, proc.ErrOperationNotFound), errors.Is(err, proc.ErrScenarioNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, proc.ErrNodeActive), errors.Is(err, proc.ErrNodeInactive):
+		return http.StatusConflict
+	case errors.Is(err, proc.ErrLimitInterval):
+		return http.StatusTooManyRequests
+	case errors.Is(err, proc.ErrTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, proc.ErrClusterShutdown), errors.Is(err, proc.ErrNoActiveNodes):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, proc.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, proc.ErrNoQuota):
+		return http.StatusPreconditionFailed
+	default:
+		return http.StatusInternalServerError
+	}
 }
@@ -0,0 +1,56 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitKeyFlags(t *testing.T) {
+	tests := []struct {
+		key          string
+		keyTxt       string
+		prefix       bool
+		serializable bool
+	}{
+		{" foo ", "foo", false, false},
+		{"foo --prefix", "foo", true, false},
+		{"foo --consistency=serializable", "foo", false, true},
+		{"--consistency=serializable foo --prefix", "foo", true, true},
+	}
+	for i, tt := range tests {
+		keyTxt, serializable := splitConsistency(tt.key)
+		keyTxt, prefix := splitPrefix(keyTxt)
+		if keyTxt != tt.keyTxt || prefix != tt.prefix || serializable != tt.serializable {
+			t.Errorf("#%d: expected (%q, %v, %v), got (%q, %v, %v)", i, tt.keyTxt, tt.prefix, tt.serializable, keyTxt, prefix, serializable)
+		}
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	env, err := parseEnv([]string{"GOMAXPROCS=2", "ETCD_DEBUG=", "ETCD_NAME=a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"GOMAXPROCS": "2", "ETCD_DEBUG": "", "ETCD_NAME": "a=b"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+	for _, bad := range []string{"GOMAXPROCS", "=2"} {
+		if _, err := parseEnv([]string{bad}); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
@@ -14,6 +14,10 @@
 
 package backend
 
+import "html"
+
+// boldHTMLMsg formats the message in bold. The message is escaped, since it
+// may embed the keys and values of the users.
 func boldHTMLMsg(msg string) string {
-	return "<br><b>[LOG] " + msg + "</b><br>"
+	return "<br><b>[LOG] " + html.EscapeString(msg) + "</b><br>"
 }
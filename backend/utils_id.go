@@ -17,6 +17,7 @@ package backend
 import (
 	"crypto/sha512"
 	"encoding/base64"
+	"net"
 	"net/http"
 	"strings"
 )
@@ -36,16 +37,50 @@ func hashSha512(s string) string {
 	return base64.StdEncoding.EncodeToString(sum[:])
 }
 
-func getUserID(req *http.Request) string {
-	ip := getRealIP(req)
-	if ip == "" {
-		ip = strings.Split(req.RemoteAddr, ":")[0]
+// getIP returns the IP address of the request.
+func getIP(req *http.Request) string {
+	if ip := getRealIP(req); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
 	}
-	ip = strings.Replace(ip, ".", "", -1)
+	return host
+}
+
+func getUserID(req *http.Request) string {
+	ip := strings.Replace(getIP(req), ".", "", -1)
 	ua := req.UserAgent()
 	return ip + simpleUA(ua) + hashSha512(ip + ua)[:15]
 }
 
+// maskIP masks the host portion of the IP address, keeping the first
+// octet of IPv4 and the first two groups of IPv6.
+func maskIP(ip string) string {
+	pip := net.ParseIP(ip)
+	switch {
+	case pip == nil:
+		return "x"
+	case pip.To4() != nil:
+		return strings.Split(pip.String(), ".")[0] + ".x.x.x"
+	default:
+		gs := strings.Split(pip.String(), ":")
+		return gs[0] + ":" + gs[1] + ":x"
+	}
+}
+
+// maskUserID replaces the IP address in the user ID with the masked one,
+// leaving the user-agent suffix intact.
+func maskUserID(userID, ip string) string {
+	suffix := userID
+	prefix := strings.Replace(ip, ".", "", -1)
+	if prefix != "" && strings.HasPrefix(userID, prefix) {
+		suffix = userID[len(prefix):]
+	}
+	return maskIP(ip) + "_" + suffix
+}
+
 func simpleUA(ua string) string {
 	var (
 		us  = ""
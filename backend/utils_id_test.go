@@ -0,0 +1,54 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMaskUserID(t *testing.T) {
+	tests := []struct {
+		userID   string
+		ip       string
+		expected string
+	}{
+		{"192168110linuxchromeabc", "192.168.1.10", "192.x.x.x_linuxchromeabc"},
+		{"2001:db8::1linuxchromeabc", "2001:db8::1", "2001:db8:x_linuxchromeabc"},
+		{"12", "", "x_12"},
+		{"12", "192.168.1.10", "192.x.x.x_12"},
+	}
+	for i, tt := range tests {
+		if s := maskUserID(tt.userID, tt.ip); s != tt.expected {
+			t.Errorf("#%d: expected %q, got %q", i, tt.expected, s)
+		}
+	}
+}
+
+func TestGetIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"192.168.1.10:1234", "192.168.1.10"},
+		{"[2001:db8::1]:1234", "2001:db8::1"},
+	}
+	for i, tt := range tests {
+		req := &http.Request{RemoteAddr: tt.remoteAddr, Header: make(http.Header)}
+		if ip := getIP(req); ip != tt.expected {
+			t.Errorf("#%d: expected %q, got %q", i, tt.expected, ip)
+		}
+	}
+}
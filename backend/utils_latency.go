@@ -0,0 +1,109 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxLatencySamples is the maximum number of recent samples to keep for
+// each node and operation.
+const maxLatencySamples = 1000
+
+type latencyKey struct {
+	name      string
+	operation string
+}
+
+// latencyRecorder keeps recent operation latencies of each node.
+type latencyRecorder struct {
+	mu          sync.Mutex
+	keyToSample map[latencyKey][]time.Duration
+}
+
+func newLatencyRecorder() *latencyRecorder {
+	return &latencyRecorder{keyToSample: make(map[latencyKey][]time.Duration)}
+}
+
+// record records the latency. An empty name means a random node.
+func (r *latencyRecorder) record(name, operation string, d time.Duration) {
+	if name == "" {
+		name = "random"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	k := latencyKey{name: name, operation: operation}
+	ss := append(r.keyToSample[k], d)
+	if len(ss) > maxLatencySamples {
+		ss = ss[len(ss)-maxLatencySamples:]
+	}
+	r.keyToSample[k] = ss
+}
+
+// LatencySummary is the latency percentiles of an operation on a node.
+type LatencySummary struct {
+	Node      string
+	Operation string
+	Count     int
+	P50       string
+	P90       string
+	P99       string
+}
+
+// summaries returns the latency percentiles sorted by node and operation.
+func (r *latencyRecorder) summaries() []LatencySummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ls []LatencySummary
+	for k, ss := range r.keyToSample {
+		sorted := make([]time.Duration, len(ss))
+		copy(sorted, ss)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		ls = append(ls, LatencySummary{
+			Node:      k.name,
+			Operation: k.operation,
+			Count:     len(sorted),
+			P50:       percentile(sorted, 50).String(),
+			P90:       percentile(sorted, 90).String(),
+			P99:       percentile(sorted, 99).String(),
+		})
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		if ls[i].Node != ls[j].Node {
+			return ls[i].Node < ls[j].Node
+		}
+		return ls[i].Operation < ls[j].Operation
+	})
+	return ls
+}
+
+// percentile returns the p-th percentile of the sorted samples, using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,72 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		if v := percentile(sorted, tt.p); v != tt.expected {
+			t.Errorf("#%d: expected %v, got %v", i, tt.expected, v)
+		}
+	}
+	if v := percentile(nil, 50); v != 0 {
+		t.Errorf("expected 0 for no samples, got %v", v)
+	}
+	if v := percentile(sorted[:1], 99); v != time.Millisecond {
+		t.Errorf("expected the only sample, got %v", v)
+	}
+}
+
+func TestLatencyRecorder(t *testing.T) {
+	r := newLatencyRecorder()
+	for i := 0; i < maxLatencySamples+10; i++ {
+		r.record("etcd1", "PUT", time.Duration(i)*time.Millisecond)
+	}
+	r.record("etcd2", "PUT", time.Second)
+	r.record("etcd1", "GET", time.Second)
+
+	ls := r.summaries()
+	if len(ls) != 3 {
+		t.Fatalf("expected 3 summaries, got %+v", ls)
+	}
+	if ls[0].Node != "etcd1" || ls[0].Operation != "GET" || ls[1].Operation != "PUT" || ls[2].Node != "etcd2" {
+		t.Errorf("unexpected order %+v", ls)
+	}
+	if ls[1].Count != maxLatencySamples {
+		t.Errorf("expected %d samples, got %d", maxLatencySamples, ls[1].Count)
+	}
+	// oldest 10 samples are dropped, so the minimum is 10ms
+	if ls[1].P50 != (time.Duration(maxLatencySamples/2+9) * time.Millisecond).String() {
+		t.Errorf("unexpected P50 %s", ls[1].P50)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"bytes"
+	"io/ioutil"
+	"text/template"
+)
+
+// defaultClusterDescription is where the cluster is said to be deployed,
+// unless set with --cluster-description.
+const defaultClusterDescription = "deployed in cloud environment"
+
+// defaultWelcomeTemplate is the welcome message, unless replaced with
+// --welcome-template. It is rendered with welcomeData as HTML.
+const defaultWelcomeTemplate = `<br><b>[LOG] Hello World! Welcome to etcd playground!</b><br><br>
+- You've joined an <a href="https://github.com/coreos/etcd" target="_blank"><b>etcd</b></a> cluster <i>with {{.OtherUsers}} other user(s) now</i>.<br>
+- This is a <b>real</b> <a href="https://github.com/coreos/etcd" target="_blank"><b>etcd</b></a> cluster of {{.ClusterSize}} nodes, {{.Description}} <font color="red"><i>(not a simulator)</i></font>.<br>
+- <a href="https://github.com/coreos/etcd" target="_blank"><b>etcd</b></a> is distributed reliable key-value store for the most critical data of a distributed system.<br>
+- Using <a href="https://raft.github.io" target="_blank">Raft</a>, <a href="https://github.com/coreos/etcd" target="_blank"><b>etcd</b></a> gracefully handles <b>network partitions</b> and <b>machine failures</b>, even <b><font color='red'>leader failures</font></b>.<br>
+- Tutorials and source code can be found at <a href="https://github.com/coreos/etcd-play" target="_blank"><b>coreos/etcd-play</b></a>.<br>
+- This runs <b>master branch of <a href="https://github.com/coreos/etcd" target="_blank">etcd</a></b>. For any issues or questions, please report at <i><b><a href="https://github.com/coreos/etcd-play/issues/new" target="_blank">issues</a></b></i>.<br>
+- Please click <font color='#0000A0'>circle(node)</font> for more node information (<font color='green'>green</font> is leader, <font color='blue'>blue</font> is follower).<br>
+- <font color='red'>Kill</font> to stop node(even the <font color='green'><b>leader</b></font>). <font color='red'>Restart</font> to recover node.<br>
+- <font color='blue'>Hash</font> shows how <b>etcd</b>, <i>as a distributed database</i>, <b>keeps its data consistent</b>.<br>
+- Select <b>any endpoint</b><i>(etcd1, etcd2, ...)</i> to PUT, GET, DELETE, and then click <b>Submit</b>.<br>
+- Pass <b><i>--prefix</i></b> to GET and DELETE to query by prefix.<br>
+- Pass <b><i>--consistency=serializable</i></b> to GET to read from the selected node without the leader (might be stale).<br>
+<br>
+<i>Note: Request logs are streamed based on your IP and user agent. So if you have multiple<br>
+web browsers running at the same time, logs might be shown only in one of them.</i><br>
+`
+
+// welcomeData is the data of the welcome template.
+type welcomeData struct {
+	// OtherUsers is the number of the other users of the cluster now.
+	OtherUsers int
+
+	// ClusterSize is the number of Nodes in the cluster, or of the Nodes
+	// to start if the cluster is not active yet.
+	ClusterSize int
+
+	// Description is the --cluster-description, such as where the
+	// cluster is deployed.
+	Description string
+}
+
+// globalWelcome renders the welcome message.
+var globalWelcome = template.Must(template.New("welcome").Parse(defaultWelcomeTemplate))
+
+// parseWelcomeTemplate parses the template file, and renders it once, so
+// that the unknown fields fail at start rather than per user.
+func parseWelcomeTemplate(path string) (*template.Template, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New("welcome").Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	if err = tmpl.Execute(ioutil.Discard, welcomeData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func getWelcomeMsg() string {
+	globalCache.mu.Lock()
+	data := welcomeData{
+		OtherUsers:  len(globalCache.users) - 1,
+		ClusterSize: globalFlags.ClusterSize,
+		Description: globalFlags.ClusterDescription,
+	}
+	cluster := globalCache.cluster
+	globalCache.mu.Unlock()
+
+	if cluster != nil {
+		_, nameToEndpoint, _ := cluster.Endpoints()
+		data.ClusterSize = len(nameToEndpoint)
+	}
+	var buf bytes.Buffer
+	if err := globalWelcome.Execute(&buf, data); err != nil {
+		logger.Errorf("welcome template error (%v)", err)
+		return boldHTMLMsg("Hello World! Welcome to etcd playground!")
+	}
+	return buf.String()
+}
@@ -0,0 +1,95 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package backend
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/etcd-play/proc"
+)
+
+// sizeCluster has the Nodes etcd1 to etcdN.
+type sizeCluster struct {
+	proc.Cluster
+	size int
+}
+
+func (c *sizeCluster) Endpoints() ([]string, map[string]string, map[string]string) {
+	nameToEndpoint := make(map[string]string)
+	for i := 1; i <= c.size; i++ {
+		nameToEndpoint[fmt.Sprintf("etcd%d", i)] = fmt.Sprintf("localhost:%d", 2378+i)
+	}
+	return nil, nameToEndpoint, nil
+}
+
+func TestWelcomeMsg(t *testing.T) {
+	prevFlags, prevWelcome := globalFlags, globalWelcome
+	globalCache.mu.Lock()
+	prevCluster := globalCache.cluster
+	globalCache.mu.Unlock()
+	defer func() {
+		globalFlags = prevFlags
+		globalWelcome = prevWelcome
+		globalCache.mu.Lock()
+		globalCache.cluster = prevCluster
+		globalCache.mu.Unlock()
+	}()
+	globalFlags.ClusterSize = 5
+	globalFlags.ClusterDescription = defaultClusterDescription
+
+	// the size to start, until the cluster is active
+	globalCache.mu.Lock()
+	globalCache.cluster = nil
+	globalCache.mu.Unlock()
+	if msg := getWelcomeMsg(); !strings.Contains(msg, "cluster of 5 nodes, deployed in cloud environment") {
+		t.Errorf("expected the flag size in %q", msg)
+	}
+
+	globalCache.mu.Lock()
+	globalCache.cluster = &sizeCluster{size: 3}
+	globalCache.mu.Unlock()
+	if msg := getWelcomeMsg(); !strings.Contains(msg, "cluster of 3 nodes,") {
+		t.Errorf("expected the cluster size in %q", msg)
+	}
+
+	dir, err := ioutil.TempDir("", "etcd-play-welcome")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "welcome.html")
+	if err := ioutil.WriteFile(path, []byte("<b>Acme demo</b>: {{.ClusterSize}} nodes {{.Description}}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if globalWelcome, err = parseWelcomeTemplate(path); err != nil {
+		t.Fatal(err)
+	}
+	globalFlags.ClusterDescription = "on a laptop"
+	if msg := getWelcomeMsg(); msg != "<b>Acme demo</b>: 3 nodes on a laptop" {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{{.Unknown}}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseWelcomeTemplate(path); err == nil {
+		t.Error("expected the error of the unknown field")
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "errors"
+
+var (
+	// ErrNodeNotFound is returned when the named node does not exist.
+	ErrNodeNotFound = errors.New("does not exist")
+
+	// ErrNodeActive is returned when the node is already running.
+	ErrNodeActive = errors.New("is already running or requested to restart")
+
+	// ErrNodeInactive is returned when the node is already terminated.
+	ErrNodeInactive = errors.New("is already terminated or requested to terminate")
+
+	// ErrLimitInterval is returned when the node was terminated or
+	// restarted within the limit interval.
+	ErrLimitInterval = errors.New("rate limited")
+
+	// ErrTimeout is returned when an operation times out.
+	ErrTimeout = errors.New("timed out")
+
+	// ErrClusterShutdown is returned when the cluster is shutting down.
+	ErrClusterShutdown = errors.New("cluster is shutting down")
+
+	// ErrNoActiveNodes is returned when no node is active to serve the
+	// request.
+	ErrNoActiveNodes = errors.New("no active node (cluster is down, revive it first)")
+
+	// ErrOperationNotFound is returned when the operation already
+	// completed, or never started.
+	ErrOperationNotFound = errors.New("does not exist or already completed")
+
+	// ErrStreamNotFound is returned when the stream to resume is already
+	// closed, or never opened.
+	ErrStreamNotFound = errors.New("stream does not exist or already closed")
+
+	// ErrScenarioNotFound is returned when RunScenario is given a name
+	// that is not one of Scenarios.
+	ErrScenarioNotFound = errors.New("is not a known scenario")
+
+	// ErrTooLarge is returned when a key or value is larger than the size
+	// limit of the cluster.
+	ErrTooLarge = errors.New("exceeds the size limit")
+
+	// ErrNoQuota is returned when FillUntilQuota is run on a cluster
+	// without the space quota, which it would fill up to the disk.
+	ErrNoQuota = errors.New("has no space quota to fill (set --quota-backend-bytes)")
+)
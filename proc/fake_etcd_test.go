@@ -0,0 +1,751 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// fakeEtcd is an in-memory etcd v3 gRPC server with just enough of the KV,
+// Watch, Cluster and Maintenance APIs for the proc tests. Unimplemented
+// methods panic through the nil embedded interfaces.
+type fakeEtcd struct {
+	pb.KVServer
+	pb.ClusterServer
+	pb.MaintenanceServer
+
+	*fakeStore
+
+	id uint64
+
+	// partitioned fails linearizable reads, as a member cut off from the
+	// leader cannot serve them. Guarded by the store mutex.
+	partitioned bool
+
+	// noLeader reports no leader in Status, as a member that cannot form
+	// a quorum. Guarded by the store mutex.
+	noLeader bool
+
+	// hashes are returned in turn by Hash instead of the revision, if
+	// any. Guarded by the store mutex.
+	hashes    []uint32
+	hashCalls int
+
+	// onStatus and onRange are called by Status and Range, if any,
+	// without holding the store mutex. Guarded by the store mutex.
+	onStatus func()
+	onRange  func()
+
+	// noLeaderPuts is the number of the next Puts to fail with no leader,
+	// as during an election. Guarded by the store mutex.
+	noLeaderPuts int
+
+	// noLeaderTxns is the same as noLeaderPuts, for Txns.
+	noLeaderTxns int
+
+	// noQuorum fails Status while it returns true, as a member without
+	// the quorum serves no clients. It is called without holding the store
+	// mutex. Guarded by the store mutex.
+	noQuorum func() bool
+
+	// health reports the health on the client URL, if any, instead of
+	// whether there is a leader. It is called without holding the store
+	// mutex. Guarded by the store mutex.
+	health func() bool
+
+	addr string
+	srv  *grpc.Server
+}
+
+// fakeStore is the key space shared by the members of a fake cluster.
+type fakeStore struct {
+	mu       sync.Mutex
+	rev      int64
+	kvs      map[string]*mvccpb.KeyValue
+	watchers map[chan *mvccpb.Event]fakeRange // channel to the watched keys
+	failKeys map[string]struct{}              // keys to fail Put
+
+	// history is the events since compactRev, for the watches from a
+	// past revision.
+	history    []*mvccpb.Event
+	compactRev int64
+
+	// dbSize is the size of the database file, which grows with every
+	// event, and shrinks only to the retained revisions by Defragment.
+	dbSize int64
+
+	// quota is the database size past which Put raises the NOSPACE alarm,
+	// or zero for no quota. The Puts fail while any alarm is raised.
+	quota  int64
+	alarms []*pb.AlarmMember
+	// members is the membership of the cluster, as changed by MemberAdd
+	// and MemberRemove.
+	members []*pb.Member
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		rev:      1,
+		kvs:      make(map[string]*mvccpb.KeyValue),
+		watchers: make(map[chan *mvccpb.Event]fakeRange),
+	}
+}
+
+// newFakeEtcd starts a fakeEtcd serving the store, listening on a random
+// local port, which is stopped at the end of the test.
+func newFakeEtcd(t testing.TB, id uint64, store *fakeStore) *fakeEtcd {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveFakeEtcd(t, l, id, store)
+}
+
+// serveFakeEtcd starts a fakeEtcd serving the store on the listener.
+func serveFakeEtcd(t testing.TB, l net.Listener, id uint64, store *fakeStore) *fakeEtcd {
+	f := &fakeEtcd{
+		fakeStore: store,
+		id:        id,
+		addr:      l.Addr().String(),
+		srv:       grpc.NewServer(),
+	}
+	pb.RegisterKVServer(f.srv, f)
+	pb.RegisterWatchServer(f.srv, f)
+	pb.RegisterClusterServer(f.srv, f)
+	pb.RegisterMaintenanceServer(f.srv, f)
+	gl := &grpcListener{Listener: l, connc: make(chan net.Conn), donec: make(chan struct{})}
+	go gl.serve(f.serveHealth)
+	go f.srv.Serve(gl)
+	t.Cleanup(f.srv.Stop)
+	return f
+}
+
+// grpcListener passes the gRPC connections of the listener to the gRPC
+// server, and serves the others as HTTP/1 requests, as etcd serves both
+// the gRPC and the health check on the client URL.
+type grpcListener struct {
+	net.Listener
+	connc chan net.Conn
+	once  sync.Once
+	donec chan struct{}
+}
+
+func (l *grpcListener) serve(serveHTTP func(net.Conn, *http.Request)) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.Close()
+			return
+		}
+		go func() {
+			// gRPC starts with the preface of HTTP/2, "PRI * HTTP/2.0"
+			br := bufio.NewReader(conn)
+			b, err := br.Peek(3)
+			if err == nil && string(b) == "PRI" {
+				select {
+				case l.connc <- &peekedConn{Conn: conn, r: br}:
+				case <-l.donec:
+					conn.Close()
+				}
+				return
+			}
+			defer conn.Close()
+			if req, err := http.ReadRequest(br); err == nil {
+				serveHTTP(conn, req)
+			}
+		}()
+	}
+}
+
+func (l *grpcListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connc:
+		return conn, nil
+	case <-l.donec:
+		return nil, fmt.Errorf("listener closed")
+	}
+}
+
+// Close closes the listener, so that the health checks fail as well once
+// the gRPC server stops.
+func (l *grpcListener) Close() error {
+	l.once.Do(func() { close(l.donec) })
+	return l.Listener.Close()
+}
+
+// peekedConn reads the bytes peeked from the connection first.
+type peekedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *peekedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// serveHealth answers the health check of the client URL, which is healthy
+// while there is a leader, unless overridden by health.
+func (f *fakeEtcd) serveHealth(conn net.Conn, req *http.Request) {
+	f.mu.Lock()
+	healthy, health := !f.noLeader, f.health
+	f.mu.Unlock()
+	if health != nil {
+		healthy = health()
+	}
+	resp := &http.Response{StatusCode: http.StatusNotFound, ProtoMajor: 1, ProtoMinor: 1, Close: true}
+	if req.URL.Path == "/health" {
+		body := fmt.Sprintf(`{"health": "%v"}`, healthy)
+		resp.StatusCode = http.StatusOK
+		resp.ContentLength = int64(len(body))
+		resp.Body = ioutil.NopCloser(strings.NewReader(body))
+	}
+	resp.Write(conn)
+}
+
+// fakeRange is the key range of a request, which is the single key if end
+// is empty.
+type fakeRange struct {
+	key, end []byte
+}
+
+// contains returns true if the key is in the range. The "\x00" range end
+// means all keys from the key.
+func (r fakeRange) contains(key []byte) bool {
+	if len(r.end) == 0 {
+		return bytes.Equal(key, r.key)
+	}
+	return bytes.Compare(key, r.key) >= 0 && (bytes.Equal(r.end, []byte{0}) || bytes.Compare(key, r.end) < 0)
+}
+
+// notify sends the event to the watchers of its key, and records it in the
+// history. Caller must hold mu.
+func (f *fakeStore) notify(ev *mvccpb.Event) {
+	f.history = append(f.history, ev)
+	f.dbSize += int64(len(ev.Kv.Key) + len(ev.Kv.Value))
+	for ch, r := range f.watchers {
+		if r.contains(ev.Kv.Key) {
+			ch <- ev
+		}
+	}
+}
+
+func (f *fakeEtcd) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{MemberId: f.id, Revision: f.rev}
+}
+
+func (f *fakeEtcd) Range(ctx context.Context, r *pb.RangeRequest) (*pb.RangeResponse, error) {
+	f.mu.Lock()
+	onRange := f.onRange
+	f.mu.Unlock()
+	if onRange != nil {
+		onRange()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.partitioned && !r.Serializable {
+		return nil, fmt.Errorf("etcdserver: request timed out")
+	}
+	return f.rangeKeys(r), nil
+}
+
+// rangeKeys returns the keys in the range. Caller must hold mu.
+func (f *fakeEtcd) rangeKeys(r *pb.RangeRequest) *pb.RangeResponse {
+	resp := &pb.RangeResponse{Header: f.header()}
+	rg := fakeRange{r.Key, r.RangeEnd}
+	for _, kv := range f.kvs {
+		if rg.contains(kv.Key) {
+			resp.Kvs = append(resp.Kvs, kv)
+		}
+	}
+	sort.Slice(resp.Kvs, func(i, j int) bool { return bytes.Compare(resp.Kvs[i].Key, resp.Kvs[j].Key) < 0 })
+	resp.Count = int64(len(resp.Kvs))
+	return resp
+}
+
+func (f *fakeEtcd) Put(ctx context.Context, r *pb.PutRequest) (*pb.PutResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.failKeys[string(r.Key)]; ok {
+		return nil, fmt.Errorf("injected failure for %q", r.Key)
+	}
+	if f.noLeaderPuts > 0 {
+		f.noLeaderPuts--
+		return nil, rpctypes.ErrGRPCNoLeader
+	}
+	if f.noSpace(r) {
+		return nil, rpctypes.ErrGRPCNoSpace
+	}
+	return f.put(r), nil
+}
+
+// noSpace returns true if an alarm is raised, or the put would exceed the
+// quota, which raises the NOSPACE alarm. Caller must hold mu.
+func (f *fakeEtcd) noSpace(r *pb.PutRequest) bool {
+	if len(f.alarms) > 0 {
+		return true
+	}
+	if f.quota > 0 && f.dbSize+int64(len(r.Key)+len(r.Value)) > f.quota {
+		f.alarms = append(f.alarms, &pb.AlarmMember{MemberID: f.id, Alarm: pb.AlarmType_NOSPACE})
+		return true
+	}
+	return false
+}
+
+// Alarm lists, raises and disarms the alarms of the store.
+func (f *fakeEtcd) Alarm(ctx context.Context, r *pb.AlarmRequest) (*pb.AlarmResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &pb.AlarmResponse{Header: f.header()}
+	switch r.Action {
+	case pb.AlarmRequest_GET:
+		resp.Alarms = append(resp.Alarms, f.alarms...)
+	case pb.AlarmRequest_ACTIVATE:
+		am := &pb.AlarmMember{MemberID: r.MemberID, Alarm: r.Alarm}
+		f.alarms = append(f.alarms, am)
+		resp.Alarms = append(resp.Alarms, am)
+	case pb.AlarmRequest_DEACTIVATE:
+		var kept []*pb.AlarmMember
+		for _, am := range f.alarms {
+			if am.MemberID == r.MemberID && am.Alarm == r.Alarm {
+				resp.Alarms = append(resp.Alarms, am)
+			} else {
+				kept = append(kept, am)
+			}
+		}
+		f.alarms = kept
+	}
+	return resp, nil
+}
+
+// put puts the key-value. Caller must hold mu.
+func (f *fakeEtcd) put(r *pb.PutRequest) *pb.PutResponse {
+	f.rev++
+	kv := &mvccpb.KeyValue{Key: r.Key, Value: r.Value, CreateRevision: f.rev, ModRevision: f.rev, Version: 1}
+	resp := &pb.PutResponse{Header: f.header()}
+	if prev, ok := f.kvs[string(r.Key)]; ok {
+		kv.CreateRevision, kv.Version = prev.CreateRevision, prev.Version+1
+		if r.PrevKv {
+			resp.PrevKv = prev
+		}
+	}
+	f.kvs[string(r.Key)] = kv
+	f.notify(&mvccpb.Event{Type: mvccpb.PUT, Kv: kv})
+	return resp
+}
+
+// Txn supports the equal comparisons of values, create and mod revisions, with
+// Range, Put and DeleteRange requests.
+func (f *fakeEtcd) Txn(ctx context.Context, r *pb.TxnRequest) (*pb.TxnResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.noLeaderTxns > 0 {
+		f.noLeaderTxns--
+		return nil, rpctypes.ErrGRPCNoLeader
+	}
+	succeeded := true
+	for _, cmp := range r.Compare {
+		if cmp.Result != pb.Compare_EQUAL {
+			return nil, fmt.Errorf("unsupported compare result %v", cmp.Result)
+		}
+		kv, ok := f.kvs[string(cmp.Key)]
+		switch tu := cmp.TargetUnion.(type) {
+		case *pb.Compare_Value:
+			succeeded = succeeded && ok && bytes.Equal(kv.Value, tu.Value)
+		case *pb.Compare_CreateRevision:
+			var crev int64
+			if ok {
+				crev = kv.CreateRevision
+			}
+			succeeded = succeeded && crev == tu.CreateRevision
+		case *pb.Compare_ModRevision:
+			var mrev int64
+			if ok {
+				mrev = kv.ModRevision
+			}
+			succeeded = succeeded && mrev == tu.ModRevision
+		default:
+			return nil, fmt.Errorf("unsupported compare target %v", cmp.Target)
+		}
+	}
+	reqs := r.Success
+	if !succeeded {
+		reqs = r.Failure
+	}
+	resp := &pb.TxnResponse{Succeeded: succeeded}
+	for _, req := range reqs {
+		switch rv := req.Request.(type) {
+		case *pb.RequestOp_RequestRange:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseRange{ResponseRange: f.rangeKeys(rv.RequestRange)}})
+		case *pb.RequestOp_RequestPut:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponsePut{ResponsePut: f.put(rv.RequestPut)}})
+		case *pb.RequestOp_RequestDeleteRange:
+			resp.Responses = append(resp.Responses, &pb.ResponseOp{Response: &pb.ResponseOp_ResponseDeleteRange{ResponseDeleteRange: f.deleteRange(rv.RequestDeleteRange)}})
+		default:
+			return nil, fmt.Errorf("unsupported request %v", req)
+		}
+	}
+	resp.Header = f.header()
+	return resp, nil
+}
+
+func (f *fakeEtcd) DeleteRange(ctx context.Context, r *pb.DeleteRangeRequest) (*pb.DeleteRangeResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deleteRange(r), nil
+}
+
+// deleteRange deletes the keys in the range. Caller must hold mu.
+func (f *fakeEtcd) deleteRange(r *pb.DeleteRangeRequest) *pb.DeleteRangeResponse {
+	resp := &pb.DeleteRangeResponse{}
+	rg := fakeRange{r.Key, r.RangeEnd}
+	for k, kv := range f.kvs {
+		if !rg.contains(kv.Key) {
+			continue
+		}
+		if resp.Deleted == 0 {
+			f.rev++
+		}
+		delete(f.kvs, k)
+		resp.Deleted++
+		f.notify(&mvccpb.Event{Type: mvccpb.DELETE, Kv: &mvccpb.KeyValue{Key: kv.Key, ModRevision: f.rev}})
+	}
+	resp.Header = f.header()
+	return resp
+}
+
+// Watch serves the watchers created on the stream, each until it is
+// canceled or the stream ends.
+func (f *fakeEtcd) Watch(stream pb.Watch_WatchServer) error {
+	var (
+		smu     sync.Mutex
+		wg      sync.WaitGroup
+		nextID  int64
+		cancels = make(map[int64]context.CancelFunc)
+	)
+	send := func(resp *pb.WatchResponse) error {
+		smu.Lock()
+		defer smu.Unlock()
+		return stream.Send(resp)
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+		wg.Wait()
+	}()
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if cr := req.GetCancelRequest(); cr != nil {
+			if cancel, ok := cancels[cr.WatchId]; ok {
+				cancel()
+				delete(cancels, cr.WatchId)
+				f.mu.Lock()
+				hdr := f.header()
+				f.mu.Unlock()
+				if err := send(&pb.WatchResponse{Header: hdr, WatchId: cr.WatchId, Canceled: true}); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		cr := req.GetCreateRequest()
+		if cr == nil {
+			continue
+		}
+		ctx, cancel := context.WithCancel(stream.Context())
+		cancels[nextID] = cancel
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			f.serveWatch(ctx, id, cr, send)
+		}(nextID)
+		nextID++
+	}
+}
+
+// serveWatch sends the created response, the past events since the start
+// revision and then the live events, until ctx is done.
+func (f *fakeEtcd) serveWatch(ctx context.Context, id int64, cr *pb.WatchCreateRequest, send func(*pb.WatchResponse) error) {
+	rg := fakeRange{cr.Key, cr.RangeEnd}
+	evc := make(chan *mvccpb.Event, 16)
+	f.mu.Lock()
+	if cr.StartRevision > 0 && cr.StartRevision < f.compactRev {
+		resp := &pb.WatchResponse{Header: f.header(), WatchId: id, Created: true, Canceled: true, CompactRevision: f.compactRev}
+		f.mu.Unlock()
+		send(resp)
+		return
+	}
+	// the past events, which the live events follow
+	var past []*mvccpb.Event
+	for _, ev := range f.history {
+		if cr.StartRevision > 0 && ev.Kv.ModRevision >= cr.StartRevision && rg.contains(ev.Kv.Key) {
+			past = append(past, ev)
+		}
+	}
+	f.watchers[evc] = rg
+	hdr := f.header()
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.watchers, evc)
+		f.mu.Unlock()
+	}()
+
+	if err := send(&pb.WatchResponse{Header: hdr, WatchId: id, Created: true}); err != nil {
+		return
+	}
+	for _, ev := range past {
+		if err := send(&pb.WatchResponse{Header: hdr, WatchId: id, Events: []*mvccpb.Event{ev}}); err != nil {
+			return
+		}
+	}
+	for {
+		select {
+		case ev := <-evc:
+			f.mu.Lock()
+			hdr := f.header()
+			f.mu.Unlock()
+			if err := send(&pb.WatchResponse{Header: hdr, WatchId: id, Events: []*mvccpb.Event{ev}}); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// compact discards the history before the revision.
+func (f *fakeStore) compact(rev int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.compactRev = rev
+	for len(f.history) > 0 && f.history[0].Kv.ModRevision < rev {
+		f.history = f.history[1:]
+	}
+}
+
+// inUse returns the size of the retained revisions, which are the history
+// since the compaction and the latest revisions compacted from it. Caller
+// must hold mu.
+func (f *fakeStore) inUse() int64 {
+	var n int64
+	for _, ev := range f.history {
+		n += int64(len(ev.Kv.Key) + len(ev.Kv.Value))
+	}
+	for _, kv := range f.kvs {
+		if kv.ModRevision < f.compactRev {
+			n += int64(len(kv.Key) + len(kv.Value))
+		}
+	}
+	return n
+}
+
+func (f *fakeEtcd) Compact(ctx context.Context, r *pb.CompactionRequest) (*pb.CompactionResponse, error) {
+	f.compact(r.Revision)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &pb.CompactionResponse{Header: f.header()}, nil
+}
+
+func (f *fakeEtcd) Defragment(ctx context.Context, r *pb.DefragmentRequest) (*pb.DefragmentResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.dbSize = f.inUse()
+	return &pb.DefragmentResponse{Header: f.header()}, nil
+}
+
+// numWatchers returns the number of open watchers.
+func (f *fakeStore) numWatchers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.watchers)
+}
+
+func (f *fakeEtcd) MemberList(ctx context.Context, r *pb.MemberListRequest) (*pb.MemberListResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &pb.MemberListResponse{Header: f.header(), Members: f.members}, nil
+}
+
+// MemberAdd adds an unstarted member with the peer URLs, which has no name
+// nor client URLs until it starts, under the next unused ID.
+func (f *fakeEtcd) MemberAdd(ctx context.Context, r *pb.MemberAddRequest) (*pb.MemberAddResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := &pb.Member{ID: 1, PeerURLs: r.PeerURLs}
+	for _, mm := range f.members {
+		if mm.ID >= m.ID {
+			m.ID = mm.ID + 1
+		}
+	}
+	// the members are copied on write, as MemberList returns them
+	f.members = append(append([]*pb.Member{}, f.members...), m)
+	return &pb.MemberAddResponse{Header: f.header(), Member: m}, nil
+}
+
+func (f *fakeEtcd) MemberRemove(ctx context.Context, r *pb.MemberRemoveRequest) (*pb.MemberRemoveResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, m := range f.members {
+		if m.ID == r.ID {
+			members := append([]*pb.Member{}, f.members[:i]...)
+			f.members = append(members, f.members[i+1:]...)
+			return &pb.MemberRemoveResponse{Header: f.header()}, nil
+		}
+	}
+	return nil, rpctypes.ErrGRPCMemberNotFound
+}
+
+func (f *fakeEtcd) Status(ctx context.Context, r *pb.StatusRequest) (*pb.StatusResponse, error) {
+	f.mu.Lock()
+	onStatus, noQuorum := f.onStatus, f.noQuorum
+	f.mu.Unlock()
+	if onStatus != nil {
+		onStatus()
+	}
+	if noQuorum != nil && noQuorum() {
+		return nil, rpctypes.ErrGRPCNoLeader
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &pb.StatusResponse{Header: f.header(), Version: "3.0.0", Leader: 1, DbSize: f.dbSize}
+	if f.noLeader {
+		resp.Leader = 0
+	}
+	return resp, nil
+}
+
+const (
+	boltMagic     = 0xED0CDAED
+	boltVersion   = 2
+	boltPageSize  = 4096
+	boltMetaFlag  = 0x04
+	boltPageHdr   = 16 // id, flags, count and overflow of a page
+	boltMetaSize  = 56 // the meta fields before the checksum
+	boltHeaderLen = 2 * boltPageSize
+)
+
+// boltHeader returns the two meta pages that a bolt database starts with,
+// as written by bolt: each page header is followed by the meta fields and
+// their FNV-1a checksum, in the byte order of the host (little endian).
+func boltHeader() []byte {
+	b := make([]byte, boltHeaderLen)
+	for i := 0; i < 2; i++ {
+		p := b[i*boltPageSize:]
+		binary.LittleEndian.PutUint64(p[0:], uint64(i)) // page id
+		binary.LittleEndian.PutUint16(p[8:], boltMetaFlag)
+		m := p[boltPageHdr:]
+		binary.LittleEndian.PutUint32(m[0:], boltMagic)
+		binary.LittleEndian.PutUint32(m[4:], boltVersion)
+		binary.LittleEndian.PutUint32(m[8:], boltPageSize)
+		binary.LittleEndian.PutUint64(m[16:], 3)         // root bucket page
+		binary.LittleEndian.PutUint64(m[32:], 2)         // freelist page
+		binary.LittleEndian.PutUint64(m[40:], 4)         // high water mark
+		binary.LittleEndian.PutUint64(m[48:], uint64(i)) // transaction ID
+		h := fnv.New64a()
+		h.Write(m[:boltMetaSize])
+		binary.LittleEndian.PutUint64(m[boltMetaSize:], h.Sum64())
+	}
+	return b
+}
+
+// Snapshot sends the bolt header and the key-values as JSON, which the
+// fake etcdctl restores into the data directory as the database file, and
+// loadSnapshot reads.
+func (f *fakeEtcd) Snapshot(r *pb.SnapshotRequest, stream pb.Maintenance_SnapshotServer) error {
+	f.mu.Lock()
+	blob, err := json.Marshal(f.kvs)
+	f.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err = stream.Send(&pb.SnapshotResponse{Blob: boltHeader()}); err != nil {
+		return err
+	}
+	return stream.Send(&pb.SnapshotResponse{Blob: blob})
+}
+
+// loadSnapshot replaces the key-values of the store with the ones of the
+// snapshot, as etcd starting from the restored data directory.
+func (f *fakeStore) loadSnapshot(db []byte) error {
+	if len(db) < boltHeaderLen {
+		return fmt.Errorf("snapshot of %d bytes has no bolt header", len(db))
+	}
+	kvs := make(map[string]*mvccpb.KeyValue)
+	if err := json.Unmarshal(db[boltHeaderLen:], &kvs); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kvs = kvs
+	for _, kv := range kvs {
+		if kv.ModRevision > f.rev {
+			f.rev = kv.ModRevision
+		}
+	}
+	return nil
+}
+
+func (f *fakeEtcd) Hash(ctx context.Context, r *pb.HashRequest) (*pb.HashResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resp := &pb.HashResponse{Header: f.header(), Hash: uint32(f.rev)}
+	if len(f.hashes) > 0 {
+		resp.Hash = f.hashes[f.hashCalls%len(f.hashes)]
+		f.hashCalls++
+	}
+	return resp, nil
+}
+
+// setClientURL points the node's advertised and listen client URLs at u.
+func setClientURL(fs *Flags, u string) {
+	fs.ListenClientURLs = map[string]struct{}{u: {}}
+	fs.AdvertiseClientURLs = map[string]struct{}{u: {}}
+}
+
+// newFakeEtcdCluster creates a test cluster of the given size whose nodes
+// run "sleep", and points each node's client URL at a fakeEtcd.
+func newFakeEtcdCluster(t testing.TB, size int, opts ...OpOption) (*defaultCluster, []*fakeEtcd) {
+	c := newTestCluster(t, size, "sleep 10 #", opts...)
+	store := newFakeStore()
+	fakes := make([]*fakeEtcd, size)
+	for i := range fakes {
+		fakes[i] = newFakeEtcd(t, uint64(i+1), store)
+		nd := c.nameToNode[fmt.Sprintf("etcd%d", i+1)].(*NodeWebLocal)
+		setClientURL(nd.Flags, "http://"+fakes[i].addr)
+	}
+	return c, fakes
+}
@@ -18,6 +18,8 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net/url"
+	"os/exec"
 	"reflect"
 	"sort"
 	"strings"
@@ -42,6 +44,20 @@ type Flags struct {
 
 	ClientAutoTLS bool `flag:"auto-tls"`
 	PeerAutoTLS   bool `flag:"peer-auto-tls"`
+
+	// zero values are omitted to use etcd defaults
+	QuotaBackendBytes       int64  `flag:"quota-backend-bytes"`
+	SnapshotCount           uint64 `flag:"snapshot-count"`
+	AutoCompactionRetention int    `flag:"auto-compaction-retention"`
+
+	// ProgramPath overrides the etcd binary of the cluster for this node,
+	// to run a mixed-version cluster. It is not an etcd flag.
+	ProgramPath string
+
+	// Env sets the environment variables of this node, such as ETCD_... or
+	// GOMAXPROCS, over the ones inherited from etcd-play. They are not etcd
+	// flags, and only applicable for local nodes.
+	Env map[string]string
 }
 
 func defaultFlags() *Flags {
@@ -114,6 +130,9 @@ func CombineFlags(remote bool, cs ...*Flags) error {
 	nameToPeerURL := make(map[string]string)
 	portCheck := ""
 	for i := range cs {
+		if _, err := cs[i].IsValid(); err != nil {
+			return err
+		}
 		if _, ok := nameToPeerURL[cs[i].Name]; ok {
 			return fmt.Errorf("%s is duplicate!", cs[i].Name)
 		}
@@ -133,6 +152,83 @@ func CombineFlags(remote bool, cs ...*Flags) error {
 	return nil
 }
 
+// Validate checks the flags of a cluster without starting anything, and
+// returns every problem found: invalid flags, duplicate names, missing
+// ProgramPath, invalid Env names, malformed URLs, overlapping listen
+// addresses, and inconsistent InitialCluster.
+func Validate(fs []*Flags) error {
+	var (
+		errs           []error
+		names          = make(map[string]struct{})
+		hostToName     = make(map[string]string)
+		initialCluster = ""
+	)
+	for _, f := range fs {
+		if _, err := f.IsValid(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", f.Name, err))
+		}
+		if _, ok := names[f.Name]; ok {
+			errs = append(errs, fmt.Errorf("%s is duplicate", f.Name))
+		}
+		if strings.TrimSpace(f.ProgramPath) != "" {
+			if _, err := exec.LookPath(strings.Fields(f.ProgramPath)[0]); err != nil {
+				errs = append(errs, fmt.Errorf("%s: ProgramPath %q not found (%v)", f.Name, f.ProgramPath, err))
+			}
+		}
+		names[f.Name] = struct{}{}
+		for _, k := range sortedKeys(f.Env) {
+			if k == "" || strings.ContainsAny(k, "=\x00") {
+				errs = append(errs, fmt.Errorf("%s: invalid environment variable name %q", f.Name, k))
+			}
+		}
+
+		for i, m := range []map[string]struct{}{f.ListenClientURLs, f.ListenPeerURLs, f.AdvertiseClientURLs, f.AdvertisePeerURLs} {
+			for _, s := range strings.Split(mapToCommaString(m), ",") {
+				if s == "" {
+					continue
+				}
+				u, err := url.Parse(s)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Port() == "" {
+					errs = append(errs, fmt.Errorf("%s: malformed URL %q", f.Name, s))
+					continue
+				}
+				if i > 1 { // advertise URLs may be shared with listen URLs
+					continue
+				}
+				if n, ok := hostToName[u.Host]; ok {
+					errs = append(errs, fmt.Errorf("%s: %s is already used by %s", f.Name, u.Host, n))
+					continue
+				}
+				hostToName[u.Host] = f.Name
+			}
+		}
+
+		if len(f.InitialCluster) == 0 {
+			continue
+		}
+		if initialCluster == "" {
+			initialCluster = mapToMapString(f.InitialCluster)
+		} else if ic := mapToMapString(f.InitialCluster); ic != initialCluster {
+			errs = append(errs, fmt.Errorf("%s: InitialCluster %q is different from %q", f.Name, ic, initialCluster))
+		}
+	}
+
+	if initialCluster != "" {
+		for _, f := range fs {
+			for _, g := range fs {
+				if len(g.InitialCluster) == 0 {
+					continue
+				}
+				if v, ok := g.InitialCluster[f.Name]; !ok || v != mapToCommaString(f.AdvertisePeerURLs) {
+					errs = append(errs, fmt.Errorf("%s: InitialCluster has %q, expected %q", f.Name, v, mapToCommaString(f.AdvertisePeerURLs)))
+				}
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (f *Flags) IsValid() (bool, error) {
 	if len(f.Name) == 0 {
 		return false, errors.New("Name must be specified!")
@@ -140,6 +236,12 @@ func (f *Flags) IsValid() (bool, error) {
 	if f.InitialClusterState != "new" && f.InitialClusterState != "existing" {
 		return false, errors.New("InitialClusterState must be either 'new' or 'existing'.")
 	}
+	if f.QuotaBackendBytes < 0 {
+		return false, fmt.Errorf("QuotaBackendBytes must not be negative (%d)", f.QuotaBackendBytes)
+	}
+	if f.AutoCompactionRetention < 0 {
+		return false, fmt.Errorf("AutoCompactionRetention must not be negative (%d)", f.AutoCompactionRetention)
+	}
 	return true, nil
 }
 
@@ -225,6 +327,30 @@ func (f *Flags) Pairs() ([][]string, error) {
 		pairs = append(pairs, []string{peerAutoTLSTag, "true"})
 	}
 
+	quotaBackendBytesTag, err := f.getTag("QuotaBackendBytes")
+	if err != nil {
+		return nil, err
+	}
+	if f.QuotaBackendBytes > 0 {
+		pairs = append(pairs, []string{quotaBackendBytesTag, fmt.Sprintf("%d", f.QuotaBackendBytes)})
+	}
+
+	snapshotCountTag, err := f.getTag("SnapshotCount")
+	if err != nil {
+		return nil, err
+	}
+	if f.SnapshotCount > 0 {
+		pairs = append(pairs, []string{snapshotCountTag, fmt.Sprintf("%d", f.SnapshotCount)})
+	}
+
+	autoCompactionRetentionTag, err := f.getTag("AutoCompactionRetention")
+	if err != nil {
+		return nil, err
+	}
+	if f.AutoCompactionRetention > 0 {
+		pairs = append(pairs, []string{autoCompactionRetentionTag, fmt.Sprintf("%d", f.AutoCompactionRetention)})
+	}
+
 	return pairs, nil
 }
 
@@ -71,3 +71,101 @@ func TestGetAllPorts(t *testing.T) {
 	}
 	fmt.Println(df.getAllPorts())
 }
+
+func TestFlagsExperimental(t *testing.T) {
+	df, err := GenerateFlags("etcd1", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf, err := df.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"quota-backend-bytes", "snapshot-count", "auto-compaction-retention"} {
+		if strings.Contains(sf, tag) {
+			t.Errorf("expected %q to be omitted in %s", tag, sf)
+		}
+	}
+
+	df.QuotaBackendBytes = 1024
+	df.SnapshotCount = 100
+	df.AutoCompactionRetention = 1
+	sf, err = df.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ss, err := df.StringSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, flag := range []string{"--quota-backend-bytes='1024'", "--snapshot-count='100'", "--auto-compaction-retention='1'"} {
+		if strings.Count(sf, flag) != 1 {
+			t.Errorf("expected %q exactly once in %s", flag, sf)
+		}
+	}
+	for _, flag := range []string{"--quota-backend-bytes", "--snapshot-count", "--auto-compaction-retention"} {
+		cn := 0
+		for _, s := range ss {
+			if s == flag {
+				cn++
+			}
+		}
+		if cn != 1 {
+			t.Errorf("expected %q exactly once in %q", flag, ss)
+		}
+	}
+
+	df.QuotaBackendBytes = -1
+	if err = CombineFlags(false, df); err == nil {
+		t.Error("expected error for negative QuotaBackendBytes")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	newFlags := func(n int) []*Flags {
+		fs := make([]*Flags, n)
+		for i := range fs {
+			df, err := GenerateFlags(fmt.Sprintf("etcd%d", i+1), "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fs[i] = df
+		}
+		return fs
+	}
+
+	tests := []struct {
+		modify func(fs []*Flags)
+		errN   int
+	}{
+		{func(fs []*Flags) {}, 0},
+		{func(fs []*Flags) { fs[1].Name = "etcd1" }, 1},
+		{func(fs []*Flags) { fs[0].ProgramPath = "sh"; fs[1].ProgramPath = "/no/such/etcd" }, 1},
+		{func(fs []*Flags) { fs[0].InitialClusterState = "unknown"; fs[1].QuotaBackendBytes = -1 }, 2},
+		{func(fs []*Flags) { fs[0].Env = map[string]string{"GOMAXPROCS": "1", "A=B": "", "": "x"} }, 2},
+		{func(fs []*Flags) { fs[0].ListenClientURLs = map[string]struct{}{"localhost": {}} }, 1},
+		{func(fs []*Flags) { fs[0].AdvertisePeerURLs = map[string]struct{}{"ftp://localhost:80": {}} }, 1},
+		{func(fs []*Flags) { fs[1].ListenClientURLs = fs[0].ListenClientURLs }, 1},
+		{func(fs []*Flags) { fs[0].ListenClientURLs = fs[0].ListenPeerURLs }, 1},
+		{func(fs []*Flags) {
+			CombineFlags(false, fs...)
+			fs[1].InitialCluster = map[string]string{"etcd1": "http://localhost:1"}
+		}, 1},
+		{func(fs []*Flags) {
+			CombineFlags(false, fs...)
+			delete(fs[0].InitialCluster, "etcd3") // shared by all nodes
+		}, 1},
+	}
+	for i, tt := range tests {
+		fs := newFlags(3)
+		tt.modify(fs)
+		err := Validate(fs)
+		errN := 0
+		if err != nil {
+			errN = len(strings.Split(err.Error(), "\n"))
+		}
+		if errN != tt.errN {
+			t.Errorf("#%d: expected %d errors, got %v", i, tt.errN, err)
+		}
+	}
+}
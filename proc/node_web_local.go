@@ -17,25 +17,31 @@ package proc
 import (
 	"bytes"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net/url"
 	"os"
 	"os/exec"
 	"sync"
 	"syscall"
 	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
 )
 
 // NodeWebLocal represents an etcd node in local web host.
 type NodeWebLocal struct {
 	pmu                *sync.Mutex // inherit from Cluster
 	pmaxProcNameLength *int
-	colorIdx           int
+	color              string
 
 	liveLog      bool
+	logFilter    *logFilter  // nil to write all log lines
+	structured   bool        // true to write the parsed log entries
 	sharedStream chan string // inherit from Cluster (no need pointer)
+	pdropped     *uint64     // inherit from Cluster
 
 	ProgramPath string
 	Flags       *Flags
@@ -47,11 +53,67 @@ type NodeWebLocal struct {
 	cmd *exec.Cmd
 	PID int
 
-	active bool
+	active    bool
+	startedAt time.Time // when the process last started or restarted
+	lastExit  ExitStatus
+	isolated  string // mechanism used to isolate the Node, if any
+
+	peerProxy *latencyProxy // in front of the peer URL, if enabled
 
 	limitInterval  time.Duration
 	lastTerminated time.Time
 	lastRestarted  time.Time
+
+	// probeTimeout is how long Start and Restart wait for the node to
+	// serve, zero not to wait.
+	probeTimeout time.Duration
+
+	hooks nodeHooks // called without pmu
+
+	// cleanPolicy decides what Clean does with the data directory, and
+	// backupDir is where CleanBackup moves it.
+	cleanPolicy CleanPolicy
+	backupDir   string
+}
+
+// ExitStatus describes how a Node process exited.
+type ExitStatus struct {
+	Code   int
+	Signal string
+	Time   time.Time
+}
+
+func (e ExitStatus) String() string {
+	if e.Time.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("code=%d signal=%s", e.Code, e.Signal)
+}
+
+// exitStatus parses the error returned by (*exec.Cmd).Wait.
+func exitStatus(err error) ExitStatus {
+	es := ExitStatus{Code: 0, Signal: "none", Time: time.Now()}
+	if err == nil {
+		return es
+	}
+	es.Code = -1
+	if ee, ok := err.(*exec.ExitError); ok {
+		if ws, ok := ee.Sys().(syscall.WaitStatus); ok {
+			es.Code = ws.ExitStatus()
+			if ws.Signaled() {
+				es.Signal = ws.Signal().String()
+			}
+		}
+	}
+	return es
+}
+
+// colorLine formats the log line of the node in its color. The line is
+// escaped, so only the color markup is rendered as HTML.
+func colorLine(color string, width int, name, line string) string {
+	format := fmt.Sprintf("%%%ds | ", width)
+	format = fmt.Sprintf(`<b><font color="%s">`, color) + format + "</font>" + "%s</b>"
+	return fmt.Sprintf(format, name, htmlReplacer.Replace(line))
 }
 
 func (nd *NodeWebLocal) Write(p []byte) (int, error) {
@@ -65,10 +127,8 @@ func (nd *NodeWebLocal) Write(p []byte) (int, error) {
 		if err != nil {
 			return wrote, err
 		}
-		if len(line) > 1 {
-			format := fmt.Sprintf("%%%ds | ", *(nd.pmaxProcNameLength))
-			format = fmt.Sprintf(`<b><font color="%s">`, colorsToHTML[nd.colorIdx]) + format + "</font>" + "%s</b>"
-			nd.sharedStream <- fmt.Sprintf(format, nd.Flags.Name, line)
+		if len(line) > 1 && nd.logFilter.allow(string(line)) {
+			nd.stream(formatLogLine(nd.structured, nd.color, *(nd.pmaxProcNameLength), nd.Flags.Name, string(line)))
 			wrote += len(line)
 		}
 	}
@@ -77,22 +137,11 @@ func (nd *NodeWebLocal) Write(p []byte) (int, error) {
 }
 
 func (nd *NodeWebLocal) Endpoint() string {
-	es := ""
-	for k := range nd.Flags.ListenClientURLs {
-		es = k
-		break
-	}
-	s, _ := url.Parse(es)
-	return s.Host
+	return clientEndpoint(nd.Flags)
 }
 
 func (nd *NodeWebLocal) StatusEndpoint() string {
-	es := ""
-	for k := range nd.Flags.ListenClientURLs {
-		es = k
-		break
-	}
-	return es
+	return clientStatusEndpoint(nd.Flags)
 }
 
 func (nd *NodeWebLocal) IsActive() bool {
@@ -103,6 +152,12 @@ func (nd *NodeWebLocal) IsActive() bool {
 }
 
 func (nd *NodeWebLocal) Start() error {
+	return nd.startNotify(nil)
+}
+
+// startNotify starts the Node, and calls started if not nil once the
+// process started, before waiting for the Node to serve.
+func (nd *NodeWebLocal) startNotify(started func()) error {
 	defer func() {
 		// if nd.TLSConfig == nil {
 		// 	tlsConfig, err := autoSelfCert(nd.TLSCertPath, nd.TLSKeyPath)
@@ -113,14 +168,14 @@ func (nd *NodeWebLocal) Start() error {
 		// }
 
 		if err := recover(); err != nil {
-			nd.sharedStream <- fmt.Sprintf("Start %s: panic (%v)\n", nd.Flags.Name, err)
+			nd.stream(fmt.Sprintf("Start %s: panic (%v)\n", nd.Flags.Name, err))
 		}
 	}()
 	nd.pmu.Lock()
 	active := nd.active
 	nd.pmu.Unlock()
 	if active {
-		return fmt.Errorf("%s is already running or requested to restart", nd.Flags.Name)
+		return fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeActive)
 	}
 
 	shell := os.Getenv("SHELL")
@@ -130,12 +185,14 @@ func (nd *NodeWebLocal) Start() error {
 	nd.pmu.Lock()
 	flagString, err := nd.Flags.String()
 	if err != nil {
+		nd.pmu.Unlock()
 		return err
 	}
 	args := []string{shell, "-c", nd.ProgramPath + " " + flagString}
+	env := mergeEnv(os.Environ(), nd.Flags.Env)
 	nd.pmu.Unlock()
 
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd := nodeCommand(args, env)
 	cmd.Stdin = nil
 	cmd.Stdout = nd
 	cmd.Stderr = nd
@@ -144,31 +201,33 @@ func (nd *NodeWebLocal) Start() error {
 		cmd.Stderr = ioutil.Discard
 	}
 
-	nd.sharedStream <- fmt.Sprintf("Start %s\n", nd.Flags.Name)
+	nd.stream(fmt.Sprintf("Start %s\n", nd.Flags.Name))
 	if err := cmd.Start(); err != nil {
 		return err
 	}
+	if started != nil {
+		started()
+	}
+	if err := nd.waitServing(cmd); err != nil {
+		return err
+	}
 
 	nd.pmu.Lock()
 	nd.cmd = cmd
 	nd.PID = cmd.Process.Pid
 	nd.active = true
+	nd.startedAt = time.Now()
 	nd.pmu.Unlock()
 
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			nd.sharedStream <- fmt.Sprintf("Start(%s) cmd.Wait returned %v\n", nd.Flags.Name, err)
-			return
-		}
-		nd.sharedStream <- fmt.Sprintf("Exiting %s\n", nd.Flags.Name)
-	}()
+	nd.hooks.started(nd.Flags.Name)
+	go nd.wait(cmd)
 	return nil
 }
 
 func (nd *NodeWebLocal) Restart() error {
 	defer func() {
 		if err := recover(); err != nil {
-			nd.sharedStream <- fmt.Sprintf("Restart %s: panic (%v)\n", nd.Flags.Name, err)
+			nd.stream(fmt.Sprintf("Restart %s: panic (%v)\n", nd.Flags.Name, err))
 		}
 	}()
 
@@ -178,18 +237,18 @@ func (nd *NodeWebLocal) Restart() error {
 	lastRestarted := nd.lastRestarted
 	nd.pmu.Unlock()
 	if active {
-		return fmt.Errorf("%s is already running or requested to restart", nd.Flags.Name)
+		return fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeActive)
 	}
 
 	// restart, 2nd restart term should be more than limit interval
 	sub := time.Now().Sub(lastRestarted)
 	if sub < nd.limitInterval {
-		return fmt.Errorf("Somebody restarted the same node (only %v ago)! Retry in %v!", sub, nd.limitInterval)
+		return fmt.Errorf("%w: somebody restarted the same node (only %v ago)! Retry in %v!", ErrLimitInterval, sub, nd.limitInterval)
 	}
 	// terminate, and immediate restart term should be more than limit interval
 	subt := time.Now().Sub(lastTerminated)
 	if subt < nd.limitInterval {
-		return fmt.Errorf("Somebody terminated the node (only %v ago)! Retry in %v!", subt, nd.limitInterval)
+		return fmt.Errorf("%w: somebody terminated the node (only %v ago)! Retry in %v!", ErrLimitInterval, subt, nd.limitInterval)
 	}
 
 	shell := os.Getenv("SHELL")
@@ -200,42 +259,64 @@ func (nd *NodeWebLocal) Restart() error {
 	nd.Flags.InitialClusterState = "existing"
 	flagString, err := nd.Flags.String()
 	if err != nil {
+		nd.pmu.Unlock()
 		return err
 	}
 	args := []string{shell, "-c", nd.ProgramPath + " " + flagString}
+	env := mergeEnv(os.Environ(), nd.Flags.Env)
 	nd.pmu.Unlock()
 
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd := nodeCommand(args, env)
 	cmd.Stdin = nil
 	cmd.Stdout = nd
 	cmd.Stderr = nd
 
-	nd.sharedStream <- fmt.Sprintf("Restart %s\n", nd.Flags.Name)
+	nd.stream(fmt.Sprintf("Restart %s\n", nd.Flags.Name))
 	if err := cmd.Start(); err != nil {
 		return err
 	}
+	if err := nd.waitServing(cmd); err != nil {
+		return err
+	}
 
 	nd.pmu.Lock()
 	nd.cmd = cmd
 	nd.PID = cmd.Process.Pid
 	nd.lastRestarted = time.Now()
 	nd.active = true
+	nd.startedAt = nd.lastRestarted
 	nd.pmu.Unlock()
 
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			nd.sharedStream <- fmt.Sprintf("Restart(%s) cmd.Wait returned %v\n", nd.Flags.Name, err)
-			return
-		}
-		nd.sharedStream <- fmt.Sprintf("Exiting %s\n", nd.Flags.Name)
-	}()
+	nd.hooks.started(nd.Flags.Name)
+	go nd.wait(cmd)
 	return nil
 }
 
 func (nd *NodeWebLocal) Terminate() error {
+	return nd.signal("Terminate", syscall.SIGTERM)
+}
+
+func (nd *NodeWebLocal) Kill() error {
+	return nd.signal("Kill", syscall.SIGKILL)
+}
+
+// nodeCommand returns the command to run the Node in its own process
+// group, whose ID is the PID, so that the signals reach the children of
+// the shell, such as etcd itself, not to orphan them. The nil env inherits
+// the environment of etcd-play.
+func nodeCommand(args, env []string) *exec.Cmd {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Env = env
+	return cmd
+}
+
+// signal sends sig to the process group of the Node, and marks it
+// inactive.
+func (nd *NodeWebLocal) signal(op string, sig syscall.Signal) error {
 	defer func() {
 		if err := recover(); err != nil {
-			nd.sharedStream <- fmt.Sprintf("Terminate %s: panic (%v)\n", nd.Flags.Name, err)
+			nd.stream(fmt.Sprintf("%s %s: panic (%v)\n", op, nd.Flags.Name, err))
 		}
 	}()
 
@@ -245,40 +326,145 @@ func (nd *NodeWebLocal) Terminate() error {
 	lastRestarted := nd.lastRestarted
 	nd.pmu.Unlock()
 	if !active {
-		return fmt.Errorf("%s is already terminated or requested to terminate", nd.Flags.Name)
+		return fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeInactive)
 	}
 
 	// terminate, 2nd terminate term should be more than limit interval
 	sub := time.Now().Sub(lastTerminated)
 	if sub < nd.limitInterval {
-		return fmt.Errorf("Somebody terminated the same node (only %v ago)! Retry in %v!", sub, nd.limitInterval)
+		return fmt.Errorf("%w: somebody terminated the same node (only %v ago)! Retry in %v!", ErrLimitInterval, sub, nd.limitInterval)
 	}
 	// restart, and immediate terminate term should be more than limit interval
 	subt := time.Now().Sub(lastRestarted)
 	if subt < nd.limitInterval {
-		return fmt.Errorf("Somebody restarted the node (only %v ago)! Retry in %v!", subt, nd.limitInterval)
+		return fmt.Errorf("%w: somebody restarted the node (only %v ago)! Retry in %v!", ErrLimitInterval, subt, nd.limitInterval)
 	}
 
-	nd.sharedStream <- fmt.Sprintf("Terminate %s [PID: %d]\n", nd.Flags.Name, nd.PID)
-	if err := syscall.Kill(nd.PID, syscall.SIGTERM); err != nil {
-		return err
+	nd.pmu.Lock()
+	isolated := nd.isolated
+	nd.pmu.Unlock()
+	if isolated != "" { // stopped process does not handle SIGTERM
+		if err := nd.Unisolate(); err != nil {
+			return err
+		}
+	}
+
+	nd.stream(fmt.Sprintf("%s %s [PID: %d]\n", op, nd.Flags.Name, nd.PID))
+	if err := syscall.Kill(-nd.PID, sig); err != nil {
+		// the process crashed and was reaped just before, which is the
+		// state to reach
+		if !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+		nd.stream(fmt.Sprintf("%s %s: already exited [PID: %d]\n", op, nd.Flags.Name, nd.PID))
 	}
-	// if err := syscall.Kill(nd.PID, syscall.SIGKILL); err != nil {
-	// 	return err
-	// }
 
 	nd.pmu.Lock()
 	nd.lastTerminated = time.Now()
 	nd.active = false
 	nd.pmu.Unlock()
 
+	nd.hooks.exited(nd.Flags.Name)
+	return nil
+}
+
+// Isolate blocks the peer traffic of the Node with iptables, or pauses
+// the process group with SIGSTOP if iptables is not available. It returns
+// the mechanism used.
+func (nd *NodeWebLocal) Isolate() (string, error) {
+	nd.pmu.Lock()
+	active, isolated, pid := nd.active, nd.isolated, nd.PID
+	nd.pmu.Unlock()
+	if !active {
+		return "", fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeInactive)
+	}
+	if isolated != "" {
+		return isolated, fmt.Errorf("%s is already isolated (%s)", nd.Flags.Name, isolated)
+	}
+
+	mechanism := "SIGSTOP"
+	if iptablesAvailable() {
+		port, err := urlPort(nd.Flags.ListenPeerURLs)
+		if err != nil {
+			return "", err
+		}
+		if err = dropPort(port); err != nil {
+			return "", err
+		}
+		mechanism = "iptables"
+	} else if err := syscall.Kill(-pid, syscall.SIGSTOP); err != nil {
+		return "", err
+	}
+
+	nd.pmu.Lock()
+	nd.isolated = mechanism
+	nd.pmu.Unlock()
+
+	nd.stream(fmt.Sprintf("Isolate %s [%s]\n", nd.Flags.Name, mechanism))
+	return mechanism, nil
+}
+
+// Unisolate recovers the Node from Isolate.
+func (nd *NodeWebLocal) Unisolate() error {
+	nd.pmu.Lock()
+	isolated, pid := nd.isolated, nd.PID
+	nd.pmu.Unlock()
+
+	switch isolated {
+	case "":
+		return fmt.Errorf("%s is not isolated", nd.Flags.Name)
+	case "iptables":
+		port, err := urlPort(nd.Flags.ListenPeerURLs)
+		if err != nil {
+			return err
+		}
+		if err = recoverPort(port); err != nil {
+			return err
+		}
+	case "SIGSTOP":
+		if err := syscall.Kill(-pid, syscall.SIGCONT); err != nil {
+			return err
+		}
+	}
+
+	nd.pmu.Lock()
+	nd.isolated = ""
+	nd.pmu.Unlock()
+
+	nd.stream(fmt.Sprintf("Unisolate %s [%s]\n", nd.Flags.Name, isolated))
+	return nil
+}
+
+// InjectLatency delays the peer traffic of the Node through the peer
+// proxy. The cluster must be created with WithPeerProxy.
+func (nd *NodeWebLocal) InjectLatency(delay time.Duration, loss float64) error {
+	if nd.peerProxy == nil {
+		return fmt.Errorf("%s has no peer proxy to inject latency (create the cluster WithPeerProxy)", nd.Flags.Name)
+	}
+	nd.peerProxy.SetImpairment(delay, loss)
+	nd.stream(fmt.Sprintf("InjectLatency %s [delay: %v, loss: %.1f%%]\n", nd.Flags.Name, delay, loss*100))
+	return nil
+}
+
+// ClearImpairments removes the latency injected by InjectLatency.
+func (nd *NodeWebLocal) ClearImpairments() error {
+	if nd.peerProxy == nil {
+		return fmt.Errorf("%s has no peer proxy to clear", nd.Flags.Name)
+	}
+	nd.peerProxy.SetImpairment(0, 0)
+	nd.stream(fmt.Sprintf("ClearImpairments %s\n", nd.Flags.Name))
 	return nil
 }
 
 func (nd *NodeWebLocal) Clean() error {
+	return nd.clean(nd.cleanPolicy)
+}
+
+// clean cleans up the data directory as the policy says.
+func (nd *NodeWebLocal) clean(policy CleanPolicy) error {
 	defer func() {
 		if err := recover(); err != nil {
-			nd.sharedStream <- fmt.Sprintf("Clean %s: panic (%v)\n", nd.Flags.Name, err)
+			nd.stream(fmt.Sprintf("Clean %s: panic (%v)\n", nd.Flags.Name, err))
 		}
 	}()
 	nd.pmu.Lock()
@@ -286,16 +472,140 @@ func (nd *NodeWebLocal) Clean() error {
 	active := nd.active
 	nd.pmu.Unlock()
 	if active {
-		return fmt.Errorf("%s is already running or requested to restart", nd.Flags.Name)
+		return fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeActive)
+	}
+
+	switch policy {
+	case CleanKeep:
+		nd.stream(fmt.Sprintf("Clean %s (keeping %s)\n", nd.Flags.Name, nd.Flags.DataDir))
+		return nil
+
+	case CleanBackup:
+		backup, err := backupDataDir(nd.Flags.DataDir, nd.backupDir, time.Now())
+		if os.IsNotExist(err) {
+			return nil // nothing to preserve
+		}
+		if err != nil {
+			return err
+		}
+		nd.stream(fmt.Sprintf("Clean %s (moved %s to %s)\n", nd.Flags.Name, nd.Flags.DataDir, backup))
+		return nil
 	}
 
-	nd.sharedStream <- fmt.Sprintf("Clean %s (%s)\n", nd.Flags.Name, nd.Flags.DataDir)
+	nd.stream(fmt.Sprintf("Clean %s (%s)\n", nd.Flags.Name, nd.Flags.DataDir))
 	if err := os.RemoveAll(nd.Flags.DataDir); err != nil {
 		return err
 	}
 	return nil
 }
 
+// resetDataDir cleans up the data directory so that the Node restarts
+// from scratch. The data directory is backed up instead of kept, if the
+// policy is to keep it.
+func (nd *NodeWebLocal) resetDataDir() error {
+	if nd.cleanPolicy == CleanKeep {
+		return nd.clean(CleanBackup)
+	}
+	return nd.clean(nd.cleanPolicy)
+}
+
+// probeInterval is the interval to probe the started node.
+var probeInterval = 100 * time.Millisecond
+
+// waitServing waits until the client endpoint of the started process
+// answers the Status RPC, so that the first operation after Start does not
+// race with etcd opening the listener. On timeout, it kills the process
+// group.
+func (nd *NodeWebLocal) waitServing(cmd *exec.Cmd) error {
+	if nd.probeTimeout == 0 {
+		return nil
+	}
+	deadline := time.Now().Add(nd.probeTimeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			// the shell of the command may have started etcd as its child
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			go cmd.Wait()
+			return fmt.Errorf("%s is not serving after %v (%w)", nd.Flags.Name, nd.probeTimeout, ErrTimeout)
+		}
+		if probeStatus(nd.Endpoint(), remaining) {
+			return nil
+		}
+		time.Sleep(probeInterval)
+	}
+}
+
+// probeStatus returns true if the endpoint answers the Status RPC within
+// the timeout.
+func probeStatus(endpoint string, timeout time.Duration) bool {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: timeout,
+	})
+	if err != nil {
+		return false
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	_, err = clientv3.NewMaintenance(cli).Status(ctx, endpoint)
+	cancel()
+	return err == nil
+}
+
+// stream sends msg to the shared stream without blocking.
+func (nd *NodeWebLocal) stream(msg string) {
+	sendNonBlocking(nd.sharedStream, msg, nd.pdropped)
+}
+
+// wait waits for the process to exit and records its exit status. The
+// Node becomes inactive if the process exited by itself, as in a crash,
+// not with Terminate or Kill.
+func (nd *NodeWebLocal) wait(cmd *exec.Cmd) {
+	es := exitStatus(cmd.Wait())
+
+	nd.pmu.Lock()
+	nd.lastExit = es
+	crashed := nd.active && nd.cmd == cmd
+	if crashed {
+		nd.active = false
+	}
+	nd.pmu.Unlock()
+
+	nd.stream(fmt.Sprintf("%s exited (%s)\n", nd.Flags.Name, es))
+	if crashed {
+		nd.hooks.exited(nd.Flags.Name)
+	}
+}
+
+// Uptime returns how long the process has run since the last Start or
+// Restart, or zero if the Node is not active.
+func (nd *NodeWebLocal) Uptime() time.Duration {
+	nd.pmu.Lock()
+	defer nd.pmu.Unlock()
+	if !nd.active {
+		return 0
+	}
+	return time.Since(nd.startedAt)
+}
+
+// activePID returns the PID of the process, and false if the Node is not
+// active.
+func (nd *NodeWebLocal) activePID() (int, bool) {
+	nd.pmu.Lock()
+	defer nd.pmu.Unlock()
+	return nd.PID, nd.active
+}
+
+// LastExit returns the exit status of the last process run.
+func (nd *NodeWebLocal) LastExit() ExitStatus {
+	nd.pmu.Lock()
+	es := nd.lastExit
+	nd.pmu.Unlock()
+	return es
+}
+
 func (nd *NodeWebLocal) TLS() *tls.Config {
 	return nd.TLSConfig
 }
@@ -0,0 +1,394 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExitStatus(t *testing.T) {
+	es := exitStatus(exec.Command("sh", "-c", "exit 3").Run())
+	if es.Code != 3 {
+		t.Errorf("expected code 3, got %d", es.Code)
+	}
+	if es.Signal != "none" {
+		t.Errorf("expected no signal, got %s", es.Signal)
+	}
+	if es.String() != "code=3 signal=none" {
+		t.Errorf("unexpected exit string %q", es.String())
+	}
+}
+
+func TestColorIndex(t *testing.T) {
+	seen := make(map[int]string)
+	for i := 1; i <= len(colorsToHTML); i++ {
+		name := fmt.Sprintf("etcd%d", i)
+		idx := colorIndex(name, len(colorsToHTML))
+		if idx != colorIndex(name, len(colorsToHTML)) {
+			t.Errorf("%s has no deterministic color", name)
+		}
+		if n, ok := seen[idx]; ok {
+			t.Errorf("%s has the same color as %s", name, n)
+		}
+		seen[idx] = name
+	}
+	for _, name := range []string{"infra", "etcd", "etcd0", ""} {
+		idx := colorIndex(name, len(colorsToHTML))
+		if idx < 0 || idx >= len(colorsToHTML) || idx != colorIndex(name, len(colorsToHTML)) {
+			t.Errorf("%q has unexpected color index %d", name, idx)
+		}
+	}
+
+	// palette exhaustion reuses colors from the beginning
+	if idx := colorIndex(fmt.Sprintf("etcd%d", len(colorsToHTML)+1), len(colorsToHTML)); idx != 0 {
+		t.Errorf("expected color index 0 after exhaustion, got %d", idx)
+	}
+}
+
+func TestColorLine(t *testing.T) {
+	line := colorLine("red", 5, "etcd1", "<script>alert(1)</script>")
+	if !strings.HasPrefix(line, `<b><font color="red">etcd1 | </font>`) {
+		t.Errorf("expected the color markup, got %q", line)
+	}
+	if !strings.HasSuffix(line, "&lt;script&gt;alert(1)&lt;/script&gt;</b>") {
+		t.Errorf("expected the escaped line, got %q", line)
+	}
+}
+
+func TestWriteLogFilter(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #", WithLiveLog(), WithLogFilter("warning", "raft"))
+	defer c.Shutdown()
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	drainStream(c.SharedStream())
+
+	nd.Write([]byte(`2016-06-15 10:00:00.000000 I | raft: 1 became follower at term 2
+2016-06-15 10:00:01.000000 W | raft: 1 lost leader 2 at term 3
+2016-06-15 10:00:02.000000 E | etcdserver: publish error
+panic: raft: tocommit(5) is out of range
+`))
+	msgs := drainStream(c.SharedStream())
+	if len(msgs) != 2 {
+		t.Fatalf("expected the warning and the panic, got %q", msgs)
+	}
+	if !strings.Contains(msgs[0], "lost leader") || !strings.Contains(msgs[1], "panic:") {
+		t.Errorf("unexpected lines %q", msgs)
+	}
+	if !strings.HasPrefix(msgs[0], `<b><font color=`) {
+		t.Errorf("expected the color markup, got %q", msgs[0])
+	}
+
+	if _, err := newLogFilter("verbose", ""); err == nil {
+		t.Error("expected error from unknown level")
+	}
+	if _, err := newLogFilter("", "raft("); err == nil {
+		t.Error("expected error from invalid pattern")
+	}
+}
+
+func TestTerminateAlreadyExited(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #")
+	defer c.Shutdown()
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	nd.limitInterval = 0
+
+	// the real process is terminated and reaped first, not to orphan it
+	if err := nd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nd.Terminate(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; !strings.Contains(strings.Join(drainStream(c.SharedStream()), "\n"), "etcd1 exited"); i++ {
+		if i == 100 {
+			t.Fatal("the process of etcd1 did not exit")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// the process crashed and was reaped, but the Node is still active
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	nd.pmu.Lock()
+	nd.active, nd.PID = true, cmd.Process.Pid
+	nd.pmu.Unlock()
+
+	if err := nd.Terminate(); err != nil {
+		t.Fatalf("expected no error from the exited process, got %v", err)
+	}
+	if nd.IsActive() {
+		t.Error("expected the Node inactive")
+	}
+	if msgs := strings.Join(drainStream(c.SharedStream()), "\n"); !strings.Contains(msgs, "already exited") {
+		t.Errorf("expected the exited process noted, got %q", msgs)
+	}
+}
+
+func TestTerminateProcessGroup(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found")
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, port, _ := net.SplitHostPort(addr)
+
+	// the shell does not exec the server in the background, which holds the
+	// port as etcd would
+	c := newTestCluster(t, 1, fmt.Sprintf("%s -m http.server --bind 127.0.0.1 %s & wait #", python, port))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+	listening := func() bool {
+		conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for !listening() {
+		if time.Now().After(deadline) {
+			t.Fatal("the child never listened")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := c.nameToNode["etcd1"].Terminate(); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(5 * time.Second)
+	for listening() {
+		if time.Now().After(deadline) {
+			t.Fatal("the child still holds the port after Terminate")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStartProbe(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := newTestCluster(t, 1, "sleep 10 #", WithStartProbe(5*time.Second))
+	defer c.Shutdown()
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	setClientURL(nd.Flags, "http://"+addr)
+
+	// the endpoint starts serving some time after the process starts
+	servingc := make(chan time.Time, 1)
+	go func() {
+		time.Sleep(500 * time.Millisecond)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		servingc <- time.Now()
+		serveFakeEtcd(t, l, 1, newFakeStore())
+	}()
+
+	if err := nd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	returned := time.Now()
+	select {
+	case serving := <-servingc:
+		if returned.Before(serving) {
+			t.Fatal("Start returned before the endpoint accepts connections")
+		}
+	default:
+		t.Fatal("Start returned before the endpoint accepts connections")
+	}
+	if !nd.IsActive() {
+		t.Fatal("node is not active after Start")
+	}
+}
+
+func TestStartProbeTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	c := newTestCluster(t, 1, "sleep 10 #", WithStartProbe(300*time.Millisecond))
+	defer c.Shutdown()
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	setClientURL(nd.Flags, "http://"+addr)
+
+	if err := nd.Start(); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+	if nd.IsActive() {
+		t.Fatal("node is active without serving")
+	}
+}
+
+func TestStartProbeTimeoutKillsGroup(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	// the shell starts the program as its child, which must not outlive it
+	dir, err := ioutil.TempDir("", "etcd-play-probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "child")
+	c := newTestCluster(t, 1, fmt.Sprintf("sleep 10 & echo $! > %s; wait #", pidFile), WithStartProbe(300*time.Millisecond))
+	defer c.Shutdown()
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	setClientURL(nd.Flags, "http://"+addr)
+
+	if err := nd.Start(); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+	b, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		// the killed child may linger as a zombie until its new parent reaps it
+		st, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil || strings.Contains(string(st), ") Z ") {
+			break
+		}
+		if i == 100 {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("the child %d of the timed out node is still running", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestUptimeResetOnRestart(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	defer c.Shutdown()
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	nd.limitInterval = 0
+
+	if up := nd.Uptime(); up != 0 {
+		t.Fatalf("expected zero uptime before Start, got %v", up)
+	}
+	if err := nd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	st, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up := st["etcd1"].UptimeSeconds; up < 1 {
+		t.Fatalf("expected uptime of at least 1 second, got %d", up)
+	}
+
+	if err := nd.Terminate(); err != nil {
+		t.Fatal(err)
+	}
+	if up := nd.Uptime(); up != 0 {
+		t.Fatalf("expected zero uptime after Terminate, got %v", up)
+	}
+	if err := nd.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	st, err = c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up := st["etcd1"].UptimeSeconds; up != 0 {
+		t.Fatalf("expected uptime to reset after Restart, got %d", up)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := []struct {
+		advertise, listen []string
+		endpoint          string
+		statusEndpoint    string
+	}{
+		{[]string{"http://127.0.0.1:2379"}, nil, "127.0.0.1:2379", "http://127.0.0.1:2379"},
+		{[]string{"http://[::1]:2379"}, nil, "[::1]:2379", "http://[::1]:2379"},
+		{[]string{"https://[fe80::1%25eth0]:2379"}, nil, "[fe80::1%eth0]:2379", "https://[fe80::1%25eth0]:2379"},
+		{[]string{"http://infra1.example.com:2379"}, nil, "infra1.example.com:2379", "http://infra1.example.com:2379"},
+
+		// the first in sorted order, whatever the map order is
+		{[]string{"http://10.0.0.2:2379", "http://10.0.0.1:2379", "http://10.0.0.3:2379"}, nil, "10.0.0.1:2379", "http://10.0.0.1:2379"},
+
+		// advertised URLs are preferred to listen URLs
+		{[]string{"http://infra1.example.com:2379"}, []string{"http://0.0.0.0:2379"}, "infra1.example.com:2379", "http://infra1.example.com:2379"},
+		{nil, []string{"http://localhost:2379"}, "localhost:2379", "http://localhost:2379"},
+
+		// invalid
+		{nil, nil, "", ""},
+		{[]string{"http://::1:2379"}, nil, "", ""},
+		{[]string{"http://localhost"}, nil, "", ""},
+		{[]string{"http://%zz"}, nil, "", ""},
+	}
+	for i, tt := range tests {
+		fs := &Flags{Name: "etcd1", AdvertiseClientURLs: make(map[string]struct{}), ListenClientURLs: make(map[string]struct{})}
+		for _, u := range tt.advertise {
+			fs.AdvertiseClientURLs[u] = struct{}{}
+		}
+		for _, u := range tt.listen {
+			fs.ListenClientURLs[u] = struct{}{}
+		}
+		for j := 0; j < 5; j++ {
+			nd := &NodeWebLocal{Flags: fs}
+			if ep := nd.Endpoint(); ep != tt.endpoint {
+				t.Fatalf("#%d: endpoint expected %q, got %q", i, tt.endpoint, ep)
+			}
+			if ep := nd.StatusEndpoint(); ep != tt.statusEndpoint {
+				t.Fatalf("#%d: status endpoint expected %q, got %q", i, tt.statusEndpoint, ep)
+			}
+		}
+		if tt.endpoint == "" {
+			if _, err := clientURL(fs); err == nil {
+				t.Errorf("#%d: expected error", i)
+			}
+		}
+	}
+}
@@ -17,7 +17,6 @@ package proc
 import (
 	"crypto/tls"
 	"fmt"
-	"net/url"
 	"sync"
 	"time"
 
@@ -34,30 +33,58 @@ type NodeWebRemoteClient struct {
 
 	Agent client.Agent
 
-	active bool
+	color        string
+	liveLog      bool
+	logFilter    *logFilter  // nil to write all log lines
+	structured   bool        // true to write the parsed log entries
+	sharedStream chan string // inherit from Cluster (no need pointer)
+	pdropped     *uint64     // inherit from Cluster
+	logTailer    logTailer   // nil if the remote log is not served
+	stopTail     chan struct{}
+
+	active    bool
+	isolated  bool
+	startedAt time.Time // when etcd last started or restarted
 
 	limitInterval  time.Duration
 	lastTerminated time.Time
 	lastRestarted  time.Time
+
+	hooks nodeHooks // called without mu
 }
 
-func (nd *NodeWebRemoteClient) Endpoint() string {
-	es := ""
-	for k := range nd.Flags.ListenClientURLs {
-		es = k
-		break
+// tailInterval is the interval to poll the remote log.
+var tailInterval = time.Second
+
+// startTail starts streaming the remote log. Caller must hold nd.mu.
+func (nd *NodeWebRemoteClient) startTail() {
+	if !nd.liveLog || nd.logTailer == nil || nd.stopTail != nil {
+		return
 	}
-	s, _ := url.Parse(es)
-	return s.Host
+	nd.stopTail = make(chan struct{})
+	name := nd.Flags.Name
+	go tailLog(nd.logTailer, tailInterval, nd.stopTail, func(line string) {
+		if !nd.logFilter.allow(line) {
+			return
+		}
+		sendNonBlocking(nd.sharedStream, formatLogLine(nd.structured, nd.color, len(name), name, line), nd.pdropped)
+	})
 }
 
-func (nd *NodeWebRemoteClient) StatusEndpoint() string {
-	es := ""
-	for k := range nd.Flags.ListenClientURLs {
-		es = k
-		break
+// stopTailing stops streaming the remote log. Caller must hold nd.mu.
+func (nd *NodeWebRemoteClient) stopTailing() {
+	if nd.stopTail != nil {
+		close(nd.stopTail)
+		nd.stopTail = nil
 	}
-	return es
+}
+
+func (nd *NodeWebRemoteClient) Endpoint() string {
+	return clientEndpoint(nd.Flags)
+}
+
+func (nd *NodeWebRemoteClient) StatusEndpoint() string {
+	return clientStatusEndpoint(nd.Flags)
 }
 
 func (nd *NodeWebRemoteClient) IsActive() bool {
@@ -66,12 +93,38 @@ func (nd *NodeWebRemoteClient) IsActive() bool {
 	return nd.active
 }
 
+func (nd *NodeWebRemoteClient) Uptime() time.Duration {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+	if !nd.active {
+		return 0
+	}
+	return time.Since(nd.startedAt)
+}
+
 func (nd *NodeWebRemoteClient) Start() error {
+	return nd.startNotify(nil)
+}
+
+// startNotify starts the Node, and calls started if not nil once the agent
+// started the process.
+func (nd *NodeWebRemoteClient) startNotify(started func()) error {
+	if err := nd.start(); err != nil {
+		return err
+	}
+	if started != nil {
+		started()
+	}
+	nd.hooks.started(nd.Flags.Name)
+	return nil
+}
+
+func (nd *NodeWebRemoteClient) start() error {
 	nd.mu.Lock()
 	defer nd.mu.Unlock()
 
 	if nd.active {
-		return fmt.Errorf("%s is already running or requested to restart", nd.Flags.Name)
+		return fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeActive)
 	}
 
 	flagSlice, err := nd.Flags.StringSlice()
@@ -91,17 +144,27 @@ func (nd *NodeWebRemoteClient) Start() error {
 	// }
 
 	nd.active = true
+	nd.startedAt = time.Now()
+	nd.startTail()
 	return nil
 }
 
 func (nd *NodeWebRemoteClient) Restart() error {
+	if err := nd.restart(); err != nil {
+		return err
+	}
+	nd.hooks.started(nd.Flags.Name)
+	return nil
+}
+
+func (nd *NodeWebRemoteClient) restart() error {
 	nd.mu.Lock()
 	defer nd.mu.Unlock()
 
 	lastTerminated := nd.lastTerminated
 	lastRestarted := nd.lastRestarted
 	if nd.active {
-		return fmt.Errorf("%s is already running or requested to restart", nd.Flags.Name)
+		return fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeActive)
 	}
 
 	// TODO: better way to wait resource release?
@@ -110,12 +173,12 @@ func (nd *NodeWebRemoteClient) Restart() error {
 	// restart, 2nd restart term should be more than limit interval
 	sub := time.Now().Sub(lastRestarted)
 	if sub < nd.limitInterval {
-		return fmt.Errorf("Somebody restarted the same node (only %v ago)! Retry in %v!", sub, nd.limitInterval)
+		return fmt.Errorf("%w: somebody restarted the same node (only %v ago)! Retry in %v!", ErrLimitInterval, sub, nd.limitInterval)
 	}
 	// terminate, and immediate restart term should be more than limit interval
 	subt := time.Now().Sub(lastTerminated)
 	if subt < nd.limitInterval {
-		return fmt.Errorf("Somebody terminated the node (only %v ago)! Retry in %v!", subt, nd.limitInterval)
+		return fmt.Errorf("%w: somebody terminated the node (only %v ago)! Retry in %v!", ErrLimitInterval, subt, nd.limitInterval)
 	}
 	if _, err := nd.Agent.Restart(); err != nil {
 		return err
@@ -123,28 +186,38 @@ func (nd *NodeWebRemoteClient) Restart() error {
 
 	nd.lastRestarted = time.Now()
 	nd.active = true
+	nd.startedAt = nd.lastRestarted
+	nd.startTail()
 	return nil
 }
 
 func (nd *NodeWebRemoteClient) Terminate() error {
+	if err := nd.terminate(); err != nil {
+		return err
+	}
+	nd.hooks.exited(nd.Flags.Name)
+	return nil
+}
+
+func (nd *NodeWebRemoteClient) terminate() error {
 	nd.mu.Lock()
 	defer nd.mu.Unlock()
 
 	lastTerminated := nd.lastTerminated
 	lastRestarted := nd.lastRestarted
 	if !nd.active {
-		return fmt.Errorf("%s is already terminated or requested to terminate", nd.Flags.Name)
+		return fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeInactive)
 	}
 
 	// terminate, 2nd terminate term should be more than limit interval
 	sub := time.Now().Sub(lastTerminated)
 	if sub < nd.limitInterval {
-		return fmt.Errorf("Somebody terminated the same node (only %v ago)! Retry in %v!", sub, nd.limitInterval)
+		return fmt.Errorf("%w: somebody terminated the same node (only %v ago)! Retry in %v!", ErrLimitInterval, sub, nd.limitInterval)
 	}
 	// restart, and immediate terminate term should be more than limit interval
 	subt := time.Now().Sub(lastRestarted)
 	if subt < nd.limitInterval {
-		return fmt.Errorf("Somebody restarted the node (only %v ago)! Retry in %v!", subt, nd.limitInterval)
+		return fmt.Errorf("%w: somebody restarted the node (only %v ago)! Retry in %v!", ErrLimitInterval, subt, nd.limitInterval)
 	}
 	if err := nd.Agent.Stop(); err != nil {
 		return err
@@ -152,9 +225,72 @@ func (nd *NodeWebRemoteClient) Terminate() error {
 
 	nd.lastTerminated = time.Now()
 	nd.active = false
+	nd.stopTailing()
+	return nil
+}
+
+// Kill stops the Node process. The agent does not expose SIGKILL, so this
+// is the same as Terminate.
+func (nd *NodeWebRemoteClient) Kill() error {
+	return nd.Terminate()
+}
+
+// Isolate drops the peer traffic of the Node through the agent.
+func (nd *NodeWebRemoteClient) Isolate() (string, error) {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+
+	if !nd.active {
+		return "", fmt.Errorf("%s %w", nd.Flags.Name, ErrNodeInactive)
+	}
+	if nd.isolated {
+		return "agent", fmt.Errorf("%s is already isolated (agent)", nd.Flags.Name)
+	}
+	port, err := urlPort(nd.Flags.ListenPeerURLs)
+	if err != nil {
+		return "", err
+	}
+	if err = nd.Agent.DropPort(port); err != nil {
+		return "", err
+	}
+	nd.isolated = true
+	return "agent", nil
+}
+
+// Unisolate recovers the Node from Isolate.
+func (nd *NodeWebRemoteClient) Unisolate() error {
+	nd.mu.Lock()
+	defer nd.mu.Unlock()
+
+	if !nd.isolated {
+		return fmt.Errorf("%s is not isolated", nd.Flags.Name)
+	}
+	port, err := urlPort(nd.Flags.ListenPeerURLs)
+	if err != nil {
+		return err
+	}
+	if err = nd.Agent.RecoverPort(port); err != nil {
+		return err
+	}
+	nd.isolated = false
 	return nil
 }
 
+// InjectLatency delays the network of the Node through the agent. The
+// agent does not support packet loss.
+func (nd *NodeWebRemoteClient) InjectLatency(delay time.Duration, loss float64) error {
+	if loss > 0 {
+		return fmt.Errorf("%s does not support packet loss through the agent", nd.Flags.Name)
+	}
+	ms := int(delay / time.Millisecond)
+	return nd.Agent.SetLatency(ms, 0)
+}
+
+// ClearImpairments removes the latency injected by InjectLatency.
+func (nd *NodeWebRemoteClient) ClearImpairments() error {
+	return nd.Agent.RemoveLatency()
+}
+
 func (nd *NodeWebRemoteClient) Clean() error {
 	if err := nd.Agent.Cleanup(); err != nil {
 		return err
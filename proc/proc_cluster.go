@@ -15,23 +15,37 @@
 package proc
 
 import (
+	"bytes"
 	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path"
 	"reflect"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
 	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/mvcc/mvccpb"
 	"github.com/coreos/etcd/tools/functional-tester/etcd-agent/client"
 	"github.com/dustin/go-humanize"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 var (
@@ -41,9 +55,33 @@ var (
 		"#ff9933", // yellow
 		"#0000ff", // blue
 		"#ff00ff", // magenta
+		"#00a0a0", // cyan
+		"#8b4513", // brown
+		"#800080", // purple
+		"#808000", // olive
+		"#ff1493", // pink
 	}
+
+	htmlColorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
 )
 
+// colorIndex returns the index of the color in the palette of the given
+// size for the node name, so that the same node always gets the same
+// color. Names with a numeric suffix (etcd1, etcd2, ...) take consecutive
+// colors to avoid collisions, and others are hashed.
+func colorIndex(name string, size int) int {
+	i := len(name)
+	for i > 0 && '0' <= name[i-1] && name[i-1] <= '9' {
+		i--
+	}
+	if n, err := strconv.Atoi(name[i:]); err == nil && n > 0 {
+		return (n - 1) % size
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return int(h.Sum32() % uint32(size))
+}
+
 // Node contains node operations.
 type Node interface {
 	// Endpoint returns the gRPC endpoint.
@@ -55,17 +93,40 @@ type Node interface {
 	// IsActive returns true if the Node is running(active).
 	IsActive() bool
 
+	// Uptime returns how long the Node has run since its last Start or
+	// Restart, or zero if it is not active.
+	Uptime() time.Duration
+
 	// Start starts Node process.
 	Start() error
 
 	// Restart restarts Node process.
 	Restart() error
 
-	// Terminate kills the Node process.
+	// Terminate gracefully stops the Node process with SIGTERM.
 	Terminate() error
 
+	// Kill stops the Node process with SIGKILL, without letting it clean
+	// up, to simulate a crash.
+	Kill() error
+
+	// Isolate blocks the peer traffic of the Node without stopping it,
+	// and returns the mechanism used.
+	Isolate() (string, error)
+
+	// Unisolate recovers the Node from Isolate.
+	Unisolate() error
+
+	// InjectLatency delays the peer traffic of the Node, and simulates
+	// packet loss with the probability of loss.
+	InjectLatency(delay time.Duration, loss float64) error
+
+	// ClearImpairments removes the latency injected by InjectLatency.
+	ClearImpairments() error
+
 	// Clean cleans up the resources from the Node. This must be called
-	// after Terminate.
+	// after Terminate. Local data directories are removed, unless
+	// WithCleanPolicy backs them up or keeps them.
 	Clean() error
 
 	// TLS returns the *tls.Config of the Node.
@@ -84,12 +145,49 @@ type ServerStatus struct {
 	DbSize    uint64
 	DbSizeTxt string
 
+	// Version is the server version, and ClusterVersion is the cluster
+	// (storage) version that the Node reports, if available.
+	Version        string
+	ClusterVersion string
+
+	// LastExit is the exit status of the last local process, if any.
+	LastExit string
+
+	// UptimeSeconds is the time since the Node last started or restarted.
+	UptimeSeconds int64
+
+	// CPUPercent is the CPU usage of the local process group since the
+	// last Status, in percent of one CPU, and RSS is its resident memory
+	// in bytes. Both are zero for remote Nodes, or where /proc is not
+	// available.
+	CPUPercent float64
+	RSS        uint64
+	RSSTxt     string
+
+	// LeaderChanges is the number of leader changes the Node has seen, and
+	// Metrics holds the metrics requested with WithStatusMetrics. Both are
+	// scraped only from local Nodes.
+	LeaderChanges int
+	Metrics       map[string]float64
+
+	// RaftIndex is the raft index of the Node, and RaftIndexBehind is the
+	// number of entries it is behind the leader. CatchingUp is true when
+	// it is more than catchUpThreshold entries behind, such as after a
+	// reset or a long isolation.
+	RaftIndex       uint64
+	RaftIndexBehind uint64
+	CatchingUp      bool
+
 	// NumberOfKeys int
 }
 
-// Cluster controls a set of Nodes.
+// Cluster controls a set of Nodes. The operations that take a Node name
+// also take a raw client endpoint, such as '10.0.0.3:2379', to target a
+// member that is not a Node of the Cluster.
 type Cluster interface {
-	// Write writes messages to a Node process.
+	// Write writes messages of a Node to the streams of streamIDs, or to
+	// the shared stream if no stream ID is given. Operations take
+	// streamIDs to reach the streams of the users who requested them.
 	Write(name, msg string, streamIDs ...string) error
 
 	// SharedStream returns a shared stream.
@@ -98,61 +196,407 @@ type Cluster interface {
 	// Stream returns the channel for streaming logs.
 	Stream(streamID string) chan string
 
+	// CloseStream removes the stream ID, once its user has left, and
+	// closes the stream when no other stream ID shares it. Readers see the
+	// channel closed, and later messages to the stream ID start a new
+	// stream.
+	CloseStream(streamID string)
+
+	// ResumeStream makes newStreamID share the stream of oldStreamID, so
+	// that a user reconnecting with a new stream ID keeps reading the
+	// messages of the operations started with the old one. The messages
+	// already sent to newStreamID move to the shared stream.
+	ResumeStream(oldStreamID, newStreamID string) error
+
+	// Dropped returns the number of log lines dropped because a stream
+	// was full.
+	Dropped() uint64
+
+	// Events returns the latest messages written with Write after the
+	// time, from the oldest.
+	Events(since time.Time) []Event
+
 	// Start starts Node process.
 	Start(name string) error
 
 	// Restart restarts Node process.
 	Restart(name string) error
 
-	// Revive restarts all Nodes in case no Node is up for a certain period of
-	// time.
+	// Revive restarts the Nodes that are down, all at once so that they
+	// regain the quorum, leaving the active ones alone. Nodes terminated
+	// or restarted within the limit interval are skipped.
 	Revive() error
 
-	// Terminate kills the Node process.
+	// RollingRestart restarts Nodes one at a time, waiting for the cluster
+	// to become healthy before moving on. It aborts when restarting a
+	// Node would lose the quorum, or when the cluster loses the quorum.
+	RollingRestart(streamIDs ...string) error
+
+	// ResetNode terminates the Node, removes its data directory, replaces
+	// its member with a new one of the same peer URLs, and restarts it to
+	// rejoin the cluster from scratch, catching up with the snapshot from
+	// the leader. It returns once the Node is healthy, and aborts when the
+	// other Nodes do not have the quorum.
+	ResetNode(name string, streamIDs ...string) error
+
+	// Chaos terminates a random Node at each interval, and restarts it
+	// after the downtime, until ctx is canceled or the rounds run out. It
+	// never terminates a Node when that would lose the quorum. It is listed
+	// in the operations of the streams, which can cancel it.
+	Chaos(ctx context.Context, cfg ChaosConfig, streamIDs ...string) error
+
+	// Terminate gracefully stops the Node process with SIGTERM.
 	Terminate(name string) error
 
+	// Kill stops the Node process with SIGKILL, to simulate a crash.
+	Kill(name string) error
+
+	// Isolate partitions the Node from its peers without stopping it.
+	Isolate(name string, streamIDs ...string) error
+
+	// Unisolate heals the partition made by Isolate.
+	Unisolate(name string, streamIDs ...string) error
+
+	// InjectLatency delays the peer traffic of the Node, and simulates
+	// packet loss with the probability of loss (0.0 ~ 1.0).
+	InjectLatency(name string, delay time.Duration, loss float64, streamIDs ...string) error
+
+	// ClearImpairments removes the latency injected by InjectLatency.
+	ClearImpairments(name string, streamIDs ...string) error
+
 	// Clean cleans up the resources from the Node. This must be called
-	// after Terminate.
+	// after Terminate. Local data directories are removed, unless
+	// WithCleanPolicy backs them up or keeps them.
 	Clean(name string) error
 
-	// Bootstrap starts all Node processes.
+	// InspectDataDir reports the WAL files, snapshots and database size in
+	// the data directory of the local Node, whether or not it is running.
+	InspectDataDir(name string) (DataDirInfo, error)
+
+	// Bootstrap starts all Node processes, and returns once they are all
+	// started, and a leader is elected if WithLeaderWait is given. It does
+	// not wait for the processes to exit. With WithSnapshotRestore, it
+	// first restores the data directories from the snapshot.
 	Bootstrap() error
 
-	// Shutdown terminates and cleans all Nodes.
+	// BootstrapProgress is the same as Bootstrap, but sends the progress
+	// of each Node to progress, and closes it when Bootstrap completes.
+	// The caller must keep receiving until progress is closed.
+	BootstrapProgress(progress chan<- BootstrapEvent) error
+
+	// Shutdown cancels the long-running operations, such as Stress, Chaos
+	// and the watches, waits for in-flight operations, and terminates and
+	// cleans all Nodes. Operations after Shutdown return
+	// ErrClusterShutdown.
 	Shutdown() error
 
+	// SaveTopology writes the flags and the active state of all Nodes to
+	// the file as JSON, to be restored by LoadTopology.
+	SaveTopology(path string) error
+
 	// Endpoints returns all endpoints for clients and a map of name and endpoint, vice versa.
 	Endpoints() ([]string, map[string]string, map[string]string)
 
+	// DiscoverEndpoints returns the client endpoints of the current members
+	// listed by the seed, merged with the endpoints of the active Nodes, so
+	// that the membership changed out of band is found. The seed is a Node
+	// name or an endpoint. If the seed is not specified, it asks a random
+	// node.
+	DiscoverEndpoints(seed string) ([]string, error)
+
 	// Leader returns the name of the leader.
 	Leader() (string, error)
 
-	// Status returns all endpoints and status of the cluster.
+	// TODO: add MoveLeader to transfer the leadership to a caught-up
+	// follower without killing the leader. It is blocked on updating the
+	// vendored etcd, whose clientv3 and etcdserverpb predate the
+	// Maintenance.MoveLeader RPC of etcd v3.3.
+
+	// Status returns all endpoints and status of the cluster. The
+	// unreachable Nodes get the empty status, and the error joins the
+	// error of each of them.
 	Status() (map[string]ServerStatus, error)
 
+	// VersionSkew returns the versions of each reachable Node, and true if
+	// they disagree.
+	VersionSkew() (map[string]string, bool)
+
+	// Health returns true for each Node if its endpoint reports healthy.
+	// Inactive or unreachable Nodes are unhealthy.
+	Health() (map[string]bool, error)
+
 	// Put puts key-value to the cluster. If the name is not specified, it
 	// sends request to a random node.
 	Put(name, key, value string, streamIDs ...string) (time.Duration, error)
 
+	// PutWithPrevKV puts key-value to the cluster, and returns the previous
+	// key-value, or nil if the key is created.
+	PutWithPrevKV(name, key, value string, streamIDs ...string) (*KeyValue, time.Duration, error)
+
+	// PutBytes puts the value as bytes, such as non-UTF8 data, which is
+	// streamed in hex. If the name is not specified, it sends request to
+	// a random node.
+	PutBytes(name, key string, value []byte, streamIDs ...string) (time.Duration, error)
+
+	// PutBatch puts all key-values concurrently, and streams a summary
+	// instead of each key. On failures, the error reports how many keys
+	// were put. If the name is not specified, it puts to a random node.
+	PutBatch(name string, kvs map[string]string, streamIDs ...string) error
+
+	// PutCompare puts the value to each active Node in turn, to compare the
+	// latency of writing through the leader and through the followers.
+	// Each Node gets its own key, suffixed with the Node name.
+	PutCompare(key, value string, streamIDs ...string) (map[string]time.Duration, error)
+
+	// CompareAndSwap puts the new value only if the key has the expected
+	// value, and returns true if it swapped. An empty expected value
+	// creates the key only if it does not exist yet. If the name is not
+	// specified, it sends request to a random node.
+	CompareAndSwap(name, key, expected, newValue string, streamIDs ...string) (bool, error)
+
+	// CompareAndDelete deletes the key only if it has the expected value,
+	// and returns true if it deleted. If the name is not specified, it
+	// sends request to a random node.
+	CompareAndDelete(name, key, expected string, streamIDs ...string) (bool, error)
+
+	// WatchPut watches the key on all active Nodes, puts the key-value via
+	// the named Node, and returns how long it took until every watcher
+	// received the put. If the name is not specified, it puts to a random
+	// node.
+	WatchPut(name, key, value string, streamIDs ...string) (time.Duration, error)
+
+	// WatchPutContext is the same as WatchPut, but stops and closes the
+	// watchers when ctx is canceled.
+	WatchPutContext(ctx context.Context, name, key, value string, streamIDs ...string) (time.Duration, error)
+
+	// History replays the changes of the keys with the prefix, from the
+	// start revision up to the current revision, in the order of the
+	// revisions, as a watch from a past revision does. If the start
+	// revision is compacted, it replays from the compact revision, and
+	// notes it in the stream. It stops when ctx is canceled, or after
+	// maxEvents events, so that a long history is not buffered whole. If
+	// the name is not specified, it watches a random node.
+	History(ctx context.Context, name, prefix string, startRev int64, maxEvents int, streamIDs ...string) ([]WatchEvent, error)
+
+	// RunSTM runs fn as a software transactional memory transaction, which
+	// commits its writes only if none of the keys it read has changed
+	// meanwhile. On a conflict, it runs fn again with the new values, up
+	// to stmMaxRetries times, and streams the number of retries. An error
+	// from fn aborts the transaction without writing. If the name is not
+	// specified, it sends requests to a random node.
+	RunSTM(name string, fn func(stm STM) error, streamIDs ...string) error
+
+	// RunScenario runs the failure scenario of the name, as listed by
+	// Scenarios, narrating each step to the streams. It is listed with
+	// the running operations, and stops at the next step when canceled
+	// or on Shutdown.
+	RunScenario(name string, streamIDs ...string) error
+
+	// ListOperations returns the running long operations, which are
+	// Stress, WatchPut, History, RollingRestart, Chaos and the scenarios,
+	// from the oldest.
+	ListOperations() []OperationHandle
+
+	// CancelOperation cancels the running operation of the ID.
+	CancelOperation(id uint64) error
+
+	// ObserveLeader streams the value of the leader of the election, such
+	// as '/my-service', whenever the campaigners change it, until cancel
+	// is called. cancel waits for the observer to stop, and closes its
+	// client.
+	ObserveLeader(electionName string, streamIDs ...string) (cancel func(), err error)
+
 	// Get get the value from the key. If the name is not specified,
 	// it gets from a random node.
 	Get(name, key string, prefix bool, streamIDs ...string) ([]string, time.Duration, error)
 
-	// Delete deletes the key.
-	Delete(ame, key string, prefix bool, streamIDs ...string) (int64, time.Duration, error)
+	// GetBytes gets the value of the key as bytes, or nil if the key does
+	// not exist. Binary values are streamed in hex. If the name is not
+	// specified, it gets from a random node.
+	GetBytes(name, key string, streamIDs ...string) ([]byte, time.Duration, error)
+
+	// GetSerializable is the same as Get, but reads from the local Node
+	// without going through the leader, so the value might be stale. With
+	// a name, it reads from that Node even if it is partitioned from the
+	// leader, and labels the read with the role of the Node.
+	GetSerializable(name, key string, prefix bool, streamIDs ...string) ([]string, time.Duration, error)
+
+	// GetWithRevisions is the same as Get, but returns the key-values with
+	// their revisions and versions, sorted by key.
+	GetWithRevisions(name, key string, prefix bool, streamIDs ...string) ([]KeyValue, time.Duration, error)
+
+	// Keys returns all keys with their revisions but without the values,
+	// sorted by key, and the current revision of the cluster. It does not
+	// stream, for the housekeeping in the background. If the name is not
+	// specified, it gets from a random node.
+	Keys(name string) ([]KeyValue, int64, error)
+
+	// DeleteUnmodified deletes each of the keys unless it was modified
+	// after its ModRevision, and returns the number of deleted keys. Like
+	// Keys, it does not stream. If the name is not specified, it deletes
+	// through a random node.
+	DeleteUnmodified(name string, kvs []KeyValue) (int, error)
+
+	// GetNodes gets the key from each of the named Nodes concurrently, or
+	// from all Nodes if names is empty, to compare the values across the
+	// Nodes. Serializable reads get the local values of partitioned Nodes.
+	GetNodes(names []string, key string, prefix, serializable bool, streamIDs ...string) map[string]GetResult
+
+	// Delete deletes the key, and returns the number of deleted keys. If
+	// prefix is true, it deletes all keys with the prefix. An empty key
+	// deletes all keys only when prefix is true.
+	Delete(name, key string, prefix bool, streamIDs ...string) (int64, time.Duration, error)
 
 	// Stress stresses the cluster. If the name is not specified, it stresses
 	// random nodes.
 	Stress(name string, stressN int, streamIDs ...string) (time.Duration, error)
+
+	// StressWithConfig is the same as Stress, but writes the keys and values
+	// of the sizes and the distribution in cfg.
+	StressWithConfig(name string, stressN int, cfg StressConfig, streamIDs ...string) (time.Duration, error)
+
+	// FillUntilQuota writes increasingly large values until the cluster
+	// raises the NOSPACE alarm, or ctx is canceled. If the name is not
+	// specified, it writes to a random node. It returns ErrNoQuota if no
+	// Node is configured with the space quota, and stops writing at twice
+	// the quota.
+	FillUntilQuota(ctx context.Context, name string, streamIDs ...string) error
+
+	// AlarmList returns all active alarms in the cluster.
+	AlarmList(streamIDs ...string) ([]Alarm, error)
+
+	// AlarmDisarm disarms all active alarms in the cluster.
+	AlarmDisarm(streamIDs ...string) error
+
+	// Defragment defragments the storage backend of the node. If the name
+	// is not specified, it defragments all active nodes one by one.
+	Defragment(name string, streamIDs ...string) error
+
+	// ReclaimSpace compacts the history before the current revision, and
+	// defragments the node, writing the DB size before and after each
+	// step. If the name is not specified, it defragments all active nodes
+	// one by one.
+	ReclaimSpace(name string, streamIDs ...string) error
+
+	// Snapshot writes a point-in-time snapshot of the node's backend
+	// database to w. If the name is not specified, it takes the snapshot
+	// from a random node.
+	Snapshot(name string, w io.Writer, streamIDs ...string) error
+
+	// HashHistory samples the hash of the node's key-value store n times
+	// at the interval, to watch it stabilize after writes or compaction.
+	// If the name is not specified, it samples a random node.
+	HashHistory(name string, n int, interval time.Duration, streamIDs ...string) ([]int, error)
+
+	// HashHistoryContext is the same as HashHistory, but stops sampling
+	// and returns the samples so far when ctx is canceled.
+	HashHistoryContext(ctx context.Context, name string, n int, interval time.Duration, streamIDs ...string) ([]int, error)
+}
+
+// KeyValue is a key-value pair stored in the cluster.
+type KeyValue struct {
+	Key   string
+	Value string
+
+	// CreateRevision and ModRevision are the revisions of the cluster
+	// when the key was created and last modified, and Version is the
+	// number of modifications since the creation.
+	CreateRevision int64
+	ModRevision    int64
+	Version        int64
+}
+
+// newKeyValue converts the mvcc key-value.
+func newKeyValue(kv *mvccpb.KeyValue) KeyValue {
+	return KeyValue{
+		Key:            string(kv.Key),
+		Value:          string(kv.Value),
+		CreateRevision: kv.CreateRevision,
+		ModRevision:    kv.ModRevision,
+		Version:        kv.Version,
+	}
+}
+
+// WatchEvent is a change of a key, at the ModRevision of the key-value.
+type WatchEvent struct {
+	Type string // "PUT" or "DELETE"
+	KeyValue
+}
+
+// GetResult is the result of a get from a Node.
+type GetResult struct {
+	Values []string
+	Took   time.Duration
+	Err    error
+}
+
+// Alarm is an alarm raised by a member.
+type Alarm struct {
+	MemberID string
+	Type     string
 }
 
 // defaultCluster groups a set of Node processes.
 type defaultCluster struct {
 	mu           sync.Mutex // guards the following
 	sharedStream chan string
+	dropped      uint64 // number of log lines dropped, accessed atomically
+	nextIndex    uint64 // round-robin index of nextName, accessed atomically
+	events       *eventLog
+	operations   *operationRegistry
 	idToStream   map[string]chan string
 	nameToNode   map[string]Node
 	epToName     map[string]string
+	closing      bool // true once Shutdown starts
+	stressSeed   int64
+	dialTimeout  time.Duration
+
+	// leaderTimeout is how long Bootstrap waits for a leader, or zero not
+	// to wait.
+	leaderTimeout time.Duration
+
+	// restoreSnapshot is the snapshot file that Bootstrap restores the
+	// data directories from with etcdctlPath, if not empty.
+	restoreSnapshot string
+	etcdctlPath     string
+
+	statusMetrics     []string // metric names to scrape in Status
+	statusConcurrency int      // members to ask at once in Status
+
+	// retry is the policy to retry Put, Get and Delete.
+	retry RetryPolicy
+
+	// balanced dials all active endpoints for the Put, Get and Delete not
+	// given a Node name, for clientv3 to fail over between them.
+	balanced bool
+
+	// members are the client endpoints of the members added to the etcd
+	// cluster out of band, by name, as of the last syncMembers.
+	members map[string]string
+
+	// discovered are the client endpoints of the members found by
+	// DiscoverEndpoints, which may be targeted as raw endpoints.
+	discovered map[string]struct{}
+
+	// memberWatchInterval is the interval of syncMembers after Bootstrap,
+	// or zero not to watch the membership.
+	memberWatchInterval time.Duration
+	memberWatchOnce     sync.Once
+
+	// maxKeySize and maxValueSize limit the size of the keys and values
+	// written by the users, or zero not to limit.
+	maxKeySize   int
+	maxValueSize int
+
+	// cpu samples the CPU usage of the local Nodes in Status.
+	cpu cpuSampler
+
+	inflight sync.WaitGroup // in-flight client operations
+
+	// ctx is canceled by Shutdown, to stop the long-running operations
+	// derived from it.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type NodeType int
@@ -164,8 +608,39 @@ const (
 
 type op struct {
 	liveLog        bool
+	logLevel       string
+	logMatch       string
+	structuredLog  bool
+	peerProxy      bool
 	limitInterval  time.Duration
 	agentEndpoints []string
+	agentLogURLs   []string
+	colors         []string
+	stressSeed     int64
+	probeTimeout   time.Duration
+	dialTimeout    time.Duration
+	leaderTimeout  time.Duration
+	statusMetrics  []string
+
+	statusConcurrency int
+
+	retry    RetryPolicy
+	balanced bool
+
+	memberWatchInterval time.Duration
+
+	restoreSnapshot string
+	etcdctlPath     string
+
+	maxKeySize   int
+	maxValueSize int
+
+	// nodeHooks are the hooks of each Node name, or of all Nodes for the
+	// empty name.
+	nodeHooks map[string]nodeHooks
+
+	cleanPolicy CleanPolicy
+	backupDir   string
 }
 
 func (o *op) apply(opts []OpOption) {
@@ -184,6 +659,27 @@ func WithLiveLog() OpOption {
 	}
 }
 
+// WithLogFilter writes only the etcd log lines at the level or higher,
+// such as "warning", that match the pattern, such as "raft", with
+// WithLiveLog. Either may be empty not to filter by it. The lines without
+// a level, such as the stack trace of a panic, are written regardless of
+// the level.
+func WithLogFilter(level, pattern string) OpOption {
+	return func(o *op) {
+		o.logLevel = level
+		o.logMatch = pattern
+	}
+}
+
+// WithStructuredLog parses the etcd log lines with WithLiveLog, and writes
+// their level, component and text in HTML elements that the UI can color
+// and collapse. The lines not in the etcd log format are written as is.
+func WithStructuredLog() OpOption {
+	return func(o *op) {
+		o.structuredLog = true
+	}
+}
+
 // WithLimitInterval puts limit interval between terminate and immediate restart,
 // restart and immediate terminate.
 func WithLimitInterval(d time.Duration) OpOption {
@@ -200,14 +696,222 @@ func WithAgentEndpoints(eps []string) OpOption {
 	}
 }
 
+// WithAgentLogURLs specifies the URLs serving the etcd log file of each
+// etcd-agent, to stream remote logs with WithLiveLog. The server must
+// support Range requests. Only applicable for 'etcd-play web' command when
+// deployed with remote machines.
+func WithAgentLogURLs(urls []string) OpOption {
+	return func(o *op) {
+		o.agentLogURLs = urls
+	}
+}
+
+// WithPeerProxy puts a userspace proxy in front of the peer URL of each
+// node, so that InjectLatency works without privileges. Only applicable
+// for 'etcd-play web' command in localhost.
+func WithPeerProxy() OpOption {
+	return func(o *op) {
+		o.peerProxy = true
+	}
+}
+
+// WithColors specifies the palette of HTML colors(#rrggbb) for node logs.
+// Nodes reuse colors when there are more nodes than colors.
+func WithColors(colors []string) OpOption {
+	return func(o *op) {
+		o.colors = colors
+	}
+}
+
+// WithStressSeed seeds the keys and values of Stress and FillUntilQuota,
+// so that the runs can be replayed. Zero seeds each run with the current
+// time.
+func WithStressSeed(seed int64) OpOption {
+	return func(o *op) {
+		o.stressSeed = seed
+	}
+}
+
+// WithStartProbe makes Start and Restart wait until the node answers the
+// Status RPC, for at most the timeout. Only applicable for 'etcd-play web'
+// command in localhost.
+func WithStartProbe(timeout time.Duration) OpOption {
+	return func(o *op) {
+		o.probeTimeout = timeout
+	}
+}
+
+// defaultDialTimeout is the client dial timeout unless WithDialTimeout
+// is given.
+const defaultDialTimeout = 5 * time.Second
+
+// WithDialTimeout sets the timeout for the clients to connect to the
+// nodes. Non-positive values keep the default of 5 seconds.
+func WithDialTimeout(d time.Duration) OpOption {
+	return func(o *op) {
+		o.dialTimeout = d
+	}
+}
+
+// WithLeaderWait makes Bootstrap wait until the started nodes elect a
+// leader, and roll back the nodes if they do not within the timeout, as
+// with a misconfigured initial cluster.
+func WithLeaderWait(timeout time.Duration) OpOption {
+	return func(o *op) {
+		o.leaderTimeout = timeout
+	}
+}
+
+// WithSnapshotRestore makes Bootstrap restore the data directory of each
+// node from the snapshot file with the etcdctl binary, so that the cluster
+// starts with the keys of the snapshot. Only applicable for 'etcd-play
+// web' command in localhost.
+func WithSnapshotRestore(snapshotPath, etcdctlPath string) OpOption {
+	return func(o *op) {
+		o.restoreSnapshot = snapshotPath
+		o.etcdctlPath = etcdctlPath
+	}
+}
+
+// WithStatusMetrics specifies the Prometheus metrics, such as
+// 'etcd_disk_wal_fsync_duration_seconds_sum', that Status scrapes into
+// ServerStatus.Metrics. Only applicable for 'etcd-play web' command in
+// localhost.
+func WithStatusMetrics(names []string) OpOption {
+	return func(o *op) {
+		o.statusMetrics = names
+	}
+}
+
+// defaultStatusConcurrency is the number of members Status asks at once
+// unless WithStatusConcurrency is given.
+const defaultStatusConcurrency = 8
+
+// WithStatusConcurrency limits the number of members Status asks at once,
+// so that a large cluster under stress is not flooded with status
+// requests. Non-positive values keep the default of 8.
+func WithStatusConcurrency(n int) OpOption {
+	return func(o *op) {
+		o.statusConcurrency = n
+	}
+}
+
+// WithRetryPolicy retries the Put, Get and Delete requests that fail with
+// a transient error, so that a leader election does not fail the requests
+// of a demo. The requests are not retried by default.
+func WithRetryPolicy(p RetryPolicy) OpOption {
+	return func(o *op) {
+		o.retry = p
+	}
+}
+
+// WithBalancedEndpoints dials all active endpoints for the Put, Get and
+// Delete not given a Node name, so that clientv3 fails over to another
+// endpoint when the Node dies. The Node that served the request is written
+// to the streams. The requests given a Node name still dial only the Node.
+func WithBalancedEndpoints() OpOption {
+	return func(o *op) {
+		o.balanced = true
+	}
+}
+
+// WithMemberWatch reconciles the Nodes with the etcd membership every
+// interval after Bootstrap, so that Status also shows the members added
+// out of band, such as with etcdctl. Non-positive values do not watch the
+// membership.
+func WithMemberWatch(interval time.Duration) OpOption {
+	return func(o *op) {
+		o.memberWatchInterval = interval
+	}
+}
+
+// WithSizeLimits rejects the writes of the keys or values larger than the
+// sizes in bytes, so that the users of a public demo cannot fill the
+// cluster. Zero does not limit the size.
+func WithSizeLimits(maxKeySize, maxValueSize int) OpOption {
+	return func(o *op) {
+		o.maxKeySize = maxKeySize
+		o.maxValueSize = maxValueSize
+	}
+}
+
+// WithNodeHooks registers onStart, called when the named Node becomes
+// active with Start or Restart, and onExit, called when it becomes
+// inactive with Terminate, Kill, or an exit of its process. The empty name
+// registers the hooks for all Nodes, and either hook may be nil. The hooks
+// run without the cluster lock, so they may call the Cluster, but they
+// block the operation until they return.
+func WithNodeHooks(name string, onStart, onExit NodeHook) OpOption {
+	return func(o *op) {
+		if o.nodeHooks == nil {
+			o.nodeHooks = make(map[string]nodeHooks)
+		}
+		var h nodeHooks
+		if onStart != nil {
+			h.onStart = []NodeHook{onStart}
+		}
+		if onExit != nil {
+			h.onExit = []NodeHook{onExit}
+		}
+		o.nodeHooks[name] = o.nodeHooks[name].merge(h)
+	}
+}
+
+// WithCleanPolicy sets what Clean does with the data directories, to keep
+// them for inspection after a crash. CleanBackup moves them into
+// backupDir, or next to each data directory if backupDir is empty, which
+// must be on the same filesystem. ResetNode backs up the data directory
+// with CleanKeep, since it must start from scratch. Only applicable for
+// 'etcd-play web' command in localhost.
+func WithCleanPolicy(policy CleanPolicy, backupDir string) OpOption {
+	return func(o *op) {
+		o.cleanPolicy = policy
+		o.backupDir = backupDir
+	}
+}
+
 // NewCluster creates Cluster with generated flags.
 func NewCluster(opt NodeType, programPath string, fs []*Flags, opts ...OpOption) (Cluster, error) {
+	c, err := newCluster(opt, programPath, fs, true, opts...)
+	if c == nil {
+		// not to return a non-nil Cluster holding a nil pointer
+		return nil, err
+	}
+	return c, err
+}
+
+// newCluster creates Cluster. If combine is false, it keeps the initial
+// cluster flags of fs, to restore a saved cluster.
+func newCluster(opt NodeType, programPath string, fs []*Flags, combine bool, opts ...OpOption) (*defaultCluster, error) {
 	if len(fs) == 0 {
 		return nil, nil
 	}
 
-	o := &op{}
+	o := &op{colors: colorsToHTML, statusMetrics: defaultStatusMetrics}
 	o.apply(opts)
+	if o.dialTimeout <= 0 {
+		o.dialTimeout = defaultDialTimeout
+	}
+	if o.statusConcurrency <= 0 {
+		o.statusConcurrency = defaultStatusConcurrency
+	}
+	if o.retry.Backoff <= 0 {
+		o.retry.Backoff = defaultRetryBackoff
+	}
+	if o.retry.MaxBackoff <= 0 {
+		o.retry.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if o.maxKeySize < 0 || o.maxValueSize < 0 {
+		return nil, fmt.Errorf("negative key size limit %d or value size limit %d", o.maxKeySize, o.maxValueSize)
+	}
+	if len(o.colors) == 0 {
+		return nil, fmt.Errorf("no colors found")
+	}
+	for _, cr := range o.colors {
+		if !htmlColorRegex.MatchString(cr) {
+			return nil, fmt.Errorf("%q is not a valid HTML color", cr)
+		}
+	}
 
 	if len(o.agentEndpoints) > 0 && opt == WebRemote {
 		if len(o.agentEndpoints) != len(fs) {
@@ -215,25 +919,82 @@ func NewCluster(opt NodeType, programPath string, fs []*Flags, opts ...OpOption)
 		}
 	}
 
-	if err := CombineFlags(opt == WebRemote, fs...); err != nil {
+	logFilter, err := newLogFilter(o.logLevel, o.logMatch)
+	if err != nil {
+		return nil, err
+	}
+
+	switch o.cleanPolicy {
+	case CleanRemove, CleanBackup, CleanKeep:
+	default:
+		return nil, fmt.Errorf("unknown clean policy %v", o.cleanPolicy)
+	}
+
+	if o.restoreSnapshot != "" {
+		if opt != WebLocal {
+			return nil, fmt.Errorf("snapshot restore is only supported for local nodes")
+		}
+		if err := checkSnapshotFile(o.restoreSnapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := Validate(fs); err != nil {
 		return nil, err
 	}
+	for name := range o.nodeHooks {
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, f := range fs {
+			found = found || f.Name == name
+		}
+		if !found {
+			return nil, fmt.Errorf("hooks for %s %w", name, ErrNodeNotFound)
+		}
+	}
+	if combine {
+		if err := CombineFlags(opt == WebRemote, fs...); err != nil {
+			return nil, err
+		}
+	}
 
 	bufferedStream := make(chan string, 5000)
+	ctx, cancel := context.WithCancel(context.Background())
 	c := &defaultCluster{
 		mu:           sync.Mutex{},
 		sharedStream: bufferedStream,
 		idToStream:   make(map[string]chan string),
+		events:       newEventLog(eventLogSize),
+		operations:   newOperationRegistry(ctx),
 		nameToNode:   make(map[string]Node),
 		epToName:     make(map[string]string),
+		members:      make(map[string]string),
+		discovered:   make(map[string]struct{}),
+		stressSeed:   o.stressSeed,
+		dialTimeout:  o.dialTimeout,
+
+		leaderTimeout:     o.leaderTimeout,
+		statusMetrics:     o.statusMetrics,
+		statusConcurrency: o.statusConcurrency,
+		retry:             o.retry,
+		balanced:          o.balanced,
+
+		memberWatchInterval: o.memberWatchInterval,
+
+		restoreSnapshot: o.restoreSnapshot,
+		etcdctlPath:     o.etcdctlPath,
+
+		maxKeySize:   o.maxKeySize,
+		maxValueSize: o.maxValueSize,
+
+		ctx:    ctx,
+		cancel: cancel,
 	}
 
-	var maxProcNameLength, colorIdx int
+	var maxProcNameLength int
 	for i, f := range fs {
-		if colorIdx >= len(colorsToHTML) {
-			colorIdx = 0
-		}
-
 		name := f.Name
 		if len(name) > maxProcNameLength {
 			maxProcNameLength = len(name)
@@ -243,16 +1004,32 @@ func NewCluster(opt NodeType, programPath string, fs []*Flags, opts ...OpOption)
 			certPath = path.Join(f.DataDir, "fixtures/client/cert.pem")
 			keyPath  = path.Join(f.DataDir, "fixtures/client/key.pem")
 		)
+		nodeProgramPath := programPath
+		if strings.TrimSpace(f.ProgramPath) != "" {
+			nodeProgramPath = f.ProgramPath
+		}
+
 		var ni Node
 		switch opt {
 		case WebLocal:
+			var peerProxy *latencyProxy
+			if o.peerProxy {
+				p, err := setPeerProxy(f)
+				if err != nil {
+					return nil, err
+				}
+				peerProxy = p
+			}
 			ni = &NodeWebLocal{
 				pmu:                &c.mu,
 				pmaxProcNameLength: &maxProcNameLength,
-				colorIdx:           colorIdx,
+				color:              o.colors[colorIndex(name, len(o.colors))],
 				liveLog:            o.liveLog,
+				logFilter:          logFilter,
+				structured:         o.structuredLog,
 				sharedStream:       bufferedStream, // shared by all nodes
-				ProgramPath:        programPath,
+				pdropped:           &c.dropped,
+				ProgramPath:        nodeProgramPath,
 				Flags:              f,
 				TLSCertPath:        certPath,
 				TLSKeyPath:         keyPath,
@@ -261,6 +1038,11 @@ func NewCluster(opt NodeType, programPath string, fs []*Flags, opts ...OpOption)
 				PID:                0,
 				active:             false,
 				limitInterval:      o.limitInterval,
+				peerProxy:          peerProxy,
+				probeTimeout:       o.probeTimeout,
+				hooks:              o.nodeHooks[""].merge(o.nodeHooks[name]),
+				cleanPolicy:        o.cleanPolicy,
+				backupDir:          o.backupDir,
 			}
 
 		case WebRemote:
@@ -271,22 +1053,32 @@ func NewCluster(opt NodeType, programPath string, fs []*Flags, opts ...OpOption)
 			if err != nil {
 				return nil, err
 			}
+			var lt logTailer
+			if i < len(o.agentLogURLs) {
+				lt = newHTTPLogTailer(o.agentLogURLs[i])
+			}
 			ni = &NodeWebRemoteClient{
 				Flags:         f,
 				TLSCertPath:   certPath,
 				TLSKeyPath:    keyPath,
 				TLSConfig:     nil,
 				Agent:         a,
+				color:         o.colors[colorIndex(name, len(o.colors))],
+				liveLog:       o.liveLog,
+				logFilter:     logFilter,
+				structured:    o.structuredLog,
+				sharedStream:  bufferedStream, // shared by all nodes
+				pdropped:      &c.dropped,
+				logTailer:     lt,
 				active:        false,
 				limitInterval: o.limitInterval,
+				hooks:         o.nodeHooks[""].merge(o.nodeHooks[name]),
 			}
 
 		default:
 			return nil, fmt.Errorf("NodeType %v is not implemented", opt)
 		}
 		c.nameToNode[name] = ni
-
-		colorIdx++
 	}
 
 	return c, nil
@@ -297,33 +1089,47 @@ func (c *defaultCluster) Write(name, msg string, streamIDs ...string) error {
 	nd, ok := c.nameToNode[name]
 	c.mu.Unlock()
 
-	if !ok {
-		return fmt.Errorf("%s does not exist", name)
+	// the operations on a raw endpoint are written under the endpoint
+	if _, raw := rawEndpoint(name); !ok && !raw {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
 	}
 
-	switch vt := nd.(type) {
-	case *NodeWebLocal:
-		if len(streamIDs) == 0 {
-			vt.sharedStream <- msg
-		} else {
-			for _, streamID := range streamIDs {
-				c.Stream(streamID) <- msg
-			}
-		}
-
-	case *NodeWebRemoteClient:
-		if len(streamIDs) > 0 {
-			for _, streamID := range streamIDs {
-				c.Stream(streamID) <- msg
-			}
-		}
-
+	switch nd.(type) {
+	case *NodeWebLocal, *NodeWebRemoteClient, nil:
 	default:
 		return fmt.Errorf("%v does not implement Write", reflect.TypeOf(nd))
 	}
+	c.events.add(newEvent(name, msg, streamIDs...))
+
+	// the streams are rendered as HTML, and the messages embed the keys and
+	// values of the users
+	msg = htmlReplacer.Replace(msg)
+
+	// without stream IDs, the message goes to the shared stream, which is
+	// read by only one of the users
+	if len(streamIDs) == 0 {
+		sendNonBlocking(c.sharedStream, msg, &c.dropped)
+		return nil
+	}
+	for _, streamID := range streamIDs {
+		c.sendStream(streamID, msg)
+	}
 	return nil
 }
 
+// sendStream sends the message to the stream of streamID without blocking.
+// It holds mu, so that CloseStream does not close the stream in between.
+func (c *defaultCluster) sendStream(streamID, msg string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.idToStream[streamID]
+	if !ok {
+		ch = make(chan string, 5000)
+		c.idToStream[streamID] = ch
+	}
+	sendNonBlocking(ch, msg, &c.dropped)
+}
+
 func (c *defaultCluster) SharedStream() chan string {
 	if c == nil {
 		return nil
@@ -346,543 +1152,3037 @@ func (c *defaultCluster) Stream(streamID string) chan string {
 	return ch
 }
 
-func (c *defaultCluster) Start(name string) error {
-	c.mu.Lock()
-	nd, ok := c.nameToNode[name]
-	c.mu.Unlock()
-	if !ok {
-		return fmt.Errorf("%s does not exist", name)
+func (c *defaultCluster) CloseStream(streamID string) {
+	if c == nil {
+		return
 	}
-	return nd.Start()
-}
-
-func (c *defaultCluster) Restart(name string) error {
 	c.mu.Lock()
-	nd, ok := c.nameToNode[name]
-	c.mu.Unlock()
-	if !ok {
-		return fmt.Errorf("%s does not exist", name)
+	defer c.mu.Unlock()
+	if ch, ok := c.idToStream[streamID]; ok {
+		delete(c.idToStream, streamID)
+		c.closeIfUnused(ch)
 	}
-	return nd.Restart()
 }
 
-func (c *defaultCluster) Revive() error {
-	for _, nd := range c.nameToNode {
-		if nd.IsActive() {
-			return nil
+// closeIfUnused closes the stream if no stream ID shares it. Caller must
+// hold mu.
+func (c *defaultCluster) closeIfUnused(ch chan string) {
+	for _, v := range c.idToStream {
+		if v == ch {
+			return
 		}
 	}
-	for _, nd := range c.nameToNode {
-		if err := nd.Restart(); err != nil {
-			return err
+	close(ch)
+}
+
+func (c *defaultCluster) ResumeStream(oldStreamID, newStreamID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch, ok := c.idToStream[oldStreamID]
+	if !ok {
+		return fmt.Errorf("%s %w", oldStreamID, ErrStreamNotFound)
+	}
+	prev, ok := c.idToStream[newStreamID]
+	c.idToStream[newStreamID] = ch
+	if !ok || prev == ch {
+		return nil
+	}
+	for {
+		select {
+		case msg := <-prev:
+			sendNonBlocking(ch, msg, &c.dropped)
+		default:
+			c.closeIfUnused(prev)
+			return nil
 		}
 	}
-	return nil
 }
 
-func (c *defaultCluster) Terminate(name string) error {
+func (c *defaultCluster) Dropped() uint64 {
+	if c == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.dropped)
+}
+
+func (c *defaultCluster) Events(since time.Time) []Event {
+	if c == nil {
+		return nil
+	}
+	return c.events.since(since)
+}
+
+func (c *defaultCluster) ListOperations() []OperationHandle {
+	return c.operations.list()
+}
+
+func (c *defaultCluster) CancelOperation(id uint64) error {
+	return c.operations.cancel(id)
+}
+
+func (c *defaultCluster) Start(name string) error {
 	c.mu.Lock()
 	nd, ok := c.nameToNode[name]
 	c.mu.Unlock()
 	if !ok {
-		return fmt.Errorf("%s does not exist", name)
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
 	}
-	return nd.Terminate()
+	return nd.Start()
 }
 
-func (c *defaultCluster) Clean(name string) error {
+func (c *defaultCluster) Restart(name string) error {
 	c.mu.Lock()
 	nd, ok := c.nameToNode[name]
 	c.mu.Unlock()
 	if !ok {
-		return fmt.Errorf("%s does not exist", name)
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
 	}
-	return nd.Clean()
+	return nd.Restart()
 }
 
-func (c *defaultCluster) Bootstrap() error {
-	if len(c.nameToNode) == 0 {
-		return nil
-	}
-	done, errC := make(chan struct{}), make(chan error)
+// nodes returns a snapshot of the Nodes taken under mu, so that callers
+// can iterate them while Nodes are added or removed. The Nodes must be
+// called without holding mu, which local Nodes share.
+func (c *defaultCluster) nodes() map[string]Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nameToNode := make(map[string]Node, len(c.nameToNode))
 	for name, nd := range c.nameToNode {
+		nameToNode[name] = nd
+	}
+	return nameToNode
+}
+
+// names returns the sorted names of the Nodes.
+func (c *defaultCluster) names() []string {
+	nameToNode := c.nodes()
+	names := make([]string, 0, len(nameToNode))
+	for name := range nameToNode {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Revive restarts the down Nodes together rather than one by one: etcd
+// serves the clients only with the quorum, so a Node restarted alone after
+// the quorum is lost would never pass the start probe.
+func (c *defaultCluster) Revive() error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		rerr error
+	)
+	for name, nd := range c.nodes() {
+		if nd.IsActive() {
+			continue
+		}
+		wg.Add(1)
 		go func(name string, nd Node) {
-			logger.Infof("starting node %q", name)
-			err := nd.Start()
-			if err != nil {
-				errC <- fmt.Errorf("%s (%v)", name, err)
-				return
+			defer wg.Done()
+			logger.Infof("reviving node %q", name)
+			if err := nd.Restart(); err != nil {
+				logger.Errorf("revive %q error (%v)", name, err)
+				mu.Lock()
+				if rerr == nil {
+					rerr = fmt.Errorf("%s (%w)", name, err)
+				}
+				mu.Unlock()
 			}
-			done <- struct{}{}
 		}(name, nd)
 	}
+	wg.Wait()
+	return rerr
+}
 
-	cn := 0
-	for cn != len(c.nameToNode) {
-		select {
-		case <-done:
-		case err := <-errC:
-			return err
+var (
+	// rollingTimeout is the timeout for each Node to rejoin the cluster in
+	// RollingRestart.
+	rollingTimeout = time.Minute
+
+	// rollingPollInterval is the interval to check the cluster health in
+	// RollingRestart.
+	rollingPollInterval = 500 * time.Millisecond
+)
+
+// healthyCount returns the number of healthy Nodes.
+func healthyCount(nameToHealth map[string]bool) int {
+	n := 0
+	for _, ok := range nameToHealth {
+		if ok {
+			n++
 		}
-		cn++
 	}
-
-	sc := make(chan os.Signal, 10)
-	signal.Notify(sc, os.Interrupt, os.Kill)
-	s := <-sc
-	logger.Infof("shutting down cluster with signal %q", s.String())
-	return c.Shutdown()
+	return n
 }
 
-func (c *defaultCluster) Shutdown() error {
-	if len(c.nameToNode) == 0 {
-		return nil
+func (c *defaultCluster) RollingRestart(streamIDs ...string) error {
+	ctx, deregister := c.operations.register(c.ctx, "ROLLING RESTART", streamIDs)
+	defer deregister()
+
+	nameToNode := c.nodes()
+	names := make([]string, 0, len(nameToNode))
+	for name := range nameToNode {
+		names = append(names, name)
 	}
-	var wg sync.WaitGroup
-	wg.Add(len(c.nameToNode))
-	for name, nd := range c.nameToNode {
-		go func(name string, nd Node) {
-			defer wg.Done()
-			if err := nd.Terminate(); err != nil {
-				logger.Errorf("terminate %q error (%v)", name, err)
+	sort.Strings(names)
+	quorum := len(names)/2 + 1
+
+	for _, name := range names {
+		// canceled between the Nodes, not to leave one down
+		if ctx.Err() != nil {
+			c.Write(name, fmt.Sprintf("[ROLLING RESTART] Canceled before restarting %s", name), streamIDs...)
+			return ctx.Err()
+		}
+		nameToHealth, _ := c.Health()
+		if n := healthyCount(nameToHealth); n-1 < quorum {
+			return fmt.Errorf("only %d of %d nodes are healthy, restarting %s would lose the quorum (%d)", n, len(names), name, quorum)
+		}
+
+		c.Write(name, fmt.Sprintf("[ROLLING RESTART] Terminating %s", name), streamIDs...)
+		if err := c.Terminate(name); err != nil {
+			return err
+		}
+
+		deadline := time.Now().Add(rollingTimeout)
+		if err := c.restartBy(name, deadline); err != nil {
+			return err
+		}
+		c.Write(name, fmt.Sprintf("[ROLLING RESTART] Restarted %s, waiting for it to rejoin", name), streamIDs...)
+
+		for {
+			nameToHealth, _ = c.Health()
+			n := healthyCount(nameToHealth)
+			if n == len(names) {
+				break
 			}
-			if err := nd.Clean(); err != nil {
-				logger.Errorf("clean %q error (%v)", name, err)
+			if n < quorum {
+				return fmt.Errorf("lost the quorum while restarting %s (%d of %d nodes are healthy)", name, n, len(names))
 			}
-		}(name, nd)
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s did not rejoin the cluster (%w)", name, ErrTimeout)
+			}
+			time.Sleep(rollingPollInterval)
+		}
+		c.Write(name, fmt.Sprintf("[ROLLING RESTART] %s rejoined the cluster", name), streamIDs...)
 	}
-	wg.Wait()
 	return nil
 }
 
-func (c *defaultCluster) Endpoints() ([]string, map[string]string, map[string]string) {
-	var (
-		endpoints          []string
-		nameToGRPCEndpoint = make(map[string]string)
-		grpcEndpointToName = make(map[string]string)
-	)
-	for n, nd := range c.nameToNode {
-		if nd.Endpoint() != "" && nd.IsActive() {
-			endpoints = append(endpoints, nd.Endpoint())
+// restartBy restarts the Node, waiting out the limit interval since it was
+// terminated until the deadline.
+func (c *defaultCluster) restartBy(name string, deadline time.Time) error {
+	for {
+		err := c.Restart(name)
+		if err == nil {
+			return nil
 		}
-		ep := nd.Endpoint()
-		nameToGRPCEndpoint[n] = ep
-		grpcEndpointToName[ep] = n
+		if !errors.Is(err, ErrLimitInterval) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(rollingPollInterval)
 	}
-	sort.Strings(endpoints)
-	return endpoints, nameToGRPCEndpoint, grpcEndpointToName
 }
 
-func (c *defaultCluster) Leader() (string, error) {
-	endpoints, _, epToName := c.Endpoints()
-	var lerr error
-	for _, ep := range endpoints {
-		cli, err := clientv3.New(clientv3.Config{
-			Endpoints:   []string{ep},
-			DialTimeout: 5 * time.Second,
-		})
-		if err != nil {
-			lerr = err
-			continue
+func (c *defaultCluster) ResetNode(name string, streamIDs ...string) error {
+	done, err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	size := len(c.nameToNode)
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	quorum := size/2 + 1
+
+	// the other Nodes must keep the quorum, to replicate to the reset one
+	nameToHealth, _ := c.Health()
+	others := healthyCount(nameToHealth)
+	if nameToHealth[name] {
+		others--
+	}
+	if others < quorum {
+		return fmt.Errorf("only %d of %d other nodes are healthy, resetting %s would lose the quorum (%d)", others, size-1, name, quorum)
+	}
+
+	if nd.IsActive() {
+		c.Write(name, fmt.Sprintf("[RESET] Terminating %s", name), streamIDs...)
+		if err := nd.Terminate(); err != nil {
+			return err
 		}
-		defer cli.Close()
+	}
 
-		mapi := clientv3.NewMaintenance(cli)
-		resp, err := mapi.Status(context.Background(), ep)
-		if err != nil {
-			lerr = err
-			continue
+	// A member cannot restart with the empty data under its old ID: the
+	// leader still has the old log index of the member, and raft panics
+	// with "tocommit out of range" on the first append. So the member is
+	// replaced, and the Node restarts to join as the new member, with
+	// InitialClusterState "existing". The data is removed only after the
+	// replacement, so that a failed one leaves the Node restartable.
+	if err := c.replaceMember(name, nd.Endpoint(), streamIDs...); err != nil {
+		return err
+	}
+
+	c.Write(name, fmt.Sprintf("[RESET] Removing the data of %s", name), streamIDs...)
+	clean := nd.Clean
+	if local, ok := nd.(*NodeWebLocal); ok {
+		clean = local.resetDataDir
+	}
+	if err := clean(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(rollingTimeout)
+	if err := c.restartBy(name, deadline); err != nil {
+		return err
+	}
+	c.Write(name, fmt.Sprintf("[RESET] Restarted %s with empty data, waiting for the snapshot from the leader", name), streamIDs...)
+
+	local, _ := nd.(*NodeWebLocal)
+	var last DataDirInfo
+	for {
+		nameToHealth, _ = c.Health()
+
+		// the received snapshot shows up in the data directory of local Nodes
+		if local != nil {
+			if info, err := inspectDataDir(local.Flags.DataDir); err == nil {
+				if info.LatestSnapshotIndex != last.LatestSnapshotIndex || info.DBSize != last.DBSize {
+					c.Write(name, fmt.Sprintf("[RESET] %s has snapshot at index %d (database %s)", name, info.LatestSnapshotIndex, humanize.Bytes(uint64(info.DBSize))), streamIDs...)
+				}
+				last = info
+			}
 		}
 
-		if resp.Header.MemberId == resp.Leader {
-			return epToName[ep], nil
+		if nameToHealth[name] {
+			break
 		}
-		lerr = nil
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not rejoin the cluster (%w)", name, ErrTimeout)
+		}
+		time.Sleep(rollingPollInterval)
 	}
-	return "", fmt.Errorf("no leader found (%v)", lerr)
+	c.Write(name, fmt.Sprintf("[RESET] %s rejoined the cluster", name), streamIDs...)
+	return nil
 }
 
-var emptyStat = ServerStatus{
-	Name:      "",
-	ID:        "unknown",
-	Endpoint:  "unknown",
-	State:     "unreachable",
-	Hash:      0,
-	DbSize:    0,
-	DbSizeTxt: "0 B",
+// replaceMember removes the member of the Node from the cluster, and adds
+// a new member with the same peer URLs, via another active Node. The member
+// is found by its name, or by the client endpoint if it has no name yet.
+func (c *defaultCluster) replaceMember(name, endpoint string, streamIDs ...string) error {
+	seedName, seed, err := c.pick("")
+	if err != nil {
+		return err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{seed},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
 
-	// NumberOfKeys: 0,
+	capi := clientv3.NewCluster(cli)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	lresp, err := capi.MemberList(ctx)
+	if err != nil {
+		return fmt.Errorf("member list from %s (%v)", seedName, err)
+	}
+	var m *pb.Member
+	for _, mm := range lresp.Members {
+		if mm.Name == name || memberClientEndpoint(mm.ClientURLs) == endpoint {
+			m = mm
+			break
+		}
+	}
+	if m == nil {
+		return fmt.Errorf("%s is not a member of the cluster", name)
+	}
+
+	if _, err = capi.MemberRemove(ctx, m.ID); err != nil {
+		return fmt.Errorf("remove member %x of %s (%v)", m.ID, name, err)
+	}
+	c.Write(name, fmt.Sprintf("[RESET] Removed member %x of %s", m.ID, name), streamIDs...)
+	aresp, err := capi.MemberAdd(ctx, m.PeerURLs)
+	if err != nil {
+		return fmt.Errorf("add member of %s with peer URLs %q (%v)", name, m.PeerURLs, err)
+	}
+	c.Write(name, fmt.Sprintf("[RESET] Added member %x of %s (peer URLs: %q)", aresp.Member.ID, name, m.PeerURLs), streamIDs...)
+	return nil
 }
 
-func getStatus(name, grpcEndpoint, v2Endpoint string, rs chan ServerStatus, errc chan error) {
-	// func getStatus(name, grpcEndpoint, v2Endpoint string, tlsConfig *tls.Config, rs chan ServerStatus, errc chan error) {
-	// tc := credentials.NewTLS(tlsConfig)
-	// conn, err := grpc.Dial(grpcEndpoint, grpc.WithTransportCredentials(tc), grpc.WithTimeout(5*time.Second))
+// ChaosConfig configures Chaos.
+type ChaosConfig struct {
+	// Interval is the time between rounds.
+	Interval time.Duration
 
-	conn, err := grpc.Dial(grpcEndpoint, grpc.WithInsecure(), grpc.WithTimeout(5*time.Second))
-	if err != nil {
-		errc <- err
-		return
+	// KillProbability is the probability (0.0 ~ 1.0) to terminate a Node
+	// in each round.
+	KillProbability float64
+
+	// Downtime is how long the terminated Node stays down.
+	Downtime time.Duration
+
+	// Rounds is the number of rounds to run. Zero runs until the context
+	// is canceled.
+	Rounds int
+
+	// Seed seeds the random choices, so that a run can be replayed. Zero
+	// seeds with the current time.
+	Seed int64
+}
+
+func (c *defaultCluster) Chaos(ctx context.Context, cfg ChaosConfig, streamIDs ...string) error {
+	if cfg.KillProbability < 0 || cfg.KillProbability > 1 {
+		return fmt.Errorf("kill probability %v is out of range [0, 1]", cfg.KillProbability)
 	}
-	defer conn.Close()
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	rnd := rand.New(rand.NewSource(seed))
+	logger.Infof("chaos started with seed %d", seed)
 
-	stat := emptyStat
-	stat.Name = name
-	stat.Endpoint = grpcEndpoint
+	ctx, deregister := c.operations.register(ctx, "CHAOS", streamIDs)
+	defer deregister()
 
-	done, errChan := make(chan struct{}), make(chan error)
+	for round := 0; cfg.Rounds == 0 || round < cfg.Rounds; round++ {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(cfg.Interval):
+		}
+		if err := c.chaosRound(ctx, rnd, cfg, streamIDs...); err != nil {
+			if errors.Is(err, ErrClusterShutdown) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
 
-	// ID, State, DbSize
-	go func() {
-		mapi := pb.NewMaintenanceClient(conn)
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		sresp, err := mapi.Status(ctx, &pb.StatusRequest{})
-		cancel()
-		if err != nil {
-			errChan <- err
-			return
+// chaosRound terminates a random active Node with the kill probability,
+// unless that loses the quorum, and restarts it after the downtime.
+func (c *defaultCluster) chaosRound(ctx context.Context, rnd *rand.Rand, cfg ChaosConfig, streamIDs ...string) error {
+	done, err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	nameToNode := c.nodes()
+	names := make([]string, 0, len(nameToNode))
+	for name := range nameToNode {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var active []string
+	for _, name := range names {
+		if nameToNode[name].IsActive() {
+			active = append(active, name)
 		}
-		mid := sresp.Header.MemberId
-		stat.ID = fmt.Sprintf("%x", mid)
-		stat.State = "Follower"
-		if mid == sresp.Leader {
-			stat.State = "Leader"
+	}
+	quorum := len(names)/2 + 1
+
+	kill := rnd.Float64() < cfg.KillProbability
+	if !kill || len(active) == 0 {
+		return nil
+	}
+	name := active[rnd.Intn(len(active))]
+	if len(active)-1 < quorum {
+		c.Write(name, fmt.Sprintf("[CHAOS] Skipping %s, only %d of %d nodes are active (quorum %d)", name, len(active), len(names), quorum), streamIDs...)
+		return nil
+	}
+
+	c.Write(name, fmt.Sprintf("[CHAOS] Terminating %s", name), streamIDs...)
+	if err := c.Terminate(name); err != nil {
+		if errors.Is(err, ErrLimitInterval) || errors.Is(err, ErrNodeInactive) {
+			c.Write(name, fmt.Sprintf("[CHAOS] Skipping %s (%v)", name, err), streamIDs...)
+			return nil
 		}
-		stat.DbSize = uint64(sresp.DbSize)
-		stat.DbSizeTxt = humanize.Bytes(stat.DbSize)
-		done <- struct{}{}
-	}()
+		return err
+	}
+
 	select {
-	case <-time.After(5 * time.Second):
-		errc <- fmt.Errorf("timed out")
-		return
-	case err := <-errChan:
-		errc <- err
-		return
-	case <-done:
+	case <-ctx.Done():
+	case <-time.After(cfg.Downtime):
+	}
+
+	// restart even after ctx is canceled, so that the cluster is left with
+	// all nodes running; wait out the limit interval if needed, but not
+	// past the rolling timeout or the shutdown of the cluster
+	deadline := time.Now().Add(rollingTimeout)
+	for {
+		err := c.Restart(name)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrLimitInterval) || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-time.After(rollingPollInterval):
+		case <-c.ctx.Done():
+			return ErrClusterShutdown
+		}
+	}
+	c.Write(name, fmt.Sprintf("[CHAOS] Restarted %s", name), streamIDs...)
+	return nil
+}
+
+func (c *defaultCluster) Terminate(name string) error {
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	return nd.Terminate()
+}
+
+func (c *defaultCluster) Kill(name string) error {
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	return nd.Kill()
+}
+
+func (c *defaultCluster) Isolate(name string, streamIDs ...string) error {
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	mechanism, err := nd.Isolate()
+	if err != nil {
+		return err
+	}
+	c.Write(name, fmt.Sprintf("[ISOLATE] %s is isolated from its peers (using %s)", name, mechanism), streamIDs...)
+	return nil
+}
+
+func (c *defaultCluster) Unisolate(name string, streamIDs ...string) error {
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	if err := nd.Unisolate(); err != nil {
+		return err
+	}
+	c.Write(name, fmt.Sprintf("[ISOLATE] %s rejoined its peers", name), streamIDs...)
+	return nil
+}
+
+func (c *defaultCluster) InjectLatency(name string, delay time.Duration, loss float64, streamIDs ...string) error {
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	if loss < 0 || loss > 1 {
+		return fmt.Errorf("loss must be between 0.0 and 1.0 (%v)", loss)
+	}
+	if err := nd.InjectLatency(delay, loss); err != nil {
+		return err
+	}
+	c.Write(name, fmt.Sprintf("[LATENCY] %s peer traffic is delayed by %v with %.1f%% loss", name, delay, loss*100), streamIDs...)
+	return nil
+}
+
+func (c *defaultCluster) ClearImpairments(name string, streamIDs ...string) error {
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	if err := nd.ClearImpairments(); err != nil {
+		return err
+	}
+	c.Write(name, fmt.Sprintf("[LATENCY] %s peer traffic is back to normal", name), streamIDs...)
+	return nil
+}
+
+func (c *defaultCluster) Clean(name string) error {
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	return nd.Clean()
+}
+
+func (c *defaultCluster) InspectDataDir(name string) (DataDirInfo, error) {
+	c.mu.Lock()
+	nd, ok := c.nameToNode[name]
+	c.mu.Unlock()
+	if !ok {
+		return DataDirInfo{}, fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	v, ok := nd.(*NodeWebLocal)
+	if !ok {
+		return DataDirInfo{}, fmt.Errorf("%s is not a local node (data directory is on the remote machine)", name)
+	}
+	return inspectDataDir(v.Flags.DataDir)
+}
+
+func (c *defaultCluster) Bootstrap() error {
+	return c.bootstrap(nil)
+}
+
+func (c *defaultCluster) BootstrapProgress(progress chan<- BootstrapEvent) error {
+	defer close(progress)
+	return c.bootstrap(progress)
+}
+
+// bootstrap starts all Nodes, sending their progress to progress if not
+// nil.
+func (c *defaultCluster) bootstrap(progress chan<- BootstrapEvent) error {
+	emit := func(name string, state BootstrapState, err error) {
+		if progress != nil {
+			progress <- BootstrapEvent{Name: name, State: state, Err: err}
+		}
+	}
+	nameToNode := c.nodes()
+	if len(nameToNode) == 0 {
+		return nil
+	}
+	if c.restoreSnapshot != "" {
+		for name, nd := range nameToNode {
+			logger.Infof("restoring node %q from %q", name, c.restoreSnapshot)
+			if err := restoreDataDir(c.etcdctlPath, c.restoreSnapshot, nd.(*NodeWebLocal).Flags); err != nil {
+				return err
+			}
+		}
+	}
+	var (
+		wg      sync.WaitGroup
+		smu     sync.Mutex // guards started
+		started []string
+		errc    = make(chan error, len(nameToNode))
+	)
+	wg.Add(len(nameToNode))
+	for name, nd := range nameToNode {
+		go func(name string, nd Node) {
+			defer wg.Done()
+			logger.Infof("starting node %q", name)
+			emit(name, NodeStarting, nil)
+			start := nd.Start
+			if sn, ok := nd.(startNotifier); ok {
+				start = func() error {
+					return sn.startNotify(func() { emit(name, NodeStarted, nil) })
+				}
+			}
+			if err := start(); err != nil {
+				emit(name, NodeFailed, err)
+				errc <- fmt.Errorf("%s (%w)", name, err)
+				return
+			}
+			emit(name, NodeReady, nil)
+			smu.Lock()
+			started = append(started, name)
+			smu.Unlock()
+		}(name, nd)
+	}
+	wg.Wait()
+	close(errc)
+
+	err, failed := <-errc
+	if !failed && c.leaderTimeout > 0 {
+		err = c.waitLeader(c.leaderTimeout)
+		failed = err != nil
+	}
+	if !failed {
+		c.startMemberWatch()
+		return nil
+	}
+
+	// roll back the nodes that did start, not to leak processes and ports
+	for _, name := range started {
+		nd := nameToNode[name]
+		logger.Infof("rolling back node %q", name)
+		if terr := nd.Terminate(); terr != nil {
+			logger.Errorf("terminate %q error (%v)", name, terr)
+		}
+		if cerr := nd.Clean(); cerr != nil {
+			logger.Errorf("clean %q error (%v)", name, cerr)
+		}
+	}
+	return err
+}
+
+// leaderPollInterval is the interval to check for a leader in Bootstrap.
+var leaderPollInterval = 500 * time.Millisecond
+
+// waitLeader waits until a Node reports itself as the leader.
+func (c *defaultCluster) waitLeader(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		name, err := c.Leader()
+		if err == nil {
+			logger.Infof("%q is elected as the leader", name)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no leader elected within %v, check the initial cluster (%w, %v)", timeout, ErrTimeout, err)
+		}
+		time.Sleep(leaderPollInterval)
+	}
+}
+
+// WaitForSignal blocks until the process receives an interrupt signal,
+// and returns the signal.
+func WaitForSignal() os.Signal {
+	sc := make(chan os.Signal, 10)
+	signal.Notify(sc, os.Interrupt, os.Kill)
+	defer signal.Stop(sc)
+	return <-sc
+}
+
+// shutdownDrainTimeout is the maximum time for Shutdown to wait for
+// in-flight operations.
+var shutdownDrainTimeout = 10 * time.Second
+
+// begin registers an in-flight operation, so that Shutdown waits for it.
+// The returned function must be called when the operation is done.
+func (c *defaultCluster) begin() (func(), error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return nil, ErrClusterShutdown
+	}
+	c.inflight.Add(1)
+	return c.inflight.Done, nil
+}
+
+func (c *defaultCluster) Shutdown() error {
+	nameToNode := c.nodes()
+	if len(nameToNode) == 0 {
+		return nil
+	}
+
+	// stop the long-running operations, and wait for in-flight
+	// operations, so that they do not dial terminated endpoints
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+	c.cancel()
+	drainc := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(drainc)
+	}()
+	select {
+	case <-drainc:
+	case <-time.After(shutdownDrainTimeout):
+		logger.Warningf("shutting down with in-flight operations after %v", shutdownDrainTimeout)
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(nameToNode))
+	for name, nd := range nameToNode {
+		go func(name string, nd Node) {
+			defer wg.Done()
+			if err := nd.Terminate(); err != nil {
+				logger.Errorf("terminate %q error (%v)", name, err)
+			}
+			if err := nd.Clean(); err != nil {
+				logger.Errorf("clean %q error (%v)", name, err)
+			}
+		}(name, nd)
+	}
+	wg.Wait()
+
+	for _, nd := range nameToNode {
+		if v, ok := nd.(*NodeWebLocal); ok && v.peerProxy != nil {
+			v.peerProxy.Close()
+		}
+	}
+	return nil
+}
+
+func (c *defaultCluster) Endpoints() ([]string, map[string]string, map[string]string) {
+	var (
+		endpoints          []string
+		nameToGRPCEndpoint = make(map[string]string)
+		grpcEndpointToName = make(map[string]string)
+	)
+	for n, nd := range c.nodes() {
+		if nd.Endpoint() != "" && nd.IsActive() {
+			endpoints = append(endpoints, nd.Endpoint())
+		}
+		ep := nd.Endpoint()
+		nameToGRPCEndpoint[n] = ep
+		grpcEndpointToName[ep] = n
+	}
+	sort.Strings(endpoints)
+	return endpoints, nameToGRPCEndpoint, grpcEndpointToName
+}
+
+func (c *defaultCluster) DiscoverEndpoints(seed string) ([]string, error) {
+	done, err := c.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	endpoints, nameToEndpoint, _ := c.Endpoints()
+	if ep, ok := nameToEndpoint[seed]; ok {
+		seed = ep
+	}
+	if seed == "" {
+		_, ep, err := c.anyEndpoint()
+		if err != nil {
+			return nil, err
+		}
+		seed = ep
+	}
+
+	members, err := c.memberList(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]struct{})
+	for _, ep := range endpoints {
+		seen[ep] = struct{}{}
+	}
+	for _, m := range members {
+		for _, cu := range m.ClientURLs {
+			u, err := url.Parse(cu)
+			if err != nil || u.Host == "" {
+				continue
+			}
+			seen[u.Host] = struct{}{}
+		}
+	}
+	merged := make([]string, 0, len(seen))
+	c.mu.Lock()
+	for ep := range seen {
+		merged = append(merged, ep)
+		c.discovered[ep] = struct{}{}
+	}
+	c.mu.Unlock()
+	sort.Strings(merged)
+	return merged, nil
+}
+
+// memberList returns the members of the etcd cluster as listed by the
+// seed endpoint.
+func (c *defaultCluster) memberList(seed string) ([]*pb.Member, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{seed},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	resp, err := clientv3.NewCluster(cli).MemberList(ctx)
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("member list from %s (%v)", seed, err)
+	}
+	return resp.Members, nil
+}
+
+func (c *defaultCluster) Leader() (string, error) {
+	endpoints, _, epToName := c.Endpoints()
+	var lerr error
+	for _, ep := range endpoints {
+		cli, err := clientv3.New(clientv3.Config{
+			Endpoints:   []string{ep},
+			DialTimeout: c.dialTimeout,
+		})
+		if err != nil {
+			lerr = err
+			continue
+		}
+		defer cli.Close()
+
+		mapi := clientv3.NewMaintenance(cli)
+		resp, err := mapi.Status(context.Background(), ep)
+		if err != nil {
+			lerr = err
+			continue
+		}
+
+		if resp.Header.MemberId == resp.Leader {
+			return epToName[ep], nil
+		}
+		lerr = nil
+	}
+	return "", fmt.Errorf("no leader found (%v)", lerr)
+}
+
+// statusTimeout is the timeout for each status request.
+var statusTimeout = 5 * time.Second
+
+// catchUpThreshold is the number of raft entries a Node can be behind the
+// leader before it is reported as catching up.
+var catchUpThreshold uint64 = 1000
+
+var emptyStat = ServerStatus{
+	Name:      "",
+	ID:        "unknown",
+	Endpoint:  "unknown",
+	State:     "unreachable",
+	Hash:      0,
+	DbSize:    0,
+	DbSizeTxt: "0 B",
+
+	// NumberOfKeys: 0,
+}
+
+func getStatus(name, grpcEndpoint, v2Endpoint string, metricNames []string, rs chan ServerStatus, errc chan error) {
+	// func getStatus(name, grpcEndpoint, v2Endpoint string, tlsConfig *tls.Config, rs chan ServerStatus, errc chan error) {
+	// tc := credentials.NewTLS(tlsConfig)
+	// conn, err := grpc.Dial(grpcEndpoint, grpc.WithTransportCredentials(tc), grpc.WithTimeout(statusTimeout))
+
+	conn, err := grpc.Dial(grpcEndpoint, grpc.WithInsecure(), grpc.WithTimeout(statusTimeout))
+	if err != nil {
+		errc <- fmt.Errorf("%s status (%w)", name, err)
+		return
+	}
+	defer conn.Close()
+
+	stat := emptyStat
+	stat.Name = name
+	stat.Endpoint = grpcEndpoint
+
+	// buffered so that the requests do not block after timeout
+	done, errChan := make(chan struct{}, 1), make(chan error, 1)
+
+	// ID, State, DbSize
+	go func() {
+		mapi := pb.NewMaintenanceClient(conn)
+		ctx, cancel := context.WithTimeout(context.Background(), statusTimeout)
+		sresp, err := mapi.Status(ctx, &pb.StatusRequest{})
+		cancel()
+		if err != nil {
+			errChan <- err
+			return
+		}
+		mid := sresp.Header.MemberId
+		stat.ID = fmt.Sprintf("%x", mid)
+		stat.State = "Follower"
+		if mid == sresp.Leader {
+			stat.State = "Leader"
+		}
+		stat.DbSize = uint64(sresp.DbSize)
+		stat.DbSizeTxt = humanize.Bytes(stat.DbSize)
+		stat.RaftIndex = sresp.RaftIndex
+		stat.Version = sresp.Version
+		getV2Status(&stat, v2Endpoint, metricNames)
+		done <- struct{}{}
+	}()
+	select {
+	case <-time.After(statusTimeout):
+		errc <- fmt.Errorf("%s status (%s %w)", name, grpcEndpoint, ErrTimeout)
+		return
+	case err := <-errChan:
+		errc <- fmt.Errorf("%s status (%w)", name, err)
+		return
+	case <-done:
+	}
+
+	// Hash
+	go func() {
+		h, err := getHash(context.Background(), conn)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		stat.Hash = h
+		done <- struct{}{}
+	}()
+	select {
+	case <-time.After(statusTimeout):
+		errc <- fmt.Errorf("%s status (%s %w)", name, grpcEndpoint, ErrTimeout)
+		return
+	case err := <-errChan:
+		errc <- fmt.Errorf("%s status (%w)", name, err)
+		return
+	case <-done:
+		rs <- stat
+	}
+}
+
+// getHash returns the hash of the Node's key-value store.
+func getHash(ctx context.Context, conn *grpc.ClientConn) (int, error) {
+	mc := pb.NewMaintenanceClient(conn)
+	ctx, cancel := context.WithTimeout(ctx, statusTimeout)
+	resp, err := mc.Hash(ctx, &pb.HashRequest{})
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Hash), nil
+}
+
+func (c *defaultCluster) Status() (map[string]ServerStatus, error) {
+	_, nameToEndpoint, _ := c.Endpoints()
+	nameToNode := c.nodes()
+	// the members added out of band are asked like the Nodes, and shown
+	// unreachable if they do not answer
+	for name, ep := range c.memberEndpoints() {
+		nameToEndpoint[name] = ep
+	}
+	nameToV2Endpoint := make(map[string]string)
+	for name, nd := range nameToNode {
+		// v2 endpoint of remote node may not be reachable across the agent
+		// boundary, so remote status is collected only with gRPC
+		if _, ok := nd.(*NodeWebLocal); ok {
+			nameToV2Endpoint[name] = nd.StatusEndpoint()
+		}
+	}
+
+	// a pool of workers asks the members, so that the requests to a large
+	// cluster are throttled
+	sc, errc := make(chan ServerStatus), make(chan error)
+	namec := make(chan string, len(nameToEndpoint))
+	for name := range nameToEndpoint {
+		namec <- name
+	}
+	close(namec)
+	workersN := c.statusConcurrency
+	if workersN <= 0 || workersN > len(nameToEndpoint) {
+		workersN = len(nameToEndpoint)
+	}
+	for i := 0; i < workersN; i++ {
+		go func() {
+			for name := range namec {
+				getStatus(name, nameToEndpoint[name], nameToV2Endpoint[name], c.statusMetrics, sc, errc)
+				// getStatus(name, nameToEndpoint[name], nameToV2Endpoint[name], c.nameToNode[name].TLS(), sc, errc)
+			}
+		}()
+	}
+
+	nameToStatus := make(map[string]ServerStatus)
+	var errs []error
+	cn := 0
+	for cn != len(nameToEndpoint) {
+		select {
+		case s := <-sc:
+			nameToStatus[s.Name] = s
+		case err := <-errc:
+			errs = append(errs, err)
+		}
+		cn++
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+
+	for name, endpoint := range nameToEndpoint {
+		if _, ok := nameToStatus[name]; !ok {
+			stat := emptyStat
+			stat.Name = name
+			stat.Endpoint = endpoint
+			if v, ok := nameToNode[name].(*NodeWebRemoteClient); ok && v.Agent != nil {
+				stat.State = fmt.Sprintf("unreachable (agent: %s)", agentState(v.Agent))
+			}
+			nameToStatus[name] = stat
+		}
+	}
+	var (
+		pgidToStat map[int]processStat // read once for all local Nodes
+		statRead   bool
+	)
+	for name, nd := range nameToNode {
+		stat := nameToStatus[name]
+		stat.UptimeSeconds = int64(nd.Uptime() / time.Second)
+		if v, ok := nd.(*NodeWebLocal); ok {
+			stat.LastExit = v.LastExit().String()
+			if pid, ok := v.activePID(); ok {
+				if !statRead {
+					pgidToStat, _ = readProcessGroupStats()
+					statRead = true
+				}
+				if ps, ok := pgidToStat[pid]; ok {
+					stat.CPUPercent = c.cpu.percent(pid, ps.cpuTime, time.Now())
+					stat.RSS = ps.rss
+					stat.RSSTxt = humanize.Bytes(ps.rss)
+				}
+			}
+		}
+		nameToStatus[name] = stat
+	}
+	setRaftIndexBehind(nameToStatus)
+	return nameToStatus, errors.Join(errs...)
+}
+
+// setRaftIndexBehind sets how far each reachable Node is behind the raft
+// index of the leader, or of the most advanced Node if the leader is not
+// reachable.
+func setRaftIndexBehind(nameToStatus map[string]ServerStatus) {
+	var leaderIndex, maxIndex uint64
+	for _, stat := range nameToStatus {
+		if stat.State == "Leader" {
+			leaderIndex = stat.RaftIndex
+		}
+		if stat.RaftIndex > maxIndex {
+			maxIndex = stat.RaftIndex
+		}
+	}
+	if leaderIndex == 0 {
+		leaderIndex = maxIndex
+	}
+	for name, stat := range nameToStatus {
+		if stat.State != "Leader" && stat.State != "Follower" {
+			continue
+		}
+		stat.RaftIndexBehind = 0
+		if stat.RaftIndex < leaderIndex {
+			stat.RaftIndexBehind = leaderIndex - stat.RaftIndex
+		}
+		stat.CatchingUp = stat.RaftIndexBehind > catchUpThreshold
+		nameToStatus[name] = stat
+	}
+}
+
+// agentState returns the state of the etcd process reported by the agent.
+func agentState(a client.Agent) string {
+	sc := make(chan string, 1)
+	go func() {
+		st, err := a.Status()
+		if err != nil {
+			sc <- "unknown"
+			return
+		}
+		sc <- st.State
+	}()
+	select {
+	case s := <-sc:
+		return s
+	case <-time.After(statusTimeout):
+		return "unknown"
+	}
+}
+
+// v2ProbeTimeout bounds the probe of the v2 endpoint, so that Status does
+// not wait out the timeout of each request to an etcd that does not serve
+// it.
+var v2ProbeTimeout = 300 * time.Millisecond
+
+// getV2Status fills in the cluster version and the metrics of the status
+// from the v2 endpoint. They are left empty without waiting if the probe
+// of the endpoint fails.
+func getV2Status(stat *ServerStatus, v2Endpoint string, metricNames []string) {
+	if !probeV2Endpoint(v2Endpoint) {
+		return
+	}
+	stat.ClusterVersion = getClusterVersion(v2Endpoint)
+	getMetrics(stat, v2Endpoint, metricNames)
+}
+
+// probeV2Endpoint returns true if the v2 endpoint serves the metrics to a
+// HEAD request within v2ProbeTimeout.
+func probeV2Endpoint(v2Endpoint string) bool {
+	if v2Endpoint == "" {
+		return false
+	}
+	cli := &http.Client{Timeout: v2ProbeTimeout}
+	resp, err := cli.Head(v2Endpoint + "/metrics")
+	if err != nil {
+		return false
+	}
+	gracefulClose(resp)
+	return resp.StatusCode == http.StatusOK
+}
+
+// getMetrics scrapes the metrics of the v2 endpoint once, and fills in
+// the requested metrics of the status. Metrics are left empty if not
+// available.
+func getMetrics(stat *ServerStatus, v2Endpoint string, metricNames []string) {
+	if v2Endpoint == "" {
+		return
+	}
+	ms, err := scrapeMetrics(v2Endpoint)
+	if err != nil {
+		return
+	}
+	if v, err := lookupMetric(ms, leaderChangesMetric); err == nil {
+		stat.LeaderChanges = int(v)
+	}
+	stat.Metrics = make(map[string]float64, len(metricNames))
+	for _, name := range metricNames {
+		if v, err := lookupMetric(ms, name); err == nil {
+			stat.Metrics[name] = v
+		}
+	}
+}
+
+// getClusterVersion returns the cluster version from the v2 version
+// endpoint, or an empty string if not available.
+func getClusterVersion(v2Endpoint string) string {
+	if v2Endpoint == "" {
+		return ""
+	}
+	cli := &http.Client{Timeout: time.Second}
+	resp, err := cli.Get(v2Endpoint + "/version")
+	if err != nil {
+		return ""
+	}
+	defer gracefulClose(resp)
+
+	var v struct {
+		Cluster string `json:"etcdcluster"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		return ""
+	}
+	return v.Cluster
+}
+
+// CompareVersions returns the versions of each Node in the statuses, and
+// true if the server or cluster versions disagree. Unreachable Nodes are
+// ignored.
+func CompareVersions(nameToStatus map[string]ServerStatus) (map[string]string, bool) {
+	nameToVersion := make(map[string]string)
+	versions, clusterVersions := make(map[string]struct{}), make(map[string]struct{})
+	for name, stat := range nameToStatus {
+		if stat.Version == "" {
+			continue
+		}
+		v := stat.Version
+		versions[stat.Version] = struct{}{}
+		if stat.ClusterVersion != "" {
+			v = fmt.Sprintf("%s (cluster %s)", stat.Version, stat.ClusterVersion)
+			clusterVersions[stat.ClusterVersion] = struct{}{}
+		}
+		nameToVersion[name] = v
+	}
+	return nameToVersion, len(versions) > 1 || len(clusterVersions) > 1
+}
+
+func (c *defaultCluster) VersionSkew() (map[string]string, bool) {
+	nameToStatus, _ := c.Status()
+	return CompareVersions(nameToStatus)
+}
+
+// healthTimeout is the timeout for each health check.
+var healthTimeout = 3 * time.Second
+
+// checkHealth returns true if the endpoint reports healthy.
+func checkHealth(endpoint string) bool {
+	cli := &http.Client{Timeout: healthTimeout}
+	resp, err := cli.Get(endpoint + "/health")
+	if err != nil {
+		return false
+	}
+	defer gracefulClose(resp)
+
+	var h struct {
+		Health string `json:"health"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		return false
+	}
+	return h.Health == "true"
+}
+
+func (c *defaultCluster) Health() (map[string]bool, error) {
+	type result struct {
+		name    string
+		healthy bool
+	}
+
+	nameToNode := c.nodes()
+	rc := make(chan result, len(nameToNode))
+	for name, nd := range nameToNode {
+		go func(name string, nd Node) {
+			rc <- result{name: name, healthy: nd.IsActive() && checkHealth(nd.StatusEndpoint())}
+		}(name, nd)
+	}
+
+	nameToHealth := make(map[string]bool)
+	healthyN := 0
+	for range nameToNode {
+		r := <-rc
+		nameToHealth[r.name] = r.healthy
+		if r.healthy {
+			healthyN++
+		}
+	}
+	if healthyN == 0 {
+		return nameToHealth, fmt.Errorf("no healthy node found")
+	}
+	return nameToHealth, nil
+}
+
+func (c *defaultCluster) Put(name, key, value string, streamIDs ...string) (time.Duration, error) {
+	_, took, err := c.put(name, key, value, false, streamIDs...)
+	return took, err
+}
+
+func (c *defaultCluster) PutWithPrevKV(name, key, value string, streamIDs ...string) (*KeyValue, time.Duration, error) {
+	return c.put(name, key, value, true, streamIDs...)
+}
+
+func (c *defaultCluster) PutBytes(name, key string, value []byte, streamIDs ...string) (time.Duration, error) {
+	_, took, err := c.put(name, key, string(value), false, streamIDs...)
+	return took, err
+}
+
+// checkSize returns ErrTooLarge if the key or value is larger than the
+// limits of WithSizeLimits.
+func (c *defaultCluster) checkSize(keySize, valueSize int) error {
+	if c.maxKeySize > 0 && keySize > c.maxKeySize {
+		return fmt.Errorf("key of %d bytes %w of %d bytes", keySize, ErrTooLarge, c.maxKeySize)
+	}
+	if c.maxValueSize > 0 && valueSize > c.maxValueSize {
+		return fmt.Errorf("value of %d bytes %w of %d bytes", valueSize, ErrTooLarge, c.maxValueSize)
+	}
+	return nil
+}
+
+func (c *defaultCluster) put(name, key, value string, prevKV bool, streamIDs ...string) (*KeyValue, time.Duration, error) {
+	if err := c.checkSize(len(key), len(value)); err != nil {
+		return nil, time.Duration(0), err
+	}
+	done, err := c.begin()
+	if err != nil {
+		return nil, time.Duration(0), err
+	}
+	defer done()
+
+	endpoints, nameToEndpoint, epToName := c.Endpoints()
+	if len(endpoints) == 0 {
+		return nil, time.Duration(0), ErrNoActiveNodes
+	}
+	balanced := name == "" && c.balanced
+	if name == "" {
+		name = c.nextName(endpoints, epToName)
+	}
+	name, ep, ok := c.resolveEndpoint(name, nameToEndpoint, epToName)
+	if !ok {
+		return nil, time.Duration(0), fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	if !balanced {
+		endpoints = []string{ep}
+	}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return nil, time.Duration(0), err
+	}
+	defer cli.Close()
+
+	var opts []clientv3.OpOption
+	if prevKV {
+		opts = append(opts, clientv3.WithPrevKV())
+	}
+
+	kvc := clientv3.NewKV(cli)
+	st := time.Now()
+
+	c.Write(name, fmt.Sprintf("[PUT] Started! deadline %v (endpoints: %q)", requestTimeout, endpoints), streamIDs...)
+	var resp *clientv3.PutResponse
+	err = c.doRetry("PUT", name, endpoints, func(ctx context.Context) (err error) {
+		resp, err = kvc.Put(ctx, key, value, opts...)
+		return err
+	}, streamIDs...)
+	if err != nil {
+		return nil, time.Duration(0), c.requestErr("PUT", name, err, endpoints, streamIDs...)
+	}
+
+	took := time.Since(st)
+	if balanced {
+		c.Write(name, fmt.Sprintf("[PUT] Served by %s (balanced among the endpoints: %q)", servedBy(cli, resp.Header.MemberId, epToName), endpoints), streamIDs...)
+	}
+	if !prevKV {
+		c.Write(name, fmt.Sprintf("[PUT] %q : %s / Took %v (endpoints: %q)", key, renderValue(value), took, endpoints), streamIDs...)
+		return nil, took, nil
+	}
+
+	var prev *KeyValue
+	if resp.PrevKv != nil {
+		pkv := newKeyValue(resp.PrevKv)
+		prev = &pkv
+		c.Write(name, fmt.Sprintf("[PUT] %q : was %s now %s / Took %v (endpoints: %q)", key, renderValue(prev.Value), renderValue(value), took, endpoints), streamIDs...)
+	} else {
+		c.Write(name, fmt.Sprintf("[PUT] %q : %s (created) / Took %v (endpoints: %q)", key, renderValue(value), took, endpoints), streamIDs...)
+	}
+	return prev, took, nil
+}
+
+func (c *defaultCluster) CompareAndSwap(name, key, expected, newValue string, streamIDs ...string) (bool, error) {
+	if err := c.checkSize(len(key), len(newValue)); err != nil {
+		return false, err
+	}
+	// an empty expected value means the key must not exist yet
+	cmp := clientv3.Compare(clientv3.Value(key), "=", expected)
+	if expected == "" {
+		cmp = clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	}
+	rs, err := c.txnIf(name, "CAS", key, cmp, clientv3.OpPut(key, newValue), streamIDs...)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case rs.succeeded && expected == "":
+		c.Write(rs.name, fmt.Sprintf("[CAS] %q : %s (created) / Took %v (endpoints: %q)", key, renderValue(newValue), rs.took, rs.endpoints), streamIDs...)
+	case rs.succeeded:
+		c.Write(rs.name, fmt.Sprintf("[CAS] %q : swapped %s to %s / Took %v (endpoints: %q)", key, renderValue(expected), renderValue(newValue), rs.took, rs.endpoints), streamIDs...)
+	case expected == "":
+		c.Write(rs.name, fmt.Sprintf("[CAS] %q : not created, it already %s / Took %v (endpoints: %q)", key, rs.describeCurrent(), rs.took, rs.endpoints), streamIDs...)
+	default:
+		c.Write(rs.name, fmt.Sprintf("[CAS] %q : not swapped, expected %s but it %s / Took %v (endpoints: %q)", key, renderValue(expected), rs.describeCurrent(), rs.took, rs.endpoints), streamIDs...)
+	}
+	return rs.succeeded, nil
+}
+
+func (c *defaultCluster) CompareAndDelete(name, key, expected string, streamIDs ...string) (bool, error) {
+	cmp := clientv3.Compare(clientv3.Value(key), "=", expected)
+	rs, err := c.txnIf(name, "CAD", key, cmp, clientv3.OpDelete(key), streamIDs...)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case rs.succeeded:
+		c.Write(rs.name, fmt.Sprintf("[CAD] %q : deleted %s / Took %v (endpoints: %q)", key, renderValue(expected), rs.took, rs.endpoints), streamIDs...)
+	case rs.current == nil:
+		c.Write(rs.name, fmt.Sprintf("[CAD] %q : nothing to delete, it does not exist / Took %v (endpoints: %q)", key, rs.took, rs.endpoints), streamIDs...)
+	default:
+		c.Write(rs.name, fmt.Sprintf("[CAD] %q : not deleted, expected %s but it %s / Took %v (endpoints: %q)", key, renderValue(expected), rs.describeCurrent(), rs.took, rs.endpoints), streamIDs...)
+	}
+	return rs.succeeded, nil
+}
+
+// txnResult is the outcome of txnIf.
+type txnResult struct {
+	name      string
+	endpoints []string
+	took      time.Duration
+
+	succeeded bool
+
+	// current is the key-value when the comparison failed, or nil if the
+	// key does not exist.
+	current *KeyValue
+}
+
+// describeCurrent describes the current value of the key.
+func (rs txnResult) describeCurrent() string {
+	if rs.current == nil {
+		return "does not exist"
+	}
+	return "is " + renderValue(rs.current.Value)
+}
+
+// txnIf runs then on the node if the comparison succeeds, or else gets the
+// key to report its current value. op is the stream prefix of the
+// operation. If the name is not specified, it sends request to a random
+// node.
+func (c *defaultCluster) txnIf(name, op, key string, cmp clientv3.Cmp, then clientv3.Op, streamIDs ...string) (txnResult, error) {
+	done, err := c.begin()
+	if err != nil {
+		return txnResult{}, err
+	}
+	defer done()
+
+	if len(key) == 0 {
+		return txnResult{}, fmt.Errorf("empty key")
+	}
+	name, endpoint, err := c.pick(name)
+	if err != nil {
+		return txnResult{}, err
+	}
+	rs := txnResult{name: name, endpoints: []string{endpoint}}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   rs.endpoints,
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return txnResult{}, err
+	}
+	defer cli.Close()
+
+	kvc := clientv3.NewKV(cli)
+	c.Write(name, fmt.Sprintf("[%s] Started! deadline %v (endpoints: %q)", op, requestTimeout, rs.endpoints), streamIDs...)
+	st := time.Now()
+	var resp *clientv3.TxnResponse
+	err = c.doRetry(op, name, rs.endpoints, func(ctx context.Context) (err error) {
+		resp, err = kvc.Txn(ctx).If(cmp).Then(then).Else(clientv3.OpGet(key)).Commit()
+		return err
+	}, streamIDs...)
+	if err != nil {
+		return txnResult{}, c.requestErr(op, name, err, rs.endpoints, streamIDs...)
+	}
+	rs.took = time.Since(st)
+	rs.succeeded = resp.Succeeded
+
+	if !resp.Succeeded && len(resp.Responses) > 0 {
+		if rr := resp.Responses[0].GetResponseRange(); rr != nil && len(rr.Kvs) > 0 {
+			kv := newKeyValue(rr.Kvs[0])
+			rs.current = &kv
+		}
+	}
+	return rs, nil
+}
+
+func (c *defaultCluster) PutCompare(key, value string, streamIDs ...string) (map[string]time.Duration, error) {
+	done, err := c.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	endpoints, _, epToName := c.Endpoints()
+	if len(endpoints) == 0 {
+		return nil, ErrNoActiveNodes
+	}
+	leader, _ := c.Leader() // only to label the leader
+
+	// serially, so that the writes do not slow down each other
+	nameToTook := make(map[string]time.Duration, len(endpoints))
+	names := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		name := epToName[ep]
+		took, err := c.Put(name, fmt.Sprintf("%s_%s", key, name), value, streamIDs...)
+		if err != nil {
+			return nameToTook, fmt.Errorf("%s (%w)", name, err)
+		}
+		nameToTook[name] = took
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool { return nameToTook[names[i]] < nameToTook[names[j]] })
+	for i, name := range names {
+		role := "follower"
+		if name == leader {
+			role = "leader"
+		}
+		c.Write(name, fmt.Sprintf("[PUT COMPARE] #%d %s (%s) took %v", i+1, name, role, nameToTook[name]), streamIDs...)
+	}
+	return nameToTook, nil
+}
+
+func (c *defaultCluster) PutBatch(name string, kvs map[string]string, streamIDs ...string) error {
+	// rejects the whole batch, not to write a part of it
+	for k, v := range kvs {
+		if err := c.checkSize(len(k), len(v)); err != nil {
+			return err
+		}
+	}
+	done, err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	name, endpoint, err := c.pick(name)
+	if err != nil {
+		return err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	c.Write(name, fmt.Sprintf("[PUT BATCH] Started! %d keys (endpoint: %q)", len(keys), endpoint), streamIDs...)
+	st := time.Now()
+
+	clientsN := 10 // 1 connection, 10 clients
+	var (
+		wg    sync.WaitGroup
+		keyc  = make(chan string)
+		errmu sync.Mutex // guards ferr
+		ferr  error
+		putN  int64 // accessed atomically
+	)
+	wg.Add(clientsN)
+	for i := 0; i < clientsN; i++ {
+		go func(kvc clientv3.KV) {
+			defer wg.Done()
+			for key := range keyc {
+				ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+				_, err := kvc.Put(ctx, key, kvs[key])
+				cancel()
+				if err != nil {
+					errmu.Lock()
+					if ferr == nil {
+						ferr = fmt.Errorf("%q (%v)", key, err)
+					}
+					errmu.Unlock()
+					continue
+				}
+				atomic.AddInt64(&putN, 1)
+			}
+		}(clientv3.NewKV(cli))
+	}
+	for _, key := range keys {
+		keyc <- key
+	}
+	close(keyc)
+	wg.Wait()
+
+	took := time.Since(st)
+	if ferr != nil {
+		c.Write(name, fmt.Sprintf("[PUT BATCH] Failed! %d of %d keys / Took %v (first error: %v)", putN, len(keys), took, ferr), streamIDs...)
+		return fmt.Errorf("put %d of %d keys to %s, first error: %v", putN, len(keys), name, ferr)
+	}
+	var pt time.Duration
+	if len(keys) > 0 {
+		pt = took / time.Duration(len(keys))
+	}
+	c.Write(name, fmt.Sprintf("[PUT BATCH] Done! %d keys / Took %v (%v per each), %d client(s) (endpoint: %q)", len(keys), took, pt, clientsN, endpoint), streamIDs...)
+	return nil
+}
+
+// watchPutTimeout is how long WatchPut waits for the watchers to be
+// created and to receive the put.
+var watchPutTimeout = 5 * time.Second
+
+func (c *defaultCluster) WatchPut(name, key, value string, streamIDs ...string) (time.Duration, error) {
+	return c.WatchPutContext(context.Background(), name, key, value, streamIDs...)
+}
+
+func (c *defaultCluster) History(ctx context.Context, name, prefix string, startRev int64, maxEvents int, streamIDs ...string) ([]WatchEvent, error) {
+	if startRev <= 0 {
+		startRev = 1
+	}
+	if maxEvents <= 0 {
+		return nil, fmt.Errorf("maximum number of events must be positive (%d)", maxEvents)
+	}
+	done, err := c.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	name, endpoint, err := c.pick(name)
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+	defer cli.Watcher.Close()
+
+	ctx, deregister := c.operations.register(ctx, "HISTORY", streamIDs)
+	defer deregister()
+
+	gctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	resp, err := clientv3.NewKV(cli).Get(gctx, "\x00", clientv3.WithFromKey(), clientv3.WithCountOnly())
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	endRev := resp.Header.Revision
+	evs := []WatchEvent{}
+	// the first revision of a new cluster has no event
+	if startRev > endRev || endRev <= 1 {
+		c.Write(name, fmt.Sprintf("[HISTORY] Nothing to replay after revision %d (current revision %d)", startRev, endRev), streamIDs...)
+		return evs, nil
+	}
+	c.Write(name, fmt.Sprintf("[HISTORY] Started! replaying %q from revision %d to %d (endpoint: %q)", prefix, startRev, endRev, endpoint), streamIDs...)
+
+	// watches all keys, since every revision has an event, so that the
+	// replay knows when it reached the current revision
+	for rev := startRev; rev <= endRev; {
+		wctx, wcancel := context.WithCancel(ctx)
+		wch := cli.Watch(wctx, "\x00", clientv3.WithFromKey(), clientv3.WithRev(rev))
+		for rev <= endRev {
+			wresp, werr := waitWatch(wctx, wch)
+			if wresp.CompactRevision != 0 {
+				c.Write(name, fmt.Sprintf("[HISTORY] Revisions before %d are compacted, replaying from %d", wresp.CompactRevision, wresp.CompactRevision), streamIDs...)
+				rev = wresp.CompactRevision
+				break
+			}
+			if werr != nil {
+				wcancel()
+				if ctx.Err() != nil {
+					c.Write(name, fmt.Sprintf("[HISTORY] Canceled after %d events", len(evs)), streamIDs...)
+					return evs, ctxErr(ctx)
+				}
+				return evs, werr
+			}
+			for _, ev := range wresp.Events {
+				rev = ev.Kv.ModRevision + 1
+				if !strings.HasPrefix(string(ev.Kv.Key), prefix) {
+					continue
+				}
+				we := WatchEvent{Type: ev.Type.String(), KeyValue: newKeyValue(ev.Kv)}
+				evs = append(evs, we)
+				if ev.Type == clientv3.EventTypeDelete {
+					c.Write(name, fmt.Sprintf("[HISTORY] %d DELETE %q", we.ModRevision, we.Key), streamIDs...)
+				} else {
+					c.Write(name, fmt.Sprintf("[HISTORY] %d PUT %q : %s", we.ModRevision, we.Key, renderValue(we.Value)), streamIDs...)
+				}
+				if len(evs) >= maxEvents {
+					wcancel()
+					c.Write(name, fmt.Sprintf("[HISTORY] Stopped at the limit of %d events at revision %d (current revision %d)", maxEvents, we.ModRevision, endRev), streamIDs...)
+					return evs, nil
+				}
+			}
+		}
+		wcancel()
+	}
+	c.Write(name, fmt.Sprintf("[HISTORY] Done! %d events of %q up to revision %d", len(evs), prefix, endRev), streamIDs...)
+	return evs, nil
+}
+
+func (c *defaultCluster) RunScenario(name string, streamIDs ...string) error {
+	sc, err := lookupScenario(name)
+	if err != nil {
+		return err
+	}
+	done, err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, deregister := c.operations.register(c.ctx, "SCENARIO "+name, streamIDs)
+	defer deregister()
+
+	r := &scenarioRun{c: c, name: name, streamIDs: streamIDs}
+	r.say("", "Started! This scenario %s", sc.description)
+	st := time.Now()
+	if err := sc.run(ctx, r); err != nil {
+		if ctx.Err() != nil {
+			r.say("", "Canceled after %v", time.Since(st))
+			return ctx.Err()
+		}
+		r.say("", "Failed! %v", err)
+		return err
+	}
+	r.say("", "Done! / Took %v", time.Since(st))
+	return nil
+}
+
+// stmMaxRetries is the number of times RunSTM retries a conflicting
+// transaction before it gives up.
+const stmMaxRetries = 10
+
+func (c *defaultCluster) RunSTM(name string, fn func(stm STM) error, streamIDs ...string) error {
+	done, err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	name, endpoint, err := c.pick(name)
+	if err != nil {
+		return err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	kvc := clientv3.NewKV(cli)
+	c.Write(name, fmt.Sprintf("[STM] Started! (endpoint: %q)", endpoint), streamIDs...)
+	st := time.Now()
+	for retries := 0; retries <= stmMaxRetries; retries++ {
+		s := newSTM(c.ctx, kvc)
+		if err := fn(s); err != nil {
+			c.Write(name, fmt.Sprintf("[STM] Aborted! %v (retries: %d)", err, retries), streamIDs...)
+			return err
+		}
+		if s.err != nil {
+			return s.err
+		}
+		for _, k := range s.writtenKeys() {
+			if err := c.checkSize(len(k), len(s.wset[k].value)); err != nil {
+				return err
+			}
+		}
+		ok, err := s.commit()
+		if err != nil {
+			return err
+		}
+		if ok {
+			c.Write(name, fmt.Sprintf("[STM] Committed %d reads and %d writes after %d retries / Took %v (endpoint: %q)", len(s.rset), len(s.wset), retries, time.Since(st), endpoint), streamIDs...)
+			return nil
+		}
+		if retries == stmMaxRetries {
+			break
+		}
+		c.Write(name, fmt.Sprintf("[STM] Conflict! a read key has changed, retrying (retry %d)", retries+1), streamIDs...)
+	}
+	return fmt.Errorf("gave up after %d conflicting retries", stmMaxRetries)
+}
+
+func (c *defaultCluster) WatchPutContext(ctx context.Context, name, key, value string, streamIDs ...string) (time.Duration, error) {
+	if err := c.checkSize(len(key), len(value)); err != nil {
+		return time.Duration(0), err
+	}
+	done, err := c.begin()
+	if err != nil {
+		return time.Duration(0), err
+	}
+	defer done()
+
+	endpoints, nameToEndpoint, epToName := c.Endpoints()
+	if len(endpoints) == 0 {
+		return time.Duration(0), ErrNoActiveNodes
+	}
+	if name == "" {
+		name = c.nextName(endpoints, epToName)
+	}
+	if _, ok := nameToEndpoint[name]; !ok {
+		return time.Duration(0), fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	ctx, deregister := c.operations.register(ctx, "WATCH PUT", streamIDs)
+	defer deregister()
+
+	// All watches share one parent context, which is canceled before the
+	// watchers and clients are closed in the order of endpoints, so that
+	// no watcher outlives WatchPut.
+	ctx, cancel := context.WithTimeout(ctx, watchPutTimeout)
+	var clients []*clientv3.Client
+	defer func() {
+		cancel()
+		for _, cli := range clients {
+			cli.Watcher.Close()
+			cli.Close()
+		}
+	}()
+
+	wchs := make([]clientv3.WatchChan, len(endpoints))
+	for i, ep := range endpoints {
+		cli, err := clientv3.New(clientv3.Config{
+			Endpoints:   []string{ep},
+			DialTimeout: c.dialTimeout,
+		})
+		if err != nil {
+			return time.Duration(0), err
+		}
+		clients = append(clients, cli)
+		wchs[i] = cli.Watch(ctx, key, clientv3.WithCreatedNotify())
+	}
+	for i, wch := range wchs {
+		if _, err := waitWatch(ctx, wch); err != nil {
+			return time.Duration(0), fmt.Errorf("%s watch (%w)", epToName[endpoints[i]], err)
+		}
+	}
+	c.Write(name, fmt.Sprintf("[WATCH] Started! watching %q on %q", key, endpoints), streamIDs...)
+
+	st := time.Now()
+	var pcli *clientv3.Client
+	for i, ep := range endpoints {
+		if epToName[ep] == name {
+			pcli = clients[i]
+		}
+	}
+	if pcli == nil {
+		return time.Duration(0), fmt.Errorf("%s %w", name, ErrNodeInactive)
+	}
+	if _, err := clientv3.NewKV(pcli).Put(ctx, key, value); err != nil {
+		return time.Duration(0), err
+	}
+
+	for i, wch := range wchs {
+		resp, err := waitWatch(ctx, wch)
+		if err != nil {
+			return time.Duration(0), fmt.Errorf("%s watch (%w)", epToName[endpoints[i]], err)
+		}
+		for _, ev := range resp.Events {
+			c.Write(epToName[endpoints[i]], fmt.Sprintf("[WATCH] %s %q : %s / Took %v", ev.Type, ev.Kv.Key, renderValue(string(ev.Kv.Value)), time.Since(st)), streamIDs...)
+		}
+	}
+	took := time.Since(st)
+	c.Write(name, fmt.Sprintf("[WATCH] Done! %d watchers received %q / Took %v", len(wchs), key, took), streamIDs...)
+	return took, nil
+}
+
+// waitWatch returns the next response from the watch channel.
+func waitWatch(ctx context.Context, wch clientv3.WatchChan) (clientv3.WatchResponse, error) {
+	select {
+	case resp, ok := <-wch:
+		if !ok {
+			if ctx.Err() != nil {
+				return resp, ctxErr(ctx)
+			}
+			return resp, fmt.Errorf("channel closed")
+		}
+		return resp, resp.Err()
+	case <-ctx.Done():
+		return clientv3.WatchResponse{}, ctxErr(ctx)
+	}
+}
+
+// requestTimeout is the deadline of each Put, Get and Delete request.
+var requestTimeout = 3 * time.Second
+
+// deadlineErr returns ErrTimeout with the deadline of the request if err
+// is the deadline exceeded, or else err as is.
+func deadlineErr(err error, deadline time.Duration) error {
+	if errors.Is(err, context.DeadlineExceeded) || grpc.Code(err) == codes.DeadlineExceeded {
+		return fmt.Errorf("exceeded the deadline of %v (%w)", deadline, ErrTimeout)
+	}
+	return err
+}
+
+// requestErr converts the error of the request with deadlineErr, and
+// writes the timeout to the streams, since the users would not know the
+// deadline otherwise.
+func (c *defaultCluster) requestErr(op, name string, err error, endpoints []string, streamIDs ...string) error {
+	err = deadlineErr(err, requestTimeout)
+	if errors.Is(err, ErrTimeout) {
+		c.Write(name, fmt.Sprintf("[%s] Timed out! %v (endpoints: %q)", op, err, endpoints), streamIDs...)
+	}
+	return err
+}
+
+// ctxErr returns ErrTimeout if ctx timed out, or the error of canceled
+// ctx.
+func ctxErr(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
+
+func (c *defaultCluster) ObserveLeader(electionName string, streamIDs ...string) (func(), error) {
+	name, ep, err := c.pick("")
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{ep},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	donec := make(chan struct{})
+	go func() {
+		defer close(donec)
+		c.observeLeader(ctx, cli, name, electionName, streamIDs...)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-donec
+			cli.Watcher.Close()
+			cli.Close()
+			c.Write(name, fmt.Sprintf("[OBSERVE] Stopped observing %q", electionName), streamIDs...)
+		})
+	}, nil
+}
+
+// observeLeader writes the leader of the election whenever it changes,
+// until ctx is canceled. The campaigners hold the keys under the election
+// prefix, and the one with the oldest key is the leader, as in the
+// election of the etcd concurrency package.
+func (c *defaultCluster) observeLeader(ctx context.Context, cli *clientv3.Client, name, electionName string, streamIDs ...string) {
+	prefix := electionName + "/"
+	leader, rev, err := electionLeader(ctx, cli, prefix)
+	if err != nil {
+		c.Write(name, fmt.Sprintf("[OBSERVE] error %v (election %q)", err, electionName), streamIDs...)
+		return
+	}
+	c.Write(name, leaderMessage(electionName, leader), streamIDs...)
+
+	wch := cli.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+	for {
+		if _, err := waitWatch(ctx, wch); err != nil {
+			if ctx.Err() == nil {
+				c.Write(name, fmt.Sprintf("[OBSERVE] error %v (election %q)", err, electionName), streamIDs...)
+			}
+			return
+		}
+		next, _, err := electionLeader(ctx, cli, prefix)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.Write(name, fmt.Sprintf("[OBSERVE] error %v (election %q)", err, electionName), streamIDs...)
+			}
+			return
+		}
+		if !sameLeader(leader, next) {
+			leader = next
+			c.Write(name, leaderMessage(electionName, leader), streamIDs...)
+		}
+	}
+}
+
+// electionLeader returns the campaign key with the lowest create revision
+// under the prefix, or nil if nobody campaigns, and the revision it read.
+func electionLeader(ctx context.Context, cli *clientv3.Client, prefix string) (*mvccpb.KeyValue, int64, error) {
+	resp, err := clientv3.NewKV(cli).Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, 0, err
+	}
+	var leader *mvccpb.KeyValue
+	for _, kv := range resp.Kvs {
+		if leader == nil || kv.CreateRevision < leader.CreateRevision {
+			leader = kv
+		}
+	}
+	return leader, resp.Header.Revision, nil
+}
+
+// sameLeader returns true if both are the same campaign with the same value.
+func sameLeader(a, b *mvccpb.KeyValue) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return bytes.Equal(a.Key, b.Key) && a.CreateRevision == b.CreateRevision && bytes.Equal(a.Value, b.Value)
+}
+
+func leaderMessage(electionName string, leader *mvccpb.KeyValue) string {
+	if leader == nil {
+		return fmt.Sprintf("[OBSERVE] %q has no leader", electionName)
+	}
+	return fmt.Sprintf("[OBSERVE] %q leader is %q (key %q)", electionName, leader.Value, leader.Key)
+}
+
+func (c *defaultCluster) Get(name, key string, prefix bool, streamIDs ...string) ([]string, time.Duration, error) {
+	kvs, took, err := c.get(name, key, prefix, false, streamIDs...)
+	return values(kvs), took, err
+}
+
+func (c *defaultCluster) GetSerializable(name, key string, prefix bool, streamIDs ...string) ([]string, time.Duration, error) {
+	kvs, took, err := c.get(name, key, prefix, true, streamIDs...)
+	return values(kvs), took, err
+}
+
+func (c *defaultCluster) GetBytes(name, key string, streamIDs ...string) ([]byte, time.Duration, error) {
+	kvs, took, err := c.get(name, key, false, false, streamIDs...)
+	if err != nil || len(kvs) == 0 {
+		return nil, took, err
+	}
+	return []byte(kvs[0].Value), took, nil
+}
+
+func (c *defaultCluster) GetWithRevisions(name, key string, prefix bool, streamIDs ...string) ([]KeyValue, time.Duration, error) {
+	return c.get(name, key, prefix, false, streamIDs...)
+}
+
+func (c *defaultCluster) Keys(name string) ([]KeyValue, int64, error) {
+	done, err := c.begin()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer done()
+
+	_, endpoint, err := c.pick(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	resp, err := clientv3.NewKV(cli).Get(ctx, "\x00", clientv3.WithFromKey(), clientv3.WithKeysOnly())
+	cancel()
+	if err != nil {
+		return nil, 0, err
+	}
+	kvs := make([]KeyValue, 0, len(resp.Kvs))
+	for _, ev := range resp.Kvs {
+		kv := newKeyValue(ev)
+		kv.Value = ""
+		kvs = append(kvs, kv)
+	}
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, resp.Header.Revision, nil
+}
+
+func (c *defaultCluster) DeleteUnmodified(name string, kvs []KeyValue) (int, error) {
+	done, err := c.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	_, endpoint, err := c.pick(name)
+	if err != nil {
+		return 0, err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+
+	kvc := clientv3.NewKV(cli)
+	deleted := 0
+	for _, kv := range kvs {
+		// the key written again in the meantime is kept
+		cmp := clientv3.Compare(clientv3.ModRevision(kv.Key), "=", kv.ModRevision)
+		ctx, cancel := context.WithTimeout(c.ctx, 3*time.Second)
+		resp, err := kvc.Txn(ctx).If(cmp).Then(clientv3.OpDelete(kv.Key)).Commit()
+		cancel()
+		if err != nil {
+			return deleted, err
+		}
+		if resp.Succeeded {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (c *defaultCluster) GetNodes(names []string, key string, prefix, serializable bool, streamIDs ...string) map[string]GetResult {
+	if len(names) == 0 {
+		for name := range c.nodes() {
+			names = append(names, name)
+		}
+	}
+
+	type result struct {
+		name string
+		GetResult
+	}
+	rc := make(chan result, len(names))
+	for _, name := range names {
+		go func(name string) {
+			kvs, took, err := c.get(name, key, prefix, serializable, streamIDs...)
+			rc <- result{name, GetResult{Values: values(kvs), Took: took, Err: err}}
+		}(name)
+	}
+	nameToResult := make(map[string]GetResult, len(names))
+	for range names {
+		r := <-rc
+		nameToResult[r.name] = r.GetResult
+	}
+	return nameToResult
+}
+
+// values returns the sorted values of the key-values, or nil if kvs is nil.
+func values(kvs []KeyValue) []string {
+	if kvs == nil {
+		return nil
+	}
+	vs := []string{}
+	for _, kv := range kvs {
+		vs = append(vs, kv.Value)
+	}
+	sort.Strings(vs)
+	return vs
+}
+
+func (c *defaultCluster) get(name, key string, prefix, serializable bool, streamIDs ...string) ([]KeyValue, time.Duration, error) {
+	done, err := c.begin()
+	if err != nil {
+		return nil, time.Duration(0), err
+	}
+	defer done()
+
+	endpoints, nameToEndpoint, epToName := c.Endpoints()
+	if len(endpoints) == 0 {
+		return nil, time.Duration(0), ErrNoActiveNodes
+	}
+	balanced := name == "" && c.balanced && !serializable
+	if name == "" {
+		name = c.nextName(endpoints, epToName)
+	}
+	name, ep, ok := c.resolveEndpoint(name, nameToEndpoint, epToName)
+	if !ok {
+		return nil, time.Duration(0), fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	if !balanced {
+		endpoints = []string{ep}
+	}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return nil, time.Duration(0), err
+	}
+	defer cli.Close()
+
+	var opts []clientv3.OpOption
+	if len(key) == 0 {
+		key = "\x00" // query the whole key
+		opts = append(opts, clientv3.WithFromKey())
+	} else if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+	consistency := "linearizable"
+	if serializable {
+		// the node answers serializable reads alone, even when it is
+		// partitioned from the leader, so label whom the read came from
+		consistency = fmt.Sprintf("serializable (from %s %s, may be stale)", memberRole(cli, endpoints[0]), name)
+		opts = append(opts, clientv3.WithSerializable())
+	}
+
+	kvc := clientv3.NewKV(cli)
+	c.Write(name, fmt.Sprintf("[GET] Started! %s read, deadline %v (endpoints: %q)", consistency, requestTimeout, endpoints), streamIDs...)
+	st := time.Now()
+	var resp *clientv3.GetResponse
+	err = c.doRetry("GET", name, endpoints, func(ctx context.Context) (err error) {
+		resp, err = kvc.Get(ctx, key, opts...)
+		return err
+	}, streamIDs...)
+	if err != nil {
+		return nil, time.Duration(0), c.requestErr("GET", name, err, endpoints, streamIDs...)
+	}
+	if balanced {
+		c.Write(name, fmt.Sprintf("[GET] Served by %s (balanced among the endpoints: %q)", servedBy(cli, resp.Header.MemberId, epToName), endpoints), streamIDs...)
+	}
+	kvs := []KeyValue{}
+	if len(resp.Kvs) > 0 {
+		for _, ev := range resp.Kvs {
+			kv := newKeyValue(ev)
+			kvs = append(kvs, kv)
+			c.Write(name, fmt.Sprintf("[GET] %q : %s (v%d, created@%d, modified@%d)", kv.Key, renderValue(kv.Value), kv.Version, kv.CreateRevision, kv.ModRevision), streamIDs...)
+		}
+	} else {
+		c.Write(name, fmt.Sprintf("[GET] %q does not exist!", key), streamIDs...)
+	}
+
+	took := time.Since(st)
+	c.Write(name, fmt.Sprintf("[GET] Done! %s read took %v (endpoints: %q)", consistency, took, endpoints), streamIDs...)
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	return kvs, took, nil
+}
+
+// memberRole returns whether the member at the endpoint is the leader or
+// a follower, as the member itself sees it.
+func memberRole(cli *clientv3.Client, endpoint string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	resp, err := clientv3.NewMaintenance(cli).Status(ctx, endpoint)
+	cancel()
+	switch {
+	case err != nil:
+		return "member"
+	case resp.Leader == resp.Header.MemberId:
+		return "leader"
+	default:
+		return "follower"
+	}
+}
+
+// servedBy returns the name of the Node with the member ID, as listed by
+// the members, or the member name or ID if it is not a Node.
+func servedBy(cli *clientv3.Client, id uint64, epToName map[string]string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	resp, err := clientv3.NewCluster(cli).MemberList(ctx)
+	cancel()
+	if err == nil {
+		for _, m := range resp.Members {
+			if m.ID != id {
+				continue
+			}
+			if name, ok := epToName[memberClientEndpoint(m.ClientURLs)]; ok {
+				return name
+			}
+			if m.Name != "" {
+				return m.Name
+			}
+		}
+	}
+	return fmt.Sprintf("member %x", id)
+}
+
+func (c *defaultCluster) Delete(name, key string, prefix bool, streamIDs ...string) (int64, time.Duration, error) {
+	if len(key) == 0 && !prefix {
+		return 0, time.Duration(0), fmt.Errorf("empty key (set prefix to delete all keys)")
+	}
+	done, err := c.begin()
+	if err != nil {
+		return 0, time.Duration(0), err
+	}
+	defer done()
+
+	endpoints, nameToEndpoint, epToName := c.Endpoints()
+	if len(endpoints) == 0 {
+		return 0, time.Duration(0), ErrNoActiveNodes
+	}
+	balanced := name == "" && c.balanced
+	if name == "" {
+		name = c.nextName(endpoints, epToName)
+	}
+	name, ep, ok := c.resolveEndpoint(name, nameToEndpoint, epToName)
+	if !ok {
+		return 0, time.Duration(0), fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	if !balanced {
+		endpoints = []string{ep}
+	}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return 0, time.Duration(0), err
+	}
+	defer cli.Close()
+
+	opts := []clientv3.OpOption{clientv3.WithPrevKV()}
+	if len(key) == 0 {
+		key = "\x00" // query the whole key
+		opts = append(opts, clientv3.WithFromKey())
+	} else if prefix {
+		opts = append(opts, clientv3.WithPrefix())
+	}
+
+	kvc := clientv3.NewKV(cli)
+	c.Write(name, fmt.Sprintf("[DELETE] Started! deadline %v (endpoints: %q)", requestTimeout, endpoints), streamIDs...)
+	st := time.Now()
+	var dresp *clientv3.DeleteResponse
+	err = c.doRetry("DELETE", name, endpoints, func(ctx context.Context) (err error) {
+		dresp, err = kvc.Delete(ctx, key, opts...)
+		return err
+	}, streamIDs...)
+	if err != nil {
+		return 0, time.Duration(0), c.requestErr("DELETE", name, err, endpoints, streamIDs...)
+	}
+
+	took := time.Since(st)
+	if balanced {
+		c.Write(name, fmt.Sprintf("[DELETE] Served by %s (balanced among the endpoints: %q)", servedBy(cli, dresp.Header.MemberId, epToName), endpoints), streamIDs...)
+	}
+	for i, kv := range dresp.PrevKvs {
+		if i == 10 {
+			c.Write(name, fmt.Sprintf("[DELETE] ... and %d more", len(dresp.PrevKvs)-i), streamIDs...)
+			break
+		}
+		c.Write(name, fmt.Sprintf("[DELETE] %q : %s", kv.Key, renderValue(string(kv.Value))), streamIDs...)
+	}
+	c.Write(name, fmt.Sprintf("[DELETE] %d deleted! Took %v (endpoints: %q)", dresp.Deleted, took, endpoints), streamIDs...)
+
+	return dresp.Deleted, took, nil
+}
+
+// newRand returns a random source seeded by WithStressSeed, or by the
+// current time.
+func (c *defaultCluster) newRand() *rand.Rand {
+	seed := c.stressSeed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+func (c *defaultCluster) stress(ctx context.Context, name string, stressN int, cfg StressConfig, donec chan struct{}, errc chan error, streamIDs ...string) {
+	endpoints, nameToEndpoint, epToName := c.Endpoints()
+	if name == "" {
+		name = c.nextName(endpoints, epToName)
+	}
+	if n, ep, ok := c.resolveEndpoint(name, nameToEndpoint, epToName); ok {
+		name, endpoints = n, []string{ep}
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   endpoints,
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		errc <- err
+		return
+	}
+	defer cli.Close()
+
+	clientsN := 10 // 1 connection, 10 clients
+	kvcs := make([]clientv3.KV, clientsN)
+	for i := range kvcs {
+		kvcs[i] = clientv3.NewKV(cli)
+	}
+
+	// rnd is only used by this goroutine, so concurrent stresses do not
+	// share the random state
+	rnd := c.newRand()
+	keys, vals := stressKeys(rnd, cfg, stressN), stressValues(rnd, cfg, stressN)
+	reads := stressReads(rnd, cfg, stressN)
+
+	// reads get the keys already written, not to skew the latency with
+	// misses
+	var (
+		mu         sync.Mutex // guards the following
+		written    []string
+		readTook   time.Duration
+		writeTook  time.Duration
+		writtenc   = make(chan struct{}) // closed once a key is written
+		stopc      = make(chan struct{})
+		readN      = stressReadN(cfg, stressN)
+		writeN     = stressN - readN
+		firstWrite sync.Once
+	)
+	defer close(stopc)
+
+	st := time.Now()
+	done, errChan := make(chan struct{}), make(chan error)
+	for i := 0; i < stressN; i++ {
+		if reads[i] {
+			go func(i, pick int, kvc clientv3.KV) {
+				select {
+				case <-writtenc:
+				case <-stopc:
+					return
+				}
+				mu.Lock()
+				key := written[pick%len(written)]
+				mu.Unlock()
+
+				ost := time.Now()
+				ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+				resp, err := kvc.Get(ctx, key)
+				cancel()
+				if err != nil {
+					errChan <- err
+					return
+				}
+				mu.Lock()
+				readTook += time.Since(ost)
+				mu.Unlock()
+				val := ""
+				if len(resp.Kvs) > 0 {
+					val = string(resp.Kvs[0].Value)
+				}
+				c.Write(name, fmt.Sprintf("[STRESS GET %2d] %q : %q", i, key, val), streamIDs...)
+				done <- struct{}{}
+			}(i, rnd.Int(), kvcs[rnd.Intn(clientsN)])
+			continue
+		}
+		go func(i int, kvc clientv3.KV) {
+			key, val := keys[i], vals[i]
+			ost := time.Now()
+			ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+			_, err := kvc.Put(ctx, key, val)
+			cancel()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			mu.Lock()
+			writeTook += time.Since(ost)
+			written = append(written, key)
+			mu.Unlock()
+			firstWrite.Do(func() { close(writtenc) })
+			c.Write(name, fmt.Sprintf("[STRESS PUT %2d] %q : %q", i, key, val), streamIDs...)
+			done <- struct{}{}
+		}(i, kvcs[rnd.Intn(clientsN)])
+	}
+	cn := 0
+	for cn != stressN {
+		select {
+		case err := <-errChan:
+			errc <- err
+			return
+		case <-done:
+			cn++
+		}
+	}
+	tt := time.Since(st)
+	pt := tt / time.Duration(stressN)
+
+	c.Write(name, fmt.Sprintf("[STRESS] Done! Took %v for %d requests(%v per each), %d client(s), %s keys of %d bytes, values of %d bytes (endpoints: %s)", tt, stressN, pt, clientsN, cfg.Distribution, cfg.KeySize, cfg.ValueSize, endpoints), streamIDs...)
+	if readN > 0 {
+		c.Write(name, fmt.Sprintf("[STRESS] %d reads took %v per each, %d writes took %v per each", readN, readTook/time.Duration(readN), writeN, writeTook/time.Duration(writeN)), streamIDs...)
+	}
+	donec <- struct{}{}
+	return
+}
+
+func (c *defaultCluster) Stress(name string, stressN int, streamIDs ...string) (time.Duration, error) {
+	return c.StressWithConfig(name, stressN, StressConfig{}, streamIDs...)
+}
+
+func (c *defaultCluster) StressWithConfig(name string, stressN int, cfg StressConfig, streamIDs ...string) (time.Duration, error) {
+	cfg, err := cfg.withDefaults(stressN)
+	if err != nil {
+		return time.Duration(0), err
+	}
+	if err := c.checkSize(stressMaxKeySize(cfg, stressN), cfg.ValueSize); err != nil {
+		return time.Duration(0), err
+	}
+	done, err := c.begin()
+	if err != nil {
+		return time.Duration(0), err
+	}
+	if endpoints, _, _ := c.Endpoints(); len(endpoints) == 0 {
+		done()
+		return time.Duration(0), ErrNoActiveNodes
+	}
+	ctx, deregister := c.operations.register(c.ctx, "STRESS", streamIDs)
+
+	// buffered so that stress does not block after timeout
+	donec, errc := make(chan struct{}, 1), make(chan error, 1)
+	st := time.Now()
+	go func() {
+		defer done()
+		defer deregister()
+		c.stress(ctx, name, stressN, cfg, donec, errc, streamIDs...)
+	}()
+	select {
+	case err := <-errc:
+		if ctx.Err() != nil {
+			return time.Duration(0), ctx.Err()
+		}
+		return time.Duration(0), err
+	case <-donec:
+		took := time.Since(st)
+		return took, nil
+	case <-ctx.Done():
+		return time.Duration(0), ctx.Err()
+	case <-time.After(5 * time.Second):
+		return time.Duration(0), fmt.Errorf("stress %w", ErrTimeout)
+	}
+}
+
+// nextName returns the name of the next of the active endpoints, so that
+// the operations on unnamed nodes spread over the active nodes in turn.
+// It returns an empty name if there is no active endpoint.
+func (c *defaultCluster) nextName(endpoints []string, epToName map[string]string) string {
+	if len(endpoints) == 0 {
+		return ""
+	}
+	i := atomic.AddUint64(&c.nextIndex, 1) - 1
+	return epToName[endpoints[i%uint64(len(endpoints))]]
+}
+
+// pick returns the name and endpoint of the node. If the name is not
+// specified, it picks the next active node. The name may be a raw
+// endpoint, as resolved by resolveEndpoint.
+func (c *defaultCluster) pick(name string) (string, string, error) {
+	endpoints, nameToEndpoint, epToName := c.Endpoints()
+	if name == "" {
+		if len(endpoints) == 0 {
+			return "", "", ErrNoActiveNodes
+		}
+		name = c.nextName(endpoints, epToName)
+	}
+	name, ep, ok := c.resolveEndpoint(name, nameToEndpoint, epToName)
+	if !ok {
+		return "", "", fmt.Errorf("%s %w", name, ErrNodeNotFound)
+	}
+	return name, ep, nil
+}
+
+// resolveEndpoint returns the name and endpoint of the Node name. A raw
+// endpoint resolves to the Node that serves it, or else is used as is,
+// named after itself, only if it is of a member found by DiscoverEndpoints
+// or syncMembers, so that the users cannot make the server dial any
+// address.
+func (c *defaultCluster) resolveEndpoint(name string, nameToEndpoint, epToName map[string]string) (string, string, bool) {
+	if ep, ok := nameToEndpoint[name]; ok {
+		return name, ep, true
+	}
+	ep, ok := rawEndpoint(name)
+	if !ok {
+		return name, "", false
+	}
+	if n, ok := epToName[ep]; ok {
+		return n, ep, true
+	}
+	if !c.isMemberEndpoint(ep) {
+		return name, "", false
+	}
+	return ep, ep, true
+}
+
+// isMemberEndpoint returns true if the endpoint is of a member found by
+// DiscoverEndpoints or syncMembers.
+func (c *defaultCluster) isMemberEndpoint(ep string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.discovered[ep]; ok {
+		return true
+	}
+	for _, mep := range c.members {
+		if mep == ep {
+			return true
+		}
+	}
+	return false
+}
+
+// anyEndpoint returns the name and endpoint of an active node.
+func (c *defaultCluster) anyEndpoint() (string, string, error) {
+	endpoints, _, epToName := c.Endpoints()
+	if len(endpoints) == 0 {
+		return "", "", ErrNoActiveNodes
+	}
+	ep := endpoints[rand.Intn(len(endpoints))]
+	return epToName[ep], ep, nil
+}
+
+// quotaBackendBytes returns the largest space quota of the local Nodes, or
+// zero if none is configured or known.
+func (c *defaultCluster) quotaBackendBytes() int64 {
+	var quota int64
+	for _, nd := range c.nodes() {
+		if local, ok := nd.(*NodeWebLocal); ok && local.Flags.QuotaBackendBytes > quota {
+			quota = local.Flags.QuotaBackendBytes
+		}
+	}
+	return quota
+}
+
+func (c *defaultCluster) FillUntilQuota(ctx context.Context, name string, streamIDs ...string) error {
+	done, err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	name, endpoint, err := c.pick(name)
+	if err != nil {
+		return err
+	}
+	quota := c.quotaBackendBytes()
+	if quota == 0 {
+		return fmt.Errorf("%s %w", name, ErrNoQuota)
+	}
+	// the database grows a bit past the written bytes, so this is only
+	// reached if the Node does not enforce the quota
+	maxTotal := 2 * uint64(quota)
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	const (
+		minValSize = 1024
+		maxWrites  = 10000
+	)
+	maxValSize := 1024 * 1024 // etcd rejects requests larger than 1.5 MB
+	if c.maxValueSize > 0 && c.maxValueSize < maxValSize {
+		maxValSize = c.maxValueSize
 	}
 
-	// Hash
-	go func() {
-		mc := pb.NewMaintenanceClient(conn)
-		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-		resp, err := mc.Hash(ctx, &pb.HashRequest{})
+	kvc := clientv3.NewKV(cli)
+	c.Write(name, fmt.Sprintf("[QUOTA] Started! (endpoints: %q)", endpoint), streamIDs...)
+	var total uint64
+	valSize := minValSize
+	if valSize > maxValSize {
+		valSize = maxValSize
+	}
+	rnd := c.newRand()
+	for i := 0; i < maxWrites && total < maxTotal; i++ {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		key, val := fmt.Sprintf("quota_%d", i), string(randBytes(rnd, valSize))
+		pctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		_, err = kvc.Put(pctx, key, val)
 		cancel()
+		if err == rpctypes.ErrNoSpace {
+			c.Write(name, fmt.Sprintf("[QUOTA] Database space exceeded after writing %d keys (%s)!", i, humanize.Bytes(total)), streamIDs...)
+			break
+		}
 		if err != nil {
-			errChan <- err
-			return
+			return err
+		}
+		total += uint64(valSize)
+		if i%100 == 0 {
+			c.Write(name, fmt.Sprintf("[QUOTA] Wrote %d keys (%s)", i+1, humanize.Bytes(total)), streamIDs...)
+		}
+		if valSize *= 2; valSize > maxValSize {
+			valSize = maxValSize
 		}
-		stat.Hash = int(resp.Hash)
-		done <- struct{}{}
-	}()
-	select {
-	case <-time.After(5 * time.Second):
-		errc <- fmt.Errorf("timed out")
-		return
-	case err := <-errChan:
-		errc <- err
-		return
-	case <-done:
-		rs <- stat
+	}
+	if err != rpctypes.ErrNoSpace {
+		return fmt.Errorf("quota %s not exceeded after writing %s", humanize.Bytes(uint64(quota)), humanize.Bytes(total))
 	}
 
-	// Number of keys
-	// go func() {
-	// 	resp, err := http.Get(v2Endpoint + "/metrics")
-	// 	if err != nil {
-	// 		errChan <- err
-	// 		return
-	// 	}
-	// 	scanner := bufio.NewScanner(resp.Body)
-	// 	for scanner.Scan() {
-	// 		txt := scanner.Text()
-	// 		if strings.HasPrefix(txt, "#") {
-	// 			continue
-	// 		}
-	// 		ts := strings.SplitN(txt, " ", 2)
-	// 		fv := 0.0
-	// 		if len(ts) == 2 {
-	// 			v, err := strconv.ParseFloat(ts[1], 64)
-	// 			if err == nil {
-	// 				fv = v
-	// 			}
-	// 		}
-	// 		if ts[0] == "etcd_debugging_mvcc_keys_total" {
-	// 			stat.NumberOfKeys = int(fv)
-	// 			break
-	// 		}
-	// 	}
-	// 	gracefulClose(resp)
-	// 	done <- struct{}{}
-	// }()
-	// select {
-	// case <-time.After(5 * time.Second):
-	// 	errc <- fmt.Errorf("timed out")
-	// 	return
-	// case err := <-errChan:
-	// 	errc <- err
-	// 	return
-	// case <-done:
-	// 	rs <- stat
-	// }
-
-	return
+	if _, err = c.AlarmList(streamIDs...); err != nil {
+		return err
+	}
+	c.Write(name, "[QUOTA] The cluster only accepts reads and deletes now. To clear the alarm, compact and defragment to free up space, and then disarm the alarm.", streamIDs...)
+	return nil
 }
 
-func (c *defaultCluster) Status() (map[string]ServerStatus, error) {
-	_, nameToEndpoint, _ := c.Endpoints()
-	nameToV2Endpoint := make(map[string]string)
-	for name, nd := range c.nameToNode {
-		nameToV2Endpoint[name] = nd.StatusEndpoint()
+func (c *defaultCluster) AlarmList(streamIDs ...string) ([]Alarm, error) {
+	done, err := c.begin()
+	if err != nil {
+		return nil, err
 	}
+	defer done()
 
-	sc, errc := make(chan ServerStatus), make(chan error)
-	for name, grpcEndpoint := range nameToEndpoint {
-		go getStatus(name, grpcEndpoint, nameToV2Endpoint[name], sc, errc)
-		// go getStatus(name, grpcEndpoint, nameToV2Endpoint[name], c.nameToNode[name].TLS(), sc, errc)
+	name, endpoint, err := c.anyEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return nil, err
 	}
+	defer cli.Close()
 
-	nameToStatus := make(map[string]ServerStatus)
-	var err error
-	cn := 0
-	for cn != len(nameToEndpoint) {
-		select {
-		case s := <-sc:
-			nameToStatus[s.Name] = s
-		case err = <-errc:
-		}
-		cn++
+	mapi := clientv3.NewMaintenance(cli)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	resp, err := mapi.AlarmList(ctx)
+	cancel()
+	if err != nil {
+		return nil, err
 	}
 
-	for name, endpoint := range nameToEndpoint {
-		if _, ok := nameToStatus[name]; !ok {
-			stat := emptyStat
-			stat.Name = name
-			stat.Endpoint = endpoint
-			nameToStatus[name] = stat
-		}
+	alarms := []Alarm{}
+	for _, am := range resp.Alarms {
+		a := Alarm{MemberID: fmt.Sprintf("%x", am.MemberID), Type: am.Alarm.String()}
+		alarms = append(alarms, a)
+		c.Write(name, fmt.Sprintf("[ALARM] member %s: %s", a.MemberID, a.Type), streamIDs...)
 	}
-	return nameToStatus, err
+	if len(alarms) == 0 {
+		c.Write(name, "[ALARM] No active alarm!", streamIDs...)
+	}
+	return alarms, nil
 }
 
-func (c *defaultCluster) Put(name, key, value string, streamIDs ...string) (time.Duration, error) {
-	endpoints, nameToEndpoint, _ := c.Endpoints()
-	if name == "" {
-		for n := range nameToEndpoint {
-			name = n
-			break
-		}
-	}
-	if v, ok := nameToEndpoint[name]; ok {
-		endpoints = []string{v}
-	} else {
-		return time.Duration(0), fmt.Errorf("%s does not exist", name)
+func (c *defaultCluster) AlarmDisarm(streamIDs ...string) error {
+	done, err := c.begin()
+	if err != nil {
+		return err
 	}
+	defer done()
 
+	name, endpoint, err := c.anyEndpoint()
+	if err != nil {
+		return err
+	}
 	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: 5 * time.Second,
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
 	})
 	if err != nil {
-		return time.Duration(0), err
+		return err
 	}
 	defer cli.Close()
 
-	kvc := clientv3.NewKV(cli)
-	st := time.Now()
-
-	c.Write(name, fmt.Sprintf("[PUT] Started! (endpoints: %q)", endpoints), streamIDs...)
+	mapi := clientv3.NewMaintenance(cli)
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	_, err = kvc.Put(ctx, key, value)
+	resp, err := mapi.AlarmDisarm(ctx, &clientv3.AlarmMember{}) // disarm all
 	cancel()
 	if err != nil {
-		return time.Duration(0), err
+		return err
 	}
+	c.Write(name, fmt.Sprintf("[ALARM] Disarmed %d alarm(s)!", len(resp.Alarms)), streamIDs...)
+	return nil
+}
 
-	took := time.Since(st)
-	c.Write(name, fmt.Sprintf("[PUT] %q : %q / Took %v (endpoints: %q)", key, value, took, endpoints), streamIDs...)
+// maintenanceTimeout is the timeout for Defragment and Snapshot, which may take much longer
+// than other requests.
+var maintenanceTimeout = 30 * time.Second
 
-	return took, nil
-}
+func (c *defaultCluster) Defragment(name string, streamIDs ...string) error {
+	done, err := c.begin()
+	if err != nil {
+		return err
+	}
+	defer done()
 
-func (c *defaultCluster) Get(name, key string, prefix bool, streamIDs ...string) ([]string, time.Duration, error) {
-	endpoints, nameToEndpoint, _ := c.Endpoints()
-	if name == "" {
-		for n := range nameToEndpoint {
-			name = n
-			break
-		}
+	names, err := c.namesOrActive(name)
+	if err != nil {
+		return err
 	}
-	if v, ok := nameToEndpoint[name]; ok {
-		endpoints = []string{v}
-	} else {
-		return nil, time.Duration(0), fmt.Errorf("%s does not exist", name)
+	for _, n := range names {
+		if err := c.defragment(n, streamIDs...); err != nil {
+			return fmt.Errorf("%s (%w)", n, err)
+		}
 	}
+	return nil
+}
 
+func (c *defaultCluster) defragment(name string, streamIDs ...string) error {
+	name, endpoint, err := c.pick(name)
+	if err != nil {
+		return err
+	}
 	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: 5 * time.Second,
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
 	})
 	if err != nil {
-		return nil, time.Duration(0), err
+		return err
 	}
 	defer cli.Close()
 
-	var opts []clientv3.OpOption
-	if len(key) == 0 {
-		key = "\x00" // query the whole key
-		opts = append(opts, clientv3.WithFromKey())
-	} else if prefix {
-		opts = append(opts, clientv3.WithPrefix())
+	mapi := clientv3.NewMaintenance(cli)
+	before, err := dbSize(mapi, endpoint)
+	if err != nil {
+		return err
 	}
+	c.Write(name, fmt.Sprintf("[DEFRAG] Started! DB size %s (endpoints: %q)", humanize.Bytes(before), endpoint), streamIDs...)
 
-	kvc := clientv3.NewKV(cli)
-	c.Write(name, fmt.Sprintf("[GET] Started! (endpoints: %q)", endpoints), streamIDs...)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	st := time.Now()
-	resp, err := kvc.Get(ctx, key, opts...)
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceTimeout)
+	_, err = mapi.Defragment(ctx, endpoint)
 	cancel()
 	if err != nil {
-		return nil, time.Duration(0), err
+		return err
 	}
-	vs := []string{}
-	if len(resp.Kvs) > 0 {
-		for _, ev := range resp.Kvs {
-			vs = append(vs, string(ev.Value))
-			c.Write(name, fmt.Sprintf("[GET] %q : %q", ev.Key, ev.Value), streamIDs...)
-		}
-	} else {
-		c.Write(name, fmt.Sprintf("[GET] %q does not exist!", key), streamIDs...)
+	took := time.Since(st)
+
+	after, err := dbSize(mapi, endpoint)
+	if err != nil {
+		return err
 	}
+	c.Write(name, fmt.Sprintf("[DEFRAG] Done! DB size %s -> %s / Took %v (endpoints: %q)", humanize.Bytes(before), humanize.Bytes(after), took, endpoint), streamIDs...)
+	return nil
+}
 
-	took := time.Since(st)
-	c.Write(name, fmt.Sprintf("[GET] Done! Took %v (endpoints: %q)", took, endpoints), streamIDs...)
-	sort.Strings(vs)
-	return vs, took, nil
+// namesOrActive returns the name, or the names of all active Nodes if the
+// name is empty.
+func (c *defaultCluster) namesOrActive(name string) ([]string, error) {
+	if name != "" {
+		return []string{name}, nil
+	}
+	endpoints, _, epToName := c.Endpoints()
+	if len(endpoints) == 0 {
+		return nil, ErrNoActiveNodes
+	}
+	names := make([]string, 0, len(endpoints))
+	for _, ep := range endpoints {
+		names = append(names, epToName[ep])
+	}
+	return names, nil
 }
 
-func (c *defaultCluster) Delete(name, key string, prefix bool, streamIDs ...string) (int64, time.Duration, error) {
-	endpoints, nameToEndpoint, _ := c.Endpoints()
-	if name == "" {
-		for n := range nameToEndpoint {
-			name = n
-			break
-		}
+// dbSize returns the size of the backend database of the member at the
+// endpoint, from the maintenance status.
+func dbSize(mapi clientv3.Maintenance, endpoint string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	resp, err := mapi.Status(ctx, endpoint)
+	if err != nil {
+		return 0, err
 	}
-	if v, ok := nameToEndpoint[name]; ok {
-		endpoints = []string{v}
-	} else {
-		return 0, time.Duration(0), fmt.Errorf("%s does not exist", name)
+	return uint64(resp.DbSize), nil
+}
+
+func (c *defaultCluster) ReclaimSpace(name string, streamIDs ...string) error {
+	done, err := c.begin()
+	if err != nil {
+		return err
 	}
+	defer done()
 
-	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: 5 * time.Second,
-	})
+	names, err := c.namesOrActive(name)
 	if err != nil {
-		return 0, time.Duration(0), err
+		return err
+	}
+	var (
+		endpoints = make([]string, len(names))
+		before    = make([]uint64, len(names))
+	)
+	for i, n := range names {
+		n, ep, err := c.pick(n)
+		if err != nil {
+			return err
+		}
+		names[i], endpoints[i] = n, ep
+		if before[i], err = c.memberDBSize(ep); err != nil {
+			return fmt.Errorf("%s (%w)", n, err)
+		}
+		c.Write(n, fmt.Sprintf("[RECLAIM] Started! DB size %s (endpoints: %q)", humanize.Bytes(before[i]), ep), streamIDs...)
 	}
-	defer cli.Close()
 
-	var opts []clientv3.OpOption
-	if len(key) == 0 {
-		key = "\x00" // query the whole key
-		opts = append(opts, clientv3.WithFromKey())
-	} else if prefix {
-		opts = append(opts, clientv3.WithPrefix())
+	// the compaction is replicated to all members, so one is enough
+	rev, err := c.compact(c.ctx, endpoints[0])
+	if err != nil {
+		return fmt.Errorf("%s (%w)", names[0], err)
 	}
+	c.Write(names[0], fmt.Sprintf("[RECLAIM] Compacted the revisions before %d. The freed space is reused for new writes, but the DB file does not shrink until defragmented", rev), streamIDs...)
 
-	kvc := clientv3.NewKV(cli)
-	c.Write(name, fmt.Sprintf("[DELETE] Started! (endpoints: %q)", endpoints), streamIDs...)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	st := time.Now()
-	var dresp *clientv3.DeleteResponse
-	dresp, err = kvc.Delete(ctx, key, opts...)
-	cancel()
+	for i, n := range names {
+		compacted, err := c.memberDBSize(endpoints[i])
+		if err != nil {
+			return fmt.Errorf("%s (%w)", n, err)
+		}
+		c.Write(n, fmt.Sprintf("[RECLAIM] DB size %s after the compaction (endpoints: %q)", humanize.Bytes(compacted), endpoints[i]), streamIDs...)
+
+		if err := c.defragment(n, streamIDs...); err != nil {
+			return fmt.Errorf("%s (%w)", n, err)
+		}
+		after, err := c.memberDBSize(endpoints[i])
+		if err != nil {
+			return fmt.Errorf("%s (%w)", n, err)
+		}
+		var freed uint64
+		if before[i] > after {
+			freed = before[i] - after
+		}
+		c.Write(n, fmt.Sprintf("[RECLAIM] Done! DB size %s -> %s after the compaction -> %s after the defragmentation, freed %s (endpoints: %q)", humanize.Bytes(before[i]), humanize.Bytes(compacted), humanize.Bytes(after), humanize.Bytes(freed), endpoints[i]), streamIDs...)
+	}
+	return nil
+}
+
+// memberDBSize returns the size of the backend database of the member at
+// the endpoint.
+func (c *defaultCluster) memberDBSize(endpoint string) (uint64, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
 	if err != nil {
-		return 0, time.Duration(0), err
+		return 0, err
 	}
+	defer cli.Close()
+	return dbSize(clientv3.NewMaintenance(cli), endpoint)
+}
 
-	took := time.Since(st)
-	c.Write(name, fmt.Sprintf("[DELETE] %d deleted! Took %v (endpoints: %q)", dresp.Deleted, took, endpoints), streamIDs...)
+// progressWriter counts the bytes written and reports the progress
+// every reportN bytes.
+type progressWriter struct {
+	w       io.Writer
+	written uint64
+	last    uint64
+	reportN uint64
+	report  func(written uint64)
+}
 
-	return dresp.Deleted, took, nil
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += uint64(n)
+	if pw.written-pw.last >= pw.reportN {
+		pw.last = pw.written
+		pw.report(pw.written)
+	}
+	return n, err
 }
 
-func (c *defaultCluster) stress(name string, stressN int, donec chan struct{}, errc chan error, streamIDs ...string) {
-	endpoints, nameToEndpoint, _ := c.Endpoints()
-	if name == "" {
-		for n := range nameToEndpoint {
-			name = n
-			break
-		}
+func (c *defaultCluster) Snapshot(name string, w io.Writer, streamIDs ...string) error {
+	done, err := c.begin()
+	if err != nil {
+		return err
 	}
-	if v, ok := nameToEndpoint[name]; ok {
-		endpoints = []string{v}
+	defer done()
+
+	name, endpoint, err := c.pick(name)
+	if err != nil {
+		return err
 	}
 	cli, err := clientv3.New(clientv3.Config{
-		Endpoints:   endpoints,
-		DialTimeout: 5 * time.Second,
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
 	})
 	if err != nil {
-		errc <- err
-		return
+		return err
 	}
 	defer cli.Close()
 
-	clientsN := 10 // 1 connection, 10 clients
-	kvcs := make([]clientv3.KV, clientsN)
-	for i := range kvcs {
-		kvcs[i] = clientv3.NewKV(cli)
-	}
+	mapi := clientv3.NewMaintenance(cli)
+	c.Write(name, fmt.Sprintf("[SNAPSHOT] Started! (endpoints: %q)", endpoint), streamIDs...)
 
-	keys, vals := multiRandBytes(5, stressN), multiRandBytes(5, stressN)
 	st := time.Now()
-	done, errChan := make(chan struct{}), make(chan error)
-	for i := 0; i < stressN; i++ {
-		go func(i int) {
-			kvc := kvcs[rand.Intn(clientsN)]
-			key, val := fmt.Sprintf("foo_%d_%s", i, keys[i]), string(vals[i])
-			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-			_, err = kvc.Put(ctx, key, val)
-			cancel()
-			if err != nil {
-				errChan <- err
-				return
-			}
-			c.Write(name, fmt.Sprintf("[STRESS PUT %2d] %q : %q", i, key, val), streamIDs...)
-			done <- struct{}{}
-		}(i)
+	ctx, cancel := context.WithTimeout(context.Background(), maintenanceTimeout)
+	defer cancel()
+	rc, err := mapi.Snapshot(ctx)
+	if err != nil {
+		return err
 	}
-	cn := 0
-	for cn != stressN {
-		select {
-		case err := <-errChan:
-			errc <- err
-			return
-		case <-done:
-			cn++
-		}
+	defer rc.Close()
+
+	pw := &progressWriter{
+		w:       w,
+		reportN: 1024 * 1024,
+		report: func(written uint64) {
+			c.Write(name, fmt.Sprintf("[SNAPSHOT] Wrote %s", humanize.Bytes(written)), streamIDs...)
+		},
+	}
+	if _, err = io.Copy(pw, rc); err != nil {
+		return err
 	}
-	tt := time.Since(st)
-	pt := tt / time.Duration(stressN)
 
-	c.Write(name, fmt.Sprintf("[STRESS] Done! Took %v for %d requests(%v per each), %d client(s) (endpoints: %s)", tt, stressN, pt, clientsN, endpoints), streamIDs...)
-	donec <- struct{}{}
-	return
+	c.Write(name, fmt.Sprintf("[SNAPSHOT] Done! Wrote %s / Took %v (endpoints: %q)", humanize.Bytes(pw.written), time.Since(st), endpoint), streamIDs...)
+	return nil
 }
 
-func (c *defaultCluster) Stress(name string, stressN int, streamIDs ...string) (time.Duration, error) {
-	donec, errc := make(chan struct{}), make(chan error)
-	st := time.Now()
-	go c.stress(name, stressN, donec, errc, streamIDs...)
-	select {
-	case err := <-errc:
-		return time.Duration(0), err
-	case <-donec:
-		took := time.Since(st)
-		return took, nil
-	case <-time.After(5 * time.Second):
-		return time.Duration(0), fmt.Errorf("Stress timed out!")
+func (c *defaultCluster) HashHistory(name string, n int, interval time.Duration, streamIDs ...string) ([]int, error) {
+	return c.HashHistoryContext(context.Background(), name, n, interval, streamIDs...)
+}
+
+func (c *defaultCluster) HashHistoryContext(ctx context.Context, name string, n int, interval time.Duration, streamIDs ...string) ([]int, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("number of samples %d must be positive", n)
+	}
+	done, err := c.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	ctx, cancel := withCancelOn(ctx, c.ctx)
+	defer cancel()
+
+	name, endpoint, err := c.pick(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(endpoint, grpc.WithInsecure(), grpc.WithTimeout(c.dialTimeout))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	c.Write(name, fmt.Sprintf("[HASH] Started! %d samples every %v (endpoints: %q)", n, interval, endpoint), streamIDs...)
+	hashes := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				c.Write(name, fmt.Sprintf("[HASH] Canceled after %d samples", len(hashes)), streamIDs...)
+				return hashes, ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+		h, err := getHash(ctx, conn)
+		if err != nil {
+			if ctx.Err() != nil {
+				return hashes, ctx.Err()
+			}
+			return hashes, err
+		}
+		hashes = append(hashes, h)
+		c.Write(name, fmt.Sprintf("[HASH] %d/%d %d", i+1, n, h), streamIDs...)
 	}
+	return hashes, nil
 }
@@ -0,0 +1,3374 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/coreos/etcd/etcdserver/api/v3rpc/rpctypes"
+	pb "github.com/coreos/etcd/etcdserver/etcdserverpb"
+	"github.com/coreos/etcd/tools/functional-tester/etcd-agent/client"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// newTestCluster creates a local cluster whose nodes run programPath
+// instead of etcd. programPath should end with '#' so that the etcd flags
+// are ignored by the shell.
+func newTestCluster(t testing.TB, size int, programPath string, opts ...OpOption) *defaultCluster {
+	dir, err := ioutil.TempDir("", "etcd-play-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	fs := make([]*Flags, size)
+	for i := range fs {
+		df, err := GenerateFlags(fmt.Sprintf("etcd%d", i+1), "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		df.DataDir = fmt.Sprintf("%s/etcd%d.etcd", dir, i+1)
+		fs[i] = df
+	}
+	c, err := NewCluster(WebLocal, programPath, fs, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.(*defaultCluster)
+}
+
+func TestBootstrapShutdown(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	for name, nd := range c.nameToNode {
+		if !nd.IsActive() {
+			t.Errorf("%s is not active after Bootstrap", name)
+		}
+	}
+	if err := c.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	for name, nd := range c.nameToNode {
+		if nd.IsActive() {
+			t.Errorf("%s is still active after Shutdown", name)
+		}
+	}
+}
+
+func TestBootstrapRollback(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	c.nameToNode["etcd3"].(*NodeWebLocal).Flags.InitialClusterState = "unknown"
+
+	if err := c.Bootstrap(); err == nil {
+		t.Fatal("expected Bootstrap error")
+	}
+	for name, nd := range c.nameToNode {
+		if nd.IsActive() {
+			t.Errorf("%s is still active after failed Bootstrap", name)
+		}
+		pid := nd.(*NodeWebLocal).PID
+		if pid == 0 {
+			continue
+		}
+		if !processExited(pid, 3*time.Second) {
+			t.Errorf("%s process %d lingers after failed Bootstrap", name, pid)
+		}
+	}
+}
+
+func TestBootstrapLeaderWait(t *testing.T) {
+	old := leaderPollInterval
+	leaderPollInterval = 10 * time.Millisecond
+	defer func() { leaderPollInterval = old }()
+
+	c, fakes := newFakeEtcdCluster(t, 3)
+	c.leaderTimeout = 300 * time.Millisecond
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	c.Shutdown()
+
+	// each member lists only itself in the initial cluster, so that no
+	// quorum forms, and the fakes report no leader as the members would
+	c, fakes = newFakeEtcdCluster(t, 3)
+	c.leaderTimeout = 300 * time.Millisecond
+	for name, nd := range c.nameToNode {
+		f := nd.(*NodeWebLocal).Flags
+		f.InitialCluster = map[string]string{name: f.InitialCluster[name]}
+	}
+	for _, f := range fakes {
+		f.mu.Lock()
+		f.noLeader = true
+		f.mu.Unlock()
+	}
+	err := c.Bootstrap()
+	if !errors.Is(err, ErrTimeout) || !strings.Contains(err.Error(), "no leader") {
+		t.Fatalf("expected no leader error, got %v", err)
+	}
+	for name, nd := range c.nameToNode {
+		if nd.IsActive() {
+			t.Errorf("%s is still active after failed Bootstrap", name)
+		}
+		if pid := nd.(*NodeWebLocal).PID; pid != 0 && !processExited(pid, 3*time.Second) {
+			t.Errorf("%s process %d lingers after failed Bootstrap", name, pid)
+		}
+	}
+}
+
+// fakeEtcdctl stands in for 'etcdctl snapshot restore'. It records the
+// arguments, and copies the snapshot into the data directory as the
+// database file.
+const fakeEtcdctl = `#!/bin/sh
+echo "$@" >> "$(dirname "$0")/args"
+snap=$3
+shift 3
+while [ $# -gt 0 ]; do
+	if [ "$1" = "--data-dir" ]; then dir=$2; fi
+	shift 2
+done
+mkdir -p "$dir/member/snap" && cp "$snap" "$dir/member/snap/db"
+`
+
+func TestBootstrapProgress(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 2, WithStartProbe(500*time.Millisecond))
+	// nothing serves etcd2
+	setClientURL(c.nameToNode["etcd2"].(*NodeWebLocal).Flags, "http://localhost:0")
+
+	progress := make(chan BootstrapEvent)
+	errc := make(chan error, 1)
+	go func() { errc <- c.BootstrapProgress(progress) }()
+
+	nameToStates := make(map[string][]string)
+	for ev := range progress {
+		if ev.State == NodeFailed && ev.Err == nil {
+			t.Errorf("%s failed without error", ev.Name)
+		}
+		nameToStates[ev.Name] = append(nameToStates[ev.Name], ev.State.String())
+	}
+	if err := <-errc; err == nil || !strings.Contains(err.Error(), "etcd2") {
+		t.Fatalf("expected etcd2 to fail, got %v", err)
+	}
+	expected := map[string][]string{
+		"etcd1": {"starting", "started", "ready"},
+		"etcd2": {"starting", "started", "failed"},
+	}
+	if !reflect.DeepEqual(nameToStates, expected) {
+		t.Errorf("expected %v, got %v", expected, nameToStates)
+	}
+}
+
+func TestBootstrapSnapshotRestore(t *testing.T) {
+	src, _ := newFakeEtcdCluster(t, 1)
+	if err := src.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.Put("etcd1", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := src.Snapshot("etcd1", &buf); err != nil {
+		t.Fatal(err)
+	}
+	src.Shutdown()
+
+	dir, err := ioutil.TempDir("", "etcd-play-restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapPath, etcdctlPath := filepath.Join(dir, "snapshot.db"), filepath.Join(dir, "etcdctl")
+	if err := ioutil.WriteFile(snapPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(etcdctlPath, []byte(fakeEtcdctl), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c, fakes := newFakeEtcdCluster(t, 3, WithSnapshotRestore(snapPath, etcdctlPath))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// each member is restored with the member set it starts with
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, nd := range c.nameToNode {
+		f := nd.(*NodeWebLocal).Flags
+		if want := strings.Join(restoreArgs(snapPath, f), " "); !strings.Contains(string(args), want) {
+			t.Errorf("%s: expected restore with %q, got %q", name, want, args)
+		}
+	}
+	if !strings.Contains(string(args), "--initial-cluster "+mapToMapString(c.nameToNode["etcd1"].(*NodeWebLocal).Flags.InitialCluster)) {
+		t.Errorf("expected the initial cluster of all members, got %q", args)
+	}
+
+	// the fake etcd serves the restored data directory
+	db, err := ioutil.ReadFile(filepath.Join(c.nameToNode["etcd1"].(*NodeWebLocal).Flags.DataDir, "member", "snap", "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fakes[0].loadSnapshot(db); err != nil {
+		t.Fatal(err)
+	}
+	vs, _, err := c.Get("etcd2", "foo", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vs, []string{"bar"}) {
+		t.Errorf("expected [bar] after restore, got %v", vs)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+	if _, err := c.Put("etcd1", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Snapshot("etcd1", &buf, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	// the database starts with the meta page of bolt, whose fields after
+	// the page header end with their checksum
+	db := buf.Bytes()
+	if len(db) < boltPageSize {
+		t.Fatalf("expected a bolt database, got %d bytes", len(db))
+	}
+	meta := db[boltPageHdr:]
+	if magic := binary.LittleEndian.Uint32(meta); magic != boltMagic {
+		t.Errorf("expected the bolt magic %#x, got %#x", boltMagic, magic)
+	}
+	if version := binary.LittleEndian.Uint32(meta[4:]); version != boltVersion {
+		t.Errorf("expected the bolt version %d, got %d", boltVersion, version)
+	}
+	h := fnv.New64a()
+	h.Write(meta[:boltMetaSize])
+	if sum := binary.LittleEndian.Uint64(meta[boltMetaSize:]); sum != h.Sum64() {
+		t.Errorf("expected the meta checksum %#x, got %#x", h.Sum64(), sum)
+	}
+
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	if !strings.Contains(msgs, "[SNAPSHOT] Started!") || !strings.Contains(msgs, "[SNAPSHOT] Done!") {
+		t.Errorf("unexpected messages %q", msgs)
+	}
+}
+
+func TestSnapshotRestoreMissingFile(t *testing.T) {
+	df, err := GenerateFlags("etcd1", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCluster(WebLocal, "sleep 10 #", []*Flags{df}, WithSnapshotRestore("/nonexistent/snapshot.db", "etcdctl")); err == nil {
+		t.Fatal("expected an error for the missing snapshot file")
+	}
+}
+
+// processExited returns true if the process exits within timeout.
+func processExited(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestReviveAllDown(t *testing.T) {
+	// each process leaves a file, so that the fake members serve only once
+	// the processes of the quorum run, as etcd does
+	dir, err := ioutil.TempDir("", "etcd-play-revive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := newTestCluster(t, 3, fmt.Sprintf("touch %s/$$; sleep 10 #", dir), WithStartProbe(time.Second))
+	store := newFakeStore()
+	for i := 1; i <= 3; i++ {
+		f := newFakeEtcd(t, uint64(i), store)
+		setClientURL(c.nameToNode[fmt.Sprintf("etcd%d", i)].(*NodeWebLocal).Flags, "http://"+f.addr)
+		f.noQuorum = func() bool {
+			fis, _ := ioutil.ReadDir(dir)
+			return len(fis) < 2
+		}
+	}
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	for name := range c.nameToNode {
+		if err := c.Terminate(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.RemoveAll(dir)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Revive(); err != nil {
+		t.Fatal(err)
+	}
+	for name, nd := range c.nameToNode {
+		if !nd.IsActive() {
+			t.Errorf("%s is not active after Revive", name)
+		}
+	}
+}
+
+func TestRevivePartial(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	pids := make(map[string]int)
+	for name, nd := range c.nameToNode {
+		pids[name] = nd.(*NodeWebLocal).PID
+	}
+	if err := c.Terminate("etcd2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Revive(); err != nil {
+		t.Fatal(err)
+	}
+	for name, nd := range c.nameToNode {
+		if !nd.IsActive() {
+			t.Errorf("%s is not active after Revive", name)
+		}
+		pid := nd.(*NodeWebLocal).PID
+		if name == "etcd2" && pid == pids[name] {
+			t.Errorf("%s was not restarted", name)
+		}
+		if name != "etcd2" && pid != pids[name] {
+			t.Errorf("%s was restarted while active", name)
+		}
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #", WithLimitInterval(time.Hour))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	if err := c.Start("etcd9"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("expected ErrNodeNotFound, got %v", err)
+	}
+	if err := c.Start("etcd1"); !errors.Is(err, ErrNodeActive) {
+		t.Errorf("expected ErrNodeActive, got %v", err)
+	}
+	if err := c.Terminate("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Terminate("etcd1"); !errors.Is(err, ErrNodeInactive) {
+		t.Errorf("expected ErrNodeInactive, got %v", err)
+	}
+	if err := c.Restart("etcd1"); !errors.Is(err, ErrLimitInterval) {
+		t.Errorf("expected ErrLimitInterval, got %v", err)
+	}
+	if err := c.Defragment(""); !errors.Is(err, ErrNoActiveNodes) {
+		t.Errorf("expected ErrNoActiveNodes, got %v", err)
+	}
+	if err := c.ReclaimSpace(""); !errors.Is(err, ErrNoActiveNodes) {
+		t.Errorf("expected ErrNoActiveNodes, got %v", err)
+	}
+}
+
+func TestKillRestart(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	pid := nd.PID
+	if err := c.Kill("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+	if nd.IsActive() {
+		t.Error("etcd1 is still active after Kill")
+	}
+	if !processExited(pid, 3*time.Second) {
+		t.Fatalf("process %d lingers after Kill", pid)
+	}
+	if err := c.Restart("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+	if !nd.IsActive() || nd.PID == pid {
+		t.Error("etcd1 is not restarted after Kill")
+	}
+}
+
+func TestNodeHooks(t *testing.T) {
+	var (
+		c  *defaultCluster
+		mu sync.Mutex
+		// records the hooks in order
+		hooked []string
+		exitc  = make(chan struct{}, 10)
+	)
+	record := func(prefix string) NodeHook {
+		return func(name string, active bool) {
+			// the hooks run without the cluster lock
+			c.Endpoints()
+			mu.Lock()
+			hooked = append(hooked, fmt.Sprintf("%s %s %v", prefix, name, active))
+			mu.Unlock()
+			if !active {
+				exitc <- struct{}{}
+			}
+		}
+	}
+	c = newTestCluster(t, 2, "sleep 10 #", WithNodeHooks("", record("all"), record("all")), WithNodeHooks("etcd1", nil, record("etcd1")))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	if err := c.Terminate("etcd2"); err != nil {
+		t.Fatal(err)
+	}
+	<-exitc
+
+	// etcd1 crashes
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	if err := syscall.Kill(nd.PID, syscall.SIGKILL); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-exitc:
+		case <-time.After(3 * time.Second):
+			t.Fatal("no exit hook after the crash")
+		}
+	}
+	if nd.IsActive() {
+		t.Error("etcd1 is still active after the crash")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Strings(hooked[:2]) // Bootstrap starts the nodes concurrently
+	expected := []string{"all etcd1 true", "all etcd2 true", "all etcd2 false", "all etcd1 false", "etcd1 etcd1 false"}
+	if !reflect.DeepEqual(hooked, expected) {
+		t.Errorf("expected hooks %q, got %q", expected, hooked)
+	}
+}
+
+func TestNodeHooksNotFound(t *testing.T) {
+	fs := []*Flags{}
+	df, err := GenerateFlags("etcd1", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs = append(fs, df)
+	if _, err := NewCluster(WebLocal, "sleep 10 #", fs, WithNodeHooks("etcd2", nil, nil)); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("expected %v, got %v", ErrNodeNotFound, err)
+	}
+}
+
+func TestCleanPolicy(t *testing.T) {
+	for _, policy := range []CleanPolicy{CleanRemove, CleanBackup, CleanKeep} {
+		backupDir, err := ioutil.TempDir("", "etcd-play-backup")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(backupDir)
+
+		c := newTestCluster(t, 1, "sleep 10 #", WithCleanPolicy(policy, backupDir))
+		if err := c.Bootstrap(); err != nil {
+			t.Fatal(err)
+		}
+		dataDir := c.nameToNode["etcd1"].(*NodeWebLocal).Flags.DataDir
+		if err := os.MkdirAll(filepath.Join(dataDir, "member"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dataDir, "member", "db"), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Terminate("etcd1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Clean("etcd1"); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = os.Stat(dataDir)
+		backups, _ := filepath.Glob(filepath.Join(backupDir, filepath.Base(dataDir)+".*", "member", "db"))
+		switch policy {
+		case CleanRemove:
+			if !os.IsNotExist(err) || len(backups) != 0 {
+				t.Errorf("%v: expected the data directory removed (%v, backups %q)", policy, err, backups)
+			}
+		case CleanBackup:
+			if !os.IsNotExist(err) || len(backups) != 1 {
+				t.Errorf("%v: expected the data directory moved to the backup (%v, backups %q)", policy, err, backups)
+			}
+		case CleanKeep:
+			if err != nil || len(backups) != 0 {
+				t.Errorf("%v: expected the data directory kept (%v, backups %q)", policy, err, backups)
+			}
+		}
+	}
+}
+
+func TestIsolateSIGSTOP(t *testing.T) {
+	old := iptablesAvailable
+	iptablesAvailable = func() bool { return false }
+	defer func() { iptablesAvailable = old }()
+
+	c := newTestCluster(t, 1, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	if err := c.Isolate("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+	if st := waitProcessState(t, nd.PID, "T"); st != "T" {
+		t.Errorf("expected stopped process state, got %q", st)
+	}
+	if err := c.Unisolate("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+	if st := waitProcessState(t, nd.PID, "S"); st == "T" {
+		t.Errorf("expected running process state, got %q", st)
+	}
+}
+
+// waitProcessState waits until the process is in the state, and returns
+// the last state read from /proc.
+func waitProcessState(t *testing.T, pid int, want string) string {
+	var st string
+	for i := 0; i < 100; i++ {
+		b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			t.Skip(err)
+		}
+		// the state follows the command name in parentheses
+		fields := strings.Fields(string(b[strings.LastIndex(string(b), ")")+1:]))
+		st = fields[0]
+		if st == want {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return st
+}
+
+func TestHealth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, `{"health": "true"}`)
+	}))
+	defer ts.Close()
+
+	c := newTestCluster(t, 2, "sleep 10 #")
+	for _, nd := range c.nameToNode {
+		setClientURL(nd.(*NodeWebLocal).Flags, ts.URL)
+	}
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+	if err := c.Terminate("etcd2"); err != nil {
+		t.Fatal(err)
+	}
+
+	nameToHealth, err := c.Health()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nameToHealth["etcd1"] {
+		t.Error("expected etcd1 healthy")
+	}
+	if nameToHealth["etcd2"] {
+		t.Error("expected stopped etcd2 unhealthy")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		statuses map[string]ServerStatus
+		skew     bool
+	}{
+		{
+			map[string]ServerStatus{
+				"etcd1": {Version: "3.0.0", ClusterVersion: "3.0.0"},
+				"etcd2": {Version: "3.0.0", ClusterVersion: "3.0.0"},
+				"etcd3": {State: "unreachable"},
+			},
+			false,
+		},
+		{
+			map[string]ServerStatus{
+				"etcd1": {Version: "3.0.0"},
+				"etcd2": {Version: "3.1.0"},
+			},
+			true,
+		},
+		{
+			map[string]ServerStatus{
+				"etcd1": {Version: "3.1.0", ClusterVersion: "3.0.0"},
+				"etcd2": {Version: "3.1.0", ClusterVersion: "3.1.0"},
+			},
+			true,
+		},
+	}
+	for i, tt := range tests {
+		nameToVersion, skew := CompareVersions(tt.statuses)
+		if skew != tt.skew {
+			t.Errorf("#%d: skew expected %v, got %v", i, tt.skew, skew)
+		}
+		if _, ok := nameToVersion["etcd3"]; ok {
+			t.Errorf("#%d: unreachable node must be ignored (%v)", i, nameToVersion)
+		}
+	}
+	if v, _ := CompareVersions(tests[0].statuses); v["etcd1"] != "3.0.0 (cluster 3.0.0)" {
+		t.Errorf("unexpected version %q", v["etcd1"])
+	}
+}
+
+func TestWithColors(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #", WithColors([]string{"#000000", "#ffffff"}))
+	expected := map[string]string{"etcd1": "#000000", "etcd2": "#ffffff", "etcd3": "#000000"}
+	for name, cr := range expected {
+		if v := c.nameToNode[name].(*NodeWebLocal).color; v != cr {
+			t.Errorf("%s: expected color %s, got %s", name, cr, v)
+		}
+	}
+
+	for _, colors := range [][]string{{}, {"red"}, {"#000000", "#12345"}} {
+		df, err := GenerateFlags("etcd1", "", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = NewCluster(WebLocal, "sleep 10 #", []*Flags{df}, WithColors(colors)); err == nil {
+			t.Errorf("expected error for colors %q", colors)
+		}
+	}
+}
+
+// fakeAgent reports the state of etcd without connecting to an agent.
+type fakeAgent struct {
+	client.Agent
+	state string
+}
+
+func (a *fakeAgent) Status() (client.Status, error) {
+	return client.Status{State: a.state}, nil
+}
+
+func TestStatusRemoteUnreachable(t *testing.T) {
+	old := statusTimeout
+	statusTimeout = 300 * time.Millisecond
+	defer func() { statusTimeout = old }()
+
+	// 192.0.2.0/24 is reserved for documentation, and not routable
+	df, err := GenerateFlags("etcd1", "192.0.2.1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &defaultCluster{
+		nameToNode: map[string]Node{
+			"etcd1": &NodeWebRemoteClient{Flags: df, Agent: &fakeAgent{state: "started"}, active: true},
+		},
+	}
+
+	st := time.Now()
+	nameToStatus, err := c.Status()
+	if err == nil {
+		t.Error("expected error from unreachable node")
+	}
+	if took := time.Since(st); took > 3*time.Second {
+		t.Errorf("Status blocked for %v", took)
+	}
+	if s := nameToStatus["etcd1"]; s.State != "unreachable (agent: started)" || s.ID != emptyStat.ID {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestStatusConcurrency(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 10, WithStatusConcurrency(3))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	var (
+		mu            sync.Mutex
+		running, maxN int
+	)
+	for _, f := range fakes {
+		f.mu.Lock()
+		f.onStatus = func() {
+			mu.Lock()
+			running++
+			if running > maxN {
+				maxN = running
+			}
+			mu.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}
+		f.mu.Unlock()
+	}
+
+	nameToStatus, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nameToStatus) != 10 {
+		t.Errorf("expected 10 statuses, got %d", len(nameToStatus))
+	}
+	for name, s := range nameToStatus {
+		if s.ID == emptyStat.ID {
+			t.Errorf("%s: expected the status, got %+v", name, s)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxN > 3 {
+		t.Errorf("expected at most 3 concurrent status requests, got %d", maxN)
+	}
+}
+
+// TestStatusSevenNodes tests a cluster larger than the 5 Nodes of the UI,
+// whose Nodes must all report and log in distinct colors.
+func TestStatusSevenNodes(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 7)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	nameToStatus, err := c.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := make(map[string]string)
+	for i := 1; i <= 7; i++ {
+		name := fmt.Sprintf("etcd%d", i)
+		if s, ok := nameToStatus[name]; !ok || s.ID == emptyStat.ID || s.Name != name {
+			t.Errorf("%s: expected the status, got %+v", name, s)
+		}
+		color := c.nameToNode[name].(*NodeWebLocal).color
+		if other, ok := colors[color]; ok {
+			t.Errorf("%s has the color %s of %s", name, color, other)
+		}
+		colors[color] = name
+	}
+	if len(nameToStatus) != 7 {
+		t.Errorf("expected 7 statuses, got %d", len(nameToStatus))
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	old := statusTimeout
+	statusTimeout = 300 * time.Millisecond
+	defer func() { statusTimeout = old }()
+
+	c, fakes := newFakeEtcdCluster(t, 3)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	fakes[1].srv.Stop()
+	fakes[2].srv.Stop()
+
+	nameToStatus, err := c.Status()
+	if err == nil {
+		t.Fatal("expected error from unreachable nodes")
+	}
+	for _, name := range []string{"etcd2", "etcd3"} {
+		if !strings.Contains(err.Error(), name+" status") {
+			t.Errorf("expected the error of %s, got %v", name, err)
+		}
+		if s := nameToStatus[name]; s.State != emptyStat.State {
+			t.Errorf("%s: expected unreachable, got %+v", name, s)
+		}
+	}
+	if strings.Contains(err.Error(), "etcd1") {
+		t.Errorf("expected no error of etcd1, got %v", err)
+	}
+	if s := nameToStatus["etcd1"]; s.ID == emptyStat.ID {
+		t.Errorf("etcd1: expected the status, got %+v", s)
+	}
+}
+
+func TestSetRaftIndexBehind(t *testing.T) {
+	old := catchUpThreshold
+	catchUpThreshold = 1000
+	defer func() { catchUpThreshold = old }()
+
+	tests := []struct {
+		states  map[string]string
+		indexes map[string]uint64
+
+		behind     map[string]uint64
+		catchingUp map[string]bool
+	}{
+		{ // in sync
+			map[string]string{"etcd1": "Leader", "etcd2": "Follower", "etcd3": "Follower"},
+			map[string]uint64{"etcd1": 100, "etcd2": 100, "etcd3": 99},
+			map[string]uint64{"etcd1": 0, "etcd2": 0, "etcd3": 1},
+			map[string]bool{},
+		},
+		{ // etcd3 was reset
+			map[string]string{"etcd1": "Follower", "etcd2": "Leader", "etcd3": "Follower"},
+			map[string]uint64{"etcd1": 5000, "etcd2": 5001, "etcd3": 1},
+			map[string]uint64{"etcd1": 1, "etcd2": 0, "etcd3": 5000},
+			map[string]bool{"etcd3": true},
+		},
+		{ // exactly at the threshold
+			map[string]string{"etcd1": "Leader", "etcd2": "Follower"},
+			map[string]uint64{"etcd1": 1500, "etcd2": 500},
+			map[string]uint64{"etcd1": 0, "etcd2": 1000},
+			map[string]bool{},
+		},
+		{ // leader unreachable, compared to the most advanced Node
+			map[string]string{"etcd1": "unreachable", "etcd2": "Follower", "etcd3": "Follower"},
+			map[string]uint64{"etcd1": 0, "etcd2": 3000, "etcd3": 1500},
+			map[string]uint64{"etcd1": 0, "etcd2": 0, "etcd3": 1500},
+			map[string]bool{"etcd3": true},
+		},
+	}
+	for i, tt := range tests {
+		nameToStatus := make(map[string]ServerStatus)
+		for name, state := range tt.states {
+			nameToStatus[name] = ServerStatus{Name: name, State: state, RaftIndex: tt.indexes[name]}
+		}
+		setRaftIndexBehind(nameToStatus)
+		for name, stat := range nameToStatus {
+			if stat.RaftIndexBehind != tt.behind[name] {
+				t.Errorf("#%d: %s expected %d entries behind, got %d", i, name, tt.behind[name], stat.RaftIndexBehind)
+			}
+			if stat.CatchingUp != tt.catchingUp[name] {
+				t.Errorf("#%d: %s expected catching up %v, got %v", i, name, tt.catchingUp[name], stat.CatchingUp)
+			}
+		}
+	}
+}
+
+func TestProgramPathPerNode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-play-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := dir + "/etcd-new"
+	if err = ioutil.WriteFile(script, []byte("#!/bin/sh\ntouch "+dir+"/executed\nsleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := make([]*Flags, 2)
+	for i := range fs {
+		if fs[i], err = GenerateFlags(fmt.Sprintf("etcd%d", i+1), "", false); err != nil {
+			t.Fatal(err)
+		}
+		fs[i].DataDir = fmt.Sprintf("%s/etcd%d.etcd", dir, i+1)
+	}
+	fs[1].ProgramPath = script + " #"
+	c, err := NewCluster(WebLocal, "sleep 10 #", fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	for i := 0; i < 100; i++ {
+		if _, err = os.Stat(dir + "/executed"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("etcd2 did not exec %s (%v)", script, err)
+	}
+}
+
+func TestNodeEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-play-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := dir + "/etcd-env"
+	if err = ioutil.WriteFile(script, []byte("#!/bin/sh\necho \"$ETCD_PLAY_TEST $ETCD_PLAY_PARENT $GOMAXPROCS\" > "+dir+"/env\nsleep 10\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ETCD_PLAY_TEST", "parent")
+	t.Setenv("ETCD_PLAY_PARENT", "inherited")
+
+	c := newTestCluster(t, 1, script+" #")
+	c.nameToNode["etcd1"].(*NodeWebLocal).Flags.Env = map[string]string{"ETCD_PLAY_TEST": "overridden", "GOMAXPROCS": "1"}
+	if err = c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	var b []byte
+	for i := 0; i < 100; i++ {
+		if b, err = ioutil.ReadFile(dir + "/env"); err == nil && len(b) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := strings.TrimSpace(string(b)); got != "overridden inherited 1" {
+		t.Errorf("env = %q (%v), want %q", got, err, "overridden inherited 1")
+	}
+}
+
+func TestRollingRestart(t *testing.T) {
+	old := rollingPollInterval
+	rollingPollInterval = 10 * time.Millisecond
+	defer func() { rollingPollInterval = old }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, `{"health": "true"}`)
+	}))
+	defer ts.Close()
+
+	c := newTestCluster(t, 3, "sleep 10 #")
+	for _, nd := range c.nameToNode {
+		setClientURL(nd.(*NodeWebLocal).Flags, ts.URL)
+	}
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	pids := make(map[string]int)
+	for name, nd := range c.nameToNode {
+		pids[name] = nd.(*NodeWebLocal).PID
+	}
+	if err := c.RollingRestart(); err != nil {
+		t.Fatal(err)
+	}
+	for name, nd := range c.nameToNode {
+		if !nd.IsActive() || nd.(*NodeWebLocal).PID == pids[name] {
+			t.Errorf("%s was not restarted", name)
+		}
+	}
+}
+
+func TestRunScenario(t *testing.T) {
+	old := rollingPollInterval
+	rollingPollInterval = 10 * time.Millisecond
+	defer func() { rollingPollInterval = old }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, `{"health": "true"}`)
+	}))
+	defer ts.Close()
+
+	c := newTestCluster(t, 3, "sleep 10 #")
+	for _, nd := range c.nameToNode {
+		setClientURL(nd.(*NodeWebLocal).Flags, ts.URL)
+	}
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	if err := c.RunScenario("no-such-scenario"); !errors.Is(err, ErrScenarioNotFound) {
+		t.Fatalf("expected %v, got %v", ErrScenarioNotFound, err)
+	}
+
+	pids := make(map[string]int)
+	for name, nd := range c.nameToNode {
+		pids[name] = nd.(*NodeWebLocal).PID
+	}
+	if err := c.RunScenario("rolling-restart", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	for name, nd := range c.nameToNode {
+		if !nd.IsActive() || nd.(*NodeWebLocal).PID == pids[name] {
+			t.Errorf("%s was not restarted", name)
+		}
+	}
+	msgs := drainStream(c.Stream("user1"))
+	if len(msgs) < 2 || !strings.HasPrefix(msgs[0], "[SCENARIO rolling-restart] Started!") || !strings.HasPrefix(msgs[len(msgs)-1], "[SCENARIO rolling-restart] Done!") {
+		t.Errorf("expected the narration of the scenario, got %q", msgs)
+	}
+}
+
+func TestRunScenarioCancel(t *testing.T) {
+	old := scenarios
+	defer func() { scenarios = old }()
+	scenarios = append(scenarios, scenario{"pause", "pauses", func(ctx context.Context, r *scenarioRun) error {
+		return r.pause(ctx)
+	}})
+	oldPause := scenarioPause
+	scenarioPause = time.Minute
+	defer func() { scenarioPause = oldPause }()
+
+	c := newTestCluster(t, 1, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.RunScenario("pause") }()
+	var ops []OperationHandle
+	for i := 0; len(ops) == 0; i++ {
+		if i == 30 {
+			t.Fatal("the scenario is not listed")
+		}
+		time.Sleep(10 * time.Millisecond)
+		ops = c.ListOperations()
+	}
+	if ops[0].Type != "SCENARIO pause" {
+		t.Fatalf("expected the scenario, got %q", ops[0].Type)
+	}
+	if err := c.CancelOperation(ops[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the scenario was not canceled")
+	}
+}
+
+func TestLeaderKillCancelRestarts(t *testing.T) {
+	old := rollingPollInterval
+	rollingPollInterval = 10 * time.Millisecond
+	defer func() { rollingPollInterval = old }()
+
+	// the fakes keep reporting etcd1 as the leader, so no new one is elected
+	c, _ := newFakeEtcdCluster(t, 3)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.RunScenario("leader-kill-and-recover", "user1") }()
+	for i := 0; c.nameToNode["etcd1"].IsActive(); i++ {
+		if i == 300 {
+			t.Fatal("the leader was not killed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	ops := c.ListOperations()
+	if len(ops) != 1 {
+		t.Fatalf("expected the scenario, got %+v", ops)
+	}
+	if err := c.CancelOperation(ops[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the scenario was not canceled")
+	}
+	if !c.nameToNode["etcd1"].IsActive() {
+		t.Error("expected the killed leader to be restarted")
+	}
+
+	// the quota scenario does not fill a cluster without the space quota
+	if err := c.RunScenario("quota-exceeded", "user1"); !errors.Is(err, ErrNoQuota) {
+		t.Fatalf("expected %v, got %v", ErrNoQuota, err)
+	}
+}
+
+func TestRollingRestartQuorumGuard(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, `{"health": "true"}`)
+	}))
+	defer ts.Close()
+
+	c := newTestCluster(t, 3, "sleep 10 #")
+	// only etcd1 reports healthy, so restarting any node loses the quorum
+	setClientURL(c.nameToNode["etcd1"].(*NodeWebLocal).Flags, ts.URL)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	pids := make(map[string]int)
+	for name, nd := range c.nameToNode {
+		pids[name] = nd.(*NodeWebLocal).PID
+	}
+	if err := c.RollingRestart(); err == nil || !strings.Contains(err.Error(), "quorum") {
+		t.Fatalf("expected quorum error, got %v", err)
+	}
+	for name, nd := range c.nameToNode {
+		if !nd.IsActive() || nd.(*NodeWebLocal).PID != pids[name] {
+			t.Errorf("%s was restarted without the quorum", name)
+		}
+	}
+}
+
+func TestResetNode(t *testing.T) {
+	old := rollingPollInterval
+	rollingPollInterval = 10 * time.Millisecond
+	defer func() { rollingPollInterval = old }()
+
+	c, fakes := newFakeEtcdCluster(t, 3)
+	nd := c.nameToNode["etcd2"].(*NodeWebLocal)
+	snapDir := filepath.Join(nd.Flags.DataDir, "member", "snap")
+	snapPath := filepath.Join(snapDir, "0000000000000002-0000000000000010.snap")
+
+	var members []*pb.Member
+	for i, f := range fakes {
+		fs := c.nameToNode[fmt.Sprintf("etcd%d", i+1)].(*NodeWebLocal).Flags
+		members = append(members, &pb.Member{ID: f.id, Name: fs.Name, PeerURLs: strings.Split(mapToCommaString(fs.AdvertisePeerURLs), ","), ClientURLs: []string{"http://" + f.addr}})
+	}
+	fakes[0].mu.Lock()
+	fakes[0].members = members
+	// etcd2 is healthy once it has the snapshot
+	fakes[1].health = func() bool {
+		_, err := os.Stat(snapPath)
+		return err == nil
+	}
+	fakes[0].mu.Unlock()
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	if err := os.MkdirAll(snapDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(snapDir, "db"), []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	pid := nd.PID
+	c.mu.Unlock()
+
+	go func() {
+		// the snapshot from the leader arrives after the restart
+		for {
+			c.mu.Lock()
+			restarted := nd.active && nd.PID != pid
+			c.mu.Unlock()
+			if restarted {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		os.MkdirAll(snapDir, 0700)
+		ioutil.WriteFile(snapPath, nil, 0600)
+	}()
+
+	drainStream(c.SharedStream())
+	if err := c.ResetNode("etcd2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(snapDir, "db")); !os.IsNotExist(err) {
+		t.Errorf("expected the stale data to be removed, got %v", err)
+	}
+	if s := nd.Flags.InitialClusterState; s != "existing" {
+		t.Errorf("expected to restart with the existing cluster, got %q", s)
+	}
+
+	// the member of etcd2 is replaced by a new one with the same peer URLs
+	fakes[0].mu.Lock()
+	got := fakes[0].members
+	fakes[0].mu.Unlock()
+	if len(got) != 3 || got[0].ID != 1 || got[1].ID != 3 || got[2].ID != 4 {
+		t.Fatalf("expected the members 1, 3 and 4, got %+v", got)
+	}
+	if !reflect.DeepEqual(got[2].PeerURLs, members[1].PeerURLs) {
+		t.Errorf("expected the peer URLs %q, got %q", members[1].PeerURLs, got[2].PeerURLs)
+	}
+
+	msgs := strings.Join(drainStream(c.SharedStream()), "\n")
+	for _, want := range []string{
+		"[RESET] Removed member 2 of etcd2",
+		"[RESET] Added member 4 of etcd2",
+		"[RESET] Removing the data of etcd2",
+		"[RESET] Restarted etcd2 with empty data",
+		"[RESET] etcd2 has snapshot at index 16",
+		"[RESET] etcd2 rejoined the cluster",
+	} {
+		i := strings.Index(msgs, want)
+		if i < 0 {
+			t.Errorf("expected %q in %q", want, msgs)
+			continue
+		}
+		msgs = msgs[i:]
+	}
+}
+
+func TestResetNodeKeepsDataOnMemberError(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 3)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// the fakes list no member, so the member of etcd2 cannot be replaced
+	nd := c.nameToNode["etcd2"].(*NodeWebLocal)
+	if err := os.MkdirAll(nd.Flags.DataDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	dbPath := filepath.Join(nd.Flags.DataDir, "db")
+	if err := ioutil.WriteFile(dbPath, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ResetNode("etcd2"); err == nil || !strings.Contains(err.Error(), "not a member") {
+		t.Fatalf("expected member error, got %v", err)
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		t.Errorf("expected the data to be kept, got %v", err)
+	}
+}
+
+func TestResetNodeQuorumGuard(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintln(w, `{"health": "true"}`)
+	}))
+	defer ts.Close()
+
+	c := newTestCluster(t, 3, "sleep 10 #")
+	// etcd3 is down, so wiping etcd1 leaves only etcd2
+	setClientURL(c.nameToNode["etcd1"].(*NodeWebLocal).Flags, ts.URL)
+	setClientURL(c.nameToNode["etcd2"].(*NodeWebLocal).Flags, ts.URL)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	pid := c.nameToNode["etcd1"].(*NodeWebLocal).PID
+	if err := c.ResetNode("etcd1"); err == nil || !strings.Contains(err.Error(), "quorum") {
+		t.Fatalf("expected quorum error, got %v", err)
+	}
+	if nd := c.nameToNode["etcd1"]; !nd.IsActive() || nd.(*NodeWebLocal).PID != pid {
+		t.Error("etcd1 was reset without the quorum")
+	}
+}
+
+func TestWriteStreams(t *testing.T) {
+	old := iptablesAvailable
+	iptablesAvailable = func() bool { return false }
+	defer func() { iptablesAvailable = old }()
+
+	c := newTestCluster(t, 1, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	drainStream(c.SharedStream())
+
+	if err := c.Isolate("etcd1", "user1", "user2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"user1", "user2"} {
+		select {
+		case msg := <-c.Stream(id):
+			if !strings.Contains(msg, "[ISOLATE]") {
+				t.Errorf("%s: unexpected message %q", id, msg)
+			}
+		default:
+			t.Errorf("%s: no message", id)
+		}
+	}
+	if msgs := drainStream(c.SharedStream()); strings.Contains(strings.Join(msgs, ""), "[ISOLATE]") {
+		t.Errorf("expected no cluster message in the shared stream, got %q", msgs)
+	}
+
+	if err := c.Unisolate("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := drainStream(c.SharedStream()); !strings.Contains(strings.Join(msgs, ""), "[ISOLATE]") {
+		t.Errorf("expected cluster message in the shared stream, got %q", msgs)
+	}
+}
+
+func TestImpairmentRequestingStream(t *testing.T) {
+	old := iptablesAvailable
+	iptablesAvailable = func() bool { return false }
+	defer func() { iptablesAvailable = old }()
+
+	c := newTestCluster(t, 1, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	drainStream(c.SharedStream())
+	other := c.Stream("user2")
+	if err := c.Isolate("etcd1", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unisolate("etcd1", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := drainStream(c.Stream("user1")); len(msgs) != 2 || !strings.Contains(msgs[0], "[ISOLATE]") || !strings.Contains(msgs[1], "[ISOLATE]") {
+		t.Errorf("expected the messages of isolate and unisolate, got %q", msgs)
+	}
+	if msgs := drainStream(other); len(msgs) != 0 {
+		t.Errorf("expected no message in the stream of the other user, got %q", msgs)
+	}
+	if msgs := drainStream(c.SharedStream()); strings.Contains(strings.Join(msgs, ""), "[ISOLATE]") {
+		t.Errorf("expected no message in the shared stream, got %q", msgs)
+	}
+}
+
+func TestStressStream(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	drainStream(c.SharedStream())
+	if _, err := c.Stress("etcd1", 5, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	if !strings.Contains(msgs, "[STRESS PUT") || !strings.Contains(msgs, "[STRESS] Done!") {
+		t.Errorf("expected the stress lines in the stream, got %q", msgs)
+	}
+	if msgs := drainStream(c.SharedStream()); strings.Contains(strings.Join(msgs, ""), "[STRESS") {
+		t.Errorf("expected no stress line in the shared stream, got %q", msgs)
+	}
+}
+
+func TestCloseStream(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #")
+
+	ch := c.Stream("user1")
+	c.CloseStream("user1")
+	if _, ok := <-ch; ok {
+		t.Fatal("expected the stream to be closed")
+	}
+	c.CloseStream("user1") // no-op on unknown stream
+
+	// writes racing with CloseStream must not send on a closed stream
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				c.Write("etcd1", "[PUT] Success!", "user1", "user2")
+			}
+		}()
+	}
+	for j := 0; j < 200; j++ {
+		c.CloseStream("user1")
+	}
+	wg.Wait()
+
+	c.CloseStream("user1")
+	c.CloseStream("user2")
+	c.mu.Lock()
+	n := len(c.idToStream)
+	c.mu.Unlock()
+	if n != 0 {
+		t.Errorf("expected no stream left, got %d", n)
+	}
+}
+
+func TestResumeStream(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #")
+
+	if err := c.ResumeStream("old", "new"); !errors.Is(err, ErrStreamNotFound) {
+		t.Fatalf("expected %v, got %v", ErrStreamNotFound, err)
+	}
+
+	// the operation started before the reconnect writes to the old stream
+	c.Write("etcd1", "[STRESS] Started!", "old")
+	reconnected := c.Stream("new")
+	c.Write("etcd1", "[LOGIN] Success!", "new")
+	if err := c.ResumeStream("old", "new"); err != nil {
+		t.Fatal(err)
+	}
+	// its messages moved to the resumed stream
+	if _, ok := <-reconnected; ok {
+		t.Fatal("expected the replaced stream to be closed")
+	}
+	c.Write("etcd1", "[STRESS] Done!", "old")
+
+	ch := c.Stream("new")
+	if got, want := drainStream(ch), []string{"[STRESS] Started!", "[LOGIN] Success!", "[STRESS] Done!"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// the stream stays open until both stream IDs are closed
+	c.CloseStream("old")
+	c.Write("etcd1", "[PUT] Success!", "new")
+	if msg, ok := <-ch; !ok || msg != "[PUT] Success!" {
+		t.Fatalf("expected the stream open after closing the old ID, got %q (%v)", msg, ok)
+	}
+	c.CloseStream("new")
+	if _, ok := <-ch; ok {
+		t.Fatal("expected the stream to be closed")
+	}
+}
+
+// drainStream returns the messages buffered in the stream.
+func drainStream(ch chan string) []string {
+	var msgs []string
+	for {
+		select {
+		case msg := <-ch:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestShutdownDrainsOperations(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := c.begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdownc := make(chan struct{})
+	go func() {
+		c.Shutdown()
+		close(shutdownc)
+	}()
+
+	select {
+	case <-shutdownc:
+		t.Fatal("Shutdown returned with an in-flight operation")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !c.nameToNode["etcd1"].IsActive() {
+		t.Error("etcd1 was terminated with an in-flight operation")
+	}
+	if _, _, err = c.Get("etcd1", "foo", false); !errors.Is(err, ErrClusterShutdown) {
+		t.Errorf("expected ErrClusterShutdown, got %v", err)
+	}
+	if _, err = c.Stress("etcd1", 1); !errors.Is(err, ErrClusterShutdown) {
+		t.Errorf("expected ErrClusterShutdown, got %v", err)
+	}
+
+	done()
+	select {
+	case <-shutdownc:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Shutdown did not return after the operation is done")
+	}
+	if c.nameToNode["etcd1"].IsActive() {
+		t.Error("etcd1 is still active after Shutdown")
+	}
+}
+
+func TestShutdownCancelsWatch(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel, err := c.ObserveLeader("/my-service", "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	for i := 0; fakes[0].numWatchers() == 0; i++ {
+		if i == 30 {
+			t.Fatal("the observer does not watch")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// the hash history would hold Shutdown until the drain timeout
+	hashc := make(chan error, 1)
+	go func() {
+		_, err := c.HashHistory("etcd1", 100, time.Second)
+		hashc <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	if err := c.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-hashc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Shutdown did not cancel the hash history")
+	}
+	for i := 0; fakes[0].numWatchers() != 0; i++ {
+		if i == 30 {
+			t.Fatal("Shutdown did not cancel the watch")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	if evs, err := c.History(context.Background(), "etcd1", "foo/", 1, 100); err != nil || len(evs) != 0 {
+		t.Fatalf("expected no event in a new cluster, got %v (%v)", evs, err)
+	}
+	for _, kv := range [][2]string{{"foo/a", "1"}, {"bar", "x"}, {"foo/b", "2"}, {"foo/a", "3"}} {
+		if _, err := c.Put("etcd1", kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := c.Delete("etcd1", "foo/b", false); err != nil {
+		t.Fatal(err)
+	}
+
+	describe := func(evs []WatchEvent) []string {
+		ss := []string{}
+		for _, ev := range evs {
+			ss = append(ss, fmt.Sprintf("%d %s %s %s", ev.ModRevision, ev.Type, ev.Key, ev.Value))
+		}
+		return ss
+	}
+	drainStream(c.Stream("user1"))
+	evs, err := c.History(context.Background(), "etcd1", "foo/", 1, 100, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"2 PUT foo/a 1", "4 PUT foo/b 2", "5 PUT foo/a 3", "6 DELETE foo/b "}
+	if got := describe(evs); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+	if msgs := drainStream(c.Stream("user1")); len(msgs) != len(expected)+2 || !strings.Contains(msgs[1], "[HISTORY] 2 PUT") {
+		t.Errorf("expected the events streamed in order, got %q", msgs)
+	}
+
+	// replays from the compact revision
+	fakes[0].compact(4)
+	evs, err = c.History(context.Background(), "etcd1", "foo/", 1, 100, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describe(evs); !reflect.DeepEqual(got, expected[1:]) {
+		t.Errorf("expected %q, got %q", expected[1:], got)
+	}
+	if msgs := drainStream(c.Stream("user1")); !strings.Contains(strings.Join(msgs, ""), "Revisions before 4 are compacted") {
+		t.Errorf("expected the compaction noted, got %q", msgs)
+	}
+
+	// stops at the maximum number of events
+	evs, err = c.History(context.Background(), "etcd1", "foo/", 1, 2, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := describe(evs); !reflect.DeepEqual(got, expected[1:3]) {
+		t.Errorf("expected %q, got %q", expected[1:3], got)
+	}
+	if msgs := drainStream(c.Stream("user1")); !strings.Contains(strings.Join(msgs, ""), "Stopped at the limit of 2 events at revision 5") {
+		t.Errorf("expected the limit noted, got %q", msgs)
+	}
+	if _, err := c.History(context.Background(), "etcd1", "foo/", 1, 0); err == nil {
+		t.Error("expected error for no maximum number of events")
+	}
+}
+
+func TestRunSTM(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	increment := func(stm STM) error {
+		n, _ := strconv.Atoi(stm.Get("counter"))
+		stm.Put("counter", strconv.Itoa(n+1))
+		return nil
+	}
+	counter := func() (v string) {
+		if err := c.RunSTM("etcd1", func(stm STM) error {
+			v = stm.Get("counter")
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// the inner transaction commits between the read and the commit of the
+	// outer one, which has to retry with the new value
+	calls := 0
+	drainStream(c.Stream("user1"))
+	err := c.RunSTM("etcd1", func(stm STM) error {
+		calls++
+		n, _ := strconv.Atoi(stm.Get("counter"))
+		if calls == 1 {
+			if err := c.RunSTM("etcd1", increment); err != nil {
+				return err
+			}
+		}
+		stm.Put("counter", strconv.Itoa(n+1))
+		return nil
+	}, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 1 retry, got %d calls", calls)
+	}
+	if v := counter(); v != "2" {
+		t.Errorf("expected counter 2, got %q", v)
+	}
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	if !strings.Contains(msgs, "Conflict!") || !strings.Contains(msgs, "after 1 retries") {
+		t.Errorf("expected the retry streamed, got %q", msgs)
+	}
+
+	// an error from fn writes nothing
+	errAbort := errors.New("abort")
+	if err := c.RunSTM("etcd1", func(stm STM) error {
+		stm.Put("counter", "100")
+		return errAbort
+	}); err != errAbort {
+		t.Fatalf("expected %v, got %v", errAbort, err)
+	}
+	if v := counter(); v != "2" {
+		t.Errorf("expected counter 2, got %q", v)
+	}
+}
+
+func TestWatchPutNoLeak(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 3)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 3; i++ {
+		if _, err := c.WatchPut("etcd1", "foo", fmt.Sprintf("bar%d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the fake servers tear down the watch streams asynchronously
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		open := fakes[0].numWatchers()
+		after := runtime.NumGoroutine()
+		if open == 0 && after <= before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("leaked %d watchers, goroutines %d -> %d", open, before, after)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPutRetry(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1, WithRetryPolicy(RetryPolicy{MaxRetries: 3, Backoff: 10 * time.Millisecond}))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// the election fails the first two attempts with no leader
+	fakes[0].mu.Lock()
+	fakes[0].noLeaderPuts = 2
+	fakes[0].failKeys = map[string]struct{}{"bad": {}}
+	fakes[0].mu.Unlock()
+	if _, err := c.Put("etcd1", "foo", "bar", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	for _, want := range []string{"[PUT] Retry 1/3 in 10ms", "[PUT] Retry 2/3 in 20ms"} {
+		if !strings.Contains(msgs, want) {
+			t.Errorf("expected %q, got %q", want, msgs)
+		}
+	}
+	if strings.Contains(msgs, "Retry 3/3") {
+		t.Errorf("expected no third retry, got %q", msgs)
+	}
+
+	// the errors other than unavailable are not retried
+	if _, err := c.Put("etcd1", "bad", "bar", "user1"); err == nil {
+		t.Fatal("expected error from the failed key")
+	}
+	if msgs := strings.Join(drainStream(c.Stream("user1")), "\n"); strings.Contains(msgs, "Retry") {
+		t.Errorf("expected no retry, got %q", msgs)
+	}
+}
+
+func TestPutNoRetryByDefault(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	fakes[0].mu.Lock()
+	fakes[0].noLeaderPuts = 1
+	fakes[0].mu.Unlock()
+	if _, err := c.Put("etcd1", "foo", "bar", "user1"); err == nil || !retryable(err) {
+		t.Fatalf("expected the unavailable error, got %v", err)
+	}
+	if msgs := strings.Join(drainStream(c.Stream("user1")), "\n"); strings.Contains(msgs, "Retry") {
+		t.Errorf("expected no retry, got %q", msgs)
+	}
+}
+
+func TestBalancedPutFailover(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 3, WithBalancedEndpoints(), WithDialTimeout(500*time.Millisecond))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	var members []*pb.Member
+	for i, f := range fakes {
+		members = append(members, &pb.Member{ID: f.id, Name: fmt.Sprintf("etcd%d", i+1), ClientURLs: []string{"http://" + f.addr}})
+	}
+	for _, f := range fakes {
+		f.members = members
+	}
+
+	// etcd1 dies, but is still active until its process exits, so the
+	// round robin targets it
+	fakes[0].srv.Stop()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Put("", "foo", "bar", "user1"); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+	msgs := drainStream(c.Stream("user1"))
+	served := 0
+	for _, msg := range msgs {
+		if !strings.Contains(msg, "[PUT] Served by") {
+			continue
+		}
+		served++
+		if !strings.Contains(msg, "Served by etcd2") && !strings.Contains(msg, "Served by etcd3") {
+			t.Errorf("expected served by a live node, got %q", msg)
+		}
+	}
+	if served != 3 {
+		t.Errorf("expected 3 served puts, got %d in %q", served, msgs)
+	}
+
+	// the named Node is still targeted alone
+	if _, err := c.Put("etcd1", "foo", "bar", "user1"); err == nil {
+		t.Fatal("expected error from the dead etcd1")
+	}
+}
+
+func TestDeadlineErr(t *testing.T) {
+	tests := []struct {
+		err     error
+		timeout bool
+	}{
+		{context.DeadlineExceeded, true},
+		{grpc.Errorf(codes.DeadlineExceeded, "context deadline exceeded"), true},
+		{grpc.Errorf(codes.Unavailable, "unavailable"), false},
+		{errors.New("etcdserver: request timed out"), false},
+	}
+	for i, tt := range tests {
+		err := deadlineErr(tt.err, 3*time.Second)
+		if errors.Is(err, ErrTimeout) != tt.timeout {
+			t.Errorf("#%d: expected timeout %v, got %v", i, tt.timeout, err)
+		}
+		if tt.timeout && err.Error() != "exceeded the deadline of 3s (timed out)" {
+			t.Errorf("#%d: unexpected message %q", i, err)
+		}
+		if !tt.timeout && err != tt.err {
+			t.Errorf("#%d: expected %v as is, got %v", i, tt.err, err)
+		}
+	}
+}
+
+func TestGetDeadline(t *testing.T) {
+	old := requestTimeout
+	requestTimeout = 100 * time.Millisecond
+	defer func() { requestTimeout = old }()
+
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	stopc := make(chan struct{})
+	defer close(stopc)
+	fakes[0].mu.Lock()
+	fakes[0].onRange = func() {
+		select {
+		case <-stopc:
+		case <-time.After(time.Second):
+		}
+	}
+	fakes[0].mu.Unlock()
+
+	if _, _, err := c.Get("etcd1", "foo", false, "user1"); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+	msgs := drainStream(c.Stream("user1"))
+	if len(msgs) != 2 || !strings.Contains(msgs[0], "deadline 100ms") || !strings.HasPrefix(msgs[1], "[GET] Timed out! exceeded the deadline of 100ms") {
+		t.Errorf("expected the deadline in the stream, got %q", msgs)
+	}
+}
+
+func TestWatchPutStream(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 3)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	drainStream(c.SharedStream())
+	if _, err := c.WatchPut("etcd1", "foo", "bar", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	// the event of each watcher reaches the stream, not only the summary
+	events := 0
+	for _, msg := range drainStream(c.Stream("user1")) {
+		if strings.HasPrefix(msg, "[WATCH] PUT") {
+			events++
+		}
+	}
+	if events != 3 {
+		t.Errorf("expected 3 watch events in the stream, got %d", events)
+	}
+	for _, msg := range drainStream(c.SharedStream()) {
+		if strings.HasPrefix(msg, "[WATCH]") {
+			t.Errorf("unexpected %q in the shared stream", msg)
+		}
+	}
+}
+
+func TestWatchPutTimeout(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 2)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	old := watchPutTimeout
+	watchPutTimeout = 500 * time.Millisecond
+	defer func() { watchPutTimeout = old }()
+
+	// etcd2 never answers
+	fakes[1].srv.Stop()
+	if _, err := c.WatchPut("etcd1", "foo", "bar"); err == nil {
+		t.Fatal("expected error from unreachable watcher")
+	}
+	if n := fakes[0].numWatchers(); n != 0 {
+		time.Sleep(time.Second)
+		if n = fakes[0].numWatchers(); n != 0 {
+			t.Fatalf("%d watchers still open on etcd1", n)
+		}
+	}
+}
+
+func TestDiscoverEndpoints(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 2)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// etcd3 joined out of band, and is unknown to the cluster
+	fakes[0].members = []*pb.Member{
+		{ID: 1, Name: "etcd1", ClientURLs: []string{"http://" + fakes[0].addr}},
+		{ID: 3, Name: "etcd3", ClientURLs: []string{"http://10.0.0.3:2379", "bad url %"}},
+	}
+	want := []string{"10.0.0.3:2379", fakes[0].addr, fakes[1].addr}
+	sort.Strings(want)
+
+	for _, seed := range []string{"etcd1", fakes[0].addr} {
+		eps, err := c.DiscoverEndpoints(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(eps, want) {
+			t.Errorf("seed %q: endpoints = %v, want %v", seed, eps, want)
+		}
+	}
+}
+
+func TestSyncMembers(t *testing.T) {
+	old := statusTimeout
+	statusTimeout = 300 * time.Millisecond
+	defer func() { statusTimeout = old }()
+
+	c, fakes := newFakeEtcdCluster(t, 2)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// etcd3 was added out of band, and the unstarted member has no client
+	// URL to ask
+	fakes[0].members = []*pb.Member{
+		{ID: 1, Name: "etcd1", ClientURLs: []string{"http://" + fakes[0].addr}},
+		{ID: 2, Name: "etcd2", ClientURLs: []string{"http://" + fakes[1].addr}},
+		{ID: 3, Name: "etcd3", ClientURLs: []string{"http://127.0.0.1:1"}},
+		{ID: 4},
+	}
+	if err := c.syncMembers("etcd1", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if eps := c.memberEndpoints(); !reflect.DeepEqual(eps, map[string]string{"etcd3": "127.0.0.1:1"}) {
+		t.Fatalf("members = %v, want etcd3 only", eps)
+	}
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	if !strings.Contains(msgs, "[MEMBERS] Found etcd3") {
+		t.Errorf("expected etcd3 found, got %q", msgs)
+	}
+
+	nameToStatus, _ := c.Status()
+	if s, ok := nameToStatus["etcd3"]; !ok || s.State != emptyStat.State || s.Endpoint != "127.0.0.1:1" {
+		t.Errorf("etcd3: expected unreachable placeholder, got %+v (%v)", s, ok)
+	}
+
+	fakes[0].members = fakes[0].members[:2]
+	if err := c.syncMembers("etcd1", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if eps := c.memberEndpoints(); len(eps) != 0 {
+		t.Fatalf("members = %v, want none", eps)
+	}
+	msgs = strings.Join(drainStream(c.Stream("user1")), "\n")
+	if !strings.Contains(msgs, "[MEMBERS] etcd3 (127.0.0.1:1) was removed") {
+		t.Errorf("expected etcd3 removed, got %q", msgs)
+	}
+	if nameToStatus, _ := c.Status(); len(nameToStatus) != 2 {
+		t.Errorf("expected the status of the 2 nodes, got %v", nameToStatus)
+	}
+}
+
+func TestRawEndpoint(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 2)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// a member that is not a Node of the cluster
+	other, otherFakes := newFakeEtcdCluster(t, 1)
+	if err := other.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Shutdown()
+
+	// the endpoints not of a member are not dialed
+	if _, err := c.Put(otherFakes[0].addr, "foo", "bar"); !errors.Is(err, ErrNodeNotFound) {
+		t.Fatalf("expected %v, got %v", ErrNodeNotFound, err)
+	}
+	fakes[0].mu.Lock()
+	fakes[0].members = []*pb.Member{{ID: 9, Name: "other", ClientURLs: []string{"http://" + otherFakes[0].addr}}}
+	fakes[0].mu.Unlock()
+	if _, err := c.DiscoverEndpoints("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+
+	st := time.Now()
+	if _, err := c.Put(otherFakes[0].addr, "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	otherFakes[0].mu.Lock()
+	_, ok := otherFakes[0].kvs["foo"]
+	otherFakes[0].mu.Unlock()
+	if !ok {
+		t.Errorf("expected foo on %s", otherFakes[0].addr)
+	}
+	fakes[0].mu.Lock()
+	_, ok = fakes[0].kvs["foo"]
+	fakes[0].mu.Unlock()
+	if ok {
+		t.Errorf("expected no foo on %s", fakes[0].addr)
+	}
+	if evs := c.Events(st); len(evs) == 0 || evs[0].Node != otherFakes[0].addr {
+		t.Errorf("expected the events of %s, got %+v", otherFakes[0].addr, evs)
+	}
+
+	// the endpoint of a Node resolves to the Node
+	st = time.Now()
+	if _, _, err := c.Get("http://"+fakes[1].addr, "foo", false); err != nil {
+		t.Fatal(err)
+	}
+	if evs := c.Events(st); len(evs) == 0 || evs[0].Node != "etcd2" {
+		t.Errorf("expected the events of etcd2, got %+v", evs)
+	}
+
+	if _, err := c.Put("etcd9", "foo", "bar"); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("expected %v, got %v", ErrNodeNotFound, err)
+	}
+}
+
+// chaosActions runs the chaos rounds, and returns the [CHAOS] messages.
+func chaosActions(t *testing.T, c *defaultCluster, cfg ChaosConfig) []string {
+	if err := c.Chaos(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, msg := range drainStream(c.SharedStream()) {
+		if strings.HasPrefix(msg, "[CHAOS]") {
+			actions = append(actions, msg)
+		}
+	}
+	return actions
+}
+
+func TestCancelOperation(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Chaos(context.Background(), ChaosConfig{Interval: 10 * time.Millisecond}, "user1")
+	}()
+
+	var ops []OperationHandle
+	for i := 0; i < 100 && len(ops) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		ops = c.ListOperations()
+	}
+	if len(ops) != 1 || ops[0].Type != "CHAOS" || !reflect.DeepEqual(ops[0].StreamIDs, []string{"user1"}) {
+		t.Fatalf("expected the chaos registered for user1, got %+v", ops)
+	}
+	if err := c.CancelOperation(ops[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("chaos did not stop after canceled")
+	}
+
+	if ops = c.ListOperations(); len(ops) != 0 {
+		t.Errorf("expected no operations after completed, got %+v", ops)
+	}
+	if err := c.CancelOperation(1); !errors.Is(err, ErrOperationNotFound) {
+		t.Errorf("expected %v, got %v", ErrOperationNotFound, err)
+	}
+}
+
+func TestChaosSeed(t *testing.T) {
+	cfg := ChaosConfig{KillProbability: 0.7, Rounds: 6, Seed: 42}
+
+	var runs [2][]string
+	for i := range runs {
+		c := newTestCluster(t, 3, "sleep 10 #")
+		if err := c.Bootstrap(); err != nil {
+			t.Fatal(err)
+		}
+		runs[i] = chaosActions(t, c, cfg)
+		for name, nd := range c.nameToNode {
+			if !nd.IsActive() {
+				t.Errorf("%s is not restarted after chaos", name)
+			}
+		}
+		c.Shutdown()
+	}
+	if len(runs[0]) == 0 {
+		t.Fatal("chaos took no action")
+	}
+	if !reflect.DeepEqual(runs[0], runs[1]) {
+		t.Fatalf("same seed, different actions:\n%q\n%q", runs[0], runs[1])
+	}
+}
+
+func TestChaosShutdown(t *testing.T) {
+	old := rollingPollInterval
+	rollingPollInterval = 10 * time.Millisecond
+	defer func() { rollingPollInterval = old }()
+
+	c := newTestCluster(t, 3, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	// the terminated node cannot restart for an hour
+	for _, nd := range c.nameToNode {
+		nd.(*NodeWebLocal).limitInterval = time.Hour
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.Chaos(context.Background(), ChaosConfig{KillProbability: 1, Rounds: 1, Seed: 1}) }()
+	for i := 0; ; i++ {
+		if i == 300 {
+			t.Fatal("chaos terminated no node")
+		}
+		active := 0
+		for _, nd := range c.nodes() {
+			if nd.IsActive() {
+				active++
+			}
+		}
+		if active < 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	st := time.Now()
+	c.Shutdown()
+	if took := time.Since(st); took >= shutdownDrainTimeout {
+		t.Errorf("shutdown waited %v for the restart of chaos", took)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("expected chaos to stop on shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("chaos did not return")
+	}
+}
+
+func TestChaosQuorumGuard(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// with etcd1 down, terminating another node loses the quorum
+	if err := c.Terminate("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+	actions := chaosActions(t, c, ChaosConfig{KillProbability: 1, Rounds: 10, Seed: 7})
+	if len(actions) != 10 {
+		t.Fatalf("expected 10 actions, got %q", actions)
+	}
+	for _, a := range actions {
+		if !strings.HasPrefix(a, "[CHAOS] Skipping") {
+			t.Errorf("unexpected action %q", a)
+		}
+	}
+	for _, name := range []string{"etcd2", "etcd3"} {
+		if !c.nameToNode[name].IsActive() {
+			t.Errorf("%s was terminated", name)
+		}
+	}
+}
+
+// TestStressConcurrent runs two stresses at once, which must not share the
+// random state under -race, and checks that the seed decides the keys.
+func TestStressConcurrent(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 2)
+	c.stressSeed = 42
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	var wg sync.WaitGroup
+	errc := make(chan error, 2)
+	for _, name := range []string{"etcd1", "etcd2"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			_, err := c.Stress(name, 5)
+			errc <- err
+		}(name)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys := multiRandBytes(rand.New(rand.NewSource(42)), 5, 5)
+	fakes[0].mu.Lock()
+	defer fakes[0].mu.Unlock()
+	if len(fakes[0].kvs) != 5 {
+		t.Fatalf("expected the same 5 keys from both stresses, got %d", len(fakes[0].kvs))
+	}
+	for i, k := range keys {
+		if _, ok := fakes[0].kvs[fmt.Sprintf("foo_%d_%s", i, k)]; !ok {
+			t.Errorf("key %d %q was not written", i, k)
+		}
+	}
+}
+
+func TestStressReadWrite(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	cfg := StressConfig{ReadPercent: 80}
+	if _, err := c.StressWithConfig("etcd1", 20, cfg, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	reads, writes := 0, 0
+	for _, msg := range drainStream(c.Stream("user1")) {
+		switch {
+		case strings.HasPrefix(msg, "[STRESS GET"):
+			reads++
+			// reads only get the keys already written
+			if strings.HasSuffix(msg, `: ""`) {
+				t.Errorf("read a missing key %q", msg)
+			}
+		case strings.HasPrefix(msg, "[STRESS PUT"):
+			writes++
+		}
+	}
+	if reads != 16 || writes != 4 {
+		t.Errorf("expected 16 reads and 4 writes, got %d and %d", reads, writes)
+	}
+	fakes[0].mu.Lock()
+	defer fakes[0].mu.Unlock()
+	if len(fakes[0].kvs) != 4 {
+		t.Errorf("expected 4 keys written, got %d", len(fakes[0].kvs))
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	tests := []struct {
+		expected, newValue string
+		swapped            bool
+		value              string
+		msg                string
+	}{
+		// create-if-absent
+		{"", "bar", true, "bar", `"foo" : "bar" (created)`},
+		{"", "baz", false, "bar", `not created, it already is "bar"`},
+		// success and mismatch
+		{"bar", "baz", true, "baz", `swapped "bar" to "baz"`},
+		{"bar", "qux", false, "baz", `expected "bar" but it is "baz"`},
+	}
+	for i, tt := range tests {
+		swapped, err := c.CompareAndSwap("etcd1", "foo", tt.expected, tt.newValue, "user1")
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if swapped != tt.swapped {
+			t.Errorf("#%d: expected swapped %v, got %v", i, tt.swapped, swapped)
+		}
+		fakes[0].mu.Lock()
+		value := string(fakes[0].kvs["foo"].Value)
+		fakes[0].mu.Unlock()
+		if value != tt.value {
+			t.Errorf("#%d: expected %q, got %q", i, tt.value, value)
+		}
+		found := false
+		for _, msg := range drainStream(c.Stream("user1")) {
+			if strings.HasPrefix(msg, "[CAS]") && strings.Contains(msg, tt.msg) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("#%d: expected [CAS] message with %q", i, tt.msg)
+		}
+	}
+
+	swapped, err := c.CompareAndSwap("etcd1", "missing", "bar", "baz", "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swapped {
+		t.Error("expected a missing key not swapped")
+	}
+	for _, msg := range drainStream(c.Stream("user1")) {
+		if strings.HasPrefix(msg, "[CAS] \"missing\"") && !strings.Contains(msg, "it does not exist") {
+			t.Errorf("unexpected message %q", msg)
+		}
+	}
+}
+
+func TestCompareAndSwapRetry(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1, WithRetryPolicy(RetryPolicy{MaxRetries: 3, Backoff: 10 * time.Millisecond}))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// the election fails the first attempt with no leader
+	fakes[0].mu.Lock()
+	fakes[0].noLeaderTxns = 1
+	fakes[0].mu.Unlock()
+	swapped, err := c.CompareAndSwap("etcd1", "foo", "", "bar", "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !swapped {
+		t.Error("expected the key to be created")
+	}
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	if !strings.Contains(msgs, "[CAS] Started! deadline "+requestTimeout.String()) || !strings.Contains(msgs, "[CAS] Retry 1/3 in 10ms") {
+		t.Errorf("unexpected messages %q", msgs)
+	}
+}
+
+func TestCompareAndDelete(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	if _, err := c.Put("etcd1", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key, expected string
+		deleted       bool
+		msg           string
+	}{
+		{"foo", "baz", false, `not deleted, expected "baz" but it is "bar"`},
+		{"foo", "bar", true, `deleted "bar"`},
+		// absent keys are not reported as a mismatch
+		{"foo", "bar", false, "nothing to delete"},
+		{"missing", "bar", false, "nothing to delete"},
+	}
+	for i, tt := range tests {
+		deleted, err := c.CompareAndDelete("etcd1", tt.key, tt.expected, "user1")
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if deleted != tt.deleted {
+			t.Errorf("#%d: expected deleted %v, got %v", i, tt.deleted, deleted)
+		}
+		found := false
+		for _, msg := range drainStream(c.Stream("user1")) {
+			if strings.HasPrefix(msg, "[CAD]") && strings.Contains(msg, tt.msg) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("#%d: expected [CAD] message with %q", i, tt.msg)
+		}
+	}
+	fakes[0].mu.Lock()
+	defer fakes[0].mu.Unlock()
+	if _, ok := fakes[0].kvs["foo"]; ok {
+		t.Error("expected foo deleted")
+	}
+}
+
+func TestDefragment(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 3)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// overwrite puts 10 revisions of 103 bytes, and compacts all but the
+	// latest, so that Defragment shrinks the DB to 103 bytes
+	overwrite := func() {
+		for i := 0; i < 10; i++ {
+			if _, err := c.Put("etcd1", "foo", strings.Repeat("x", 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		fakes[0].mu.Lock()
+		rev := fakes[0].rev
+		fakes[0].mu.Unlock()
+		fakes[0].compact(rev)
+	}
+	dbSize := func(name string) uint64 {
+		_, nameToEndpoint, _ := c.Endpoints()
+		size, err := c.memberDBSize(nameToEndpoint[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return size
+	}
+
+	overwrite()
+	if size := dbSize("etcd2"); size != 1030 {
+		t.Fatalf("expected the DB size 1030 before the defragmentation, got %d", size)
+	}
+	if err := c.Defragment("etcd2", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if size := dbSize("etcd2"); size != 103 {
+		t.Errorf("expected the DB size 103 after the defragmentation, got %d", size)
+	}
+	msgs := drainStream(c.Stream("user1"))
+	_, nameToEndpoint, _ := c.Endpoints()
+	if len(msgs) != 2 || !strings.Contains(msgs[1], nameToEndpoint["etcd2"]) || !strings.Contains(msgs[1], "[DEFRAG] Done! DB size 1.0 kB -&gt; 103 B") {
+		t.Errorf("unexpected messages %q", msgs)
+	}
+
+	// the fake members share the DB, which only the first one shrinks
+	overwrite()
+	if err := c.Defragment("", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"etcd1", "etcd2", "etcd3"} {
+		if size := dbSize(name); size != 103 {
+			t.Errorf("%s: expected the DB size 103 after the defragmentation, got %d", name, size)
+		}
+	}
+	var done []string
+	for _, msg := range drainStream(c.Stream("user1")) {
+		if strings.Contains(msg, "[DEFRAG] Done!") {
+			done = append(done, msg)
+		}
+	}
+	if len(done) != 3 || !strings.Contains(done[0], "1.1 kB -&gt; 103 B") {
+		t.Errorf("expected a defragmentation of each node, got %q", done)
+	}
+
+	for _, name := range []string{"etcd1", "etcd2", "etcd3"} {
+		if err := c.Terminate(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Defragment(""); !errors.Is(err, ErrNoActiveNodes) {
+		t.Errorf("expected ErrNoActiveNodes, got %v", err)
+	}
+}
+
+func TestReclaimSpace(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// the overwrites grow the DB by 10 revisions of 103 bytes, and only
+	// the latest revision is retained after the compaction
+	for i := 0; i < 10; i++ {
+		if _, err := c.Put("etcd1", "foo", strings.Repeat("x", 100), "user1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drainStream(c.Stream("user1"))
+
+	if err := c.ReclaimSpace("", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	// the streams are HTML escaped
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	for _, want := range []string{
+		"[RECLAIM] Started! DB size 1.0 kB",
+		"[RECLAIM] Compacted the revisions before 11",
+		"[RECLAIM] DB size 1.0 kB after the compaction",
+		"[DEFRAG] Done! DB size 1.0 kB -&gt; 103 B",
+		"[RECLAIM] Done! DB size 1.0 kB -&gt; 1.0 kB after the compaction -&gt; 103 B after the defragmentation, freed 927 B",
+	} {
+		if !strings.Contains(msgs, want) {
+			t.Errorf("expected %q, got %q", want, msgs)
+		}
+	}
+}
+
+func TestQuotaAlarm(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	fakes[0].mu.Lock()
+	fakes[0].quota = 64 * 1024
+	fakes[0].mu.Unlock()
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// without the space quota, the fill would not stop short of the disk
+	if err := c.FillUntilQuota(context.Background(), "etcd1", "user1"); !errors.Is(err, ErrNoQuota) {
+		t.Fatalf("expected %v, got %v", ErrNoQuota, err)
+	}
+	c.nameToNode["etcd1"].(*NodeWebLocal).Flags.QuotaBackendBytes = 64 * 1024
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.FillUntilQuota(ctx, "etcd1", "user1"); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+
+	if err := c.FillUntilQuota(context.Background(), "etcd1", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put("etcd1", "foo", "bar"); !errors.Is(err, rpctypes.ErrNoSpace) {
+		t.Fatalf("expected %v, got %v", rpctypes.ErrNoSpace, err)
+	}
+	alarms, err := c.AlarmList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []Alarm{{MemberID: "1", Type: "NOSPACE"}}; !reflect.DeepEqual(alarms, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, alarms)
+	}
+
+	// the space is freed by deleting the keys and compacting them, and the
+	// alarm stays until disarmed
+	if _, _, err := c.Delete("etcd1", "quota_", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReclaimSpace("etcd1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put("etcd1", "foo", "bar"); !errors.Is(err, rpctypes.ErrNoSpace) {
+		t.Fatalf("expected %v before the disarm, got %v", rpctypes.ErrNoSpace, err)
+	}
+	if err := c.AlarmDisarm("user1"); err != nil {
+		t.Fatal(err)
+	}
+	if alarms, err = c.AlarmList(); err != nil || len(alarms) != 0 {
+		t.Fatalf("expected no alarm, got %+v, %v", alarms, err)
+	}
+	if _, err := c.Put("etcd1", "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	for _, want := range []string{"[QUOTA] Database space exceeded after writing 6 keys", "[ALARM] member 1: NOSPACE", "[ALARM] Disarmed 1 alarm(s)!"} {
+		if !strings.Contains(msgs, want) {
+			t.Errorf("expected %q, got %q", want, msgs)
+		}
+	}
+}
+
+func TestHashHistory(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	fakes[0].mu.Lock()
+	fakes[0].hashes = []uint32{10, 20, 30, 30}
+	fakes[0].mu.Unlock()
+
+	hashes, err := c.HashHistory("etcd1", 4, time.Millisecond, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hashes, []int{10, 20, 30, 30}) {
+		t.Errorf("expected [10 20 30 30], got %v", hashes)
+	}
+	n := 0
+	for _, msg := range drainStream(c.Stream("user1")) {
+		if strings.HasPrefix(msg, "[HASH]") {
+			n++
+		}
+	}
+	if n != 5 {
+		t.Errorf("expected 5 [HASH] messages, got %d", n)
+	}
+
+	if _, err := c.HashHistory("etcd1", 0, time.Millisecond); err == nil {
+		t.Error("expected an error for no samples")
+	}
+}
+
+func TestHashHistoryCancel(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	st := time.Now()
+	hashes, err := c.HashHistoryContext(ctx, "etcd1", 100, 50*time.Millisecond)
+	if err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	if len(hashes) == 0 || len(hashes) >= 100 {
+		t.Errorf("expected the samples before canceled, got %d", len(hashes))
+	}
+	if took := time.Since(st); took > 2*time.Second {
+		t.Errorf("expected to stop soon after canceled, took %v", took)
+	}
+}
+
+func TestPutWithPrevKV(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	prev, _, err := c.PutWithPrevKV("etcd1", "foo", "bar", "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != nil {
+		t.Errorf("expected no previous key-value of the created key, got %+v", prev)
+	}
+	kvs, _, err := c.GetWithRevisions("etcd1", "foo", false)
+	if err != nil || len(kvs) != 1 {
+		t.Fatalf("expected the key foo, got %+v (%v)", kvs, err)
+	}
+
+	if prev, _, err = c.PutWithPrevKV("etcd1", "foo", "baz", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if prev == nil || prev.Key != "foo" || prev.Value != "bar" || prev.ModRevision != kvs[0].ModRevision {
+		t.Errorf("expected the previous value bar at revision %d, got %+v", kvs[0].ModRevision, prev)
+	}
+
+	msgs := strings.Join(drainStream(c.Stream("user1")), "\n")
+	if !strings.Contains(msgs, `[PUT] "foo" : "bar" (created)`) || !strings.Contains(msgs, `[PUT] "foo" : was "bar" now "baz"`) {
+		t.Errorf("unexpected messages %q", msgs)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	for _, key := range []string{"/a/1", "/a/2", "/b"} {
+		if _, err := c.Put("etcd1", key, "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, _, err := c.Delete("etcd1", "/a/", true, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 deleted keys, got %d", n)
+	}
+	if vs, _, err := c.Get("etcd1", "/a/", true); err != nil || len(vs) != 0 {
+		t.Errorf("expected no keys with the prefix /a/, got %q (%v)", vs, err)
+	}
+	if vs, _, err := c.Get("etcd1", "/b", false); err != nil || len(vs) != 1 {
+		t.Errorf("expected the key /b to survive, got %q (%v)", vs, err)
+	}
+	drainStream(c.Stream("user1"))
+
+	// the empty key without prefix fails before any request
+	if _, _, err = c.Delete("etcd1", "", false, "user1"); err == nil {
+		t.Error("expected the error of the empty key")
+	}
+	if msgs := drainStream(c.Stream("user1")); len(msgs) != 0 {
+		t.Errorf("expected no request, got %q", msgs)
+	}
+	if vs, _, err := c.Get("etcd1", "/b", false); err != nil || len(vs) != 1 {
+		t.Errorf("expected the key /b to survive, got %q (%v)", vs, err)
+	}
+}
+
+func TestPutGetBytes(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	value := []byte{0xff, 0x00, '<', 0xfe}
+	if _, err := c.PutBytes("etcd1", "blob", value, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := c.GetBytes("etcd1", "blob", "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("expected %x, got %x", value, got)
+	}
+	for _, msg := range drainStream(c.Stream("user1")) {
+		if strings.Contains(msg, "<") || !utf8.ValidString(msg) {
+			t.Errorf("unsafe message %q", msg)
+		}
+		if strings.HasPrefix(msg, "[PUT] \"blob\"") || strings.HasPrefix(msg, "[GET] \"blob\"") {
+			if !strings.Contains(msg, "0xff003cfe (4 bytes)") {
+				t.Errorf("expected the value in hex, got %q", msg)
+			}
+		}
+	}
+
+	if got, _, err = c.GetBytes("etcd1", "missing"); err != nil || got != nil {
+		t.Errorf("expected nil for a missing key, got %v (%v)", got, err)
+	}
+}
+
+func TestRenderValueMessages(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	value := string([]byte{0xff, 0x00, 0xfe})
+	if _, err := c.CompareAndSwap("etcd1", "blob", "", value, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CompareAndSwap("etcd1", "blob", "other", "x", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.CompareAndDelete("etcd1", "blob", value, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.WatchPut("etcd1", "blob", value, "user1"); err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, msg := range drainStream(c.Stream("user1")) {
+		if !utf8.ValidString(msg) {
+			t.Errorf("unsafe message %q", msg)
+		}
+		for _, op := range []string{"[CAS]", "[CAD]", "[WATCH] PUT"} {
+			if strings.HasPrefix(msg, op+" ") && strings.Contains(msg, "0xff00fe (3 bytes)") {
+				found[op] = true
+			}
+		}
+	}
+	for _, op := range []string{"[CAS]", "[CAD]", "[WATCH] PUT"} {
+		if !found[op] {
+			t.Errorf("expected the value in hex in the %s messages", op)
+		}
+	}
+}
+
+func TestWriteEscapesHTML(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	if _, err := c.Put("etcd1", "<b>key</b>", "<script>alert(1)</script>", "user1"); err != nil {
+		t.Fatal(err)
+	}
+	msgs := drainStream(c.Stream("user1"))
+	found := false
+	for _, msg := range msgs {
+		if strings.Contains(msg, "<") {
+			t.Errorf("unescaped message %q", msg)
+		}
+		if strings.Contains(msg, `&lt;b&gt;key&lt;/b&gt;`) && strings.Contains(msg, `&lt;script&gt;alert(1)&lt;/script&gt;`) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected the escaped key and value, got %q", msgs)
+	}
+}
+
+func TestPutBatch(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	kvs := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		kvs[fmt.Sprintf("batch_%03d", i)] = fmt.Sprintf("value_%d", i)
+	}
+	drainStream(c.SharedStream())
+	if err := c.PutBatch("etcd1", kvs); err != nil {
+		t.Fatal(err)
+	}
+	fakes[0].mu.Lock()
+	for k, v := range kvs {
+		if kv, ok := fakes[0].kvs[k]; !ok || string(kv.Value) != v {
+			t.Errorf("%q is not put", k)
+		}
+	}
+	fakes[0].failKeys = map[string]struct{}{"batch_007": {}, "batch_042": {}}
+	fakes[0].mu.Unlock()
+
+	msgs := drainStream(c.SharedStream())
+	if len(msgs) != 2 {
+		t.Errorf("expected started and done summary, got %q", msgs)
+	}
+
+	err := c.PutBatch("etcd1", kvs)
+	if err == nil || !strings.Contains(err.Error(), "put 98 of 100 keys") {
+		t.Fatalf("expected partial failure, got %v", err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	for _, k := range []string{"b", "a", "c"} {
+		if _, err := c.Put("etcd1", k, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	drainStream(c.SharedStream())
+
+	kvs, rev, err := c.Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 3 || kvs[0].Key != "a" || kvs[2].Key != "c" || kvs[0].Value != "" {
+		t.Errorf("expected the keys sorted without values, got %+v", kvs)
+	}
+	if rev != kvs[2].ModRevision {
+		t.Errorf("expected revision %d, got %d", kvs[2].ModRevision, rev)
+	}
+	if msgs := drainStream(c.SharedStream()); len(msgs) != 0 {
+		t.Errorf("expected no message, got %q", msgs)
+	}
+}
+
+func TestDeleteUnmodified(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	for _, k := range []string{"a", "b"} {
+		if _, err := c.Put("etcd1", k, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kvs, _, err := c.Keys("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "a" is written again after the listing, so it is kept
+	if _, err := c.Put("etcd1", "a", "again"); err != nil {
+		t.Fatal(err)
+	}
+	drainStream(c.SharedStream())
+
+	n, err := c.DeleteUnmodified("", kvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 deleted key, got %d", n)
+	}
+	if msgs := drainStream(c.SharedStream()); len(msgs) != 0 {
+		t.Errorf("expected no message, got %q", msgs)
+	}
+	if vs, _, err := c.Get("etcd1", "", true); err != nil || !reflect.DeepEqual(vs, []string{"again"}) {
+		t.Errorf("expected only the key written again, got %q (%v)", vs, err)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1, WithSizeLimits(4, 8))
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// at the limits
+	if _, err := c.Put("etcd1", "abcd", "12345678"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutBatch("etcd1", map[string]string{"efgh": "12345678"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, put := range []func() error{
+		func() error { _, err := c.Put("etcd1", "abcde", "1"); return err },
+		func() error { _, err := c.Put("etcd1", "a", "123456789"); return err },
+		func() error { _, err := c.PutBytes("etcd1", "a", make([]byte, 9)); return err },
+		func() error { _, err := c.CompareAndSwap("etcd1", "abcd", "12345678", "123456789"); return err },
+		func() error { return c.PutBatch("etcd1", map[string]string{"b": "1", "c": "123456789"}) },
+		func() error {
+			_, err := c.StressWithConfig("etcd1", 1, StressConfig{ValueSize: 9})
+			return err
+		},
+	} {
+		if err := put(); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("#%d: expected %v, got %v", i, ErrTooLarge, err)
+		}
+	}
+
+	fakes[0].mu.Lock()
+	defer fakes[0].mu.Unlock()
+	for _, k := range []string{"abcde", "a", "b", "c"} {
+		if _, ok := fakes[0].kvs[k]; ok {
+			t.Errorf("%q is put over the size limit", k)
+		}
+	}
+	if v := string(fakes[0].kvs["abcd"].Value); v != "12345678" {
+		t.Errorf("expected the value at the limit, got %q", v)
+	}
+}
+
+func benchmarkPut(b *testing.B, batch bool) {
+	c, _ := newFakeEtcdCluster(b, 1)
+	if err := c.Bootstrap(); err != nil {
+		b.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	kvs := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		kvs[fmt.Sprintf("bench_%03d", i)] = "value"
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if batch {
+			if err := c.PutBatch("etcd1", kvs); err != nil {
+				b.Fatal(err)
+			}
+		} else {
+			for k, v := range kvs {
+				if _, err := c.Put("etcd1", k, v); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+		drainStream(c.SharedStream())
+	}
+}
+
+func BenchmarkPutBatch(b *testing.B)      { benchmarkPut(b, true) }
+func BenchmarkPutSequential(b *testing.B) { benchmarkPut(b, false) }
+
+func TestWatchPutContextCanceled(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 2)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.WatchPutContext(ctx, "etcd1", "foo", "bar"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := fakes[0].numWatchers(); n != 0 {
+		t.Fatalf("%d watchers still open", n)
+	}
+}
+
+func TestObserveLeader(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 1)
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	stream := c.Stream("user1")
+	waitMsg := func(want string) {
+		timeout := time.After(3 * time.Second)
+		for {
+			select {
+			case msg := <-stream:
+				if strings.Contains(msg, want) {
+					return
+				}
+				if strings.Contains(msg, "[OBSERVE]") {
+					t.Fatalf("expected %q, got %q", want, msg)
+				}
+			case <-timeout:
+				t.Fatalf("timed out waiting for %q", want)
+			}
+		}
+	}
+
+	cancel, err := c.ObserveLeader("/my-service", "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitMsg(`"/my-service" has no leader`)
+	for fakes[0].numWatchers() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// two campaigners, and the first one leads until it resigns
+	if _, err := c.Put("etcd1", "/my-service/alice", "alice:8080"); err != nil {
+		t.Fatal(err)
+	}
+	waitMsg(`"/my-service" leader is "alice:8080"`)
+	if _, err := c.Put("etcd1", "/my-service/bob", "bob:8080"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Delete("etcd1", "/my-service/alice", false); err != nil {
+		t.Fatal(err)
+	}
+	waitMsg(`"/my-service" leader is "bob:8080"`)
+
+	cancel()
+	waitMsg(`[OBSERVE] Stopped observing "/my-service"`)
+	cancel() // no-op
+	time.Sleep(100 * time.Millisecond)
+	if n := fakes[0].numWatchers(); n != 0 {
+		t.Fatalf("%d watchers still open", n)
+	}
+}
+
+func TestNoActiveNodes(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	defer c.Shutdown()
+
+	tests := map[string]func() error{
+		"put": func() error {
+			_, err := c.Put("etcd1", "foo", "bar")
+			return err
+		},
+		"get": func() error {
+			_, _, err := c.Get("etcd1", "foo", false)
+			return err
+		},
+		"delete": func() error {
+			_, _, err := c.Delete("etcd1", "foo", false)
+			return err
+		},
+		"stress": func() error {
+			_, err := c.Stress("etcd1", 10)
+			return err
+		},
+		"watch put": func() error {
+			_, err := c.WatchPut("etcd1", "foo", "bar")
+			return err
+		},
+	}
+	for op, fn := range tests {
+		st := time.Now()
+		if err := fn(); !errors.Is(err, ErrNoActiveNodes) {
+			t.Errorf("%s: expected %v, got %v", op, ErrNoActiveNodes, err)
+		}
+		if took := time.Since(st); took > time.Second {
+			t.Errorf("%s: took %v to fail, expected not to dial", op, took)
+		}
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	dialTimeout := 300 * time.Millisecond
+	c := newTestCluster(t, 1, "sleep 10 #", WithDialTimeout(dialTimeout))
+	defer c.Shutdown()
+	nd := c.nameToNode["etcd1"].(*NodeWebLocal)
+	setClientURL(nd.Flags, "http://"+addr)
+	if err := nd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	st := time.Now()
+	if _, err := c.Put("etcd1", "foo", "bar"); err == nil {
+		t.Fatal("expected error dialing an unreachable endpoint")
+	}
+	if took := time.Since(st); took < dialTimeout || took > defaultDialTimeout {
+		t.Fatalf("expected Put to fail after the dial timeout %v, took %v", dialTimeout, took)
+	}
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	defer c.Shutdown()
+	for _, name := range []string{"etcd1", "etcd3"} {
+		if err := c.Start(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		name, _, err := c.pick("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[name]++
+	}
+	if counts["etcd2"] != 0 {
+		t.Errorf("picked inactive etcd2 %d times", counts["etcd2"])
+	}
+	if counts["etcd1"] != 50 || counts["etcd3"] != 50 {
+		t.Errorf("expected balanced picks, got %v", counts)
+	}
+}
+
+func TestGetSerializableFromFollower(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	defer c.Shutdown()
+
+	// etcd2 is partitioned from the majority, so it misses the update
+	majority, minority := newFakeStore(), newFakeStore()
+	fakes := make([]*fakeEtcd, 3)
+	for i := range fakes {
+		store := majority
+		if i == 1 {
+			store = minority
+		}
+		fakes[i] = newFakeEtcd(t, uint64(i+1), store)
+		nd := c.nameToNode[fmt.Sprintf("etcd%d", i+1)].(*NodeWebLocal)
+		setClientURL(nd.Flags, "http://"+fakes[i].addr)
+		if err := nd.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Put("etcd2", "foo", "old"); err != nil {
+		t.Fatal(err)
+	}
+	fakes[1].mu.Lock()
+	fakes[1].partitioned = true
+	fakes[1].mu.Unlock()
+	if _, err := c.Put("etcd1", "foo", "new"); err != nil {
+		t.Fatal(err)
+	}
+	drainStream(c.SharedStream())
+
+	if _, _, err := c.Get("etcd2", "foo", false); err == nil {
+		t.Fatal("expected linearizable read from the partitioned follower to fail")
+	}
+	vs, _, err := c.GetSerializable("etcd2", "foo", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vs, []string{"old"}) {
+		t.Fatalf("expected stale value [old], got %v", vs)
+	}
+	if lines := drainStream(c.SharedStream()); !strings.Contains(strings.Join(lines, "\n"), "from follower etcd2") {
+		t.Fatalf("expected the read to be labeled as from follower etcd2, got %q", lines)
+	}
+}
+
+func TestNodesConcurrentMembership(t *testing.T) {
+	c, fakes := newFakeEtcdCluster(t, 3)
+	defer c.Shutdown()
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+
+	// add and remove a member pointing at etcd1, as MemberAdd and
+	// MemberRemove would, while reading the cluster
+	f, err := GenerateFlags("etcd9", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setClientURL(f, "http://"+fakes[0].addr)
+	added := &NodeWebLocal{pmu: &c.mu, Flags: f, sharedStream: c.sharedStream, pdropped: &c.dropped}
+
+	stopc, donec := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(donec)
+		for {
+			select {
+			case <-stopc:
+				return
+			default:
+			}
+			c.mu.Lock()
+			if _, ok := c.nameToNode["etcd9"]; ok {
+				delete(c.nameToNode, "etcd9")
+			} else {
+				c.nameToNode["etcd9"] = added
+			}
+			c.mu.Unlock()
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		if _, err := c.Status(); err != nil {
+			t.Fatal(err)
+		}
+		c.Endpoints()
+		c.Health()
+	}
+	close(stopc)
+	<-donec
+}
+
+func TestGetWithRevisions(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 1)
+	defer c.Shutdown()
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+
+	// revisions start at 1, so the puts are at revisions 2, 3, 4 and 5
+	for _, kv := range [][2]string{{"foo", "v1"}, {"bar", "v1"}, {"foo", "v2"}, {"foo", "v3"}} {
+		if _, err := c.Put("etcd1", kv[0], kv[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kvs, _, err := c.GetWithRevisions("etcd1", "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []KeyValue{
+		{Key: "bar", Value: "v1", CreateRevision: 3, ModRevision: 3, Version: 1},
+		{Key: "foo", Value: "v3", CreateRevision: 2, ModRevision: 5, Version: 3},
+	}
+	if !reflect.DeepEqual(kvs, want) {
+		t.Fatalf("expected %+v, got %+v", want, kvs)
+	}
+
+	// Get keeps returning the values only
+	drainStream(c.SharedStream())
+	vs, _, err := c.Get("etcd1", "foo", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vs, []string{"v3"}) {
+		t.Fatalf("expected [v3], got %v", vs)
+	}
+	if lines := drainStream(c.SharedStream()); !strings.Contains(strings.Join(lines, "\n"), `"foo" : "v3" (v3, created@2, modified@5)`) {
+		t.Fatalf("expected the revisions in the stream, got %q", lines)
+	}
+}
+
+func TestGetNodes(t *testing.T) {
+	c := newTestCluster(t, 2, "sleep 10 #")
+	defer c.Shutdown()
+
+	// each node has its own store, as if they were partitioned
+	fakes := make([]*fakeEtcd, 2)
+	for i := range fakes {
+		fakes[i] = newFakeEtcd(t, uint64(i+1), newFakeStore())
+		nd := c.nameToNode[fmt.Sprintf("etcd%d", i+1)].(*NodeWebLocal)
+		setClientURL(nd.Flags, "http://"+fakes[i].addr)
+	}
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put("etcd1", "foo", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put("etcd2", "foo", "old"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := c.GetNodes(nil, "foo", false, true)
+	if len(rs) != 2 {
+		t.Fatalf("expected results from 2 nodes, got %v", rs)
+	}
+	for name, want := range map[string]string{"etcd1": "new", "etcd2": "old"} {
+		if rs[name].Err != nil {
+			t.Fatalf("%s: %v", name, rs[name].Err)
+		}
+		if !reflect.DeepEqual(rs[name].Values, []string{want}) {
+			t.Errorf("%s: expected [%s], got %v", name, want, rs[name].Values)
+		}
+	}
+
+	// after healing, the values converge
+	if _, err := c.Put("etcd2", "foo", "new"); err != nil {
+		t.Fatal(err)
+	}
+	rs = c.GetNodes([]string{"etcd1", "etcd2", "etcd9"}, "foo", false, false)
+	if !reflect.DeepEqual(rs["etcd1"].Values, rs["etcd2"].Values) {
+		t.Errorf("expected the values to converge, got %v and %v", rs["etcd1"].Values, rs["etcd2"].Values)
+	}
+	if !errors.Is(rs["etcd9"].Err, ErrNodeNotFound) {
+		t.Errorf("expected %v for etcd9, got %v", ErrNodeNotFound, rs["etcd9"].Err)
+	}
+}
+
+func TestPutCompare(t *testing.T) {
+	c, _ := newFakeEtcdCluster(t, 3)
+	defer c.Shutdown()
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	drainStream(c.SharedStream())
+
+	nameToTook, err := c.PutCompare("foo", "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nameToTook) != 3 {
+		t.Fatalf("expected all 3 endpoints measured, got %v", nameToTook)
+	}
+	for name, took := range nameToTook {
+		if took <= 0 {
+			t.Errorf("%s: expected positive latency, got %v", name, took)
+		}
+		vs, _, err := c.Get(name, "foo_"+name, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(vs, []string{"bar"}) {
+			t.Errorf("%s: expected its own key, got %v", name, vs)
+		}
+	}
+
+	var ranked []string
+	for _, line := range drainStream(c.SharedStream()) {
+		if strings.Contains(line, "[PUT COMPARE]") {
+			ranked = append(ranked, line)
+		}
+	}
+	if len(ranked) != 3 || !strings.Contains(strings.Join(ranked, "\n"), "etcd1 (leader)") {
+		t.Fatalf("expected a ranked line per node with the leader labeled, got %q", ranked)
+	}
+}
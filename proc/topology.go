@@ -0,0 +1,118 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+)
+
+// Topology is the configuration of a cluster saved by SaveTopology.
+type Topology struct {
+	NodeType NodeType
+	Nodes    []TopologyNode
+}
+
+// TopologyNode is the flags and state of a Node in Topology.
+type TopologyNode struct {
+	Flags  Flags
+	Active bool
+}
+
+func (c *defaultCluster) SaveTopology(path string) error {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.nameToNode))
+	for name := range c.nameToNode {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tp := Topology{Nodes: make([]TopologyNode, len(names))}
+	nds := make([]Node, len(names))
+	for i, name := range names {
+		nds[i] = c.nameToNode[name]
+		// copy the flags under the lock, as Restart updates them
+		switch nd := nds[i].(type) {
+		case *NodeWebLocal:
+			tp.NodeType = WebLocal
+			tp.Nodes[i].Flags = *nd.Flags
+			tp.Nodes[i].Flags.ProgramPath = nd.ProgramPath
+		case *NodeWebRemoteClient:
+			tp.NodeType = WebRemote
+			tp.Nodes[i].Flags = *nd.Flags
+		default:
+			c.mu.Unlock()
+			return fmt.Errorf("%v does not implement SaveTopology", reflect.TypeOf(nd))
+		}
+	}
+	c.mu.Unlock()
+
+	for i, nd := range nds {
+		tp.Nodes[i].Active = nd.IsActive()
+	}
+
+	b, err := json.MarshalIndent(tp, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// LoadTopology creates a cluster from the file saved by SaveTopology, and
+// starts the Nodes that were active. It keeps the saved initial cluster
+// flags, so that the Nodes rejoin the same cluster with their data
+// directories. Remote clusters need WithAgentEndpoints.
+func LoadTopology(path string, opts ...OpOption) (Cluster, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tp Topology
+	if err := json.Unmarshal(b, &tp); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(tp.Nodes) == 0 {
+		return nil, fmt.Errorf("%s: no nodes found", path)
+	}
+
+	fs := make([]*Flags, len(tp.Nodes))
+	for i := range tp.Nodes {
+		f := tp.Nodes[i].Flags
+		fs[i] = &f
+	}
+	c, err := newCluster(tp.NodeType, "", fs, false, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	var started []string
+	for _, n := range tp.Nodes {
+		if !n.Active {
+			continue
+		}
+		if err := c.Start(n.Flags.Name); err != nil {
+			// terminate without cleaning, to keep the data directories
+			for _, name := range started {
+				c.Terminate(name)
+			}
+			return nil, err
+		}
+		started = append(started, n.Flags.Name)
+	}
+	return c, nil
+}
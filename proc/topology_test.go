@@ -0,0 +1,105 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestTopologyRoundTrip(t *testing.T) {
+	c := newTestCluster(t, 3, "sleep 10 #")
+	if err := c.Bootstrap(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Shutdown()
+
+	// partially down, and etcd3 restarted as an existing member
+	if err := c.Terminate("etcd2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Terminate("etcd3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Restart("etcd3"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "etcd-play-topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "topology.json")
+	if err := c.SaveTopology(path); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"etcd1", "etcd3"} {
+		if err := c.Terminate(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lc, err := LoadTopology(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := lc.(*defaultCluster)
+	defer l.Shutdown()
+
+	expectedActive := map[string]bool{"etcd1": true, "etcd2": false, "etcd3": true}
+	for name, nd := range c.nameToNode {
+		ln, ok := l.nameToNode[name].(*NodeWebLocal)
+		if !ok {
+			t.Fatalf("%s is not restored", name)
+		}
+		if ln.IsActive() != expectedActive[name] {
+			t.Errorf("%s: active = %v, want %v", name, ln.IsActive(), expectedActive[name])
+		}
+		// the cluster program path is saved per node
+		want := *nd.(*NodeWebLocal).Flags
+		want.ProgramPath = "sleep 10 #"
+		if !reflect.DeepEqual(*ln.Flags, want) {
+			t.Errorf("%s: flags = %+v, want %+v", name, *ln.Flags, want)
+		}
+		if ln.ProgramPath != "sleep 10 #" {
+			t.Errorf("%s: program path = %q", name, ln.ProgramPath)
+		}
+	}
+	if s := l.nameToNode["etcd3"].(*NodeWebLocal).Flags.InitialClusterState; s != "existing" {
+		t.Errorf("etcd3 initial cluster state = %q, want existing", s)
+	}
+}
+
+func TestLoadTopologyError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcd-play-topology")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, content := range []string{"{", `{"Nodes": []}`} {
+		path := filepath.Join(dir, "topology.json")
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTopology(path); err == nil {
+			t.Errorf("expected error loading %q", content)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "fmt"
+
+// BootstrapState is the progress of a Node in Bootstrap.
+type BootstrapState int
+
+const (
+	// NodeStarting is sent before the Node process starts.
+	NodeStarting BootstrapState = iota
+
+	// NodeStarted is sent once the Node process started.
+	NodeStarted
+
+	// NodeReady is sent once the Node is active. With WithStartProbe, it
+	// means that the Node answers the Status RPC.
+	NodeReady
+
+	// NodeFailed is sent when the Node fails to start.
+	NodeFailed
+)
+
+func (s BootstrapState) String() string {
+	switch s {
+	case NodeStarting:
+		return "starting"
+	case NodeStarted:
+		return "started"
+	case NodeReady:
+		return "ready"
+	case NodeFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("BootstrapState(%d)", int(s))
+	}
+}
+
+// BootstrapEvent reports the progress of a Node in Bootstrap.
+type BootstrapEvent struct {
+	Name  string
+	State BootstrapState
+	Err   error // why the Node failed, if NodeFailed
+}
+
+func (ev BootstrapEvent) String() string {
+	if ev.Err != nil {
+		return fmt.Sprintf("%s %s (%v)", ev.Name, ev.State, ev.Err)
+	}
+	return fmt.Sprintf("%s %s", ev.Name, ev.State)
+}
+
+// startNotifier is a Node that reports when its process started, before
+// the Node becomes active.
+type startNotifier interface {
+	startNotify(started func()) error
+}
@@ -0,0 +1,160 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CleanPolicy decides what Clean does with the data directory of a local
+// Node.
+type CleanPolicy int
+
+const (
+	// CleanRemove removes the data directory.
+	CleanRemove CleanPolicy = iota
+
+	// CleanBackup moves the data directory to a timestamped backup
+	// directory, to inspect it after a crash.
+	CleanBackup
+
+	// CleanKeep leaves the data directory as it is.
+	CleanKeep
+)
+
+func (p CleanPolicy) String() string {
+	switch p {
+	case CleanRemove:
+		return "remove"
+	case CleanBackup:
+		return "backup"
+	case CleanKeep:
+		return "keep"
+	default:
+		return fmt.Sprintf("CleanPolicy(%d)", int(p))
+	}
+}
+
+// ParseCleanPolicy parses "remove", "backup" or "keep".
+func ParseCleanPolicy(s string) (CleanPolicy, error) {
+	for _, p := range []CleanPolicy{CleanRemove, CleanBackup, CleanKeep} {
+		if p.String() == s {
+			return p, nil
+		}
+	}
+	return CleanRemove, fmt.Errorf("unknown clean policy %q", s)
+}
+
+// backupDataDir moves the data directory into backupDir, or next to the
+// data directory if backupDir is empty, and returns the backup path. The
+// backup is named after the data directory and the time, such as
+// 'etcd1.etcd.20160102-150405.000'.
+func backupDataDir(dataDir, backupDir string, now time.Time) (string, error) {
+	if _, err := os.Stat(dataDir); err != nil {
+		return "", err
+	}
+	if backupDir == "" {
+		backupDir = filepath.Dir(dataDir)
+	}
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return "", err
+	}
+	backup := filepath.Join(backupDir, fmt.Sprintf("%s.%s", filepath.Base(dataDir), now.Format("20060102-150405.000")))
+	return backup, os.Rename(dataDir, backup)
+}
+
+// DataDirFile is a file in the etcd data directory.
+type DataDirFile struct {
+	Name string
+	Size int64
+}
+
+// DataDirInfo describes the on-disk state of an etcd data directory.
+type DataDirInfo struct {
+	DataDir string
+
+	// WALFiles are the write-ahead log files, in the order of sequence.
+	WALFiles []DataDirFile
+	WALSize  int64
+
+	// SnapshotCount is the number of raft snapshot files, and the latest
+	// snapshot is at the raft term and index parsed from its file name.
+	SnapshotCount       int
+	LatestSnapshotTerm  uint64
+	LatestSnapshotIndex uint64
+
+	// DBSize is the size of the backend database file.
+	DBSize int64
+}
+
+// inspectDataDir reads the etcd data directory without modifying it. The
+// WAL and snapshot directories that do not exist yet are reported empty.
+func inspectDataDir(dataDir string) (DataDirInfo, error) {
+	info := DataDirInfo{DataDir: dataDir}
+	if _, err := os.Stat(dataDir); err != nil {
+		return info, err
+	}
+
+	walDir, snapDir := filepath.Join(dataDir, "member", "wal"), filepath.Join(dataDir, "member", "snap")
+	fs, err := readDirIfExist(walDir)
+	if err != nil {
+		return info, err
+	}
+	for _, f := range fs {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".wal" {
+			continue
+		}
+		// sorted by name, which is the sequence and the index in hex
+		info.WALFiles = append(info.WALFiles, DataDirFile{Name: f.Name(), Size: f.Size()})
+		info.WALSize += f.Size()
+	}
+
+	fs, err = readDirIfExist(snapDir)
+	if err != nil {
+		return info, err
+	}
+	for _, f := range fs {
+		switch {
+		case f.IsDir():
+		case f.Name() == "db":
+			info.DBSize = f.Size()
+		case filepath.Ext(f.Name()) == ".snap":
+			var term, index uint64
+			if _, err := fmt.Sscanf(strings.TrimSuffix(f.Name(), ".snap"), "%016x-%016x", &term, &index); err != nil {
+				continue // not a snapshot written by etcd
+			}
+			info.SnapshotCount++
+			if term > info.LatestSnapshotTerm || (term == info.LatestSnapshotTerm && index > info.LatestSnapshotIndex) {
+				info.LatestSnapshotTerm, info.LatestSnapshotIndex = term, index
+			}
+		}
+	}
+	return info, nil
+}
+
+// readDirIfExist returns the directory entries sorted by name, or none if
+// the directory does not exist.
+func readDirIfExist(dir string) ([]os.FileInfo, error) {
+	fs, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return fs, err
+}
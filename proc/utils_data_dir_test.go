@@ -0,0 +1,82 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeDataDirFixture lays out an etcd data directory with files of the
+// given sizes, relative to dataDir.
+func writeDataDirFixture(t *testing.T, dataDir string, files map[string]int) {
+	for name, size := range files {
+		fpath := filepath.Join(dataDir, name)
+		if err := os.MkdirAll(filepath.Dir(fpath), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fpath, make([]byte, size), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInspectDataDir(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #")
+	defer c.Shutdown()
+	dataDir := c.nameToNode["etcd1"].(*NodeWebLocal).Flags.DataDir
+
+	// the data directory does not exist before the first start
+	if _, err := c.InspectDataDir("etcd1"); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error, got %v", err)
+	}
+
+	writeDataDirFixture(t, dataDir, map[string]int{
+		"member/wal/0000000000000000-0000000000000000.wal": 640,
+		"member/wal/0000000000000001-0000000000002711.wal": 320,
+		"member/wal/0.tmp": 64,
+		"member/snap/0000000000000002-0000000000002711.snap": 10,
+		"member/snap/0000000000000003-0000000000004e22.snap": 10,
+		"member/snap/0000000000000003-0000000000000fff.snap": 10,
+		"member/snap/db":     4096,
+		"member/snap/broken": 1,
+	})
+	info, err := c.InspectDataDir("etcd1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DataDirInfo{
+		DataDir: dataDir,
+		WALFiles: []DataDirFile{
+			{Name: "0000000000000000-0000000000000000.wal", Size: 640},
+			{Name: "0000000000000001-0000000000002711.wal", Size: 320},
+		},
+		WALSize:             960,
+		SnapshotCount:       3,
+		LatestSnapshotTerm:  3,
+		LatestSnapshotIndex: 20002,
+		DBSize:              4096,
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("expected %+v, got %+v", want, info)
+	}
+
+	if _, err := c.InspectDataDir("etcd9"); err == nil {
+		t.Fatal("expected error for a node not found")
+	}
+}
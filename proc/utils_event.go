@@ -0,0 +1,93 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventLogSize is the number of the latest events a cluster keeps.
+const eventLogSize = 1000
+
+// Event is a message written to the cluster streams, such as
+// '[PUT] Started!'.
+type Event struct {
+	Time   time.Time
+	Node   string
+	Op     string // the bracketed prefix of the message, if any
+	Detail string
+
+	// StreamIDs are the streams the message was written to, which are the
+	// user IDs in the web UI, or empty for the shared stream. They are not
+	// serialized, not to expose the users.
+	StreamIDs []string `json:"-"`
+}
+
+// newEvent parses the message written to the streams into an Event.
+func newEvent(name, msg string, streamIDs ...string) Event {
+	ev := Event{Time: time.Now(), Node: name, Detail: msg}
+	if len(streamIDs) > 0 {
+		ev.StreamIDs = append([]string(nil), streamIDs...)
+	}
+	if strings.HasPrefix(msg, "[") {
+		if idx := strings.Index(msg, "]"); idx != -1 {
+			ev.Op = msg[1:idx]
+			ev.Detail = strings.TrimSpace(msg[idx+1:])
+		}
+	}
+	return ev
+}
+
+// eventLog is a ring buffer of the latest events.
+type eventLog struct {
+	mu     sync.Mutex
+	events []Event
+	next   int // index to write the next event
+	full   bool
+}
+
+func newEventLog(size int) *eventLog {
+	return &eventLog{events: make([]Event, size)}
+}
+
+// add appends the event, overwriting the oldest one if full.
+func (l *eventLog) add(ev Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events[l.next] = ev
+	l.next = (l.next + 1) % len(l.events)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// since returns the events after t, from the oldest.
+func (l *eventLog) since(t time.Time) []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ordered := l.events[:l.next]
+	if l.full {
+		ordered = append(append([]Event{}, l.events[l.next:]...), ordered...)
+	}
+	evs := []Event{}
+	for _, ev := range ordered {
+		if ev.Time.After(t) {
+			evs = append(evs, ev)
+		}
+	}
+	return evs
+}
@@ -0,0 +1,81 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewEvent(t *testing.T) {
+	tests := []struct {
+		msg    string
+		op     string
+		detail string
+	}{
+		{"[PUT] Started! (endpoints: [])", "PUT", "Started! (endpoints: [])"},
+		{"[WATCH] Started!", "WATCH", "Started!"},
+		{"no operation", "", "no operation"},
+		{"[unclosed", "", "[unclosed"},
+	}
+	for i, tt := range tests {
+		ev := newEvent("etcd1", tt.msg)
+		if ev.Node != "etcd1" || ev.Op != tt.op || ev.Detail != tt.detail {
+			t.Errorf("#%d: expected (etcd1, %q, %q), got (%s, %q, %q)", i, tt.op, tt.detail, ev.Node, ev.Op, ev.Detail)
+		}
+	}
+}
+
+func TestEventLogWraparound(t *testing.T) {
+	l := newEventLog(3)
+	if evs := l.since(time.Time{}); len(evs) != 0 {
+		t.Fatalf("expected no events, got %v", evs)
+	}
+
+	base := time.Now()
+	for i := 0; i < 5; i++ {
+		l.add(Event{Time: base.Add(time.Duration(i) * time.Second), Detail: fmt.Sprint(i)})
+	}
+	evs := l.since(time.Time{})
+	if len(evs) != 3 {
+		t.Fatalf("expected the latest 3 events, got %d", len(evs))
+	}
+	for i, ev := range evs {
+		if want := fmt.Sprint(i + 2); ev.Detail != want {
+			t.Errorf("#%d: expected event %s, got %s", i, want, ev.Detail)
+		}
+	}
+
+	evs = l.since(base.Add(3 * time.Second))
+	if len(evs) != 1 || evs[0].Detail != "4" {
+		t.Fatalf("expected only the last event, got %v", evs)
+	}
+}
+
+func TestWriteRecordsEvents(t *testing.T) {
+	c := newTestCluster(t, 1, "sleep 10 #")
+	defer c.Shutdown()
+
+	st := time.Now()
+	if err := c.Write("etcd1", "[PUT] Started!", "user"); err != nil {
+		t.Fatal(err)
+	}
+	evs := c.Events(st.Add(-time.Nanosecond))
+	if len(evs) != 1 || evs[0].Node != "etcd1" || evs[0].Op != "PUT" || !reflect.DeepEqual(evs[0].StreamIDs, []string{"user"}) {
+		t.Fatalf("unexpected events %v", evs)
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+// NodeHook is called with the name of the Node and whether it is active
+// now, when the Node starts or exits.
+type NodeHook func(name string, active bool)
+
+// nodeHooks are the hooks registered for a Node.
+type nodeHooks struct {
+	onStart []NodeHook
+	onExit  []NodeHook
+}
+
+// merge returns the hooks of both, h first.
+func (h nodeHooks) merge(o nodeHooks) nodeHooks {
+	return nodeHooks{
+		onStart: append(append([]NodeHook{}, h.onStart...), o.onStart...),
+		onExit:  append(append([]NodeHook{}, h.onExit...), o.onExit...),
+	}
+}
+
+// started calls the start hooks. Caller must not hold the Node lock, so
+// that the hooks can call back the Cluster.
+func (h nodeHooks) started(name string) {
+	for _, hook := range h.onStart {
+		hook(name, true)
+	}
+}
+
+// exited calls the exit hooks. Caller must not hold the Node lock.
+func (h nodeHooks) exited(name string) {
+	for _, hook := range h.onExit {
+		hook(name, false)
+	}
+}
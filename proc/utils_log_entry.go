@@ -0,0 +1,107 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// logEntry is an etcd log line parsed by parseLogLine.
+type logEntry struct {
+	Time      string // as written by etcd, such as '2016-06-15 10:00:00.000000'
+	Level     string // one of the names of logLevels
+	Component string // the package that logged, such as 'raft', if any
+	Text      string
+}
+
+var (
+	// logLineRegex matches the capnslog format of etcd, such as
+	// '2016-06-15 10:00:00.000000 W | rafthttp: lost the TCP streaming'.
+	logLineRegex = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)?) ([TDINWEC]) \| (.*)$`)
+
+	// logComponentRegex matches the package prefix of the text, such as
+	// 'raft.node: ' or 'etcdserver/api/v3rpc: '.
+	logComponentRegex = regexp.MustCompile(`^([A-Za-z0-9_./-]+): (.*)$`)
+)
+
+// parseLogLine parses the etcd log line, and returns false if it is not in
+// the etcd log format, such as the stack trace of a panic.
+func parseLogLine(line string) (logEntry, bool) {
+	m := logLineRegex.FindStringSubmatch(strings.TrimRight(line, "\r\n"))
+	if m == nil {
+		return logEntry{}, false
+	}
+	e := logEntry{Time: m[1], Text: m[3]}
+	for _, l := range logLevels {
+		if l.letter == m[2][0] {
+			e.Level = l.name
+		}
+	}
+	if cm := logComponentRegex.FindStringSubmatch(e.Text); cm != nil {
+		e.Component, e.Text = cm[1], cm[2]
+	}
+	return e, true
+}
+
+// logLevelColors are the colors of the levels that need attention.
+var logLevelColors = map[string]string{
+	"warning":  "#FF8C00",
+	"error":    "#FF0000",
+	"critical": "#FF0000",
+}
+
+// logSummaryLength is the length of the text that longer log entries are
+// collapsed to, until expanded.
+const logSummaryLength = 120
+
+// renderLogEntry formats the parsed log line of the node in its color, with
+// the level, component and time in classes and attributes that the UI can
+// style and filter by. The texts longer than logSummaryLength are
+// collapsed. The text is escaped, so only the markup is rendered as HTML.
+func renderLogEntry(color string, width int, name string, e logEntry) string {
+	head := fmt.Sprintf(`<b><font color="%s">%*s | </font></b>`, color, width, name)
+	level := strings.ToUpper(e.Level)
+	if c, ok := logLevelColors[e.Level]; ok {
+		level = fmt.Sprintf(`<font color="%s">%s</font>`, c, level)
+	}
+	head += fmt.Sprintf(`<span class="log-level">%s</span> `, level)
+	if e.Component != "" {
+		head += fmt.Sprintf(`<span class="log-component"><b>%s</b></span>: `, e.Component)
+	}
+
+	attrs := fmt.Sprintf(`class="log log-%s" data-level="%s" data-component="%s" title="%s"`, e.Level, e.Level, e.Component, e.Time)
+	if len(e.Text) <= logSummaryLength {
+		return fmt.Sprintf(`<span %s>%s%s</span>`, attrs, head, htmlReplacer.Replace(e.Text))
+	}
+	n := logSummaryLength
+	for n > 0 && !utf8.RuneStart(e.Text[n]) {
+		n-- // not to cut a multi-byte character
+	}
+	return fmt.Sprintf(`<details %s><summary>%s%s...</summary>%s</details>`, attrs, head, htmlReplacer.Replace(e.Text[:n]), htmlReplacer.Replace(e.Text))
+}
+
+// formatLogLine formats the log line of the node with renderLogEntry if
+// structured and the line parses, or else with colorLine.
+func formatLogLine(structured bool, color string, width int, name, line string) string {
+	if structured {
+		if e, ok := parseLogLine(line); ok {
+			return renderLogEntry(color, width, name, e)
+		}
+	}
+	return colorLine(color, width, name, line)
+}
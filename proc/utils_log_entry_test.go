@@ -0,0 +1,95 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		want logEntry
+	}{
+		{
+			"2016-06-15 10:00:00.123456 I | etcdserver: published {Name:etcd1 ClientURLs:[http://localhost:2379]} to cluster 7e27652122e8b2ae\n",
+			true,
+			logEntry{"2016-06-15 10:00:00.123456", "info", "etcdserver", "published {Name:etcd1 ClientURLs:[http://localhost:2379]} to cluster 7e27652122e8b2ae"},
+		},
+		{
+			"2016-06-15 10:00:01.000000 W | rafthttp: lost the TCP streaming connection with peer 8211f1d0f64f3269 (stream MsgApp v2 reader)",
+			true,
+			logEntry{"2016-06-15 10:00:01.000000", "warning", "rafthttp", "lost the TCP streaming connection with peer 8211f1d0f64f3269 (stream MsgApp v2 reader)"},
+		},
+		{
+			"2016-06-15 10:00:02.000000 N | raft.node: 8e9e05c52164694d elected leader 8e9e05c52164694d at term 2",
+			true,
+			logEntry{"2016-06-15 10:00:02.000000", "notice", "raft.node", "8e9e05c52164694d elected leader 8e9e05c52164694d at term 2"},
+		},
+		{
+			"2016-06-15 10:00:03.000000 C | etcdserver/api/v3rpc: failed to serve",
+			true,
+			logEntry{"2016-06-15 10:00:03.000000", "critical", "etcdserver/api/v3rpc", "failed to serve"},
+		},
+		{
+			// no component
+			"2016-06-15 10:00:04.000000 E | failed to dial: connection refused",
+			true,
+			logEntry{"2016-06-15 10:00:04.000000", "error", "", "failed to dial: connection refused"},
+		},
+		{"panic: runtime error: invalid memory address or nil pointer dereference", false, logEntry{}},
+		{"goroutine 1 [running]:", false, logEntry{}},
+		{`{"level":"info","ts":"2016-06-15T10:00:00.000Z","msg":"serving client traffic"}`, false, logEntry{}},
+	}
+	for i, tt := range tests {
+		e, ok := parseLogLine(tt.line)
+		if ok != tt.ok || e != tt.want {
+			t.Errorf("#%d: parseLogLine = %+v, %v, want %+v, %v", i, e, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestFormatLogLine(t *testing.T) {
+	line := formatLogLine(true, "red", 5, "etcd1", "2016-06-15 10:00:01.000000 W | raft: <lost> leader")
+	for _, want := range []string{
+		`data-level="warning"`,
+		`data-component="raft"`,
+		`<font color="red">etcd1 | </font>`,
+		`<font color="#FF8C00">WARNING</font>`,
+		"&lt;lost&gt; leader</span>",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("expected %q in %q", want, line)
+		}
+	}
+
+	// the long texts are collapsed
+	long := formatLogLine(true, "red", 5, "etcd1", "2016-06-15 10:00:01.000000 I | raft: "+strings.Repeat("x", logSummaryLength+1))
+	if !strings.HasPrefix(long, "<details ") || !strings.Contains(long, strings.Repeat("x", logSummaryLength)+"...</summary>") {
+		t.Errorf("expected the collapsed entry, got %q", long)
+	}
+
+	// the unparseable lines and the raw mode are passed through
+	for _, raw := range []string{
+		formatLogLine(true, "red", 5, "etcd1", "panic: <nil>"),
+		formatLogLine(false, "red", 5, "etcd1", "2016-06-15 10:00:01.000000 W | raft: lost leader"),
+	} {
+		if !strings.HasPrefix(raw, `<b><font color="red">etcd1 | </font>`) || strings.Contains(raw, "data-level") {
+			t.Errorf("expected the raw line, got %q", raw)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// logLevels are the capnslog levels of the etcd log lines, from the
+// lowest, by the letter etcd writes.
+var logLevels = []struct {
+	name   string
+	letter byte
+}{
+	{"trace", 'T'},
+	{"debug", 'D'},
+	{"info", 'I'},
+	{"notice", 'N'},
+	{"warning", 'W'},
+	{"error", 'E'},
+	{"critical", 'C'},
+}
+
+// logLevelRegex matches the level letter of an etcd log line, such as
+// '2016-06-15 10:00:00.000000 W | etcdserver: ...'.
+var logLevelRegex = regexp.MustCompile(`^\S+ \S+ ([TDINWEC]) \| `)
+
+// logFilter selects the etcd log lines that the Nodes write to the
+// shared stream with live logs. The nil logFilter selects all lines.
+type logFilter struct {
+	// minLevel is the index in logLevels of the lowest level to write.
+	minLevel int
+	match    *regexp.Regexp // nil to match all lines
+}
+
+// newLogFilter returns the filter of the lines at the level or higher,
+// such as "warning", that match the pattern. It returns nil if both are
+// empty.
+func newLogFilter(level, pattern string) (*logFilter, error) {
+	if level == "" && pattern == "" {
+		return nil, nil
+	}
+	f := &logFilter{}
+	if level != "" {
+		f.minLevel = -1
+		for i, l := range logLevels {
+			if l.name == strings.ToLower(level) {
+				f.minLevel = i
+			}
+		}
+		if f.minLevel < 0 {
+			return nil, fmt.Errorf("unknown log level %q", level)
+		}
+	}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("log filter %q (%v)", pattern, err)
+		}
+		f.match = re
+	}
+	return f, nil
+}
+
+// allow returns true if the line is to be written. The lines without a
+// level, such as the stack trace of a panic, pass the level filter.
+func (f *logFilter) allow(line string) bool {
+	if f == nil {
+		return true
+	}
+	if m := logLevelRegex.FindStringSubmatch(line); m != nil {
+		for i, l := range logLevels {
+			if l.letter == m[1][0] && i < f.minLevel {
+				return false
+			}
+		}
+	}
+	return f.match == nil || f.match.MatchString(line)
+}
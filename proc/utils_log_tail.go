@@ -0,0 +1,139 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logTailer fetches the log of a remote etcd process.
+type logTailer interface {
+	// Tail returns the log written since offset, and the next offset.
+	Tail(offset int64) ([]byte, int64, error)
+}
+
+// httpLogTailer fetches the log file served over HTTP, such as the
+// etcd-agent log directory behind a static file server, with Range
+// requests.
+type httpLogTailer struct {
+	url string
+	cli *http.Client
+}
+
+func newHTTPLogTailer(url string) *httpLogTailer {
+	return &httpLogTailer{url: url, cli: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (t *httpLogTailer) Tail(offset int64) ([]byte, int64, error) {
+	req, err := http.NewRequest("GET", t.url, nil)
+	if err != nil {
+		return nil, offset, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := t.cli.Do(req)
+	if err != nil {
+		return nil, offset, err
+	}
+	defer gracefulClose(resp)
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		b, err := ioutil.ReadAll(resp.Body)
+		return b, offset + int64(len(b)), err
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// no new log, or the log was truncated on restart
+		// (Content-Range: bytes */size)
+		cr := resp.Header.Get("Content-Range")
+		size, err := strconv.ParseInt(cr[strings.LastIndex(cr, "/")+1:], 10, 64)
+		if err == nil && size < offset {
+			return nil, 0, nil
+		}
+		return nil, offset, nil
+
+	case http.StatusOK:
+		// server ignores Range
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, offset, err
+		}
+		if int64(len(b)) < offset {
+			return b, int64(len(b)), nil
+		}
+		return b[offset:], int64(len(b)), nil
+
+	default:
+		return nil, offset, fmt.Errorf("%s returned %q", t.url, resp.Status)
+	}
+}
+
+// maxTailLines is the maximum number of lines to stream for each poll, so
+// that a chatty node does not flood the streams.
+const maxTailLines = 100
+
+// tailLog polls the logTailer every interval until stopc is closed, and
+// emits each complete line.
+func tailLog(t logTailer, interval time.Duration, stopc <-chan struct{}, emit func(line string)) {
+	var (
+		offset  int64
+		partial []byte
+	)
+	for {
+		select {
+		case <-stopc:
+			return
+		case <-time.After(interval):
+		}
+
+		b, next, err := t.Tail(offset)
+		if err != nil {
+			continue
+		}
+		if next < offset { // log truncated
+			partial = nil
+		}
+		offset = next
+
+		buf := bytes.NewBuffer(append(partial, b...))
+		partial = nil
+		emitted, skipped := 0, 0
+		for {
+			line, err := buf.ReadBytes('\n')
+			if err == io.EOF {
+				partial = line
+				break
+			}
+			if len(line) <= 1 {
+				continue
+			}
+			if emitted == maxTailLines {
+				skipped++
+				continue
+			}
+			emit(string(line))
+			emitted++
+		}
+		if skipped > 0 {
+			emit(fmt.Sprintf("... skipped %d lines\n", skipped))
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeTailer emits the chunks in order, one for each Tail.
+type fakeTailer struct {
+	mu     sync.Mutex
+	chunks []string
+}
+
+func (t *fakeTailer) Tail(offset int64) ([]byte, int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.chunks) == 0 {
+		return nil, offset, nil
+	}
+	b := []byte(t.chunks[0])
+	t.chunks = t.chunks[1:]
+	return b, offset + int64(len(b)), nil
+}
+
+func TestTailLog(t *testing.T) {
+	many := strings.Repeat("line\n", maxTailLines+3)
+	ft := &fakeTailer{chunks: []string{"hello\nwor", "ld\n", many}}
+
+	linec := make(chan string, 2*maxTailLines)
+	stopc := make(chan struct{})
+	go tailLog(ft, time.Millisecond, stopc, func(line string) { linec <- line })
+	defer close(stopc)
+
+	var lines []string
+	timeout := time.After(3 * time.Second)
+	for len(lines) < maxTailLines+3 {
+		select {
+		case l := <-linec:
+			lines = append(lines, l)
+		case <-timeout:
+			t.Fatalf("timed out with %d lines", len(lines))
+		}
+	}
+	if lines[0] != "hello\n" || lines[1] != "world\n" {
+		t.Errorf("unexpected lines %q", lines[:2])
+	}
+	if last := lines[len(lines)-1]; last != "... skipped 3 lines\n" {
+		t.Errorf("unexpected last line %q", last)
+	}
+}
+
+func TestHTTPLogTailer(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		log = "hello\n"
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		http.ServeContent(w, req, "etcd.log", time.Time{}, bytes.NewReader([]byte(log)))
+	}))
+	defer ts.Close()
+
+	lt := newHTTPLogTailer(ts.URL)
+	b, offset, err := lt.Tail(0)
+	if err != nil || string(b) != "hello\n" || offset != 6 {
+		t.Fatalf("unexpected tail %q, %d, %v", b, offset, err)
+	}
+	if b, offset, err = lt.Tail(offset); err != nil || len(b) != 0 || offset != 6 {
+		t.Fatalf("expected no new log, got %q, %d, %v", b, offset, err)
+	}
+
+	mu.Lock()
+	log += "world\n"
+	mu.Unlock()
+	if b, offset, err = lt.Tail(offset); err != nil || string(b) != "world\n" || offset != 12 {
+		t.Fatalf("unexpected tail %q, %d, %v", b, offset, err)
+	}
+
+	mu.Lock()
+	log = "new\n" // truncated on restart
+	mu.Unlock()
+	if _, offset, err = lt.Tail(offset); err != nil || offset != 0 {
+		t.Fatalf("expected offset reset, got %d, %v", offset, err)
+	}
+}
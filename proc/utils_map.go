@@ -44,3 +44,32 @@ func mapToMapString(m map[string]string) string {
 	sort.Strings(ss)
 	return strings.TrimSpace(strings.Join(ss, ","))
 }
+
+// sortedKeys returns the keys of the map, sorted.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mergeEnv returns the environment, in the form of os.Environ, with the
+// variables of env set over it, or nil to inherit the environment as is
+// if env is empty.
+func mergeEnv(environ []string, env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	merged := make([]string, 0, len(environ)+len(env))
+	for _, kv := range environ {
+		if _, ok := env[strings.SplitN(kv, "=", 2)[0]]; !ok {
+			merged = append(merged, kv)
+		}
+	}
+	for _, k := range sortedKeys(env) {
+		merged = append(merged, k+"="+env[k])
+	}
+	return merged
+}
@@ -0,0 +1,134 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// startMemberWatch starts syncing the members every memberWatchInterval,
+// until Shutdown. It starts only once, even if Bootstrap is called again.
+func (c *defaultCluster) startMemberWatch() {
+	if c.memberWatchInterval <= 0 {
+		return
+	}
+	c.memberWatchOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(c.memberWatchInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+				case <-c.ctx.Done():
+					return
+				}
+				if err := c.syncMembers(""); err != nil {
+					logger.Warningf("member sync error (%v)", err)
+				}
+			}
+		}()
+	})
+}
+
+// memberEndpoints returns a copy of the client endpoints of the members
+// added out of band, by name.
+func (c *defaultCluster) memberEndpoints() map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nameToEndpoint := make(map[string]string, len(c.members))
+	for name, ep := range c.members {
+		nameToEndpoint[name] = ep
+	}
+	return nameToEndpoint
+}
+
+// syncMembers reconciles the members added out of band with the member
+// list of the seed, or of any active Node if the seed is empty. The
+// members that are not Nodes are named after their member names, or their
+// IDs if unnamed or named like a Node, and are written under their client
+// endpoints when found or removed.
+func (c *defaultCluster) syncMembers(seed string, streamIDs ...string) error {
+	_, nameToEndpoint, epToName := c.Endpoints()
+	if ep, ok := nameToEndpoint[seed]; ok {
+		seed = ep
+	}
+	if seed == "" {
+		_, ep, err := c.anyEndpoint()
+		if err != nil {
+			return err
+		}
+		seed = ep
+	}
+	members, err := c.memberList(seed)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]string)
+	for _, m := range members {
+		ep := memberClientEndpoint(m.ClientURLs)
+		if ep == "" {
+			continue // not started yet, or no client URL to ask
+		}
+		if _, ok := epToName[ep]; ok {
+			continue
+		}
+		name := m.Name
+		if _, ok := nameToEndpoint[name]; ok || name == "" {
+			name = fmt.Sprintf("%x", m.ID)
+		}
+		found[name] = ep
+	}
+
+	c.mu.Lock()
+	prev := c.members
+	c.members = found
+	c.mu.Unlock()
+
+	var added, removed []string
+	for name := range found {
+		if _, ok := prev[name]; !ok {
+			added = append(added, name)
+		}
+	}
+	for name := range prev {
+		if _, ok := found[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	for _, name := range added {
+		c.Write(found[name], fmt.Sprintf("[MEMBERS] Found %s (%s), added out of band", name, found[name]), streamIDs...)
+	}
+	for _, name := range removed {
+		c.Write(prev[name], fmt.Sprintf("[MEMBERS] %s (%s) was removed", name, prev[name]), streamIDs...)
+	}
+	return nil
+}
+
+// memberClientEndpoint returns the host and port of the first valid
+// client URL, or the empty string if none.
+func memberClientEndpoint(clientURLs []string) string {
+	for _, cu := range clientURLs {
+		if u, err := url.Parse(cu); err == nil && u.Host != "" {
+			return u.Host
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// leaderChangesMetric counts the leader changes a member has seen.
+const leaderChangesMetric = "etcd_server_leader_changes_seen_total"
+
+// defaultStatusMetrics are scraped by Status unless WithStatusMetrics is
+// given.
+var defaultStatusMetrics = []string{leaderChangesMetric}
+
+// scrapeMetrics fetches the Prometheus metrics of the endpoint once, and
+// returns the value of each series.
+func scrapeMetrics(endpoint string) (map[string]float64, error) {
+	cli := &http.Client{Timeout: time.Second}
+	resp, err := cli.Get(endpoint + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer gracefulClose(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s/metrics returned %s", endpoint, resp.Status)
+	}
+	return parseMetrics(resp.Body)
+}
+
+// scrapeMetric returns the value of the metric from the endpoint.
+func scrapeMetric(endpoint, metricName string) (float64, error) {
+	ms, err := scrapeMetrics(endpoint)
+	if err != nil {
+		return 0, err
+	}
+	return lookupMetric(ms, metricName)
+}
+
+// lookupMetric returns the value of the metric. Series with labels are
+// summed up unless metricName selects one with its labels, as in
+// 'etcd_grpc_requests_total{grpc_method="Range"}'. Histograms and summaries
+// are read with their '_sum' and '_count' series.
+func lookupMetric(ms map[string]float64, metricName string) (float64, error) {
+	if v, ok := ms[metricName]; ok {
+		return v, nil
+	}
+	var (
+		sum   float64
+		found bool
+	)
+	for series, v := range ms {
+		if strings.HasPrefix(series, metricName+"{") {
+			sum += v
+			found = true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("metric %q not found", metricName)
+	}
+	return sum, nil
+}
+
+// parseMetrics parses the Prometheus text format into the value of each
+// series, keyed by the metric name and its labels as written.
+func parseMetrics(r io.Reader) (map[string]float64, error) {
+	ms := make(map[string]float64)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		txt := strings.TrimSpace(scanner.Text())
+		if txt == "" || strings.HasPrefix(txt, "#") {
+			continue
+		}
+		// label values may contain spaces, so split after the labels
+		idx := strings.LastIndex(txt, "}")
+		if idx == -1 {
+			idx = strings.Index(txt, " ")
+		} else {
+			idx++
+		}
+		if idx <= 0 || idx >= len(txt) {
+			return nil, fmt.Errorf("malformed metric line %q", txt)
+		}
+		series, rest := txt[:idx], strings.Fields(txt[idx:])
+		if len(rest) == 0 {
+			return nil, fmt.Errorf("malformed metric line %q", txt)
+		}
+		// the optional timestamp follows the value
+		v, err := strconv.ParseFloat(rest[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed metric line %q (%v)", txt, err)
+		}
+		ms[series] = v
+	}
+	return ms, scanner.Err()
+}
@@ -0,0 +1,144 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const sampleMetrics = `# HELP etcd_server_leader_changes_seen_total The number of leader changes seen.
+# TYPE etcd_server_leader_changes_seen_total counter
+etcd_server_leader_changes_seen_total 3
+# HELP etcd_disk_wal_fsync_duration_seconds The latency distributions of fsync called by wal.
+# TYPE etcd_disk_wal_fsync_duration_seconds histogram
+etcd_disk_wal_fsync_duration_seconds_bucket{le="0.001"} 2
+etcd_disk_wal_fsync_duration_seconds_bucket{le="+Inf"} 10
+etcd_disk_wal_fsync_duration_seconds_sum 0.25
+etcd_disk_wal_fsync_duration_seconds_count 10
+etcd_grpc_requests_total{grpc_method="Range",grpc_service="etcdserverpb.KV"} 7 1465337260000
+etcd_grpc_requests_total{grpc_method="Put",grpc_service="etcdserverpb.KV"} 5
+etcd_debugging_store_reads_total{action="get and watch"} 1.5e+01
+`
+
+func TestParseMetrics(t *testing.T) {
+	ms, err := parseMetrics(strings.NewReader(sampleMetrics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want float64
+	}{
+		{"etcd_server_leader_changes_seen_total", 3},
+		{"etcd_disk_wal_fsync_duration_seconds_sum", 0.25},
+		{"etcd_disk_wal_fsync_duration_seconds_count", 10},
+		{`etcd_grpc_requests_total{grpc_method="Range",grpc_service="etcdserverpb.KV"}`, 7},
+		{"etcd_grpc_requests_total", 12},
+		{"etcd_debugging_store_reads_total", 15},
+	}
+	for i, tt := range tests {
+		v, err := lookupMetric(ms, tt.name)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if v != tt.want {
+			t.Errorf("#%d: %s expected %v, got %v", i, tt.name, tt.want, v)
+		}
+	}
+	if _, err := lookupMetric(ms, "etcd_grpc_requests"); err == nil {
+		t.Error("expected error for a metric not found")
+	}
+
+	if _, err := parseMetrics(strings.NewReader("etcd_server_has_leader\n")); err == nil {
+		t.Error("expected error for a line without value")
+	}
+}
+
+func TestScrapeMetric(t *testing.T) {
+	var scrapes int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/metrics" {
+			http.NotFound(w, req)
+			return
+		}
+		atomic.AddInt32(&scrapes, 1)
+		fmt.Fprint(w, sampleMetrics)
+	}))
+	defer srv.Close()
+
+	v, err := scrapeMetric(srv.URL, leaderChangesMetric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 3 {
+		t.Fatalf("expected 3 leader changes, got %v", v)
+	}
+
+	atomic.StoreInt32(&scrapes, 0)
+	var stat ServerStatus
+	getMetrics(&stat, srv.URL, []string{"etcd_disk_wal_fsync_duration_seconds_sum", "etcd_grpc_requests_total"})
+	if n := atomic.LoadInt32(&scrapes); n != 1 {
+		t.Fatalf("expected a single scrape per status, got %d", n)
+	}
+	if stat.LeaderChanges != 3 {
+		t.Errorf("expected 3 leader changes, got %d", stat.LeaderChanges)
+	}
+	if stat.Metrics["etcd_disk_wal_fsync_duration_seconds_sum"] != 0.25 || stat.Metrics["etcd_grpc_requests_total"] != 12 {
+		t.Errorf("unexpected metrics %v", stat.Metrics)
+	}
+}
+
+func TestGetV2StatusMissingEndpoint(t *testing.T) {
+	old := v2ProbeTimeout
+	v2ProbeTimeout = 100 * time.Millisecond
+	defer func() { v2ProbeTimeout = old }()
+
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	var stat ServerStatus
+	getV2Status(&stat, srv.URL, []string{leaderChangesMetric})
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("expected only the probe, got %d requests", n)
+	}
+	if stat.ClusterVersion != "" || stat.Metrics != nil {
+		t.Errorf("expected no v2 status, got %+v", stat)
+	}
+
+	// an endpoint that never responds costs only the probe timeout
+	stopc := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-stopc
+	}))
+	defer hung.Close()
+	defer close(stopc)
+
+	st := time.Now()
+	getV2Status(&stat, hung.URL, []string{leaderChangesMetric})
+	if took := time.Since(st); took > 500*time.Millisecond {
+		t.Errorf("expected to skip after the probe timeout, took %v", took)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// iptablesAvailable returns true if the process can manage iptables rules.
+var iptablesAvailable = func() bool {
+	if os.Geteuid() != 0 {
+		return false
+	}
+	_, err := exec.LookPath("iptables")
+	return err == nil
+}
+
+// dropPort drops all incoming and outgoing packets at the port.
+func dropPort(port int) error {
+	for _, args := range [][]string{
+		{"-A", "INPUT", "-p", "tcp", "--dport", strconv.Itoa(port), "-j", "DROP"},
+		{"-A", "OUTPUT", "-p", "tcp", "--sport", strconv.Itoa(port), "-j", "DROP"},
+	} {
+		if out, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("iptables %s (%v, %s)", strings.Join(args, " "), err, out)
+		}
+	}
+	return nil
+}
+
+// recoverPort removes the rules added by dropPort.
+func recoverPort(port int) error {
+	for _, args := range [][]string{
+		{"-D", "INPUT", "-p", "tcp", "--dport", strconv.Itoa(port), "-j", "DROP"},
+		{"-D", "OUTPUT", "-p", "tcp", "--sport", strconv.Itoa(port), "-j", "DROP"},
+	} {
+		if out, err := exec.Command("iptables", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("iptables %s (%v, %s)", strings.Join(args, " "), err, out)
+		}
+	}
+	return nil
+}
+
+// urlPort returns the port of the first URL in m.
+func urlPort(m map[string]struct{}) (int, error) {
+	for k := range m {
+		u, err := url.Parse(k)
+		if err != nil {
+			return 0, err
+		}
+		_, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(port)
+	}
+	return 0, fmt.Errorf("no URL found")
+}
+
+// clientURL returns the URL that clients reach the node at. It is the first
+// of the advertised client URLs in sorted order, or of the listen client URLs
+// if none is advertised, so that the choice does not depend on map order.
+func clientURL(fs *Flags) (*url.URL, error) {
+	m := fs.AdvertiseClientURLs
+	if len(m) == 0 {
+		m = fs.ListenClientURLs
+	}
+	if len(m) == 0 {
+		return nil, fmt.Errorf("%s has no client URL", fs.Name)
+	}
+	us := make([]string, 0, len(m))
+	for k := range m {
+		us = append(us, k)
+	}
+	sort.Strings(us)
+
+	u, err := url.Parse(us[0])
+	if err != nil {
+		return nil, err
+	}
+	// IPv6 hosts must be bracketed, as in 'http://[::1]:2379'
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("invalid client URL %q (%v)", us[0], err)
+	}
+	return u, nil
+}
+
+// clientEndpoint returns the host and port of the client URL, such as
+// '127.0.0.1:2379' or '[::1]:2379'. It logs the error and returns empty
+// if the URL is invalid.
+func clientEndpoint(fs *Flags) string {
+	u, err := clientURL(fs)
+	if err != nil {
+		logger.Warningf("%s client endpoint error (%v)", fs.Name, err)
+		return ""
+	}
+	return u.Host
+}
+
+// clientStatusEndpoint returns the client URL, or empty if it is invalid.
+func clientStatusEndpoint(fs *Flags) string {
+	u, err := clientURL(fs)
+	if err != nil {
+		logger.Warningf("%s client endpoint error (%v)", fs.Name, err)
+		return ""
+	}
+	return u.String()
+}
+
+// rawEndpoint returns the host and port if s is a client endpoint, such as
+// '127.0.0.1:2379' or 'http://127.0.0.1:2379', rather than a Node name.
+func rawEndpoint(s string) (string, bool) {
+	if u, err := url.Parse(s); err == nil && u.Host != "" {
+		s = u.Host
+	}
+	if _, port, err := net.SplitHostPort(s); err != nil || port == "" {
+		return "", false
+	}
+	return s, true
+}
@@ -0,0 +1,114 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// OperationHandle is a running long operation, such as Stress or Chaos.
+type OperationHandle struct {
+	ID        uint64
+	Type      string
+	StartedAt time.Time
+
+	// StreamIDs are the streams of the users who started the operation.
+	StreamIDs []string
+
+	// Cancel cancels the operation, which stops soon after.
+	Cancel func() `json:"-"`
+}
+
+// operationRegistry tracks the running long operations.
+type operationRegistry struct {
+	root context.Context // cancels all operations when done
+
+	mu     sync.Mutex
+	nextID uint64
+	ops    map[uint64]OperationHandle
+}
+
+func newOperationRegistry(root context.Context) *operationRegistry {
+	return &operationRegistry{root: root, ops: make(map[uint64]OperationHandle)}
+}
+
+// register adds the operation, and returns the context canceled by its
+// handle or by the root context, and the function to call when the
+// operation completes.
+func (r *operationRegistry) register(parent context.Context, typ string, streamIDs []string) (context.Context, func()) {
+	ctx, cancel := withCancelOn(parent, r.root)
+
+	r.mu.Lock()
+	r.nextID++
+	id := r.nextID
+	r.ops[id] = OperationHandle{
+		ID:        id,
+		Type:      typ,
+		StartedAt: time.Now(),
+		StreamIDs: streamIDs,
+		Cancel:    cancel,
+	}
+	r.mu.Unlock()
+
+	return ctx, func() {
+		r.mu.Lock()
+		delete(r.ops, id)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// withCancelOn returns a copy of ctx that is also canceled when done is
+// canceled. The cancel function must be called to release the resources.
+func withCancelOn(ctx, done context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-done.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// list returns the running operations, from the oldest.
+func (r *operationRegistry) list() []OperationHandle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ops := make([]OperationHandle, 0, len(r.ops))
+	for _, op := range r.ops {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].ID < ops[j].ID })
+	return ops
+}
+
+// cancel cancels the running operation of the ID.
+func (r *operationRegistry) cancel(id uint64) error {
+	r.mu.Lock()
+	op, ok := r.ops[id]
+	r.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("operation %d %w", id, ErrOperationNotFound)
+	}
+	op.Cancel()
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestOperationRegistry(t *testing.T) {
+	r := newOperationRegistry(context.Background())
+	ctx1, done1 := r.register(context.Background(), "STRESS", []string{"user1"})
+	ctx2, done2 := r.register(context.Background(), "CHAOS", nil)
+	defer done2()
+
+	ops := r.list()
+	if len(ops) != 2 || ops[0].Type != "STRESS" || ops[1].Type != "CHAOS" {
+		t.Fatalf("expected [STRESS CHAOS], got %+v", ops)
+	}
+	if ops[0].ID >= ops[1].ID || ops[0].StartedAt.IsZero() {
+		t.Errorf("unexpected handles %+v", ops)
+	}
+
+	if err := r.cancel(ops[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if ctx1.Err() != context.Canceled {
+		t.Errorf("expected the operation canceled, got %v", ctx1.Err())
+	}
+	if ctx2.Err() != nil {
+		t.Errorf("expected the other operation running, got %v", ctx2.Err())
+	}
+	// canceled operations stay listed until they complete
+	if n := len(r.list()); n != 2 {
+		t.Errorf("expected 2 operations, got %d", n)
+	}
+
+	done1()
+	if ops = r.list(); len(ops) != 1 || ops[0].Type != "CHAOS" {
+		t.Errorf("expected [CHAOS], got %+v", ops)
+	}
+	if err := r.cancel(ops[0].ID - 1); !errors.Is(err, ErrOperationNotFound) {
+		t.Errorf("expected %v, got %v", ErrOperationNotFound, err)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// procDir is where the process stats are read from, on Linux.
+var procDir = "/proc"
+
+// clockTicks is the unit of the CPU times in /proc/<pid>/stat, USER_HZ,
+// which is 100 on all Linux architectures.
+const clockTicks = 100
+
+// processStat is the resource use of a process group.
+type processStat struct {
+	cpuTime time.Duration // user and system CPU time
+	rss     uint64        // resident set size in bytes
+}
+
+// readProcessGroupStats sums the resource use of the processes by their
+// process group, so that the etcd started by a shell is counted as well.
+// It scans /proc once for all the groups, and returns an error if /proc is
+// not available.
+func readProcessGroupStats() (map[int]processStat, error) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return nil, err
+	}
+	pgidToStat := make(map[int]processStat)
+	for _, e := range entries {
+		if _, err := strconv.Atoi(e.Name()); err != nil {
+			continue // not a process
+		}
+		b, err := ioutil.ReadFile(filepath.Join(procDir, e.Name(), "stat"))
+		if err != nil {
+			continue // exited since ReadDir
+		}
+		pgid, stat, err := parseProcStat(string(b))
+		if err != nil {
+			continue
+		}
+		ps := pgidToStat[pgid]
+		ps.cpuTime += stat.cpuTime
+		ps.rss += stat.rss
+		pgidToStat[pgid] = ps
+	}
+	return pgidToStat, nil
+}
+
+// parseProcStat parses the process group, the CPU time and the RSS from
+// the content of /proc/<pid>/stat.
+func parseProcStat(s string) (int, processStat, error) {
+	// the command name is in parentheses and may contain spaces
+	i := strings.LastIndexByte(s, ')')
+	if i < 0 {
+		return 0, processStat{}, fmt.Errorf("malformed stat %q", s)
+	}
+	// the fields after the command name, from the state (3rd field)
+	fields := strings.Fields(s[i+1:])
+	if len(fields) < 22 {
+		return 0, processStat{}, fmt.Errorf("malformed stat %q", s)
+	}
+	pgid, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return 0, processStat{}, err
+	}
+	var ticks [2]uint64 // utime and stime (14th and 15th fields)
+	for j := range ticks {
+		if ticks[j], err = strconv.ParseUint(fields[11+j], 10, 64); err != nil {
+			return 0, processStat{}, err
+		}
+	}
+	pages, err := strconv.ParseUint(fields[21], 10, 64) // rss (24th field)
+	if err != nil {
+		return 0, processStat{}, err
+	}
+	return pgid, processStat{
+		cpuTime: time.Duration(ticks[0]+ticks[1]) * time.Second / clockTicks,
+		rss:     pages * uint64(os.Getpagesize()),
+	}, nil
+}
+
+// cpuSampleTTL is how long the sample of a PID is kept without a newer
+// one, so that the samples of the exited processes are dropped.
+const cpuSampleTTL = time.Minute
+
+type cpuSample struct {
+	cpuTime time.Duration
+	at      time.Time
+}
+
+// cpuSampler computes the CPU usage of the processes between the samples,
+// by PID. The zero value is ready to use.
+type cpuSampler struct {
+	mu   sync.Mutex
+	last map[int]cpuSample
+}
+
+// percent records the CPU time of the process at now, and returns the
+// percentage of one CPU used since its last sample, or zero if none.
+func (s *cpuSampler) percent(pid int, cpuTime time.Duration, now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		s.last = make(map[int]cpuSample)
+	}
+	for p, sample := range s.last {
+		if now.Sub(sample.at) > cpuSampleTTL {
+			delete(s.last, p)
+		}
+	}
+
+	prev, ok := s.last[pid]
+	s.last[pid] = cpuSample{cpuTime: cpuTime, at: now}
+	elapsed := now.Sub(prev.at)
+	if !ok || elapsed <= 0 || cpuTime < prev.cpuTime {
+		return 0 // first sample, or the PID was reused
+	}
+	return float64(cpuTime-prev.cpuTime) / float64(elapsed) * 100
+}
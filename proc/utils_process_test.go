@@ -0,0 +1,114 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestParseProcStat(t *testing.T) {
+	// the command name with spaces and parentheses, utime 250, stime 50
+	// and rss 3 pages
+	stat := "4242 (etcd (x) y) S 1 4240 4240 0 -1 4194560 100 0 0 0 250 50 0 0 20 0 8 0 1000 10000000 3 18446744073709551615\n"
+	pgid, ps, err := parseProcStat(stat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pgid != 4240 {
+		t.Errorf("pgid = %d, want 4240", pgid)
+	}
+	if ps.cpuTime != 3*time.Second {
+		t.Errorf("cpuTime = %v, want 3s", ps.cpuTime)
+	}
+	if want := 3 * uint64(os.Getpagesize()); ps.rss != want {
+		t.Errorf("rss = %d, want %d", ps.rss, want)
+	}
+
+	if _, _, err := parseProcStat("4242 (etcd) S 1"); err == nil {
+		t.Error("expected the error of the truncated stat")
+	}
+}
+
+func TestCPUSampler(t *testing.T) {
+	var s cpuSampler
+	now := time.Now()
+	if p := s.percent(1, time.Second, now); p != 0 {
+		t.Errorf("first sample = %v, want 0", p)
+	}
+	if p := s.percent(1, 1500*time.Millisecond, now.Add(time.Second)); p != 50 {
+		t.Errorf("percent = %v, want 50", p)
+	}
+	// the PID was reused by a process with less CPU time
+	if p := s.percent(1, 0, now.Add(2*time.Second)); p != 0 {
+		t.Errorf("reused PID = %v, want 0", p)
+	}
+
+	// the samples not updated within cpuSampleTTL are dropped
+	s.percent(2, 0, now.Add(3*time.Second))
+	s.percent(2, 0, now.Add(3*time.Second+cpuSampleTTL))
+	if _, ok := s.last[1]; ok {
+		t.Error("expected the stale sample to be dropped")
+	}
+}
+
+func TestReadProcessGroupStat(t *testing.T) {
+	if _, err := os.Stat(filepath.Join(procDir, "self", "stat")); err != nil {
+		t.Skipf("%s not available (%v)", procDir, err)
+	}
+	cmd := exec.Command("sh", "-c", "while :; do :; done")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	}()
+
+	var s cpuSampler
+	pid := cmd.Process.Pid
+	pgidToStat, err := readProcessGroupStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps, ok := pgidToStat[pid]
+	if !ok {
+		t.Fatalf("no stat of the group %d", pid)
+	}
+	s.percent(pid, ps.cpuTime, time.Now())
+
+	time.Sleep(500 * time.Millisecond)
+	if pgidToStat, err = readProcessGroupStats(); err != nil {
+		t.Fatal(err)
+	}
+	ps = pgidToStat[pid]
+	if p := s.percent(pid, ps.cpuTime, time.Now()); p < 10 {
+		t.Errorf("CPU of the busy loop = %.1f%%, want at least 10%%", p)
+	}
+	// the kernel counts the RSS of a new process lazily, so it is checked
+	// only once the process has run
+	if ps.rss == 0 {
+		t.Error("expected the RSS of the busy loop")
+	}
+
+	if _, ok := pgidToStat[1<<30]; ok {
+		t.Error("expected no stat of the group without a process")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// retransmitPenalty is the extra delay for a lost chunk, as if TCP
+// retransmitted it.
+var retransmitPenalty = 200 * time.Millisecond
+
+// latencyProxy is a userspace TCP proxy that delays the traffic in both
+// directions, to simulate a slow network without privileges.
+type latencyProxy struct {
+	ln net.Listener
+	to string
+
+	mu    sync.Mutex // guards the following
+	delay time.Duration
+	loss  float64
+	rand  *rand.Rand
+	conns map[net.Conn]struct{}
+}
+
+func newLatencyProxy(from, to string) (*latencyProxy, error) {
+	ln, err := net.Listen("tcp", from)
+	if err != nil {
+		return nil, err
+	}
+	p := &latencyProxy{
+		ln:    ln,
+		to:    to,
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		conns: make(map[net.Conn]struct{}),
+	}
+	go p.serve()
+	return p, nil
+}
+
+// SetImpairment delays every chunk by delay, and additionally by
+// retransmitPenalty with the probability of loss.
+func (p *latencyProxy) SetImpairment(delay time.Duration, loss float64) {
+	p.mu.Lock()
+	p.delay, p.loss = delay, loss
+	p.mu.Unlock()
+}
+
+func (p *latencyProxy) Close() error {
+	err := p.ln.Close()
+	p.mu.Lock()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = make(map[net.Conn]struct{})
+	p.mu.Unlock()
+	return err
+}
+
+func (p *latencyProxy) penalty() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	d := p.delay
+	if p.loss > 0 && p.rand.Float64() < p.loss {
+		d += retransmitPenalty
+	}
+	return d
+}
+
+func (p *latencyProxy) serve() {
+	for {
+		src, err := p.ln.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(src)
+	}
+}
+
+func (p *latencyProxy) handle(src net.Conn) {
+	dst, err := net.Dial("tcp", p.to)
+	if err != nil {
+		src.Close()
+		return
+	}
+	p.mu.Lock()
+	p.conns[src], p.conns[dst] = struct{}{}, struct{}{}
+	p.mu.Unlock()
+
+	defer func() {
+		src.Close()
+		dst.Close()
+		p.mu.Lock()
+		delete(p.conns, src)
+		delete(p.conns, dst)
+		p.mu.Unlock()
+	}()
+
+	donec := make(chan struct{}, 2)
+	go func() {
+		p.pipe(dst, src)
+		donec <- struct{}{}
+	}()
+	go func() {
+		p.pipe(src, dst)
+		donec <- struct{}{}
+	}()
+	<-donec
+}
+
+func (p *latencyProxy) pipe(dst io.Writer, src io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if d := p.penalty(); d > 0 {
+				time.Sleep(d)
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// setPeerProxy moves the peer listener of the node to the next port, and
+// puts a latencyProxy at the advertised peer URL forwarding to it.
+func setPeerProxy(f *Flags) (*latencyProxy, error) {
+	if len(f.AdvertisePeerURLs) != 1 {
+		return nil, fmt.Errorf("%s must have exactly one peer URL for the peer proxy", f.Name)
+	}
+	var advertised string
+	for k := range f.AdvertisePeerURLs {
+		advertised = k
+	}
+	u, err := url.Parse(advertised)
+	if err != nil {
+		return nil, err
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return nil, err
+	}
+	pn, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	listen := net.JoinHostPort(host, strconv.Itoa(pn+1))
+
+	p, err := newLatencyProxy(u.Host, listen)
+	if err != nil {
+		return nil, err
+	}
+	u.Host = listen
+	f.ListenPeerURLs = map[string]struct{}{u.String(): {}}
+	return p, nil
+}
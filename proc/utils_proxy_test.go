@@ -0,0 +1,71 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLatencyProxy(t *testing.T) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn) // echo
+		}
+	}()
+
+	p, err := newLatencyProxy("localhost:0", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	roundTrip := func() time.Duration {
+		conn, err := net.Dial("tcp", p.ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		st := time.Now()
+		if _, err = conn.Write([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 4)
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(st)
+	}
+
+	p.SetImpairment(100*time.Millisecond, 0)
+	if took := roundTrip(); took < 200*time.Millisecond {
+		t.Errorf("expected at least 200ms round trip, took %v", took)
+	}
+	p.SetImpairment(0, 0)
+	if took := roundTrip(); took > 100*time.Millisecond {
+		t.Errorf("expected fast round trip after clear, took %v", took)
+	}
+}
@@ -14,23 +14,21 @@
 
 package proc
 
-import (
-	"math/rand"
-	"time"
-)
+import "math/rand"
 
-func randBytes(bytesN int) []byte {
+// randBytes returns bytesN random letters from rnd, which must not be
+// shared between goroutines.
+func randBytes(rnd *rand.Rand, bytesN int) []byte {
 	const (
 		letterBytes   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 		letterIdxBits = 6                    // 6 bits to represent a letter index
 		letterIdxMask = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
 		letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
 	)
-	src := rand.NewSource(time.Now().UnixNano())
 	b := make([]byte, bytesN)
-	for i, cache, remain := bytesN-1, src.Int63(), letterIdxMax; i >= 0; {
+	for i, cache, remain := bytesN-1, rnd.Int63(), letterIdxMax; i >= 0; {
 		if remain == 0 {
-			cache, remain = src.Int63(), letterIdxMax
+			cache, remain = rnd.Int63(), letterIdxMax
 		}
 		if idx := int(cache & letterIdxMask); idx < len(letterBytes) {
 			b[i] = letterBytes[idx]
@@ -42,11 +40,12 @@ func randBytes(bytesN int) []byte {
 	return b
 }
 
-func multiRandBytes(bytesN, sliceN int) [][]byte {
+// multiRandBytes returns sliceN unique random byte slices from rnd.
+func multiRandBytes(rnd *rand.Rand, bytesN, sliceN int) [][]byte {
 	m := make(map[string]struct{})
 	var rs [][]byte
 	for len(rs) != sliceN {
-		b := randBytes(bytesN)
+		b := randBytes(rnd, bytesN)
 		if _, ok := m[string(b)]; !ok {
 			rs = append(rs, b)
 			m[string(b)] = struct{}{}
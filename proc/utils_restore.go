@@ -0,0 +1,70 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// checkSnapshotFile returns an error if the snapshot file does not exist,
+// or cannot be read.
+func checkSnapshotFile(fpath string) error {
+	f, err := os.Open(fpath)
+	if err != nil {
+		return fmt.Errorf("snapshot %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("snapshot %w", err)
+	}
+	if fi.IsDir() || fi.Size() == 0 {
+		return fmt.Errorf("snapshot %q is not a snapshot file", fpath)
+	}
+	return nil
+}
+
+// restoreArgs returns the etcdctl arguments to restore the snapshot into
+// the data directory of the Node, with the member set of its initial
+// cluster flags, as the Node then starts with them.
+func restoreArgs(snapshotPath string, f *Flags) []string {
+	return []string{
+		"snapshot", "restore", snapshotPath,
+		"--name", f.Name,
+		"--data-dir", f.DataDir,
+		"--initial-cluster", mapToMapString(f.InitialCluster),
+		"--initial-cluster-token", f.InitialClusterToken,
+		"--initial-advertise-peer-urls", mapToCommaString(f.AdvertisePeerURLs),
+	}
+}
+
+// restoreDataDir replaces the data directory of the Node with the one
+// restored from the snapshot by etcdctl.
+func restoreDataDir(etcdctlPath, snapshotPath string, f *Flags) error {
+	// etcdctl does not restore into an existing data directory
+	if err := os.RemoveAll(f.DataDir); err != nil {
+		return err
+	}
+	cmd := exec.Command(etcdctlPath, restoreArgs(snapshotPath, f)...)
+	cmd.Env = append(os.Environ(), "ETCDCTL_API=3")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s restore %w (%s)", f.Name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
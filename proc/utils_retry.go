@@ -0,0 +1,86 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 2 * time.Second
+)
+
+// RetryPolicy retries the Put, Get and Delete requests that fail with a
+// transient error, such as no leader during an election. The zero value
+// does not retry.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+
+	// Backoff is the wait before the first retry, doubled for each of the
+	// next retries up to MaxBackoff. Non-positive values default to 100ms
+	// and 2s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// retryable returns true if err is transient, so that the same request
+// may succeed once the cluster has a leader again.
+func retryable(err error) bool {
+	if errors.Is(err, clientv3.ErrNoAvailableEndpoints) {
+		return true
+	}
+	// clientv3 converts the errors of etcd, such as no leader, to
+	// rpctypes.EtcdError
+	var ce interface{ Code() codes.Code }
+	if errors.As(err, &ce) {
+		return ce.Code() == codes.Unavailable
+	}
+	return grpc.Code(err) == codes.Unavailable
+}
+
+// doRetry calls do with the deadline of requestTimeout, and calls it again
+// after a backoff while it fails with a retryable error, up to the retries
+// of the policy. Each retry is written to the streams, so that the users
+// still see the failure.
+func (c *defaultCluster) doRetry(op, name string, endpoints []string, do func(ctx context.Context) error, streamIDs ...string) error {
+	backoff := c.retry.Backoff
+	for retry := 1; ; retry++ {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		err := do(ctx)
+		cancel()
+		if err == nil || retry > c.retry.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		c.Write(name, fmt.Sprintf("[%s] Retry %d/%d in %v after a transient error (%v) (endpoints: %q)", op, retry, c.retry.MaxRetries, backoff, err, endpoints), streamIDs...)
+		select {
+		case <-time.After(backoff):
+		case <-c.ctx.Done():
+			return err
+		}
+		if backoff *= 2; backoff > c.retry.MaxBackoff {
+			backoff = c.retry.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+)
+
+var (
+	// scenarioPause is the time a scenario waits between its steps, so
+	// that the users can follow the narration and the status.
+	scenarioPause = 3 * time.Second
+
+	// scenarioTimeout is the time a scenario waits for the cluster to
+	// recover, such as electing a new leader.
+	scenarioTimeout = 30 * time.Second
+)
+
+// scenarioFunc runs the steps of a scenario, narrating them with the
+// scenarioRun, until ctx is canceled.
+type scenarioFunc func(ctx context.Context, r *scenarioRun) error
+
+// scenario is a named failure scenario.
+type scenario struct {
+	name        string
+	description string
+	run         scenarioFunc
+}
+
+// scenarios are the presets RunScenario runs by name, in the order listed
+// by Scenarios.
+var scenarios = []scenario{
+	{"leader-kill-and-recover", "kills the leader, waits for the followers to elect a new one, and restarts the old leader", leaderKillAndRecover},
+	{"minority-partition", "isolates a follower, writes through the majority, and heals the partition", minorityPartition},
+	{"rolling-restart", "restarts the nodes one by one, keeping the quorum", rollingRestart},
+	{"quota-exceeded", "fills the database until the space quota alarm, and frees the space to disarm it", quotaExceeded},
+}
+
+// Scenarios returns the names and descriptions of the failure scenarios.
+func Scenarios() map[string]string {
+	m := make(map[string]string, len(scenarios))
+	for _, s := range scenarios {
+		m[s.name] = s.description
+	}
+	return m
+}
+
+// lookupScenario returns the scenario of the name.
+func lookupScenario(name string) (scenario, error) {
+	for _, s := range scenarios {
+		if s.name == name {
+			return s, nil
+		}
+	}
+	return scenario{}, fmt.Errorf("%q %w", name, ErrScenarioNotFound)
+}
+
+// scenarioRun is a running scenario.
+type scenarioRun struct {
+	c         *defaultCluster
+	name      string
+	streamIDs []string
+}
+
+// say writes the narration under the Node name, or under any Node if the
+// name is empty.
+func (r *scenarioRun) say(node, format string, args ...interface{}) {
+	if node == "" {
+		names := r.c.names()
+		if len(names) == 0 {
+			return
+		}
+		node = names[0]
+	}
+	r.c.Write(node, fmt.Sprintf("[SCENARIO %s] ", r.name)+fmt.Sprintf(format, args...), r.streamIDs...)
+}
+
+// pause waits scenarioPause, or returns the error of ctx if canceled
+// meanwhile.
+func (r *scenarioRun) pause(ctx context.Context) error {
+	select {
+	case <-time.After(scenarioPause):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func leaderKillAndRecover(ctx context.Context, r *scenarioRun) (err error) {
+	leader, err := r.c.Leader()
+	if err != nil {
+		return err
+	}
+	r.say(leader, "%s is the leader. Killing it, as if its machine crashed...", leader)
+	if err := r.c.Kill(leader); err != nil {
+		return err
+	}
+	// the old leader is restarted even if canceled
+	defer func() {
+		r.say(leader, "Restarting %s, which rejoins as a follower and catches up with the new leader", leader)
+		if rerr := r.c.restartBy(leader, time.Now().Add(scenarioTimeout)); err == nil {
+			err = rerr
+		}
+	}()
+
+	r.say(leader, "The followers elect a new leader once the election timeout passes without the heartbeats of %s", leader)
+	st := time.Now()
+	deadline := st.Add(scenarioTimeout)
+	for {
+		if next, err := r.c.Leader(); err == nil && next != leader {
+			r.say(next, "%s is the new leader (took %v)", next, time.Since(st))
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("no new leader after killing %s (%w)", leader, ErrTimeout)
+		}
+		select {
+		case <-time.After(rollingPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return r.pause(ctx)
+}
+
+func minorityPartition(ctx context.Context, r *scenarioRun) error {
+	leader, err := r.c.Leader()
+	if err != nil {
+		return err
+	}
+	var follower string
+	for _, name := range r.c.names() {
+		if name != leader {
+			follower = name
+			break
+		}
+	}
+	if follower == "" {
+		return fmt.Errorf("no follower to isolate from the leader %s", leader)
+	}
+
+	r.say(follower, "Isolating the follower %s from its peers. The majority with the leader %s keeps the quorum", follower, leader)
+	if err := r.c.Isolate(follower, r.streamIDs...); err != nil {
+		return err
+	}
+	// the partition is healed even if canceled
+	defer func() {
+		r.say(follower, "Healing the partition. %s catches up with the leader %s", follower, leader)
+		r.c.Unisolate(follower, r.streamIDs...)
+	}()
+
+	if _, err := r.c.Put(leader, "scenario/partition", "written during the partition", r.streamIDs...); err != nil {
+		return err
+	}
+	r.say(leader, "The majority accepted the write, while %s cannot serve linearizable reads", follower)
+	return r.pause(ctx)
+}
+
+func rollingRestart(ctx context.Context, r *scenarioRun) error {
+	r.say("", "Restarting the nodes one by one. Each one rejoins before the next is stopped, so the quorum is never lost")
+	return r.c.RollingRestart(r.streamIDs...)
+}
+
+func quotaExceeded(ctx context.Context, r *scenarioRun) error {
+	name, endpoint, err := r.c.pick("")
+	if err != nil {
+		return err
+	}
+	if r.c.quotaBackendBytes() == 0 {
+		return fmt.Errorf("%s %w", name, ErrNoQuota)
+	}
+	r.say(name, "Filling the database of %s until it exceeds the space quota", name)
+	if err := r.c.FillUntilQuota(ctx, name, r.streamIDs...); err != nil {
+		return err
+	}
+	if err := r.pause(ctx); err != nil {
+		return err
+	}
+
+	r.say(name, "Deleting the keys, and compacting their history to free up space")
+	if _, _, err := r.c.Delete(name, "quota_", true, r.streamIDs...); err != nil {
+		return err
+	}
+	rev, err := r.c.compact(ctx, endpoint)
+	if err != nil {
+		return err
+	}
+	r.say(name, "Compacted the revisions before %d. Defragmenting to give the space back", rev)
+	if err := r.c.Defragment("", r.streamIDs...); err != nil {
+		return err
+	}
+	r.say(name, "Disarming the alarm. The cluster accepts writes again")
+	return r.c.AlarmDisarm(r.streamIDs...)
+}
+
+// compact compacts the history before the current revision of the
+// endpoint, and returns the revision.
+func (c *defaultCluster) compact(ctx context.Context, endpoint string) (int64, error) {
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{endpoint},
+		DialTimeout: c.dialTimeout,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cli.Close()
+
+	kvc := clientv3.NewKV(cli)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	resp, err := kvc.Get(ctx, "\x00", clientv3.WithFromKey(), clientv3.WithCountOnly())
+	if err != nil {
+		return 0, err
+	}
+	rev := resp.Header.Revision
+	if _, err := kvc.Compact(ctx, rev); err != nil {
+		return 0, err
+	}
+	return rev, nil
+}
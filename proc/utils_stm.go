@@ -0,0 +1,146 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"sort"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"golang.org/x/net/context"
+)
+
+// STM is the software transactional memory given to the RunSTM function,
+// as in the etcd concurrency package. The reads see the keys as of their
+// first read, and the writes are buffered until the transaction commits.
+type STM interface {
+	// Get returns the value of the key, or the empty string if it does not
+	// exist.
+	Get(key string) string
+
+	// Rev returns the modified revision of the key, or 0 if it does not
+	// exist.
+	Rev(key string) int64
+
+	// Put writes the value when the transaction commits.
+	Put(key, value string)
+
+	// Del deletes the key when the transaction commits.
+	Del(key string)
+}
+
+// stmRead is a key as the transaction first read it.
+type stmRead struct {
+	value  string
+	modRev int64
+}
+
+// stmWrite is a buffered write, which deletes the key if deleted is true.
+type stmWrite struct {
+	value   string
+	deleted bool
+}
+
+// stm is a single attempt of the transaction. It commits only if none of
+// the read keys changed since they were read.
+type stm struct {
+	ctx  context.Context
+	kvc  clientv3.KV
+	rset map[string]stmRead
+	wset map[string]stmWrite
+
+	// err is the first failed read, which aborts the transaction.
+	err error
+}
+
+func newSTM(ctx context.Context, kvc clientv3.KV) *stm {
+	return &stm{ctx: ctx, kvc: kvc, rset: make(map[string]stmRead), wset: make(map[string]stmWrite)}
+}
+
+func (s *stm) Get(key string) string {
+	if w, ok := s.wset[key]; ok {
+		return w.value
+	}
+	return s.read(key).value
+}
+
+func (s *stm) Rev(key string) int64 {
+	return s.read(key).modRev
+}
+
+func (s *stm) Put(key, value string) {
+	s.wset[key] = stmWrite{value: value}
+}
+
+func (s *stm) Del(key string) {
+	s.wset[key] = stmWrite{deleted: true}
+}
+
+// read returns the key from the read set, or gets it from etcd.
+func (s *stm) read(key string) stmRead {
+	if r, ok := s.rset[key]; ok {
+		return r
+	}
+	if s.err != nil {
+		return stmRead{}
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 3*time.Second)
+	resp, err := s.kvc.Get(ctx, key)
+	cancel()
+	if err != nil {
+		s.err = err
+		return stmRead{}
+	}
+	var r stmRead
+	if len(resp.Kvs) > 0 {
+		r = stmRead{value: string(resp.Kvs[0].Value), modRev: resp.Kvs[0].ModRevision}
+	}
+	s.rset[key] = r
+	return r
+}
+
+// writtenKeys returns the keys to write, sorted.
+func (s *stm) writtenKeys() []string {
+	keys := make([]string, 0, len(s.wset))
+	for k := range s.wset {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// commit writes the buffered writes if no read key has changed, and
+// returns false on a conflict.
+func (s *stm) commit() (bool, error) {
+	var cmps []clientv3.Cmp
+	for k, r := range s.rset {
+		cmps = append(cmps, clientv3.Compare(clientv3.ModRevision(k), "=", r.modRev))
+	}
+	var ops []clientv3.Op
+	for _, k := range s.writtenKeys() {
+		if w := s.wset[k]; w.deleted {
+			ops = append(ops, clientv3.OpDelete(k))
+		} else {
+			ops = append(ops, clientv3.OpPut(k, w.value))
+		}
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 3*time.Second)
+	resp, err := s.kvc.Txn(ctx).If(cmps...).Then(ops...).Commit()
+	cancel()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
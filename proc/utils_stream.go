@@ -0,0 +1,32 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import "sync/atomic"
+
+// sendNonBlocking sends msg to ch without blocking. If ch is full, msg is
+// dropped and dropped is incremented, so that a slow consumer never stalls
+// the etcd process output or the cluster operations.
+func sendNonBlocking(ch chan string, msg string, dropped *uint64) bool {
+	select {
+	case ch <- msg:
+		return true
+	default:
+		if dropped != nil {
+			atomic.AddUint64(dropped, 1)
+		}
+		return false
+	}
+}
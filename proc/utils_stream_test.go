@@ -0,0 +1,45 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendNonBlocking(t *testing.T) {
+	var dropped uint64
+	ch := make(chan string, 2)
+
+	donec := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			sendNonBlocking(ch, "hello", &dropped)
+		}
+		close(donec)
+	}()
+
+	select {
+	case <-donec:
+	case <-time.After(3 * time.Second):
+		t.Fatal("sendNonBlocking blocked on a full channel")
+	}
+	if len(ch) != 2 {
+		t.Errorf("expected 2 buffered messages, got %d", len(ch))
+	}
+	if dropped != 8 {
+		t.Errorf("expected 8 dropped messages, got %d", dropped)
+	}
+}
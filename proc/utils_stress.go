@@ -0,0 +1,198 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+)
+
+// KeyDistribution decides which keys Stress writes.
+type KeyDistribution int
+
+const (
+	// UniformKeys writes a unique random key for each request.
+	UniformKeys KeyDistribution = iota
+
+	// SequentialKeys writes increasing keys, in the order of requests.
+	SequentialKeys
+
+	// ZipfianKeys writes keys with a zipfian distribution, so that a few
+	// hot keys take most of the requests.
+	ZipfianKeys
+)
+
+func (d KeyDistribution) String() string {
+	switch d {
+	case UniformKeys:
+		return "uniform"
+	case SequentialKeys:
+		return "sequential"
+	case ZipfianKeys:
+		return "zipfian"
+	default:
+		return fmt.Sprintf("KeyDistribution(%d)", int(d))
+	}
+}
+
+// ParseKeyDistribution parses "uniform", "sequential" or "zipfian".
+func ParseKeyDistribution(s string) (KeyDistribution, error) {
+	for _, d := range []KeyDistribution{UniformKeys, SequentialKeys, ZipfianKeys} {
+		if d.String() == s {
+			return d, nil
+		}
+	}
+	return UniformKeys, fmt.Errorf("unknown key distribution %q", s)
+}
+
+// StressConfig configures the requests of Stress.
+type StressConfig struct {
+	// KeySize is the size of the random or numeric part of each key.
+	// Zero uses 5.
+	KeySize int
+
+	// ValueSize is the size of each value. Zero uses 5.
+	ValueSize int
+
+	// Distribution decides which keys to write.
+	Distribution KeyDistribution
+
+	// ReadPercent is the percentage (0 ~ 100) of requests that get the
+	// keys already written, instead of putting keys. For example, 80 runs
+	// 80 reads and 20 writes in 100 requests.
+	ReadPercent int
+}
+
+const defaultStressSize = 5
+
+// zipfS is the skew of ZipfianKeys.
+const zipfS = 1.1
+
+// letterN is the number of letters randBytes chooses from.
+const letterN = 52
+
+// withDefaults fills in the default sizes, and checks that there are
+// enough random keys of the key size for stressN requests.
+func (cfg StressConfig) withDefaults(stressN int) (StressConfig, error) {
+	if cfg.KeySize < 0 || cfg.ValueSize < 0 {
+		return cfg, fmt.Errorf("negative key size %d or value size %d", cfg.KeySize, cfg.ValueSize)
+	}
+	if cfg.KeySize == 0 {
+		cfg.KeySize = defaultStressSize
+	}
+	if cfg.ValueSize == 0 {
+		cfg.ValueSize = defaultStressSize
+	}
+	switch cfg.Distribution {
+	case UniformKeys, SequentialKeys, ZipfianKeys:
+	default:
+		return cfg, fmt.Errorf("unknown key distribution %v", cfg.Distribution)
+	}
+	if cfg.ReadPercent < 0 || cfg.ReadPercent > 100 {
+		return cfg, fmt.Errorf("read percent must be between 0 and 100 (%d)", cfg.ReadPercent)
+	}
+	if reads := stressReadN(cfg, stressN); reads > 0 && reads == stressN {
+		return cfg, fmt.Errorf("%d%% reads leave no write in %d requests to read", cfg.ReadPercent, stressN)
+	}
+	if cfg.Distribution != SequentialKeys {
+		space := 1
+		for i := 0; i < cfg.KeySize && space < stressN; i++ {
+			space *= letterN
+		}
+		if space < stressN {
+			return cfg, fmt.Errorf("key size %d is too small for %d unique keys", cfg.KeySize, stressN)
+		}
+	}
+	return cfg, nil
+}
+
+// stressKeys returns stressN keys in the distribution.
+func stressKeys(rnd *rand.Rand, cfg StressConfig, stressN int) []string {
+	keys := make([]string, stressN)
+	switch cfg.Distribution {
+	case SequentialKeys:
+		// zero-padded, so that the keys also sort in order
+		width := cfg.KeySize
+		if n := len(strconv.Itoa(stressN)); n > width {
+			width = n
+		}
+		for i := range keys {
+			keys[i] = fmt.Sprintf("foo_%0*d", width, i)
+		}
+
+	case ZipfianKeys:
+		space := multiRandBytes(rnd, cfg.KeySize, stressN)
+		z := rand.NewZipf(rnd, zipfS, 1, uint64(stressN-1))
+		for i := range keys {
+			keys[i] = fmt.Sprintf("foo_%s", space[z.Uint64()])
+		}
+
+	default:
+		for i, b := range multiRandBytes(rnd, cfg.KeySize, stressN) {
+			keys[i] = fmt.Sprintf("foo_%d_%s", i, b)
+		}
+	}
+	return keys
+}
+
+// stressMaxKeySize returns the size of the longest of the stressN keys
+// that stressKeys returns.
+func stressMaxKeySize(cfg StressConfig, stressN int) int {
+	switch cfg.Distribution {
+	case SequentialKeys:
+		width := cfg.KeySize
+		if n := len(strconv.Itoa(stressN)); n > width {
+			width = n
+		}
+		return len("foo_") + width
+	case ZipfianKeys:
+		return len("foo_") + cfg.KeySize
+	default:
+		return len(fmt.Sprintf("foo_%d_", stressN-1)) + cfg.KeySize
+	}
+}
+
+// stressReadN returns the number of reads in stressN requests.
+func stressReadN(cfg StressConfig, stressN int) int {
+	return stressN * cfg.ReadPercent / 100
+}
+
+// stressReads returns whether each of stressN requests is a read, in the
+// order to issue them. The first request is always a write, so that the
+// reads have a key to get.
+func stressReads(rnd *rand.Rand, cfg StressConfig, stressN int) []bool {
+	reads, readN := make([]bool, stressN), stressReadN(cfg, stressN)
+	if readN == 0 {
+		return reads
+	}
+	for i := 0; i < readN; i++ {
+		reads[stressN-1-i] = true
+	}
+	if stressN > 1 {
+		rest := reads[1:]
+		rnd.Shuffle(len(rest), func(i, j int) { rest[i], rest[j] = rest[j], rest[i] })
+	}
+	return reads
+}
+
+// stressValues returns stressN values of the value size.
+func stressValues(rnd *rand.Rand, cfg StressConfig, stressN int) []string {
+	vals := make([]string, stressN)
+	for i := range vals {
+		vals[i] = string(randBytes(rnd, cfg.ValueSize))
+	}
+	return vals
+}
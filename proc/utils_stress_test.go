@@ -0,0 +1,135 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStressSizes(t *testing.T) {
+	for _, d := range []KeyDistribution{UniformKeys, SequentialKeys, ZipfianKeys} {
+		cfg, err := StressConfig{KeySize: 8, ValueSize: 100, Distribution: d}.withDefaults(50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rnd := rand.New(rand.NewSource(1))
+		keys, vals := stressKeys(rnd, cfg, 50), stressValues(rnd, cfg, 50)
+		if len(keys) != 50 || len(vals) != 50 {
+			t.Fatalf("%v: expected 50 keys and values, got %d, %d", d, len(keys), len(vals))
+		}
+		for i := range keys {
+			if k := keys[i][strings.LastIndex(keys[i], "_")+1:]; len(k) != 8 {
+				t.Errorf("%v: key %q has %d bytes, expected 8", d, keys[i], len(k))
+			}
+			if len(vals[i]) != 100 {
+				t.Errorf("%v: value has %d bytes, expected 100", d, len(vals[i]))
+			}
+		}
+	}
+}
+
+func TestStressSequentialKeys(t *testing.T) {
+	// key size smaller than the number of digits
+	cfg, err := StressConfig{KeySize: 1, Distribution: SequentialKeys}.withDefaults(120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := stressKeys(rand.New(rand.NewSource(1)), cfg, 120)
+	if !sort.StringsAreSorted(keys) {
+		t.Fatalf("sequential keys are not monotonic: %q", keys)
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == keys[i-1] {
+			t.Fatalf("duplicate key %q", keys[i])
+		}
+	}
+}
+
+func TestStressZipfianKeys(t *testing.T) {
+	cfg, err := StressConfig{Distribution: ZipfianKeys}.withDefaults(1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, k := range stressKeys(rand.New(rand.NewSource(1)), cfg, 1000) {
+		counts[k]++
+	}
+	hottest := 0
+	for _, n := range counts {
+		if n > hottest {
+			hottest = n
+		}
+	}
+	// a uniform distribution would write each key about once
+	if hottest < 100 {
+		t.Errorf("hottest key took only %d of 1000 requests", hottest)
+	}
+}
+
+func TestStressConfigValidate(t *testing.T) {
+	for i, cfg := range []StressConfig{
+		{KeySize: -1},
+		{ValueSize: -1},
+		{Distribution: KeyDistribution(9)},
+		{KeySize: 1}, // only 52 unique keys
+		{ReadPercent: -1},
+		{ReadPercent: 101},
+		{ReadPercent: 100}, // nothing to read
+	} {
+		if _, err := cfg.withDefaults(100); err == nil {
+			t.Errorf("#%d: expected error for %+v", i, cfg)
+		}
+	}
+	if _, err := ParseKeyDistribution("zipfian"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParseKeyDistribution("hot"); err == nil {
+		t.Error("expected error for unknown distribution")
+	}
+}
+
+func TestStressReads(t *testing.T) {
+	tests := []struct {
+		readPercent, stressN int
+		reads                int
+	}{
+		{0, 10, 0},
+		{80, 100, 80},
+		{50, 3, 1},
+		{99, 10, 9},
+	}
+	for i, tt := range tests {
+		cfg := StressConfig{ReadPercent: tt.readPercent}
+		reads := stressReads(rand.New(rand.NewSource(1)), cfg, tt.stressN)
+		if len(reads) != tt.stressN {
+			t.Fatalf("#%d: expected %d requests, got %d", i, tt.stressN, len(reads))
+		}
+		if reads[0] {
+			t.Errorf("#%d: expected the first request to write", i)
+		}
+		n := 0
+		for _, r := range reads {
+			if r {
+				n++
+			}
+		}
+		if n != tt.reads {
+			t.Errorf("#%d: expected %d reads, got %d", i, tt.reads, n)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxRenderedBytes is the number of bytes of a binary value shown in the
+// streams.
+const maxRenderedBytes = 32
+
+// htmlReplacer escapes the characters that would break the HTML streams,
+// so that the keys and values written by the users are shown as text.
+var htmlReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// renderValue formats the value for the streams, quoted if it is UTF-8, or
+// else in hex, so that binary values do not corrupt the output. It is
+// escaped with the rest of the message in Write.
+func renderValue(v string) string {
+	if utf8.ValidString(v) {
+		return strconv.Quote(v)
+	}
+	b := []byte(v)
+	if len(b) > maxRenderedBytes {
+		return fmt.Sprintf("0x%s... (%d bytes)", hex.EncodeToString(b[:maxRenderedBytes]), len(b))
+	}
+	return fmt.Sprintf("0x%s (%d bytes)", hex.EncodeToString(b), len(b))
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 CoreOS, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderValue(t *testing.T) {
+	tests := []struct {
+		value string
+		out   string
+	}{
+		{"bar", `"bar"`},
+		{"<script>", `"<script>"`},
+		{"\xff\x00\xfe", "0xff00fe (3 bytes)"},
+		{"\xff" + strings.Repeat("a", 40), "0xff" + strings.Repeat("61", maxRenderedBytes-1) + "... (41 bytes)"},
+	}
+	for i, tt := range tests {
+		if out := renderValue(tt.value); out != tt.out {
+			t.Errorf("#%d: expected %s, got %s", i, tt.out, out)
+		}
+	}
+}